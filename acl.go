@@ -0,0 +1,146 @@
+package kv
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/qsymmachus/kv/internal/wire"
+)
+
+// How much a `Grant` lets a token do with the keys it covers. Each level
+// includes the ones before it.
+type Access uint8
+
+const (
+	// Gets and watches keys.
+	AccessRead Access = iota + 1
+	// Sets and unsets keys, and uses leases and locks.
+	AccessWrite
+	// Backs up, restores, compacts, syncs and promotes the store, which affect
+	// every namespace, so they need a grant of every namespace's keys.
+	AccessAdmin
+)
+
+// Grants a token access to the keys of a namespace that start with `Prefix`,
+// and to its pub/sub topics that do.
+type Grant struct {
+	// The namespace, "" for the default one, or "*" for every namespace.
+	Namespace string
+	// The prefix of the keys granted, or "" for every key. Only string keys
+	// start with a prefix, so other keys are only granted by "".
+	Prefix string
+	Access Access
+}
+
+// What a client connected to the store's server may do.
+type permissions struct {
+	// Set for clients that authenticated with the store's `AuthToken`, or that
+	// needn't authenticate.
+	unrestricted bool
+	grants       []Grant
+}
+
+// Reports whether any grant gives `access` to every key of `namespace` that
+// starts with `prefix`. A namespace of "*" stands for every namespace.
+func (p permissions) allows(access Access, namespace string, prefix string) bool {
+	if p.unrestricted {
+		return true
+	}
+
+	for _, g := range p.grants {
+		if g.Access >= access && (g.Namespace == "*" || g.Namespace == namespace) && strings.HasPrefix(prefix, g.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether any grant gives `access` to some of the keys of `namespace`.
+func (p permissions) allowsAny(access Access, namespace string) bool {
+	if p.unrestricted {
+		return true
+	}
+
+	for _, g := range p.grants {
+		if g.Access >= access && (g.Namespace == "*" || g.Namespace == namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether any grant gives `access` to a key, encoded as JSON.
+func (p permissions) allowsKey(access Access, namespace string, key json.RawMessage) bool {
+	var prefix string
+	json.Unmarshal(key, &prefix)
+	return p.allows(access, namespace, prefix)
+}
+
+// Checks that a client may make a request. Operations that read or write every
+// key of a namespace need a grant of the whole namespace.
+func (p permissions) authorize(req wire.Request) error {
+	allowed := false
+	switch req.Op {
+	case wire.Authenticate, wire.Healthy, wire.Stats, wire.ReplaySummary, wire.Members:
+		allowed = true
+	case wire.Get, wire.GetAt, wire.GetVersioned, wire.LRange, wire.SIsMember, wire.SMembers, wire.ZRangeByScore, wire.ZRank, wire.HGet:
+		allowed = p.allowsKey(AccessRead, req.Namespace, req.Key)
+	case wire.GetMany:
+		allowed = true
+		for _, key := range req.Keys {
+			allowed = allowed && p.allowsKey(AccessRead, req.Namespace, key)
+		}
+	case wire.Watch:
+		allowed = p.allows(AccessRead, req.Namespace, req.Prefix)
+	case wire.KeysMatching:
+		allowed = p.allows(AccessRead, req.Namespace, globPrefix(req.Pattern))
+	case wire.Subscribe:
+		allowed = p.allows(AccessRead, req.Namespace, req.Topic)
+	case wire.Publish:
+		allowed = p.allows(AccessWrite, req.Namespace, req.Topic)
+	case wire.GetAll, wire.List, wire.ListDesc, wire.Scan, wire.Keys, wire.SearchKeys, wire.Len, wire.GetByIndex, wire.Search, wire.Export, wire.Digest, wire.DigestEntries, wire.Versions:
+		allowed = p.allows(AccessRead, req.Namespace, "")
+	case wire.Set, wire.SetPipelined, wire.Unset, wire.CompareAndSwap, wire.UnsetIf, wire.SetIfNotExists, wire.GetAndDelete, wire.GetAndSet, wire.Append, wire.LPush, wire.RPush, wire.LPop, wire.SAdd, wire.SRem, wire.ZAdd, wire.HSet, wire.HDel, wire.Increment, wire.Decrement, wire.SetWithLease, wire.TryLock:
+		allowed = p.allowsKey(AccessWrite, req.Namespace, req.Key)
+	case wire.Rename, wire.RenameIfNotExists:
+		allowed = p.allowsKey(AccessWrite, req.Namespace, req.Key) && p.allowsKey(AccessWrite, req.Namespace, req.To)
+	case wire.SetMany, wire.Merge, wire.Commit:
+		allowed = true
+		for _, e := range req.Entries {
+			allowed = allowed && p.allowsKey(AccessWrite, req.Namespace, e.Key)
+		}
+		for _, key := range req.Keys {
+			allowed = allowed && p.allowsKey(AccessWrite, req.Namespace, key)
+		}
+	case wire.Import, wire.Clear:
+		allowed = p.allows(AccessWrite, req.Namespace, "")
+	case wire.GrantLease, wire.KeepAlive, wire.RevokeLease:
+		// `authorizeLease` checks the lease was granted in the namespace too:
+		allowed = p.allowsAny(AccessWrite, req.Namespace)
+	case wire.Backup, wire.Restore, wire.Compact, wire.Sync, wire.Promote:
+		allowed = p.allows(AccessAdmin, "*", "")
+	default:
+		allowed = p.unrestricted
+	}
+
+	if !allowed {
+		return fmt.Errorf("%w: %s in namespace %q", ErrUnauthorized, req.Op, req.Namespace)
+	}
+	return nil
+}
+
+// Checks that a client that isn't unrestricted only keeps alive or revokes a
+// lease that was granted in the namespace of its request, which `authorize`
+// has checked it can write to. Leases the store doesn't have are left for the
+// request to fail with `ErrNoLease`.
+func (s *kvStore[K, V]) authorizeLease(p permissions, req wire.Request) error {
+	if p.unrestricted || (req.Op != wire.KeepAlive && req.Op != wire.RevokeLease) {
+		return nil
+	}
+
+	if namespace, found := s.leaseNamespace(LeaseID(req.Lease)); found && namespace != req.Namespace {
+		return fmt.Errorf("%w: %s of a lease in another namespace than %q", ErrUnauthorized, req.Op, req.Namespace)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package kv
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/qsymmachus/kv/internal/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorize(t *testing.T) {
+	perms := permissions{grants: []Grant{
+		{Namespace: "billing", Access: AccessWrite},
+		{Namespace: "users", Prefix: "team-a:", Access: AccessWrite},
+		{Namespace: "*", Prefix: "public:", Access: AccessRead},
+	}}
+	key := func(k any) json.RawMessage {
+		encoded, _ := json.Marshal(k)
+		return encoded
+	}
+
+	allowed := []wire.Request{
+		{Op: wire.Get, Namespace: "users", Key: key("team-a:alice")},
+		{Op: wire.Set, Namespace: "users", Key: key("team-a:alice")},
+		{Op: wire.SetPipelined, Namespace: "users", Key: key("team-a:alice")},
+		{Op: wire.Get, Namespace: "reports", Key: key("public:summary")},
+		{Op: wire.Watch, Namespace: "users", Prefix: "team-a:admins:"},
+		{Op: wire.KeysMatching, Namespace: "users", Pattern: "team-a:*:admin"},
+		{Op: wire.GetAll, Namespace: "billing"},
+		{Op: wire.Set, Namespace: "billing", Key: key(42)},
+		{Op: wire.SetMany, Namespace: "users", Entries: []wire.Entry{{Key: key("team-a:bob")}, {Key: key("team-a:carol")}}},
+		{Op: wire.Commit, Namespace: "users", Entries: []wire.Entry{{Key: key("team-a:bob")}}, Keys: []json.RawMessage{key("team-a:carol")}},
+		{Op: wire.GrantLease, Namespace: "users"},
+		{Op: wire.Stats},
+		{Op: wire.Members},
+	}
+	for _, req := range allowed {
+		assert.NoError(t, perms.authorize(req), "%s %s %s", req.Op, req.Namespace, req.Key)
+	}
+
+	denied := []wire.Request{
+		// Keys outside the granted prefix, or namespace:
+		{Op: wire.Get, Namespace: "users", Key: key("team-b:dave")},
+		{Op: wire.Get, Namespace: "", Key: key("team-a:alice")},
+		{Op: wire.Set, Namespace: "users", Key: key(42)},
+		{Op: wire.SetMany, Namespace: "users", Entries: []wire.Entry{{Key: key("team-a:bob")}, {Key: key("team-b:erin")}}},
+		{Op: wire.GetMany, Namespace: "users", Keys: []json.RawMessage{key("team-a:bob"), key("team-b:erin")}},
+		{Op: wire.Commit, Namespace: "users", Entries: []wire.Entry{{Key: key("team-a:bob")}}, Keys: []json.RawMessage{key("team-b:erin")}},
+		{Op: wire.Watch, Namespace: "users", Prefix: "team"},
+		{Op: wire.KeysMatching, Namespace: "users", Pattern: "team-?:*"},
+		// Reads of the whole namespace, when only a prefix is granted:
+		{Op: wire.GetAll, Namespace: "users"},
+		{Op: wire.Keys, Namespace: "users"},
+		// Writes with read access:
+		{Op: wire.Set, Namespace: "reports", Key: key("public:summary")},
+		{Op: wire.SetPipelined, Namespace: "reports", Key: key("public:summary")},
+		{Op: wire.GrantLease, Namespace: "reports"},
+		// Administration:
+		{Op: wire.Backup},
+		{Op: wire.Compact, Namespace: "billing"},
+	}
+	for _, req := range denied {
+		assert.ErrorIs(t, perms.authorize(req), ErrUnauthorized, "%s %s %s", req.Op, req.Namespace, req.Key)
+	}
+
+	admin := permissions{grants: []Grant{{Namespace: "*", Access: AccessAdmin}}}
+	assert.NoError(t, admin.authorize(wire.Request{Op: wire.Backup}))
+	assert.NoError(t, admin.authorize(wire.Request{Op: wire.Set, Namespace: "users", Key: key("team-b:dave")}))
+	assert.NoError(t, permissions{unrestricted: true}.authorize(wire.Request{Op: wire.Promote}))
+}
+
+func TestAuthorizeLease(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath))
+	lease, _ := store.Namespace("billing").GrantLease(time.Hour)
+	// A lease's namespace survives compaction:
+	assert.NoError(t, store.Compact())
+	store.Close()
+	compacted, _ := NewStore[string, string](LogPath(logPath))
+	defer compacted.Close()
+
+	perms := permissions{grants: []Grant{
+		{Namespace: "billing", Access: AccessWrite},
+		{Namespace: "users", Access: AccessWrite},
+	}}
+	s := compacted.(*kvStore[string, string])
+	for _, op := range []string{wire.KeepAlive, wire.RevokeLease} {
+		assert.NoError(t, s.authorizeLease(perms, wire.Request{Op: op, Namespace: "billing", Lease: uint64(lease)}))
+		// Write access to another namespace doesn't give access to its leases:
+		assert.ErrorIs(t, s.authorizeLease(perms, wire.Request{Op: op, Namespace: "users", Lease: uint64(lease)}), ErrUnauthorized)
+		assert.NoError(t, s.authorizeLease(permissions{unrestricted: true}, wire.Request{Op: op, Namespace: "users", Lease: uint64(lease)}))
+	}
+}
@@ -0,0 +1,51 @@
+package kv
+
+func (s *kvStore[K, V]) Range(fn func(key K, value V) bool) {
+	s.exclusive(func() {
+		for _, sh := range s.shards {
+			for k, v := range sh.lookup(s.namespace).values.all() {
+				if !fn(k, v) {
+					return
+				}
+			}
+		}
+	})
+}
+
+func (s *kvStore[K, V]) Count(match func(key K, value V) bool) int {
+	n := 0
+	s.Range(func(key K, value V) bool {
+		if match(key, value) {
+			n++
+		}
+		return true
+	})
+
+	return n
+}
+
+// Folds every key/value pair of a store into a single result, starting with
+// `initial`, such as to sum its values. The pairs are taken at a single point
+// in time, in no particular order, and folded inside the store, with `Range`,
+// so they aren't copied out of it first. Like `Range`, `fold` should be quick,
+// and mustn't use the store.
+func Aggregate[K comparable, V any, A any](store KVStore[K, V], initial A, fold func(result A, key K, value V) A) A {
+	return Fold(store, initial, func(result A, key K, value V) (A, bool) {
+		return fold(result, key, value), true
+	})
+}
+
+// Folds a store's key/value pairs into a single result, like `Aggregate`, but
+// stops as soon as `fn` returns false along with the result so far, such as
+// once it has found enough. Since the pairs are in no particular order, which
+// ones have been folded by then isn't defined.
+func Fold[K comparable, V any, A any](store KVStore[K, V], seed A, fn func(result A, key K, value V) (A, bool)) A {
+	result := seed
+	store.Range(func(key K, value V) bool {
+		var more bool
+		result, more = fn(result, key, value)
+		return more
+	})
+
+	return result
+}
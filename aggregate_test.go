@@ -0,0 +1,43 @@
+package kv
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountAndAggregate(t *testing.T) {
+	store, _ := NewStore[string, int](Shards(4))
+	defer store.Close()
+	for i, k := range []string{"a", "b", "c", "d", "e"} {
+		store.Set(k, i+1)
+	}
+	store.Namespace("other").Set("f", 100)
+
+	assert.Equal(t, 2, store.Count(func(key string, value int) bool { return value%2 == 0 }))
+	assert.Equal(t, 5, store.Count(func(string, int) bool { return true }))
+
+	sum := Aggregate(store, 0, func(sum int, key string, value int) int { return sum + value })
+	assert.Equal(t, 15, sum)
+	concatenated := Aggregate(store, "", func(keys string, key string, value int) string { return keys + strings.Repeat(key, value) })
+	assert.Len(t, concatenated, 15)
+
+	// Fold stops as soon as the function says it's done:
+	calls := 0
+	total := Fold(store, 0, func(total int, key string, value int) (int, bool) {
+		calls++
+		return total + value, total+value < 6
+	})
+	assert.GreaterOrEqual(t, total, 6)
+	assert.Less(t, calls, 5)
+	assert.Equal(t, 15, Fold(store, 0, func(total int, key string, value int) (int, bool) { return total + value, true }))
+
+	// So does Range:
+	visited := 0
+	store.Range(func(string, int) bool {
+		visited++
+		return visited < 3
+	})
+	assert.Equal(t, 3, visited)
+}
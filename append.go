@@ -0,0 +1,33 @@
+package kv
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Returned by `Append` for stores whose values aren't strings or slices.
+var ErrNotAppendable = errors.New("Store's values aren't strings or slices")
+
+func (s *kvStore[K, V]) Append(key K, values V) (revision uint64, err error) {
+	result := s.write(s.newUpdate(appendValue, key, values))
+	return result.revision, result.err
+}
+
+// Appends `values` to `value`, for strings, which are concatenated, and slices,
+// including `[]byte`, whose elements are appended. The result never shares
+// memory with `value`, which readers may still hold.
+func appendValues[V any](value V, values V) (V, error) {
+	v, e := reflect.ValueOf(&value).Elem(), reflect.ValueOf(&values).Elem()
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(v.String() + e.String())
+	case reflect.Slice:
+		joined := reflect.MakeSlice(v.Type(), 0, v.Len()+e.Len())
+		joined = reflect.AppendSlice(reflect.AppendSlice(joined, v), e)
+		v.Set(joined)
+	default:
+		return value, ErrNotAppendable
+	}
+
+	return value, nil
+}
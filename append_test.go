@@ -0,0 +1,109 @@
+package kv
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppend(t *testing.T) {
+	defer removeLog()
+
+	store, err := NewStore[string, string](LogPath(logPath))
+	assert.NoError(t, err)
+
+	// Missing keys count as empty:
+	_, err = store.Append("greeting", "hello")
+	assert.NoError(t, err)
+	first, _ := store.Append("greeting", ", world")
+	v, _ := store.Get("greeting")
+	assert.Equal(t, "hello, world", v)
+
+	// Only what was appended is logged:
+	log, _ := os.ReadFile(logPath)
+	assert.Contains(t, string(log), ", world")
+	assert.NotContains(t, string(log), "hello, world")
+
+	// Concurrent appends aren't lost:
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Append("dots", ".")
+		}()
+	}
+	wg.Wait()
+	v, _ = store.Get("dots")
+	assert.Len(t, v, 50)
+
+	// History is rebuilt from the appends:
+	store.Set("greeting", "bye")
+	v, found, err := store.GetAt("greeting", first)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "hello, world", v)
+	store.Close()
+
+	replayed, err := NewStore[string, string](LogPath(logPath))
+	assert.NoError(t, err)
+	defer replayed.Close()
+	v, _ = replayed.Get("dots")
+	assert.Len(t, v, 50)
+	v, _ = replayed.Get("greeting")
+	assert.Equal(t, "bye", v)
+}
+
+// Test that appends the snapshot covers aren't applied again if the store
+// stopped before trimming them from the log.
+func TestAppendSnapshotUntrimmedLog(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath))
+	store.Set("letters", "a")
+	// The set is trimmed, so the untrimmed log only has the append after it:
+	assert.NoError(t, store.(*kvStore[string, string]).snapshotLog())
+	store.Append("letters", "b")
+	log, _ := os.ReadFile(logPath)
+	assert.NoError(t, store.(*kvStore[string, string]).snapshotLog())
+	store.Append("letters", "c")
+	store.Close()
+
+	rest, _ := os.ReadFile(logPath)
+	_, rest, _ = bytes.Cut(rest, []byte("\n"))
+	os.WriteFile(logPath, append(log, rest...), 0600)
+
+	replayed, _ := NewStore[string, string](LogPath(logPath))
+	defer replayed.Close()
+	v, _ := replayed.Get("letters")
+	assert.Equal(t, "abc", v)
+}
+
+func TestAppendSlices(t *testing.T) {
+	bytes, _ := NewStore[string, []byte]()
+	defer bytes.Close()
+	bytes.Append("buf", []byte("ab"))
+	bytes.Append("buf", []byte("cd"))
+	v, _ := bytes.Get("buf")
+	assert.Equal(t, []byte("abcd"), v)
+
+	ints, _ := NewStore[string, []int]()
+	defer ints.Close()
+	ints.Set("list", []int{1})
+	held, _ := ints.Get("list")
+	ints.Append("list", []int{2, 3})
+	list, _ := ints.Get("list")
+	assert.Equal(t, []int{1, 2, 3}, list)
+	// Values already read are left as they were:
+	assert.Equal(t, []int{1}, held)
+
+	numbers, _ := NewStore[string, int]()
+	defer numbers.Close()
+	_, err := numbers.Append("n", 1)
+	assert.ErrorIs(t, err, ErrNotAppendable)
+	_, found := numbers.Get("n")
+	assert.False(t, found)
+}
@@ -0,0 +1,361 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where a store archives copies of its data, with `ArchiveTo`, so it can be
+// restored to an earlier revision with `RestoreArchive`, such as a bucket in
+// an object store.
+type BackupDestination interface {
+	// Stores an object, replacing any object with the same name.
+	Put(name string, data []byte) error
+	// Gets the contents of an object.
+	Get(name string) ([]byte, error)
+	// Lists the name of every object that starts with `prefix`, in any order.
+	List(prefix string) ([]string, error)
+}
+
+// The names of archived snapshots and log segments start with these, followed
+// by the revision of their first record.
+const (
+	archivedSnapshotPrefix = "snapshot-"
+	archivedSegmentPrefix  = "segment-"
+)
+
+// Returns the name of an archived object.
+func archiveName(prefix string, revision uint64) string {
+	return fmt.Sprintf("%s%020d", prefix, revision)
+}
+
+// Gets the revisions in the names of archived objects that start with
+// `prefix`, in ascending order.
+func archivedRevisions(dest BackupDestination, prefix string) ([]uint64, error) {
+	names, err := dest.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []uint64
+	for _, name := range names {
+		if revision, err := strconv.ParseUint(strings.TrimPrefix(name, prefix), 10, 64); err == nil {
+			revisions = append(revisions, revision)
+		}
+	}
+	slices.Sort(revisions)
+
+	return revisions, nil
+}
+
+// Archives the store every `interval`, until it's closed: a snapshot of its
+// data, if it has changed since the last one, and every segment of its log
+// that's been rotated, and so won't be appended to again. Anything that fails
+// to upload is retried the next time.
+func (s *kvStore[K, V]) archive(format *writeAheadLog) {
+	defer s.background.Done()
+
+	dest := s.options.archive
+	ticker := time.NewTicker(s.options.archiveInterval)
+	defer ticker.Stop()
+
+	// Segments that were archived before the store was opened aren't uploaded
+	// again:
+	archived := make(map[string]bool)
+	if names, err := dest.List(archivedSegmentPrefix); err == nil {
+		for _, name := range names {
+			archived[name] = true
+		}
+	}
+	var snapshotRevision uint64
+	var segment int
+
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ticker.C:
+		}
+
+		s.maintenance.Lock()
+		revision, err := s.archiveSnapshot(format, snapshotRevision)
+		if err != nil {
+			s.options.logger.Warn("Failed to archive snapshot", "error", err)
+		}
+		snapshotRevision = revision
+		if s.log != nil && s.log.segmentSize > 0 {
+			latest, err := s.archiveSegments(segment, archived)
+			if err != nil {
+				s.options.logger.Warn("Failed to archive log segment", "error", err)
+			}
+			segment = latest
+		}
+		s.maintenance.Unlock()
+	}
+}
+
+// Uploads a snapshot of the store, unless it's still at the revision of the
+// last one. Returns the snapshot's revision. The caller must hold
+// `maintenance`.
+func (s *kvStore[K, V]) archiveSnapshot(format *writeAheadLog, last uint64) (uint64, error) {
+	s.commit.Lock()
+	revision := s.revision
+	s.commit.Unlock()
+	if revision == last {
+		return last, nil
+	}
+
+	var state storeState[K]
+	var updates []update[K, V]
+	err := s.readView(func() {
+		s.commit.Lock()
+		defer s.commit.Unlock()
+		state = s.captureState()
+		revision = state.revision
+	}, func(v view[K, V]) { updates = s.stateUpdates(state, v) })
+	if err != nil {
+		return last, err
+	}
+
+	records, err := s.encodeUpdates(updates)
+	if err != nil {
+		return last, err
+	}
+	var data bytes.Buffer
+	if err := format.writeRecords(&data, records); err != nil {
+		return last, err
+	}
+	if err := s.options.archive.Put(archiveName(archivedSnapshotPrefix, revision), data.Bytes()); err != nil {
+		return last, err
+	}
+
+	return revision, nil
+}
+
+// Uploads every segment of the log after `after` that has been rotated, unless
+// it's already archived. Returns the latest segment that's been archived. The
+// caller must hold `maintenance`.
+func (s *kvStore[K, V]) archiveSegments(after int, archived map[string]bool) (int, error) {
+	// Read the segments while nothing can trim them:
+	s.commit.Lock()
+	segments, err := s.log.segments()
+	var rotated []int
+	var contents [][]byte
+	for _, segment := range segments {
+		if err != nil || segment <= after || segment >= s.log.segment {
+			continue
+		}
+		var data []byte
+		if data, err = os.ReadFile(s.log.segmentPath(segment)); err == nil {
+			rotated = append(rotated, segment)
+			contents = append(contents, data)
+		}
+	}
+	s.commit.Unlock()
+	if err != nil {
+		return after, err
+	}
+
+	for i, data := range contents {
+		first, err := s.firstRevision(data)
+		if err != nil {
+			return after, err
+		}
+
+		name := archiveName(archivedSegmentPrefix, first)
+		if first > 0 && !archived[name] {
+			if err := s.options.archive.Put(name, data); err != nil {
+				return after, err
+			}
+			archived[name] = true
+		}
+		after = rotated[i]
+	}
+
+	return after, nil
+}
+
+// Gets the revision of the first record in a file of the log, or 0 if it has
+// none.
+func (s *kvStore[K, V]) firstRevision(data []byte) (uint64, error) {
+	var first uint64
+	err := s.log.readRecords(bytes.NewReader(data), func(record []byte) error {
+		u, err := s.decodeUpdate(record)
+		if err != nil {
+			return err
+		}
+		first = u.Revision
+		return errPastRevision
+	})
+	if err != nil && err != errPastRevision {
+		return 0, err
+	}
+
+	return first, nil
+}
+
+// Opens a store with `options`, like `NewStore`, and restores the data that
+// was archived to `dest` by `ArchiveTo`, as of `revision`, or as of the latest
+// revision archived if it's 0. The store starts from the latest snapshot at or
+// before `revision`, then applies the updates from archived log segments after
+// it. The options must encrypt and encode records the same way the archived
+// store's did, and the store must start out empty.
+func RestoreArchive[K comparable, V any](dest BackupDestination, revision uint64, options ...Option) (KVStore[K, V], error) {
+	store, err := NewStore[K, V](options...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.(*kvStore[K, V]).restoreArchive(dest, revision); err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Applies archived updates to an empty store, keeping their revisions.
+func (s *kvStore[K, V]) restoreArchive(dest BackupDestination, revision uint64) error {
+	// The store doesn't archive itself until it's restored:
+	s.maintenance.Lock()
+	defer s.maintenance.Unlock()
+
+	s.commit.Lock()
+	empty := s.revision == 0
+	s.commit.Unlock()
+	if !empty {
+		return errors.New("Cannot restore an archive into a store that isn't empty")
+	}
+
+	format, err := newLogFormat(s.options)
+	if err != nil {
+		return err
+	}
+	snapshots, err := archivedRevisions(dest, archivedSnapshotPrefix)
+	if err != nil {
+		return err
+	}
+	segments, err := archivedRevisions(dest, archivedSegmentPrefix)
+	if err != nil {
+		return err
+	}
+
+	// A snapshot, and a segment rewritten by compaction, start with a truncate
+	// followed by records that share its revision:
+	var applied uint64
+	inSnapshot := false
+	apply := func(record []byte) error {
+		u, err := s.decodeUpdate(record)
+		if err != nil {
+			return err
+		}
+		switch {
+		case revision > 0 && u.Revision > revision:
+			return errPastRevision
+		case u.Revision == applied && inSnapshot:
+		case u.Revision <= applied:
+			return nil
+		case u.Revision > applied+1 && u.UpdateType != truncate:
+			return fmt.Errorf("Cannot restore archive, it's missing revisions %d to %d", applied+1, u.Revision-1)
+		}
+		inSnapshot = u.UpdateType == truncate || inSnapshot && u.Revision == applied
+		applied = u.Revision
+
+		u.append = s.appends()
+		return s.queueUpdate(u).err
+	}
+	restore := func(name string) error {
+		data, err := dest.Get(name)
+		if err != nil {
+			return err
+		}
+		if err := format.readRecords(bytes.NewReader(data), apply); err != nil && err != errPastRevision {
+			return err
+		}
+		return nil
+	}
+
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if revision == 0 || snapshots[i] <= revision {
+			if err := restore(archiveName(archivedSnapshotPrefix, snapshots[i])); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	inSnapshot = false
+
+	for i, first := range segments {
+		// Skip segments that end before the snapshot, and stop once past the
+		// revision:
+		if i+1 < len(segments) && segments[i+1] <= applied+1 {
+			continue
+		}
+		if revision > 0 && first > revision {
+			break
+		}
+		if err := restore(archiveName(archivedSegmentPrefix, first)); err != nil {
+			return err
+		}
+	}
+
+	if revision > 0 && applied < revision {
+		return fmt.Errorf("Cannot restore archive to revision %d, it only goes up to %d", revision, applied)
+	}
+	return nil
+}
+
+// Archives objects as files in a directory on the local filesystem.
+type dirDestination struct {
+	dir string
+}
+
+// Returns a `BackupDestination` that stores objects as files in `dir`, which
+// is created if it doesn't exist, such as a directory on a network file system.
+func DirDestination(dir string) BackupDestination {
+	return &dirDestination{dir: dir}
+}
+
+func (d *dirDestination) Put(name string, data []byte) error {
+	if err := os.MkdirAll(d.dir, 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so a failed write doesn't leave half an
+	// object behind:
+	temp := filepath.Join(d.dir, name+".writing")
+	if err := os.WriteFile(temp, data, 0600); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return os.Rename(temp, filepath.Join(d.dir, name))
+}
+
+func (d *dirDestination) Get(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(d.dir, name))
+}
+
+func (d *dirDestination) List(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(d.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), prefix) && !strings.HasSuffix(e.Name(), ".writing") {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
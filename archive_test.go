@@ -0,0 +1,122 @@
+package kv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qsymmachus/ranger"
+	"github.com/stretchr/testify/assert"
+)
+
+// Gets what a store holds after `Set(n%10, n)` for each `n` up to `revision`.
+func archivedState(revision int) map[int]int {
+	state := make(map[int]int)
+	for _, n := range ranger.Int(1, revision) {
+		state[n%10] = n
+	}
+	return state
+}
+
+// Waits until `dest` has a snapshot of `revision`.
+func awaitArchive(t *testing.T, dest BackupDestination, revision uint64) {
+	eventually(t, func() bool {
+		snapshots, _ := archivedRevisions(dest, archivedSnapshotPrefix)
+		return len(snapshots) > 0 && snapshots[len(snapshots)-1] == revision
+	})
+}
+
+func TestArchiveAndRestore(t *testing.T) {
+	defer removeLog()
+	dest := DirDestination(t.TempDir())
+
+	store, err := NewStore[int, int](LogPath(logPath), SegmentSize(256), ArchiveTo(dest, 10*time.Millisecond))
+	assert.NoError(t, err)
+	for _, n := range ranger.Int(1, 10) {
+		store.Set(n%10, n)
+	}
+	awaitArchive(t, dest, 10)
+	for _, n := range ranger.Int(11, 100) {
+		store.Set(n%10, n)
+	}
+	awaitArchive(t, dest, 100)
+	store.Close()
+
+	segments, _ := archivedRevisions(dest, archivedSegmentPrefix)
+	assert.Greater(t, len(segments), 1)
+
+	// The latest snapshot restores the store as it is now:
+	restored, err := RestoreArchive[int, int](dest, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, archivedState(100), restored.GetAll())
+	assert.Equal(t, uint64(100), restored.(*kvStore[int, int]).revision)
+	restored.Close()
+
+	// And earlier revisions are restored from the first snapshot and the log
+	// segments after it:
+	for _, revision := range []int{10, 37, 64} {
+		restored, err := RestoreArchive[int, int](dest, uint64(revision))
+		assert.NoError(t, err)
+		assert.Equal(t, archivedState(revision), restored.GetAll())
+		assert.Equal(t, uint64(revision), restored.(*kvStore[int, int]).revision)
+		restored.Close()
+	}
+
+	// A restored store is durable:
+	restored, err = RestoreArchive[int, int](dest, 64, LogPath(logPath+".restored"))
+	assert.NoError(t, err)
+	restored.Close()
+	defer os.Remove(logPath + ".restored")
+	replayed, _ := NewStore[int, int](LogPath(logPath + ".restored"))
+	assert.Equal(t, archivedState(64), replayed.GetAll())
+	replayed.Close()
+}
+
+func TestRestoreArchiveWithGap(t *testing.T) {
+	defer removeLog()
+	dir := t.TempDir()
+	dest := DirDestination(dir)
+
+	store, _ := NewStore[int, int](LogPath(logPath), SegmentSize(256), ArchiveTo(dest, 10*time.Millisecond))
+	for _, n := range ranger.Int(1, 10) {
+		store.Set(n%10, n)
+	}
+	awaitArchive(t, dest, 10)
+	for _, n := range ranger.Int(11, 100) {
+		store.Set(n%10, n)
+	}
+	awaitArchive(t, dest, 100)
+	store.Close()
+
+	// Without the segments after the first snapshot, the revisions after it
+	// can't be restored. Snapshots taken between the two batches of writes
+	// are removed too, so the first one is the latest before the revision:
+	snapshots, _ := archivedRevisions(dest, archivedSnapshotPrefix)
+	for _, revision := range snapshots[1 : len(snapshots)-1] {
+		os.Remove(filepath.Join(dir, archiveName(archivedSnapshotPrefix, revision)))
+	}
+	segments, _ := archivedRevisions(dest, archivedSegmentPrefix)
+	for _, first := range segments[:len(segments)-1] {
+		os.Remove(filepath.Join(dir, archiveName(archivedSegmentPrefix, first)))
+	}
+	_, err := RestoreArchive[int, int](dest, segments[len(segments)-1])
+	assert.Error(t, err)
+
+	// Nor can revisions that were never archived:
+	_, err = RestoreArchive[int, int](dest, 1000)
+	assert.Error(t, err)
+}
+
+func TestRestoreArchiveIntoNonEmptyStore(t *testing.T) {
+	defer removeLog()
+	dest := DirDestination(t.TempDir())
+
+	store, _ := NewStore[int, int](LogPath(logPath), ArchiveTo(dest, 10*time.Millisecond))
+	store.Set(1, 1)
+	awaitArchive(t, dest, 1)
+	store.Close()
+
+	_, err := RestoreArchive[int, int](dest, 0, LogPath(logPath))
+	assert.Error(t, err)
+}
@@ -0,0 +1,253 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/json"
+	"hash/maphash"
+	"iter"
+	"sync"
+	"unsafe"
+)
+
+// The size, in bytes, of the slabs an arena keeps its records in. Records
+// bigger than this get a slab of their own.
+const arenaSlabSize = 1 << 20
+
+// The location of a record in an arena: its key, then its value.
+type arenaRef struct {
+	slab     uint32
+	offset   uint32
+	keyLen   uint32
+	valueLen uint32
+}
+
+// Keeps a bucket's keys and encoded values in large byte slabs, indexed by a
+// hash of each key, so the garbage collector sees a few big slices and a map
+// without pointers instead of an object, or more, per pair. Keys are strings
+// or integers. String keys are kept as they are, and other keys and values as
+// JSON, like `fileStorage`.
+// Records are only ever appended, and the slabs are compacted once more than
+// half of their bytes belong to overwritten or removed pairs. `Get` reads it
+// while the update loop writes to it, so both are guarded by `mu`.
+type arenaStorage[K comparable, V any] struct {
+	mu    sync.RWMutex
+	slabs [][]byte
+	// The record of each key, by the hash of the key's encoding.
+	index map[uint64]arenaRef
+	// Keys whose hash is already the index's entry for another key. Empty
+	// unless hashes collide.
+	collided map[K]arenaRef
+	seed     maphash.Seed
+	// The bytes of records still in use, and of those that aren't.
+	live, dead int
+}
+
+func newArenaStorage[K comparable, V any]() *arenaStorage[K, V] {
+	return &arenaStorage[K, V]{
+		index:    make(map[uint64]arenaRef),
+		collided: make(map[K]arenaRef),
+		seed:     maphash.MakeSeed(),
+	}
+}
+
+// Encodes a key as it's kept in the arena. Plain string keys aren't copied.
+// Keys are strings or integers, so encoding them doesn't fail.
+func arenaKey[K comparable](key K) []byte {
+	if k, ok := any(key).(string); ok {
+		return unsafe.Slice(unsafe.StringData(k), len(k))
+	}
+
+	encoded, _ := json.Marshal(key)
+	return encoded
+}
+
+func (s *arenaStorage[K, V]) key(ref arenaRef) []byte {
+	return s.slabs[ref.slab][ref.offset : ref.offset+ref.keyLen]
+}
+
+func (s *arenaStorage[K, V]) value(ref arenaRef) []byte {
+	start := ref.offset + ref.keyLen
+	return s.slabs[ref.slab][start : start+ref.valueLen]
+}
+
+// Finds a key's record, and returns the key's encoding and its hash.
+func (s *arenaStorage[K, V]) lookup(key K) (encoded []byte, hash uint64, ref arenaRef, found bool) {
+	encoded = arenaKey(key)
+	hash = maphash.Bytes(s.seed, encoded)
+	if ref, found = s.index[hash]; found && bytes.Equal(s.key(ref), encoded) {
+		return encoded, hash, ref, true
+	}
+	if len(s.collided) > 0 {
+		ref, found = s.collided[key]
+		return encoded, hash, ref, found
+	}
+	return encoded, hash, arenaRef{}, false
+}
+
+// Decodes the value of a key. Values are only ever decoded from JSON that `put`
+// encoded, so decoding them doesn't fail.
+func (s *arenaStorage[K, V]) get(key K) (value V, found bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, _, ref, found := s.lookup(key)
+	if !found {
+		return value, false
+	}
+
+	json.Unmarshal(s.value(ref), &value)
+	return value, true
+}
+
+func (s *arenaStorage[K, V]) put(key K, value V) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	encodedKey, hash, old, found := s.lookup(key)
+	ref := s.write(encodedKey, encoded)
+	indexed, taken := s.index[hash]
+	switch {
+	case !taken || found && indexed == old:
+		s.index[hash] = ref
+	default:
+		s.collided[key] = ref
+	}
+	if found {
+		s.release(old)
+	}
+
+	s.compactIfSparse()
+	return nil
+}
+
+// Appends a record to the last slab, starting a new one if it doesn't fit.
+func (s *arenaStorage[K, V]) write(key []byte, value []byte) arenaRef {
+	size := len(key) + len(value)
+	last := len(s.slabs) - 1
+	if last < 0 || len(s.slabs[last])+size > cap(s.slabs[last]) {
+		s.slabs = append(s.slabs, make([]byte, 0, max(arenaSlabSize, size)))
+		last++
+	}
+
+	ref := arenaRef{slab: uint32(last), offset: uint32(len(s.slabs[last])), keyLen: uint32(len(key)), valueLen: uint32(len(value))}
+	s.slabs[last] = append(append(s.slabs[last], key...), value...)
+	s.live += size
+	return ref
+}
+
+// Counts a record's bytes as no longer in use.
+func (s *arenaStorage[K, V]) release(ref arenaRef) {
+	size := int(ref.keyLen + ref.valueLen)
+	s.live -= size
+	s.dead += size
+}
+
+func (s *arenaStorage[K, V]) remove(key K) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, hash, ref, found := s.lookup(key)
+	if !found {
+		return
+	}
+
+	if s.index[hash] == ref {
+		delete(s.index, hash)
+	} else {
+		delete(s.collided, key)
+	}
+	s.release(ref)
+	s.compactIfSparse()
+}
+
+// Copies every record still in use into new slabs, once most of the arena's
+// bytes are unused, so that the space of old values is given back. The caller
+// must hold `mu`.
+func (s *arenaStorage[K, V]) compactIfSparse() {
+	if s.dead < arenaSlabSize || s.dead < s.live {
+		return
+	}
+
+	old := s.slabs
+	s.slabs, s.live, s.dead = nil, 0, 0
+	copyRecord := func(ref arenaRef) arenaRef {
+		start := ref.offset
+		return s.write(old[ref.slab][start:start+ref.keyLen], old[ref.slab][start+ref.keyLen:start+ref.keyLen+ref.valueLen])
+	}
+	for hash, ref := range s.index {
+		s.index[hash] = copyRecord(ref)
+	}
+	for k, ref := range s.collided {
+		s.collided[k] = copyRecord(ref)
+	}
+}
+
+func (s *arenaStorage[K, V]) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.slabs, s.live, s.dead = nil, 0, 0
+	clear(s.index)
+	clear(s.collided)
+}
+
+func (s *arenaStorage[K, V]) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.index) + len(s.collided)
+}
+
+// Decodes the key of a record.
+func (s *arenaStorage[K, V]) decodeKey(ref arenaRef) K {
+	var key K
+	if k, ok := any(&key).(*string); ok {
+		*k = string(s.key(ref))
+	} else {
+		json.Unmarshal(s.key(ref), &key)
+	}
+	return key
+}
+
+// Visits the record of every key, with the key decoded. Only called by the
+// update loop, or while it's paused, so it doesn't need `mu`.
+func (s *arenaStorage[K, V]) records() iter.Seq2[K, arenaRef] {
+	return func(yield func(K, arenaRef) bool) {
+		for _, ref := range s.index {
+			if !yield(s.decodeKey(ref), ref) {
+				return
+			}
+		}
+		for k, ref := range s.collided {
+			if !yield(k, ref) {
+				return
+			}
+		}
+	}
+}
+
+func (s *arenaStorage[K, V]) keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for k := range s.records() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+func (s *arenaStorage[K, V]) all() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for k, ref := range s.records() {
+			var v V
+			json.Unmarshal(s.value(ref), &v)
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
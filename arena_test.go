@@ -0,0 +1,133 @@
+package kv
+
+import (
+	"fmt"
+	"hash/maphash"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArenaStorage(t *testing.T) {
+	defer removeLog()
+
+	store, err := NewStore[string, pet](ArenaStorage(), LogPath(logPath), Shards(4))
+	assert.NoError(t, err)
+	store.Set("toby", pet{"Toby", "dog"})
+	store.Set("rex", pet{"Rex", "cat"})
+	store.Set("toby", pet{"Toby", "cat"})
+	store.Unset("rex")
+
+	v, found := store.Get("toby")
+	assert.True(t, found)
+	assert.Equal(t, pet{"Toby", "cat"}, v)
+	_, found = store.Get("rex")
+	assert.False(t, found)
+	assert.Equal(t, map[string]pet{"toby": {"Toby", "cat"}}, store.GetAll())
+	assert.Equal(t, []string{"toby"}, store.Keys())
+	store.Close()
+
+	replayed, err := NewStore[string, pet](ArenaStorage(), LogPath(logPath))
+	assert.NoError(t, err)
+	defer replayed.Close()
+	assert.Equal(t, map[string]pet{"toby": {"Toby", "cat"}}, replayed.GetAll())
+
+	_, err = NewStore[float64, string](ArenaStorage())
+	assert.Error(t, err)
+	_, err = NewStore[string, string](ArenaStorage(), MmapStorage(mmapPath))
+	assert.Error(t, err)
+}
+
+func TestArenaStorageIntegerKeys(t *testing.T) {
+	store, _ := NewStore[int, int](ArenaStorage())
+	defer store.Close()
+
+	for i := range 100 {
+		store.Set(i, i*i)
+	}
+	v, found := store.Get(9)
+	assert.True(t, found)
+	assert.Equal(t, 81, v)
+	assert.Len(t, store.Keys(), 100)
+	assert.Equal(t, 100, store.Len())
+}
+
+func TestArenaStorageCompacts(t *testing.T) {
+	s := newArenaStorage[int, string]()
+
+	// Overwrite values until more than a slab's worth of them is unused:
+	value := strings.Repeat("x", 4096)
+	for i := 0; i < 4*arenaSlabSize/len(value); i++ {
+		s.put(i%16, fmt.Sprint(i, value))
+	}
+
+	assert.Less(t, s.dead, arenaSlabSize)
+	assert.LessOrEqual(t, len(s.slabs), 2)
+	assert.Equal(t, 16, s.len())
+	v, found := s.get(15)
+	assert.True(t, found)
+	assert.Equal(t, fmt.Sprint(4*arenaSlabSize/len(value)-1, value), v)
+}
+
+func TestArenaStorageCollisions(t *testing.T) {
+	s := newArenaStorage[string, string]()
+	s.put("a", "A")
+
+	// Have the index's entry for the hash of "b" be taken by "a", as if their
+	// hashes collided:
+	s.index[maphash.String(s.seed, "b")] = s.index[maphash.String(s.seed, "a")]
+	s.put("b", "B")
+	assert.Len(t, s.collided, 1)
+
+	v, _ := s.get("a")
+	assert.Equal(t, "A", v)
+	v, _ = s.get("b")
+	assert.Equal(t, "B", v)
+
+	s.remove("b")
+	_, found := s.get("b")
+	assert.False(t, found)
+	assert.Empty(t, s.collided)
+	v, _ = s.get("a")
+	assert.Equal(t, "A", v)
+}
+
+func TestArenaStorageConcurrentReads(t *testing.T) {
+	store, _ := NewStore[int, string](ArenaStorage())
+	defer store.Close()
+
+	// Gets carry on while sets and unsets compact the arena:
+	value := strings.Repeat("x", 16<<10)
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for range 2 {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				runtime.Gosched()
+				for k := range 16 {
+					if v, found := store.Get(k); found && !strings.HasSuffix(v, value) {
+						assert.Fail(t, "Got a corrupt value", k)
+					}
+				}
+			}
+		}()
+	}
+	for i := 0; i < 2*arenaSlabSize/len(value); i++ {
+		store.Set(i%16, fmt.Sprint(i, value))
+		if i%5 == 0 {
+			store.Unset((i + 8) % 16)
+		}
+	}
+	close(done)
+	readers.Wait()
+}
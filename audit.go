@@ -0,0 +1,152 @@
+package kv
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// A write made to the store, as it's recorded by an `Auditor`.
+type AuditEvent struct {
+	// When the write finished, or failed.
+	Time time.Time
+	// Who made it: "cert:" and the common name of the certificate a server's
+	// client authenticated with, "token:" and a fingerprint of the token it
+	// presented, "anonymous" for a client that did neither, or "local" for the
+	// program the store is embedded in.
+	Identity string
+	// The address of the server's client that made it, if one did.
+	RemoteAddr string `json:",omitzero"`
+	// The operation, named like it is in `Stats.Operations`.
+	Operation string
+	Namespace string `json:",omitzero"`
+	// The keys the write names, if it names any.
+	Keys []any `json:",omitzero"`
+	// The revision the write was assigned, or 0 if it wasn't applied, such as
+	// a `CompareAndSwap` whose condition wasn't met.
+	Revision uint64 `json:",omitzero"`
+	// The error the write failed with, if any.
+	Err string `json:",omitzero"`
+}
+
+// Records every write made to a store, once it has finished, to keep a trail
+// of who changed what, separately from the write-ahead log. `Audit` is called
+// by the goroutine that made the write, so it holds up the caller until it
+// returns; if it returns an error, the store logs a warning. An auditor that
+// implements `io.Closer` is closed when the store is.
+type Auditor interface {
+	Audit(event AuditEvent) error
+}
+
+// A function that implements `Auditor`.
+type AuditorFunc func(event AuditEvent) error
+
+func (f AuditorFunc) Audit(event AuditEvent) error {
+	return f(event)
+}
+
+// Returns an `Auditor` that appends each event to the file at `path` as a line
+// of JSON, creating it if it doesn't exist.
+func AuditFile(path string) (Auditor, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileAuditor{file: file}, nil
+}
+
+type fileAuditor struct {
+	// Keeps lines from concurrent writes apart.
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (a *fileAuditor) Audit(event AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.file.Write(append(line, '\n'))
+	return err
+}
+
+func (a *fileAuditor) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.file.Sync(); err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
+
+// A client of one of the store's servers, that a view of the store was made
+// for, so its writes are audited as the client's.
+type caller struct {
+	identity   string
+	remoteAddr string
+}
+
+// Identifies the client on a connection, by its certificate, if it has one,
+// and otherwise by the token it authenticated with.
+func callerOf(conn net.Conn, token string) *caller {
+	c := &caller{identity: "anonymous", remoteAddr: conn.RemoteAddr().String()}
+	if tlsConn, ok := conn.(*tls.Conn); ok && tlsConn.Handshake() == nil {
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			c.identity = "cert:" + certs[0].Subject.CommonName
+			return c
+		}
+	}
+	if token != "" {
+		sum := sha256.Sum256([]byte(token))
+		c.identity = "token:" + hex.EncodeToString(sum[:8])
+	}
+	return c
+}
+
+// Records a write that was made through this view, if the store is audited.
+func (s *kvStore[K, V]) audit(u update[K, V], result updateResult[V]) {
+	if s.options.auditor == nil {
+		return
+	}
+
+	event := AuditEvent{
+		Time:      time.Now(),
+		Identity:  "local",
+		Operation: operationNames[u.UpdateType],
+		Namespace: u.Namespace,
+		Revision:  result.revision,
+	}
+	if s.caller != nil {
+		event.Identity, event.RemoteAddr = s.caller.identity, s.caller.remoteAddr
+	}
+	switch u.UpdateType {
+	case set, unset, compareAndSwap, unsetIf, setIfNotExists, merge, increment, decrement, modify, getAndDelete, getAndSet, appendValue, pushFront, pushBack, popFront, addMembers, removeMembers, addScored, setFields, deleteFields:
+		event.Keys = []any{u.Key}
+	case rename, renameIfNotExists:
+		event.Keys = []any{u.Key, u.To}
+	case setMany, transaction:
+		for _, k := range u.Unsets {
+			event.Keys = append(event.Keys, k)
+		}
+		for _, e := range u.Entries {
+			event.Keys = append(event.Keys, e.Key)
+		}
+	}
+	if result.err != nil {
+		event.Err = result.err.Error()
+	}
+
+	if err := s.options.auditor.Audit(event); err != nil {
+		s.options.logger.Warn("Failed to audit write", "operation", event.Operation, "error", err)
+	}
+}
@@ -0,0 +1,118 @@
+package kv
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Collects the events a store audits.
+type auditTrail struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (a *auditTrail) Audit(event AuditEvent) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.events = append(a.events, event)
+	return nil
+}
+
+func (a *auditTrail) get() []AuditEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]AuditEvent(nil), a.events...)
+}
+
+func TestAudit(t *testing.T) {
+	trail := &auditTrail{}
+	store, err := NewStore[string, int](Audit(trail))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	store.Set("a", 1)
+	store.CompareAndSwap("a", 2, 3)
+	store.Namespace("users").SetMany(map[string]int{"b": 2})
+	store.Get("a")
+
+	events := trail.get()
+	assert.Len(t, events, 3)
+	assert.Equal(t, "set", events[0].Operation)
+	assert.Equal(t, "local", events[0].Identity)
+	assert.Equal(t, []any{"a"}, events[0].Keys)
+	assert.Equal(t, uint64(1), events[0].Revision)
+	assert.False(t, events[0].Time.IsZero())
+
+	// Writes that weren't applied are recorded too:
+	assert.Equal(t, "compare_and_swap", events[1].Operation)
+	assert.Zero(t, events[1].Revision)
+	assert.Equal(t, "set_many", events[2].Operation)
+	assert.Equal(t, "users", events[2].Namespace)
+	assert.Equal(t, []any{"b"}, events[2].Keys)
+
+	// So are writes that fail:
+	store.Close()
+	store.Unset("a")
+	events = trail.get()
+	assert.Equal(t, "unset", events[3].Operation)
+	assert.Equal(t, ErrClosed.Error(), events[3].Err)
+}
+
+func TestAuditMemcachedClient(t *testing.T) {
+	trail := &auditTrail{}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	store, err := NewStore[string, string](MemcachedListener(listener), Audit(trail))
+	assert.NoError(t, err)
+	defer store.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	fmt.Fprint(conn, "set name 0 0 5\r\nralph\r\n")
+	reply, _ := bufio.NewReader(conn).ReadString('\n')
+	assert.Equal(t, "STORED\r\n", reply)
+
+	events := trail.get()
+	assert.Len(t, events, 1)
+	assert.Equal(t, "anonymous", events[0].Identity)
+	assert.Equal(t, conn.LocalAddr().String(), events[0].RemoteAddr)
+}
+
+func TestAuditFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditor, err := AuditFile(path)
+	assert.NoError(t, err)
+	store, err := NewStore[string, int](Audit(auditor))
+	assert.NoError(t, err)
+	store.Set("a", 1)
+	store.Unset("a")
+	store.Close()
+
+	// Reopening it appends to it:
+	auditor, err = AuditFile(path)
+	assert.NoError(t, err)
+	store, err = NewStore[string, int](Audit(auditor))
+	assert.NoError(t, err)
+	store.Set("b", 2)
+	store.Close()
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var operations []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var event AuditEvent
+		assert.NoError(t, json.Unmarshal([]byte(line), &event))
+		operations = append(operations, event.Operation+" "+fmt.Sprint(event.Keys...))
+	}
+	assert.Equal(t, []string{"set a", "unset a", "set b"}, operations)
+}
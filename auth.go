@@ -0,0 +1,94 @@
+package kv
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/qsymmachus/kv/internal/wire"
+)
+
+// Returned to clients that don't present the token the store was given with
+// `AuthToken`.
+var ErrUnauthorized = errors.New("Client isn't authorized")
+
+// Wraps a listener the store serves clients on, so connections to it use TLS,
+// if the store was given `ServerTLS`.
+func (s *kvStore[K, V]) secure(listener net.Listener) net.Listener {
+	if s.options.serverTLS == nil {
+		return listener
+	}
+	return tls.NewListener(listener, s.options.serverTLS)
+}
+
+// Reports whether clients must authenticate: the store was given `AuthToken`
+// or `ACL`.
+func (s *kvStore[K, V]) requiresToken() bool {
+	return s.options.authToken != "" || s.options.acl != nil
+}
+
+// Gets what a client that presents `token` may do. Returns false if the store
+// doesn't accept the token. Tokens are compared in constant time.
+func (s *kvStore[K, V]) permissionsOf(token string) (permissions, bool) {
+	if !s.requiresToken() {
+		return permissions{unrestricted: true}, true
+	}
+
+	matches := func(accepted string) bool {
+		return accepted != "" && subtle.ConstantTimeCompare([]byte(token), []byte(accepted)) == 1
+	}
+	if matches(s.options.authToken) {
+		return permissions{unrestricted: true}, true
+	}
+	for accepted, grants := range s.options.acl {
+		if matches(accepted) {
+			return permissions{grants: grants}, true
+		}
+	}
+	return permissions{}, false
+}
+
+// Reads the request that a client must start its connection with, if the store
+// requires a token, and answers it. Returns what the client may do, and the
+// token it presented, or false if it didn't authenticate, after telling it so.
+func (s *kvStore[K, V]) authenticate(r *bufio.Reader, w *bufio.Writer) (perms permissions, token string, ok bool) {
+	if !s.requiresToken() {
+		return permissions{unrestricted: true}, "", true
+	}
+
+	var req wire.Request
+	if err := wire.ReadFrame(r, &req); err != nil {
+		return permissions{}, "", false
+	}
+	perms, ok = s.permissionsOf(req.Token)
+	res := wire.Response{}
+	if req.Op != wire.Authenticate || !ok {
+		res.Err = ErrUnauthorized.Error()
+	}
+	if wire.WriteFrame(w, res) != nil || w.Flush() != nil {
+		return permissions{}, "", false
+	}
+	return perms, req.Token, res.Err == ""
+}
+
+// Wraps an HTTP handler so it only answers requests with a token the store
+// accepts in their `Authorization` header, if it requires one.
+func (s *kvStore[K, V]) requireToken(handler http.Handler) http.Handler {
+	if !s.requiresToken() {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, ok := s.permissionsOf(token); !found || !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,31 @@
+package kv
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthAuthToken(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	store, err := NewStore[string, int](HealthListener(listener), AuthToken("secret"))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	check := func(token string) int {
+		req, _ := http.NewRequest("GET", "http://"+listener.Addr().String()+"/healthz", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	assert.Equal(t, http.StatusUnauthorized, check(""))
+	assert.Equal(t, http.StatusUnauthorized, check("wrong"))
+	assert.Equal(t, http.StatusOK, check("secret"))
+}
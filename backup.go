@@ -0,0 +1,77 @@
+package kv
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+func (s *kvStore[K, V]) Backup(w io.Writer) error {
+	data, err := s.snapshot()
+	if err != nil {
+		return err
+	}
+
+	entries := make([]entry[K, V], 0, len(data))
+	for k, v := range data {
+		entries = append(entries, entry[K, V]{Key: k, Value: v})
+	}
+
+	return writeEntries(w, entries)
+}
+
+func (s *kvStore[K, V]) Restore(r io.Reader) error {
+	entries, err := readEntries[K, V](r)
+	if err != nil {
+		return err
+	}
+
+	u := s.newUpdate(replaceAll, *new(K), *new(V))
+	u.Entries = entries
+	return s.write(u).err
+}
+
+// Copies every key/value pair in the namespace from a view of the store, so the
+// copy is consistent.
+func (s *kvStore[K, V]) snapshot() (map[K]V, error) {
+	data := make(map[K]V)
+	err := s.readView(nil, func(v view[K, V]) {
+		for _, values := range v.parts(s.namespace) {
+			for k, v := range values.all() {
+				data[k] = v
+			}
+		}
+	})
+
+	return data, err
+}
+
+// Writes key/value pairs as a stream of JSON entries, one per line. This is
+// the format of backups, and of the snapshots used by Raft.
+func writeEntries[K comparable, V any](w io.Writer, entries []entry[K, V]) error {
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	for _, e := range entries {
+		if err := encoder.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	return buffered.Flush()
+}
+
+// Reads key/value pairs written by `writeEntries`.
+func readEntries[K comparable, V any](r io.Reader) ([]entry[K, V], error) {
+	var entries []entry[K, V]
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	for {
+		e := entry[K, V]{}
+		if err := decoder.Decode(&e); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+}
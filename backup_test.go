@@ -0,0 +1,62 @@
+package kv
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/qsymmachus/ranger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	defer os.Remove(logPath)
+
+	original, _ := NewStore[string, int](Shards(4))
+	original.SetMany(map[string]int{"a": 1, "b": 2, "c": 3})
+
+	var backup bytes.Buffer
+	assert.NoError(t, original.Backup(&backup))
+
+	// Restoring replaces everything that was in the store:
+	restored, _ := NewStore[string, int](LogPath(logPath))
+	restored.Set("d", 4)
+	assert.NoError(t, restored.Restore(&backup))
+	assert.Equal(t, original.GetAll(), restored.GetAll())
+	restored.Close()
+
+	// And is durable:
+	replayed, _ := NewStore[string, int](LogPath(logPath))
+	assert.Equal(t, original.GetAll(), replayed.GetAll())
+	replayed.Close()
+}
+
+func TestRestoreLargeBackup(t *testing.T) {
+	defer os.Remove(logPath)
+
+	original, _ := NewStore[int, int]()
+	for _, n := range ranger.Int(1, 10000) {
+		original.Set(n, n)
+	}
+	var backup bytes.Buffer
+	original.Backup(&backup)
+
+	// The restore is logged as a single record, much larger than one value:
+	restored, _ := NewStore[int, int](LogPath(logPath))
+	assert.NoError(t, restored.Restore(&backup))
+	restored.Close()
+
+	replayed, err := NewStore[int, int](LogPath(logPath))
+	assert.NoError(t, err)
+	assert.Equal(t, 10000, replayed.Len())
+	replayed.Close()
+}
+
+func TestRestoreInvalidBackup(t *testing.T) {
+	store, _ := NewStore[string, int]()
+	store.Set("a", 1)
+
+	err := store.Restore(bytes.NewBufferString("not a backup"))
+	assert.Error(t, err)
+	assert.Equal(t, map[string]int{"a": 1}, store.GetAll())
+}
@@ -0,0 +1,164 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Starts a line of the log that refers to a record kept in a blob file, rather
+// than holding the record itself. It's followed by the blob's name, which is
+// the SHA-256 hash of its contents. No record starts with it, since records
+// are JSON or base64.
+const blobPrefix = "#kv-blob "
+
+// Returns the path of the directory that holds the log's blob files.
+func (l *writeAheadLog) blobDir() string {
+	return l.path + ".blobs"
+}
+
+// Moves an encoded record into a blob file if it's larger than the log's
+// `blobThreshold`, and returns the line that refers to it. Smaller records, and
+// those of logs that don't spill them, are returned as they are.
+func (l *writeAheadLog) spill(line []byte) ([]byte, error) {
+	if l.blobThreshold == 0 || l.path == "" || len(line) <= l.blobThreshold {
+		return line, nil
+	}
+
+	hash := sha256.Sum256(line)
+	name := hex.EncodeToString(hash[:])
+	path := filepath.Join(l.blobDir(), name)
+	if _, err := os.Stat(path); err != nil {
+		if err := l.writeBlob(path, line); err != nil {
+			return nil, err
+		}
+	}
+
+	return []byte(blobPrefix + name), nil
+}
+
+// Writes a blob file atomically, and syncs it and its directory, so it's on
+// disk before the line that refers to it is.
+func (l *writeAheadLog) writeBlob(path string, contents []byte) error {
+	if err := os.MkdirAll(l.blobDir(), 0700); err != nil {
+		return err
+	}
+
+	temp := path + ".writing"
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+
+	if _, err := file.Write(contents); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temp, path); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(l.blobDir())
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Reads the encoded record that a line of the log refers to. A blob that's
+// missing, or whose contents don't match its name, is corrupt.
+func (l *writeAheadLog) readBlob(line []byte) ([]byte, error) {
+	name := string(line[len(blobPrefix):])
+	contents, err := os.ReadFile(filepath.Join(l.blobDir(), filepath.Base(name)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptRecord, err)
+	}
+
+	hash := sha256.Sum256(contents)
+	if hex.EncodeToString(hash[:]) != name {
+		return nil, fmt.Errorf("%w: blob %s doesn't match its hash", ErrCorruptRecord, name)
+	}
+
+	return contents, nil
+}
+
+// Removes every blob file that no line of the log, or of its snapshot, refers
+// to any more. Called once records have been dropped from the log.
+func (l *writeAheadLog) removeUnusedBlobs() error {
+	blobs, err := os.ReadDir(l.blobDir())
+	if err != nil || len(blobs) == 0 {
+		return nil
+	}
+
+	files, err := l.files()
+	if err != nil {
+		return err
+	}
+	used := make(map[string]bool)
+	for _, path := range append(files, l.snapshotPath()) {
+		if err := blobReferences(path, used); err != nil {
+			return err
+		}
+	}
+
+	l.removals.Add(1)
+	for _, blob := range blobs {
+		if !used[blob.Name()] {
+			os.Remove(filepath.Join(l.blobDir(), blob.Name()))
+		}
+	}
+
+	return nil
+}
+
+// Adds the name of every blob that a file of the log refers to to `used`.
+func blobReferences(path string, used map[string]bool) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxRecordSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if !bytes.HasPrefix(line, []byte(blobPrefix)) {
+			continue
+		}
+		if tab := bytes.IndexByte(line, '\t'); tab >= 0 {
+			line = line[:tab]
+		}
+		used[string(line[len(blobPrefix):])] = true
+	}
+
+	return scanner.Err()
+}
+
+// Returns the total size of the log's blob files, in bytes.
+func (l *writeAheadLog) blobsSize() int64 {
+	blobs, _ := os.ReadDir(l.blobDir())
+	var size int64
+	for _, blob := range blobs {
+		if info, err := blob.Info(); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
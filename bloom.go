@@ -0,0 +1,64 @@
+package kv
+
+import (
+	"hash/maphash"
+	"math"
+)
+
+// A Bloom filter over keys: a set that can say for certain that a key was never
+// added, but only that one probably was. Keys can't be removed, so a removed key
+// is still reported as probably added until the filter is reset.
+type bloomFilter[K comparable] struct {
+	bits []uint64
+	// The number of bits set for each key.
+	hashes int
+	// Seeds the two hashes that each key's bits are derived from.
+	seeds [2]maphash.Seed
+}
+
+// Creates a filter sized to hold `keys` keys with the given false positive rate.
+func newBloomFilter[K comparable](keys int, falsePositiveRate float64) *bloomFilter[K] {
+	size := math.Ceil(-float64(keys) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := max(int(math.Round(size/float64(keys)*math.Ln2)), 1)
+
+	return &bloomFilter[K]{
+		bits:   make([]uint64, (int(size)+63)/64),
+		hashes: hashes,
+		seeds:  [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()},
+	}
+}
+
+func (f *bloomFilter[K]) add(key K) {
+	f.each(key, func(bit uint64) bool {
+		f.bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+}
+
+// Reports whether a key might have been added. If it's false, it never was.
+func (f *bloomFilter[K]) mayContain(key K) bool {
+	contains := true
+	f.each(key, func(bit uint64) bool {
+		contains = f.bits[bit/64]&(1<<(bit%64)) != 0
+		return contains
+	})
+
+	return contains
+}
+
+func (f *bloomFilter[K]) reset() {
+	clear(f.bits)
+}
+
+// Calls `fn` with each of a key's bits, until it returns false. The bits are
+// derived from two hashes of the key, as `h1 + i*h2`.
+func (f *bloomFilter[K]) each(key K, fn func(bit uint64) bool) {
+	size := uint64(len(f.bits) * 64)
+	h1 := maphash.Comparable(f.seeds[0], key)
+	h2 := maphash.Comparable(f.seeds[1], key) | 1
+	for i := range f.hashes {
+		if !fn((h1 + uint64(i)*h2) % size) {
+			return
+		}
+	}
+}
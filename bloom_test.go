@@ -0,0 +1,48 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloomFilter(t *testing.T) {
+	filter := newBloomFilter[int](1000, 0.01)
+	for i := range 1000 {
+		filter.add(i)
+	}
+
+	// Keys that were added are always found:
+	for i := range 1000 {
+		assert.True(t, filter.mayContain(i))
+	}
+
+	// Keys that weren't are rarely found:
+	falsePositives := 0
+	for i := 1000; i < 11000; i++ {
+		if filter.mayContain(i) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 300)
+
+	filter.reset()
+	assert.False(t, filter.mayContain(0))
+}
+
+func TestBloomFilterStore(t *testing.T) {
+	store, _ := NewStore[string, string](MmapStorage(mmapPath), BloomFilter(100, 0.01), Shards(2))
+	defer store.Close()
+
+	store.Set("name", "Toby")
+	v, found := store.Get("name")
+	assert.True(t, found)
+	assert.Equal(t, "Toby", v)
+	_, found = store.Get("food")
+	assert.False(t, found)
+
+	// Removed keys may still pass the filter, but aren't found:
+	store.Unset("name")
+	_, found = store.Get("name")
+	assert.False(t, found)
+}
@@ -0,0 +1,247 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// The kind of change made to the store.
+type ChangeType uint8
+
+const (
+	// A key was set to a value.
+	ChangeSet ChangeType = 0
+	// A key was unset.
+	ChangeUnset ChangeType = 1
+	// Every key in the namespace was removed, such as by `Restore`. The
+	// namespace's new keys follow as `ChangeSet` changes, with the same revision.
+	ChangeClear ChangeType = 2
+	// Every key in every namespace was removed, such as when a follower starts
+	// over from its leader's snapshot.
+	ChangeTruncate ChangeType = 3
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case ChangeSet:
+		return "set"
+	case ChangeUnset:
+		return "unset"
+	case ChangeClear:
+		return "clear"
+	case ChangeTruncate:
+		return "truncate"
+	default:
+		return "unknown"
+	}
+}
+
+func (t ChangeType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *ChangeType) UnmarshalText(text []byte) error {
+	for _, candidate := range []ChangeType{ChangeSet, ChangeUnset, ChangeClear, ChangeTruncate} {
+		if candidate.String() == string(text) {
+			*t = candidate
+			return nil
+		}
+	}
+
+	return errors.New("Unknown change type")
+}
+
+// A change committed to the store, as it's published to a `Publisher`.
+type Change[K comparable, V any] struct {
+	Type      ChangeType
+	Namespace string `json:",omitzero"`
+	// The key that was set or unset, and the value it was set to.
+	Key   K `json:",omitzero"`
+	Value V `json:",omitzero"`
+	// The revision of the update that made the change. Changes made by a single
+	// update, like `SetMany`, share its revision.
+	Revision uint64
+	// When the change was applied.
+	Time time.Time
+}
+
+// Receives every change committed to a store, in the order they're applied, to
+// send them on to another system, such as a message broker. If `Publish`
+// returns an error, the store retries it with exponential backoff, and doesn't
+// publish later changes until it succeeds. A publisher that implements
+// `io.Closer` is closed when the store is.
+type Publisher[K comparable, V any] interface {
+	Publish(change Change[K, V]) error
+}
+
+// A function that implements `Publisher`.
+type PublisherFunc[K comparable, V any] func(change Change[K, V]) error
+
+func (f PublisherFunc[K, V]) Publish(change Change[K, V]) error {
+	return f(change)
+}
+
+// How many changes can be waiting to be published before updates to the store
+// wait for the publisher to catch up.
+const changeBufferSize = 1024
+
+// How long the store waits before retrying a change that failed to publish, at
+// first and at most. The wait doubles with each failure.
+const (
+	minPublishBackoff = 10 * time.Millisecond
+	maxPublishBackoff = 10 * time.Second
+)
+
+// A publisher, and the changes waiting to be published to it.
+type publication[K comparable, V any] struct {
+	publisher Publisher[K, V]
+	changes   chan Change[K, V]
+}
+
+// Gets the publishers set by options, checking they're for the store's key and
+// value types.
+func newPublications[K comparable, V any](options *optionsData) ([]*publication[K, V], error) {
+	var publications []*publication[K, V]
+	for _, p := range options.publishers {
+		publisher, ok := p.(Publisher[K, V])
+		if !ok {
+			return nil, errors.New("Publisher doesn't match the store's key and value types")
+		}
+		publications = append(publications, &publication[K, V]{
+			publisher: publisher,
+			changes:   make(chan Change[K, V], changeBufferSize),
+		})
+	}
+
+	return publications, nil
+}
+
+// Starts publishing changes. Called once the log has been replayed, so changes
+// that were already published before the store was last closed aren't
+// published again.
+func (s *kvStore[K, V]) startPublishing() {
+	s.commit.Lock()
+	defer s.commit.Unlock()
+
+	for _, p := range s.publications {
+		s.background.Add(1)
+		go s.publish(p)
+	}
+	s.publishing = true
+}
+
+// Queues the changes an applied update made for every publisher. The caller
+// must hold `commit`, so changes are queued in the order they're applied.
+func (s *kvStore[K, V]) queueChanges(u update[K, V]) {
+	if !s.publishing || len(s.publications) == 0 && len(s.watchers) == 0 {
+		return
+	}
+
+	now := time.Now()
+	change := func(changeType ChangeType, key K, value V) Change[K, V] {
+		return Change[K, V]{Type: changeType, Namespace: u.Namespace, Key: key, Value: value, Revision: u.Revision, Time: now}
+	}
+
+	var changes []Change[K, V]
+	switch u.UpdateType {
+	case set:
+		changes = append(changes, change(ChangeSet, u.Key, u.Value))
+	case unset:
+		changes = append(changes, change(ChangeUnset, u.Key, *new(V)))
+	case rename:
+		changes = append(changes, change(ChangeUnset, u.Key, *new(V)), change(ChangeSet, u.To, u.Value))
+	case truncate:
+		changes = append(changes, Change[K, V]{Type: ChangeTruncate, Revision: u.Revision, Time: now})
+	case revokeLease:
+		for _, e := range u.Entries {
+			changes = append(changes, Change[K, V]{Type: ChangeUnset, Namespace: e.Namespace, Key: e.Key, Revision: u.Revision, Time: now})
+		}
+	case replaceAll, setMany, transaction:
+		if u.UpdateType == replaceAll {
+			changes = append(changes, change(ChangeClear, *new(K), *new(V)))
+		}
+		for _, k := range u.Unsets {
+			changes = append(changes, change(ChangeUnset, k, *new(V)))
+		}
+		for _, e := range u.Entries {
+			changes = append(changes, change(ChangeSet, e.Key, e.Value))
+		}
+	}
+
+	for _, p := range s.publications {
+		for _, c := range changes {
+			p.changes <- c
+		}
+	}
+	s.sendToWatchers(changes)
+}
+
+// Publishes changes as they're queued, until the store is closed and every
+// queued change has been published, or has failed to.
+func (s *kvStore[K, V]) publish(p *publication[K, V]) {
+	defer s.background.Done()
+	if closer, ok := p.publisher.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	for change := range p.changes {
+		// Once the store is closing, each change is only tried once:
+		backoff := minPublishBackoff
+		for {
+			err := p.publisher.Publish(change)
+			if err == nil {
+				break
+			}
+			s.options.logger.Warn("Failed to publish change", "revision", change.Revision, "error", err, "backoff", backoff)
+			if !s.sleep(backoff) {
+				break
+			}
+			backoff = min(backoff*2, maxPublishBackoff)
+		}
+	}
+}
+
+// Waits for `d` to pass, unless the store is closed first. Returns whether it
+// waited the whole time.
+func (s *kvStore[K, V]) sleep(d time.Duration) bool {
+	select {
+	case <-s.closing:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// Stops queueing changes, so each publisher stops once it has published the
+// changes already queued. Called once the update loops have stopped. The caller
+// must hold `commit`.
+func (s *kvStore[K, V]) stopPublishing() {
+	if !s.publishing {
+		return
+	}
+
+	s.publishing = false
+	for _, p := range s.publications {
+		close(p.changes)
+	}
+	for w := range s.watchers {
+		s.dropWatcher(w)
+	}
+}
+
+// Reports whether a key starts with `prefix`. Keys that aren't strings are
+// matched as they're formatted by `fmt.Sprint`.
+func keyHasPrefix[K comparable](key K, prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+
+	s, ok := any(key).(string)
+	if !ok {
+		s = fmt.Sprint(key)
+	}
+	return strings.HasPrefix(s, prefix)
+}
@@ -0,0 +1,147 @@
+package kv
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Collects the changes published to it.
+type changeRecorder[K comparable, V any] struct {
+	mu      sync.Mutex
+	changes []Change[K, V]
+}
+
+func (r *changeRecorder[K, V]) Publish(change Change[K, V]) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.changes = append(r.changes, change)
+	return nil
+}
+
+// Returns the changes published so far, without the times they were made.
+func (r *changeRecorder[K, V]) published() []Change[K, V] {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changes := make([]Change[K, V], len(r.changes))
+	for i, c := range r.changes {
+		c.Time = time.Time{}
+		changes[i] = c
+	}
+
+	return changes
+}
+
+func TestPublishChanges(t *testing.T) {
+	defer removeLog()
+
+	recorder := &changeRecorder[string, string]{}
+	store, err := NewStore[string, string](LogPath(logPath), PublishChanges[string, string](recorder))
+	assert.NoError(t, err)
+	store.Set("name", "ralph")
+	store.Unset("name")
+	store.SetMany(map[string]string{"food": "pizza"})
+	store.Namespace("users").Restore(strings.NewReader(`{"Key": "alice", "Value": "Alice"}`))
+	store.Close()
+
+	// Every change is published before the store finishes closing:
+	assert.Equal(t, []Change[string, string]{
+		{Type: ChangeSet, Key: "name", Value: "ralph", Revision: 1},
+		{Type: ChangeUnset, Key: "name", Revision: 2},
+		{Type: ChangeSet, Key: "food", Value: "pizza", Revision: 3},
+		{Type: ChangeClear, Namespace: "users", Revision: 4},
+		{Type: ChangeSet, Namespace: "users", Key: "alice", Value: "Alice", Revision: 4},
+	}, recorder.published())
+
+	// Changes replayed from the log aren't published again:
+	replayedRecorder := &changeRecorder[string, string]{}
+	replayed, _ := NewStore[string, string](LogPath(logPath), PublishChanges[string, string](replayedRecorder))
+	replayed.Set("name", "ziggy")
+	replayed.Close()
+	assert.Equal(t, []Change[string, string]{
+		{Type: ChangeSet, Key: "name", Value: "ziggy", Revision: 5},
+	}, replayedRecorder.published())
+}
+
+func TestPublishRetries(t *testing.T) {
+	var mu sync.Mutex
+	failures := 0
+	var published []string
+	publisher := PublisherFunc[string, string](func(change Change[string, string]) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures < 3 {
+			failures++
+			return errors.New("Broker is down")
+		}
+		published = append(published, change.Value)
+		return nil
+	})
+
+	store, _ := NewStore[string, string](PublishChanges[string, string](publisher))
+	store.Set("name", "ralph")
+	store.Set("name", "ziggy")
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(published) == 2
+	}, time.Second, 10*time.Millisecond)
+	store.Close()
+
+	// Changes are published in order, even after a failure:
+	assert.Equal(t, []string{"ralph", "ziggy"}, published)
+}
+
+func TestPublisherTypes(t *testing.T) {
+	_, err := NewStore[string, int](PublishChanges[string, string](&changeRecorder[string, string]{}))
+	assert.Error(t, err)
+}
+
+func TestNATSPublisher(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	// A NATS server that accepts one client, and sends on what it publishes:
+	messages := make(chan string)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprint(conn, "INFO {}\r\nPING\r\n")
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "PUB changes ") {
+				payload, _ := r.ReadString('\n')
+				messages <- strings.TrimSpace(payload)
+			}
+		}
+	}()
+
+	store, _ := NewStore[string, string](PublishChanges(NATSPublisher[string, string](listener.Addr().String(), "changes")))
+	defer store.Close()
+	store.Set("name", "ralph")
+
+	select {
+	case message := <-messages:
+		assert.Contains(t, message, `"Type":"set","Key":"name","Value":"ralph","Revision":1`)
+	case <-time.After(time.Second):
+		t.Fatal("Nothing was published")
+	}
+}
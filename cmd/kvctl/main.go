@@ -0,0 +1,154 @@
+// `kvctl` inspects and repairs a store's write-ahead log from the command line.
+//
+// Usage:
+//
+//	kvctl [flags] <log path> <command> [arguments]
+//
+// Commands:
+//
+//	get <key>            Prints the value of a key, as JSON.
+//	set <key> <value>    Sets a key.
+//	unset <key>          Unsets a key.
+//	keys                 Prints every key, one per line.
+//	search <regexp>      Prints the keys the regular expression matches, one per line.
+//	dump                 Prints every key/value pair, as JSON.
+//	compact              Rewrites the log so it only holds the store's current state.
+//
+// Keys and values are treated as strings, unless `-json` is given, in which
+// case they're parsed as JSON. Don't run `kvctl` against a log that a running
+// store has open.
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/qsymmachus/kv"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "kvctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// Parses the command line, opens the log, and runs the command against it.
+func run(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("kvctl", flag.ContinueOnError)
+	parseJSON := flags.Bool("json", false, "parse keys and values as JSON, rather than strings")
+	encryptionKey := flags.String("encryption-key", "", "hex-encoded key the log is encrypted with")
+	segmentSize := flags.Int64("segment-size", 0, "size in bytes of the log's segments, if it's segmented")
+	compress := flags.Bool("compress", false, "compress records that are written")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		return errors.New("Usage: kvctl [flags] <log path> <command> [arguments]")
+	}
+
+	options := []kv.Option{kv.LogPath(flags.Arg(0))}
+	if *encryptionKey != "" {
+		key, err := hex.DecodeString(*encryptionKey)
+		if err != nil {
+			return fmt.Errorf("Invalid encryption key: %w", err)
+		}
+		options = append(options, kv.EncryptionKey(key))
+	}
+	if *segmentSize > 0 {
+		options = append(options, kv.SegmentSize(*segmentSize))
+	}
+	if *compress {
+		options = append(options, kv.CompressLog())
+	}
+
+	// Values are kept as raw JSON, so they're written back exactly as they were
+	// read, whatever type the store that wrote them used:
+	store, err := kv.NewStore[any, json.RawMessage](options...)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	parse := func(arg string) (any, error) {
+		if !*parseJSON {
+			return arg, nil
+		}
+		var parsed any
+		err := json.Unmarshal([]byte(arg), &parsed)
+		return parsed, err
+	}
+	command, commandArgs := flags.Arg(1), flags.Args()[2:]
+
+	switch {
+	case command == "get" && len(commandArgs) == 1:
+		key, err := parse(commandArgs[0])
+		if err != nil {
+			return err
+		}
+		value, found := store.Get(key)
+		if !found {
+			return fmt.Errorf("Key not found: %s", commandArgs[0])
+		}
+		fmt.Fprintf(stdout, "%s\n", value)
+	case command == "set" && len(commandArgs) == 2:
+		key, err := parse(commandArgs[0])
+		if err != nil {
+			return err
+		}
+		value, err := parse(commandArgs[1])
+		if err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		_, err = store.Set(key, encoded)
+		return err
+	case command == "unset" && len(commandArgs) == 1:
+		key, err := parse(commandArgs[0])
+		if err != nil {
+			return err
+		}
+		_, err = store.Unset(key)
+		return err
+	case command == "keys" && len(commandArgs) == 0:
+		for _, key := range store.Keys() {
+			printKey(stdout, key, *parseJSON)
+		}
+	case command == "search" && len(commandArgs) == 1:
+		pattern, err := regexp.Compile(commandArgs[0])
+		if err != nil {
+			return err
+		}
+		for key := range store.SearchKeys(pattern) {
+			printKey(stdout, key, *parseJSON)
+		}
+	case command == "dump" && len(commandArgs) == 0:
+		return store.Export(stdout, kv.JSON)
+	case command == "compact" && len(commandArgs) == 0:
+		return store.Compact()
+	default:
+		return fmt.Errorf("Unknown command, or wrong number of arguments: %s", command)
+	}
+
+	return nil
+}
+
+// Prints a key on a line of its own: as it is if it's a string, unless `-json`
+// is given, or otherwise as JSON.
+func printKey(stdout io.Writer, key any, asJSON bool) {
+	if s, ok := key.(string); ok && !asJSON {
+		fmt.Fprintln(stdout, s)
+		return
+	}
+	encoded, _ := json.Marshal(key)
+	fmt.Fprintf(stdout, "%s\n", encoded)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/qsymmachus/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKvctl(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "kv.log")
+	kvctl := func(args ...string) (string, error) {
+		var stdout bytes.Buffer
+		err := run(append([]string{logPath}, args...), &stdout)
+		return stdout.String(), err
+	}
+
+	_, err := kvctl("set", "name", "ralph")
+	assert.NoError(t, err)
+	_, err = kvctl("set", "food", "pizza")
+	assert.NoError(t, err)
+	_, err = kvctl("unset", "food")
+	assert.NoError(t, err)
+
+	out, err := kvctl("get", "name")
+	assert.NoError(t, err)
+	assert.Equal(t, "\"ralph\"\n", out)
+	_, err = kvctl("get", "food")
+	assert.Error(t, err)
+
+	out, _ = kvctl("keys")
+	assert.Equal(t, "name\n", out)
+	out, _ = kvctl("search", "^na")
+	assert.Equal(t, "name\n", out)
+	out, _ = kvctl("search", "^food$")
+	assert.Equal(t, "", out)
+	_, err = kvctl("search", "(")
+	assert.Error(t, err)
+	out, _ = kvctl("dump")
+	assert.JSONEq(t, `[{"key": "name", "value": "ralph"}]`, out)
+
+	_, err = kvctl("compact")
+	assert.NoError(t, err)
+
+	// The log can still be read by a store with concrete types:
+	store, err := kv.NewStore[string, string](kv.LogPath(logPath))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"name": "ralph"}, store.GetAll())
+	store.Close()
+}
+
+func TestKvctlJSON(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "kv.log")
+	store, _ := kv.NewStore[int, []int](kv.LogPath(logPath))
+	store.Set(1, []int{1, 2, 3})
+	store.Close()
+
+	var stdout bytes.Buffer
+	assert.NoError(t, run([]string{"-json", logPath, "get", "1"}, &stdout))
+	assert.Equal(t, "[1,2,3]\n", stdout.String())
+
+	assert.NoError(t, run([]string{"-json", logPath, "set", "2", "[4, 5]"}, &stdout))
+	store, _ = kv.NewStore[int, []int](kv.LogPath(logPath))
+	v, _ := store.Get(2)
+	assert.Equal(t, []int{4, 5}, v)
+	store.Close()
+}
+
+func TestKvctlUsage(t *testing.T) {
+	assert.Error(t, run([]string{}, &bytes.Buffer{}))
+	assert.Error(t, run([]string{filepath.Join(t.TempDir(), "kv.log"), "explode"}, &bytes.Buffer{}))
+}
@@ -0,0 +1,174 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Encodes and decodes the update records that the store writes to its
+// write-ahead log and streams to followers.
+type Codec interface {
+	Encode(v any) ([]byte, error)
+	Decode(data []byte, v any) error
+}
+
+// Encodes records as JSON. This is the default codec, and the only one whose
+// records are written to the log as plain text.
+var JSONCodec Codec = jsonCodec{}
+
+// Encodes records with `encoding/gob`. Keys or values of interface types, such
+// as `any`, need their concrete types registered with `gob.Register`.
+var GobCodec Codec = gobCodec{}
+
+// Encodes records as MessagePack, which is more compact and faster to decode
+// than JSON.
+var MsgpackCodec Codec = msgpackCodec{}
+
+// Encodes records of stores of `[]byte` values with string keys, such as
+// `KVStore[string, []byte]`, in a compact binary format that writes values as
+// they are, rather than as base64 inside JSON. Updates other than sets and
+// unsets are encoded as MessagePack. Values set on the store aren't copied, and
+// gets return them as they were set, so they mustn't be changed afterwards.
+var BytesCodec Codec = bytesCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Decode(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Encode(v any) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (gobCodec) Decode(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Encode(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Decode(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// The first byte of each record written by `BytesCodec`, which says how the
+// rest of it is encoded.
+const (
+	bytesRecordMsgpack byte = 0
+	// The update's type, revision, namespace, key and value, in that order.
+	// The revision is a uvarint, and the others are prefixed with their length
+	// as one.
+	bytesRecordFrame byte = 1
+)
+
+type bytesCodec struct{}
+
+func (bytesCodec) Encode(v any) ([]byte, error) {
+	u, ok := v.(update[string, []byte])
+	if !ok || !isPlainWrite(u) {
+		record, err := msgpack.Marshal(v)
+		return append([]byte{bytesRecordMsgpack}, record...), err
+	}
+
+	record := make([]byte, 0, 1+4*binary.MaxVarintLen64+len(u.Namespace)+len(u.Key)+len(u.Value))
+	record = append(record, bytesRecordFrame)
+	record = binary.AppendUvarint(record, uint64(u.UpdateType))
+	record = binary.AppendUvarint(record, u.Revision)
+	for _, field := range [][]byte{[]byte(u.Namespace), []byte(u.Key), u.Value} {
+		record = binary.AppendUvarint(record, uint64(len(field)))
+		record = append(record, field...)
+	}
+
+	return record, nil
+}
+
+func (bytesCodec) Decode(data []byte, v any) error {
+	if len(data) == 0 {
+		return errors.New("Failed to decode record, it is empty")
+	}
+	if data[0] == bytesRecordMsgpack {
+		return msgpack.Unmarshal(data[1:], v)
+	}
+	u, ok := v.(*update[string, []byte])
+	if data[0] != bytesRecordFrame || !ok {
+		return errors.New("Failed to decode record, it wasn't written by BytesCodec")
+	}
+
+	truncated := errors.New("Failed to decode record, it is truncated")
+	data = data[1:]
+	var numbers [2]uint64
+	for i := range numbers {
+		number, n := binary.Uvarint(data)
+		if n <= 0 {
+			return truncated
+		}
+		numbers[i], data = number, data[n:]
+	}
+	var fields [3][]byte
+	for i := range fields {
+		length, n := binary.Uvarint(data)
+		if n <= 0 || length > uint64(len(data)-n) {
+			return truncated
+		}
+		fields[i], data = data[n:n+int(length)], data[n+int(length):]
+	}
+
+	// The record's buffer may be reused once it's decoded, so the value is
+	// copied out of it. Values keep their nil-ness, for unsets:
+	*u = update[string, []byte]{
+		UpdateType: updateType(numbers[0]),
+		Revision:   numbers[1],
+		Namespace:  string(fields[0]),
+		Key:        string(fields[1]),
+	}
+	if u.UpdateType == set {
+		u.Value = bytes.Clone(fields[2])
+	}
+	return nil
+}
+
+// Reports whether an update is a set or unset with nothing more to it than its
+// namespace, revision, key and value, which `BytesCodec` encodes as a frame.
+func isPlainWrite(u update[string, []byte]) bool {
+	return (u.UpdateType == set || u.UpdateType == unset) &&
+		u.To == "" && u.Expected == nil && u.Entries == nil && u.Unsets == nil &&
+		u.Lease == 0 && u.TTL == 0 && u.Timestamp == 0 && !u.Deleted &&
+		u.Node == "" && u.Clock == nil && u.Epoch == 0 && u.RaftIndex == 0 && u.Count == 0 && u.Fields == nil
+}
+
+// Encodes an update with the store's codec, reusing its value's encoding if
+// it's already been encoded.
+func (s *kvStore[K, V]) encodeUpdate(u update[K, V]) ([]byte, error) {
+	if u.encodedValue != nil {
+		return s.options.codec.Encode(preencodedUpdate[K, V]{u, u.encodedValue})
+	}
+
+	return s.options.codec.Encode(u)
+}
+
+// Decodes an update encoded with the store's codec.
+func (s *kvStore[K, V]) decodeUpdate(record []byte) (update[K, V], error) {
+	u := update[K, V]{}
+	err := s.options.codec.Decode(record, &u)
+	return u, err
+}
@@ -0,0 +1,122 @@
+package kv
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecs(t *testing.T) {
+	for name, codec := range map[string]Codec{"json": JSONCodec, "gob": GobCodec, "msgpack": MsgpackCodec} {
+		t.Run(name, func(t *testing.T) {
+			defer removeLog()
+
+			store, err := NewStore[string, pet](LogPath(logPath), LogCodec(codec))
+			assert.NoError(t, err)
+			store.Set("toby", pet{"Toby", "dog"})
+			store.Set("rex", pet{"Rex", "cat"})
+			store.Unset("rex")
+			store.SetMany(map[string]pet{"ziggy": {"Ziggy", "cat"}})
+			store.Close()
+
+			replayed, err := NewStore[string, pet](LogPath(logPath), LogCodec(codec))
+			assert.NoError(t, err)
+			defer replayed.Close()
+			assert.Equal(t, map[string]pet{"toby": {"Toby", "dog"}, "ziggy": {"Ziggy", "cat"}}, replayed.GetAll())
+			assert.Equal(t, ReplaySummary{Recovered: 4}, replayed.ReplaySummary())
+
+			v, _, err := replayed.GetAt("rex", 2)
+			assert.NoError(t, err)
+			assert.Equal(t, pet{"Rex", "cat"}, v)
+		})
+	}
+}
+
+func TestBinaryCodecLog(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath), LogCodec(GobCodec))
+	store.Set("name", "line one\nline two")
+	store.Close()
+
+	// Binary records are written as base64, one per line after the header:
+	written, _ := os.ReadFile(logPath)
+	lines := strings.Split(strings.TrimSuffix(string(written), "\n"), "\n")
+	assert.Len(t, lines, 2)
+	assert.NotEqual(t, byte('{'), lines[1][0])
+}
+
+func TestCodecReplication(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	leader, _ := NewStore[string, string](ReplicationListener(listener), LogCodec(MsgpackCodec))
+	defer leader.Close()
+	leader.Set("a", "a")
+
+	follower, err := NewStore[string, string](FollowerOf(listener.Addr().String()), LogCodec(MsgpackCodec))
+	assert.NoError(t, err)
+	defer follower.Close()
+
+	leader.Set("b", "b\nb")
+	eventually(t, func() bool {
+		v, _ := follower.Get("b")
+		return v == "b\nb" && follower.Len() == 2
+	})
+}
+
+func TestBytesCodec(t *testing.T) {
+	defer removeLog()
+
+	store, err := NewStore[string, []byte](LogPath(logPath), LogCodec(BytesCodec))
+	assert.NoError(t, err)
+	value := []byte("line one\nline two\x00")
+	store.Set("a", value)
+	store.Set("b", []byte{})
+	store.Set("c", []byte("c"))
+	store.Unset("c")
+	store.SetMany(map[string][]byte{"d": []byte("d")})
+
+	// Values aren't copied:
+	got, _ := store.Get("a")
+	assert.Same(t, &value[0], &got[0])
+	store.Close()
+
+	replayed, err := NewStore[string, []byte](LogPath(logPath), LogCodec(BytesCodec))
+	assert.NoError(t, err)
+	defer replayed.Close()
+	assert.Equal(t, map[string][]byte{"a": value, "b": {}, "d": []byte("d")}, replayed.GetAll())
+	assert.Equal(t, ReplaySummary{Recovered: 5}, replayed.ReplaySummary())
+
+	v, _, err := replayed.GetAt("c", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("c"), v)
+
+	_, err = NewStore[string, string](LogCodec(BytesCodec))
+	assert.Error(t, err)
+}
+
+func TestBytesCodecRecords(t *testing.T) {
+	// Plain sets are written as frames, and anything else as MessagePack:
+	record, err := BytesCodec.Encode(update[string, []byte]{UpdateType: set, Namespace: "ns", Revision: 7, Key: "k", Value: []byte("v")})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{bytesRecordFrame, byte(set), 7, 2, 'n', 's', 1, 'k', 1, 'v'}, record)
+
+	var u update[string, []byte]
+	assert.NoError(t, BytesCodec.Decode(record, &u))
+	assert.Equal(t, update[string, []byte]{UpdateType: set, Namespace: "ns", Revision: 7, Key: "k", Value: []byte("v")}, u)
+
+	leased := update[string, []byte]{UpdateType: set, Key: "k", Value: []byte("v"), Lease: 1}
+	record, err = BytesCodec.Encode(leased)
+	assert.NoError(t, err)
+	assert.Equal(t, bytesRecordMsgpack, record[0])
+	u = update[string, []byte]{}
+	assert.NoError(t, BytesCodec.Decode(record, &u))
+	assert.Equal(t, leased, u)
+
+	assert.Error(t, BytesCodec.Decode(record[:0], &u))
+	assert.Error(t, BytesCodec.Decode([]byte{bytesRecordFrame, byte(set), 7, 2, 'n'}, &u))
+}
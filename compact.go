@@ -0,0 +1,135 @@
+package kv
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+func (s *kvStore[K, V]) Compact() error {
+	if s.log == nil {
+		return errors.New("Cannot compact, store has no log")
+	}
+	if s.options.readOnly {
+		return ErrReadOnly
+	}
+
+	s.maintenance.Lock()
+	defer s.maintenance.Unlock()
+
+	// The compacted log starts from an empty store, at the current revision,
+	// so revisions carry on from it when the log is replayed. Only a view of the
+	// store is taken while updates are paused; it's encoded while they carry on:
+	var state storeState[K]
+	var updates []update[K, V]
+	var position logPosition
+	pauseErr := s.readView(func() {
+		s.commit.Lock()
+		defer s.commit.Unlock()
+		state = s.captureState()
+		position = s.log.position()
+	}, func(v view[K, V]) { updates = s.stateUpdates(state, v) })
+	if pauseErr != nil {
+		return pauseErr
+	}
+
+	records, err := s.encodeUpdates(updates)
+	if err != nil {
+		err = errors.New("Failed to encode update for the log")
+	} else {
+		// Records appended since the view was taken follow it in the
+		// compacted log:
+		s.commit.Lock()
+		var tail [][]byte
+		if tail, err = s.log.recordsSince(position); err == nil {
+			err = s.log.rewrite(append(records, tail...))
+		}
+		s.commit.Unlock()
+	}
+
+	if err != nil {
+		s.options.logger.Error("Failed to compact write-ahead log", "error", err)
+	} else {
+		s.options.logger.Info("Compacted write-ahead log", "revision", state.revision)
+	}
+	return err
+}
+
+// How the store's background compactions are going. Compactions in the
+// background write a snapshot of the store, then trim the records it covers
+// from the log, so they're taken for `AutoCompact`, `SnapshotEvery` and
+// `SnapshotInterval` alike. Returned in `Stats`.
+type CompactionStats struct {
+	// Whether a compaction is writing its snapshot.
+	Running bool
+	// How many bytes of its snapshot the running compaction has written.
+	Written int64
+	// The size, in bytes, of the snapshot the last compaction wrote, which the
+	// running one's is likely to be close to.
+	LastSize int64
+	// How many compactions have finished since the store opened.
+	Completed uint64
+}
+
+// How far background compactions have got. Read by `Stats` without holding
+// any lock.
+type compactionProgress struct {
+	running   atomic.Bool
+	written   atomic.Int64
+	lastSize  atomic.Int64
+	completed atomic.Uint64
+	// Closed once the store starts closing, so compactions stop pacing their
+	// writes and `Close` doesn't wait long on them.
+	unpaced chan struct{}
+}
+
+func (p *compactionProgress) stats() CompactionStats {
+	return CompactionStats{
+		Running:   p.running.Load(),
+		Written:   p.written.Load(),
+		LastSize:  p.lastSize.Load(),
+		Completed: p.completed.Load(),
+	}
+}
+
+// Paces the writes of a compaction to `rate` bytes a second, if it's positive,
+// and counts them towards its progress.
+type compactionWriter struct {
+	w        io.Writer
+	rate     int
+	started  time.Time
+	progress *compactionProgress
+}
+
+func (c *compactionWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	written := c.progress.written.Add(int64(n))
+	if c.rate <= 0 || err != nil {
+		return n, err
+	}
+
+	// Wait until the bytes written so far are due at the rate:
+	due := c.started.Add(time.Duration(float64(written) / float64(c.rate) * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-c.progress.unpaced:
+		}
+	}
+	return n, nil
+}
+
+// Asks for a background compaction once `AutoCompact` bytes have been appended
+// to the log since the last one. The caller must hold `commit`.
+func (s *kvStore[K, V]) countForCompaction() {
+	if s.options.autoCompact == 0 || s.log == nil {
+		return
+	}
+
+	if s.log.appended.Load()-s.appendedAtSnapshot >= uint64(s.options.autoCompact) {
+		s.requestSnapshot()
+	}
+}
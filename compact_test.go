@@ -0,0 +1,113 @@
+package kv
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qsymmachus/ranger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompact(t *testing.T) {
+	defer os.Remove(logPath)
+
+	store, _ := NewStore[int, int](LogPath(logPath))
+	for i := 0; i < 10; i++ {
+		for _, n := range ranger.Int(1, 100) {
+			store.Set(n, n*i)
+		}
+	}
+	before, _ := os.Stat(logPath)
+
+	assert.NoError(t, store.Compact())
+	after, _ := os.Stat(logPath)
+	assert.Less(t, after.Size(), before.Size()/5)
+
+	// The store keeps appending to the compacted log:
+	store.Set(1, 1000)
+	store.Close()
+
+	replayed, err := NewStore[int, int](LogPath(logPath))
+	assert.NoError(t, err)
+	assert.Equal(t, 100, replayed.Len())
+	v, _ := replayed.Get(1)
+	assert.Equal(t, 1000, v)
+	v, _ = replayed.Get(2)
+	assert.Equal(t, 18, v)
+	replayed.Close()
+}
+
+func TestCompactSegmentedLog(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[int, int](LogPath(logPath), SegmentSize(1024))
+	for i := 0; i < 10; i++ {
+		for _, n := range ranger.Int(1, 10) {
+			store.Set(n, n*i)
+		}
+	}
+	assert.NoError(t, store.Compact())
+	store.Close()
+
+	segments, _ := filepath.Glob(logPath + ".*")
+	assert.Len(t, segments, 1)
+
+	replayed, _ := NewStore[int, int](LogPath(logPath), SegmentSize(1024))
+	assert.Equal(t, 10, replayed.Len())
+	replayed.Close()
+}
+
+func TestCompactWhileWriting(t *testing.T) {
+	for _, segmentSize := range []int64{0, 1024} {
+		removeLog()
+		store, _ := NewStore[int, int](LogPath(logPath), SegmentSize(segmentSize), Shards(4))
+		entries := make(map[int]int)
+		for n := 1; n <= 20000; n++ {
+			entries[n] = 0
+		}
+		store.SetMany(entries)
+
+		// Writes made while the log is compacted are kept in it:
+		done := make(chan struct{})
+		last := make([]int, 4)
+		var writers sync.WaitGroup
+		for w := range last {
+			writers.Add(1)
+			go func() {
+				defer writers.Done()
+				for i := 1; ; i++ {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					store.Set(w+1, i)
+					last[w] = i
+				}
+			}()
+		}
+		time.Sleep(10 * time.Millisecond)
+		assert.NoError(t, store.Compact())
+		close(done)
+		writers.Wait()
+		store.Close()
+
+		replayed, err := NewStore[int, int](LogPath(logPath), SegmentSize(segmentSize))
+		assert.NoError(t, err)
+		for w, i := range last {
+			v, _ := replayed.Get(w + 1)
+			assert.Equal(t, i, v)
+		}
+		assert.Equal(t, 20000, replayed.Len())
+		replayed.Close()
+	}
+	removeLog()
+}
+
+func TestCompactWithoutLog(t *testing.T) {
+	store, _ := NewStore[int, int]()
+	assert.Error(t, store.Compact())
+}
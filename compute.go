@@ -0,0 +1,138 @@
+package kv
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A call to a `GetOrCompute` loader that's in progress. Concurrent callers for
+// the same key wait for it instead of calling the loader themselves.
+type computation[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// Tracks the loaders that are currently running, by key, so each one runs once.
+type computations[K comparable, V any] struct {
+	mu      sync.Mutex
+	pending map[K]*computation[V]
+}
+
+// Returns the computation in progress for a key, or starts a new one. `started`
+// is true if the caller is responsible for running it.
+func (c *computations[K, V]) start(key K) (call *computation[V], started bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if call, found := c.pending[key]; found {
+		return call, false
+	}
+
+	if c.pending == nil {
+		c.pending = make(map[K]*computation[V])
+	}
+	call = &computation[V]{done: make(chan struct{})}
+	c.pending[key] = call
+	return call, true
+}
+
+// Records the outcome of a computation and wakes up everyone waiting for it.
+func (c *computations[K, V]) finish(key K, call *computation[V], value V, err error) {
+	c.mu.Lock()
+	delete(c.pending, key)
+	c.mu.Unlock()
+
+	call.value, call.err = value, err
+	close(call.done)
+}
+
+func (s *kvStore[K, V]) GetOrCompute(key K, loader func() (V, error)) (value V, err error) {
+	return s.compute(key, loader, 0)
+}
+
+// Gets a value from the store, or calls `loader` to compute it and sets it,
+// attached to a new lease if `ttl` isn't 0. Concurrent calls for the same key
+// share a single call to `loader`, even if one is from `GetOrCompute` and
+// another from `Get` calling `Loader`.
+func (s *kvStore[K, V]) compute(key K, loader func() (V, error), ttl time.Duration) (value V, err error) {
+	if value, found := s.find(key); found {
+		return value, nil
+	}
+
+	pending := namespacedKey[K]{s.namespace, key}
+	call, started := s.computations.start(pending)
+	if !started {
+		<-call.done
+		return call.value, call.err
+	}
+
+	// Waiters are woken up even if `loader` panics, with an error, before the
+	// panic carries on:
+	defer func() {
+		if r := recover(); r != nil {
+			s.computations.finish(pending, call, *new(V), fmt.Errorf("Loader panicked: %v", r))
+			panic(r)
+		}
+		s.computations.finish(pending, call, value, err)
+	}()
+
+	// Another call may have finished between the `Get` above and starting this one:
+	if value, found := s.find(key); found {
+		return value, nil
+	}
+
+	value, err = loader()
+	if err == nil {
+		// Only store the computed value if nobody set the key while it was being
+		// computed. Otherwise, return the value they set:
+		u := s.newUpdate(setIfNotExists, key, value)
+		u.TTL = ttl
+		result := s.write(u)
+		if err = result.err; err == nil && !result.ok {
+			value = result.value
+		}
+	}
+	return value, err
+}
+
+func (s *kvStore[K, V]) Update(key K, fn func(old V, found bool) (V, error)) (value V, err error) {
+	// Functions can't be proposed to a cluster, so their results are swapped
+	// in instead:
+	if s.raft != nil {
+		for {
+			old, found := s.find(key)
+			if value, err = fn(old, found); err != nil {
+				return value, err
+			}
+
+			var swapped bool
+			if found {
+				swapped, err = s.CompareAndSwap(key, old, value)
+			} else {
+				swapped, err = s.SetIfNotExists(key, value)
+			}
+			if err != nil || swapped {
+				return value, err
+			}
+		}
+	}
+
+	u := s.newUpdate(modify, key, *new(V))
+	u.modify = fn
+	result := s.write(u)
+	return result.value, result.err
+}
+
+// Calls an `Update` function, turning a panic into an error, so it can't stop
+// the update loop it runs in.
+func callModify[V any](fn func(old V, found bool) (V, error), old V, found bool) (value V, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Update function panicked: %v", r)
+		}
+	}()
+
+	return fn(old, found)
+}
@@ -0,0 +1,238 @@
+package kv
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetOrCompute(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	store.Set("name", "Toby")
+
+	// Existing values are returned without calling the loader:
+	v, err := store.GetOrCompute("name", func() (string, error) {
+		t.Fatal("loader should not be called")
+		return "", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Toby", v)
+
+	// Missing values are computed and stored:
+	v, err = store.GetOrCompute("food", func() (string, error) { return "pizza", nil })
+	assert.NoError(t, err)
+	assert.Equal(t, "pizza", v)
+	v, _ = store.Get("food")
+	assert.Equal(t, "pizza", v)
+}
+
+func TestGetOrComputeError(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	failure := errors.New("failed")
+
+	_, err := store.GetOrCompute("name", func() (string, error) { return "", failure })
+	assert.ErrorIs(t, err, failure)
+	_, found := store.Get("name")
+	assert.False(t, found)
+}
+
+func TestGetOrComputeSingleFlight(t *testing.T) {
+	store, _ := NewStore[string, int]()
+	var calls int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := store.GetOrCompute("answer", func() (int, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(50 * time.Millisecond)
+				return 42, nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, 42, v)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls)
+}
+
+func TestGetOrComputePanic(t *testing.T) {
+	store, _ := NewStore[string, int]()
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		assert.PanicsWithValue(t, "failed", func() {
+			store.GetOrCompute("answer", func() (int, error) {
+				close(started)
+				<-release
+				panic("failed")
+			})
+		})
+	}()
+	<-started
+
+	// Callers waiting for a loader that panics get an error, rather than waiting
+	// forever:
+	waited := make(chan error)
+	go func() {
+		_, err := store.GetOrCompute("answer", func() (int, error) { return 42, nil })
+		waited <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	assert.EqualError(t, <-waited, "Loader panicked: failed")
+
+	// And a later call computes it again:
+	v, err := store.GetOrCompute("answer", func() (int, error) { return 42, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 42, v)
+}
+
+func TestLoader(t *testing.T) {
+	var calls atomic.Int32
+	failure := errors.New("failed")
+	store, err := NewStore[string, int](Loader(func(key string) (int, error) {
+		calls.Add(1)
+		if key == "missing" {
+			return 0, failure
+		}
+		time.Sleep(50 * time.Millisecond)
+		return len(key), nil
+	}), LoaderTTL(200*time.Millisecond))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	// Concurrent gets of a missing key share a single load:
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, found := store.Get("answer")
+			assert.True(t, found)
+			assert.Equal(t, 6, v)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+	store.Get("answer")
+	assert.Equal(t, int32(1), calls.Load())
+
+	// Nothing is set if the loader fails:
+	_, found := store.Get("missing")
+	assert.False(t, found)
+	assert.Equal(t, 1, store.Len())
+
+	// Loaded values expire, and are loaded again:
+	assert.Eventually(t, func() bool { return store.Len() == 0 }, time.Second, 10*time.Millisecond)
+	v, found := store.Get("answer")
+	assert.True(t, found)
+	assert.Equal(t, 6, v)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestLoaderInternalReads(t *testing.T) {
+	var calls atomic.Int32
+	store, err := NewStore[string, []string](Loader(func(key string) ([]string, error) {
+		calls.Add(1)
+		return []string{"loaded"}, nil
+	}))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	// Only `Get` loads missing keys; reads the store makes for its other
+	// methods don't:
+	values, err := store.LRange("list", 0, -1)
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+	members, err := store.SMembers("tags")
+	assert.NoError(t, err)
+	assert.Empty(t, members)
+	_, found, err := store.GetWithConsistency("key", ConsistencyOne)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, int32(0), calls.Load())
+	assert.Equal(t, 0, store.Len())
+}
+
+func TestLoaderOptions(t *testing.T) {
+	_, err := NewStore[string, int](Loader(func(key int) (int, error) { return key, nil }))
+	assert.Error(t, err)
+	_, err = NewStore[string, int](LoaderTTL(time.Second))
+	assert.Error(t, err)
+}
+
+func TestUpdate(t *testing.T) {
+	defer removeLog()
+
+	store, err := NewStore[string, []string](LogPath(logPath))
+	assert.NoError(t, err)
+	appendName := func(name string) func([]string, bool) ([]string, error) {
+		return func(old []string, found bool) ([]string, error) {
+			return append(append([]string(nil), old...), name), nil
+		}
+	}
+
+	value, err := store.Update("names", func(old []string, found bool) ([]string, error) {
+		assert.False(t, found)
+		return []string{"ralph"}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ralph"}, value)
+
+	// Concurrent updates each see the result of the one before:
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Update("names", appendName("ziggy"))
+		}()
+	}
+	wg.Wait()
+	value, _ = store.Get("names")
+	assert.Len(t, value, 51)
+
+	// Errors and panics leave the key as it was:
+	oops := errors.New("oops")
+	_, err = store.Update("names", func([]string, bool) ([]string, error) { return nil, oops })
+	assert.ErrorIs(t, err, oops)
+	_, err = store.Update("names", func([]string, bool) ([]string, error) { panic("oops") })
+	assert.ErrorContains(t, err, "panicked")
+	value, _ = store.Get("names")
+	assert.Len(t, value, 51)
+	store.Close()
+
+	replayed, err := NewStore[string, []string](LogPath(logPath))
+	assert.NoError(t, err)
+	defer replayed.Close()
+	value, _ = replayed.Get("names")
+	assert.Len(t, value, 51)
+}
+
+func TestUpdateRaft(t *testing.T) {
+	stores := newCluster(t, 3)
+	defer func() {
+		for _, store := range stores {
+			store.Close()
+		}
+	}()
+	setOnLeader(t, stores, "name", "ralph")
+
+	for _, store := range stores {
+		value, err := store.Update("name", func(old string, found bool) (string, error) {
+			return old + "!", nil
+		})
+		if err == nil {
+			assert.Equal(t, "ralph!", value)
+			return
+		}
+	}
+	t.Fatal("no node accepted the update")
+}
@@ -0,0 +1,104 @@
+package kv
+
+import (
+	"encoding/json"
+	"hash/fnv"
+)
+
+// How many buckets a `Digest` splits the key space into.
+const digestBuckets = 1024
+
+// A summary of a namespace's data, for finding which keys two stores disagree
+// on without sending every key. Keys are split into buckets by a hash of their
+// JSON encoding, and each bucket holds a hash of its key/value pairs, so two
+// stores with the same data have the same digest, whatever order it was written
+// in.
+type Digest [digestBuckets]uint64
+
+// Lists the buckets that differ between two digests.
+func (d Digest) Differences(other Digest) []int {
+	var buckets []int
+	for i := range d {
+		if d[i] != other[i] {
+			buckets = append(buckets, i)
+		}
+	}
+
+	return buckets
+}
+
+// Hashes a key/value pair, and picks the digest bucket its key belongs in.
+// Keys and values the store holds can always be encoded, since they were
+// written to the log, or could have been.
+func digestEntry[K comparable, V any](key K, value V) (bucket int, sum uint64) {
+	k, _ := json.Marshal(key)
+	v, _ := json.Marshal(value)
+
+	h := fnv.New64a()
+	h.Write(k)
+	bucket = int(h.Sum64() % digestBuckets)
+	h.Write([]byte{0})
+	h.Write(v)
+	return bucket, h.Sum64()
+}
+
+func (s *kvStore[K, V]) Digest() Digest {
+	data, _ := s.snapshot()
+
+	var d Digest
+	for k, v := range data {
+		bucket, sum := digestEntry(k, v)
+		// Adding the sums, rather than hashing them in turn, makes the order
+		// entries are visited in not matter:
+		d[bucket] += sum
+	}
+
+	return d
+}
+
+func (s *kvStore[K, V]) DigestEntries(buckets []int) map[K]V {
+	wanted := make(map[int]bool, len(buckets))
+	for _, b := range buckets {
+		wanted[b] = true
+	}
+
+	data, _ := s.snapshot()
+	for k, v := range data {
+		if bucket, _ := digestEntry(k, v); !wanted[bucket] {
+			delete(data, k)
+		}
+	}
+
+	return data
+}
+
+func (s *kvStore[K, V]) SyncWith(other KVStore[K, V]) error {
+	buckets := s.Digest().Differences(other.Digest())
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	ours := s.DigestEntries(buckets)
+	theirs := other.DigestEntries(buckets)
+	changed := make(map[K]V)
+	for k, v := range theirs {
+		if mine, found := ours[k]; !found || !equal(mine, v) {
+			changed[k] = v
+		}
+	}
+
+	if len(changed) > 0 {
+		if err := s.SetMany(changed); err != nil {
+			return err
+		}
+	}
+	for k := range ours {
+		if _, found := theirs[k]; !found {
+			if _, err := s.Unset(k); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDigest(t *testing.T) {
+	a, _ := NewStore[string, int]()
+	defer a.Close()
+	b, _ := NewStore[string, int]()
+	defer b.Close()
+
+	// Stores with the same data have the same digest, however it was written:
+	a.Set("one", 1)
+	a.Set("two", 2)
+	b.SetMany(map[string]int{"two": 2, "one": 1})
+	assert.Equal(t, a.Digest(), b.Digest())
+	assert.Empty(t, a.Digest().Differences(b.Digest()))
+
+	b.Set("two", 3)
+	differences := a.Digest().Differences(b.Digest())
+	assert.Len(t, differences, 1)
+	assert.Equal(t, map[string]int{"two": 2}, a.DigestEntries(differences))
+}
+
+func TestSyncWith(t *testing.T) {
+	replica, _ := NewStore[string, int]()
+	defer replica.Close()
+	primary, _ := NewStore[string, int]()
+	defer primary.Close()
+
+	for i, key := range []string{"a", "b", "c", "d", "e"} {
+		primary.Set(key, i)
+		replica.Set(key, i)
+	}
+	primary.Set("b", 10)
+	primary.Set("f", 5)
+	primary.Unset("c")
+	replica.Set("g", 6)
+
+	assert.NoError(t, replica.SyncWith(primary))
+	assert.Equal(t, primary.GetAll(), replica.GetAll())
+	assert.Equal(t, primary.Digest(), replica.Digest())
+
+	// Namespaces are synced separately:
+	primary.Namespace("users").Set("alice", 1)
+	assert.NoError(t, replica.SyncWith(primary))
+	assert.Equal(t, 0, replica.Namespace("users").Len())
+	assert.NoError(t, replica.Namespace("users").SyncWith(primary.Namespace("users")))
+	assert.Equal(t, 1, replica.Namespace("users").Len())
+}
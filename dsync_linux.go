@@ -0,0 +1,9 @@
+//go:build linux
+
+package kv
+
+import "syscall"
+
+// Opens a file so each write returns once its data, and whatever metadata is
+// needed to read it back, has reached the disk.
+const dsyncFlag = syscall.O_DSYNC
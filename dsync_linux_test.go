@@ -0,0 +1,33 @@
+//go:build linux
+
+package kv
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncWrites(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath), SyncWrites(), SegmentSize(256))
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		store.Set(key, "value")
+	}
+	assert.NoError(t, store.Sync())
+
+	// Segments started since the store opened are synced as they're written
+	// too:
+	log := store.(*kvStore[string, string]).log
+	assert.Greater(t, log.segment, 1)
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, log.file.Fd(), syscall.F_GETFL, 0)
+	assert.Zero(t, errno)
+	assert.NotZero(t, flags&syscall.O_DSYNC)
+	store.Close()
+
+	replayed, _ := NewStore[string, string](LogPath(logPath), SegmentSize(256))
+	defer replayed.Close()
+	assert.Equal(t, 8, replayed.Len())
+}
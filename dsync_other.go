@@ -0,0 +1,10 @@
+//go:build !linux
+
+package kv
+
+import "os"
+
+// Opens a file so each write returns once it has reached the disk. Not every
+// platform can leave out metadata that isn't needed to read the data back, so
+// elsewhere than Linux, every write syncs its metadata too.
+const dsyncFlag = os.O_SYNC
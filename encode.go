@@ -0,0 +1,74 @@
+package kv
+
+import "encoding/json"
+
+// An update whose value has already been encoded as JSON. It encodes to the
+// same JSON as the update itself, since its `Value` takes the place of the
+// update's.
+type preencodedUpdate[K comparable, V any] struct {
+	update[K, V]
+	Value json.RawMessage
+}
+
+// Runs the jobs of encoding updates' values, on one of the store's
+// `EncodeWorkers`, until the store is closed.
+func (s *kvStore[K, V]) encodeValues() {
+	defer s.background.Done()
+
+	for {
+		select {
+		case job := <-s.encoders:
+			job()
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// Encodes the values of the updates in a batch that will be logged as they
+// are, spread across the store's encoding workers, before the batch is
+// applied. Values are encoded while the shard holds no lock, so the update
+// loops of other shards can commit meanwhile. Updates keep their order, since
+// the batch is only applied once every value is encoded.
+//
+// Only the values of sets, and of the conditional updates that are logged as
+// sets of the value they were given, are encoded ahead. `OnBeforeSet` can
+// change any value, so none are if it's set. A value that fails to encode is
+// left for `applyBatch`, which fails its update as usual.
+func (s *kvStore[K, V]) preencode(batch []update[K, V]) {
+	if s.encoders == nil || s.hooks.beforeSet != nil || len(batch) < 2 {
+		return
+	}
+
+	done := make(chan struct{}, len(batch))
+	jobs := 0
+	for i := range batch {
+		u := &batch[i]
+		switch u.UpdateType {
+		case set, setIfNotExists, compareAndSwap, getAndSet:
+		default:
+			continue
+		}
+		if !u.append || u.Revision != 0 {
+			continue
+		}
+
+		job := func() {
+			if encoded, err := json.Marshal(u.Value); err == nil {
+				u.encodedValue = encoded
+			}
+			done <- struct{}{}
+		}
+		// If every worker is busy, the loop encodes the value itself:
+		select {
+		case s.encoders <- job:
+		default:
+			job()
+		}
+		jobs++
+	}
+
+	for range jobs {
+		<-done
+	}
+}
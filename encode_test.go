@@ -0,0 +1,60 @@
+package kv
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/qsymmachus/ranger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeWorkers(t *testing.T) {
+	defer removeLog()
+
+	store, err := NewStore[int, string](LogPath(logPath), EncodeWorkers(4))
+	assert.NoError(t, err)
+	value := strings.Repeat("x", 10_000)
+	var results []<-chan error
+	for _, n := range ranger.Int(1, 1000) {
+		results = append(results, store.SetAsync(n%100, value+string(rune('a'+n%26))))
+	}
+	for _, result := range results {
+		assert.NoError(t, <-result)
+	}
+	current, _ := store.Get(1)
+	swapped, _ := store.CompareAndSwap(1, current, "swapped")
+	assert.True(t, swapped)
+	want := store.GetAll()
+	store.Close()
+
+	// Updates were logged in the order they were applied:
+	replayed, _ := NewStore[int, string](LogPath(logPath))
+	defer replayed.Close()
+	assert.Equal(t, want, replayed.GetAll())
+
+	_, err = NewStore[int, string](EncodeWorkers(4), LogCodec(MsgpackCodec))
+	assert.Error(t, err)
+}
+
+func TestPreencode(t *testing.T) {
+	store, _ := NewStore[string, pet](EncodeWorkers(2))
+	defer store.Close()
+	s := store.(*kvStore[string, pet])
+
+	batch := []update[string, pet]{
+		{UpdateType: set, Key: "toby", Value: pet{"Toby", "dog"}, append: true},
+		{UpdateType: increment, Key: "rex", append: true},
+		{UpdateType: set, Key: "rex", Value: pet{"Rex", "cat"}, Revision: 7},
+	}
+	s.preencode(batch)
+	assert.JSONEq(t, `{"Name":"Toby","Species":"dog"}`, string(batch[0].encodedValue))
+	assert.Nil(t, batch[1].encodedValue)
+	assert.Nil(t, batch[2].encodedValue)
+
+	// The update's record is the same either way:
+	batch[0].Revision = 1
+	preencoded, _ := s.encodeUpdate(batch[0])
+	batch[0].encodedValue = nil
+	encoded, _ := s.encodeUpdate(batch[0])
+	assert.Equal(t, string(encoded), string(preencoded))
+}
@@ -0,0 +1,163 @@
+package kv
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// A file format the store can export data to and import data from.
+type Format uint8
+
+const (
+	// A JSON array of objects, each with a "key" and a "value".
+	JSON Format = 0
+	// CSV with a header row, and a row for each key/value pair. String keys and
+	// values are written as they are; anything else is written as JSON.
+	CSV Format = 1
+	// A Redis RDB file, as written by `SAVE` or `BGSAVE`. Only `Import` reads
+	// it, loading the string keys of database 0 that haven't expired, without
+	// their expiry times, and skipping keys of other types.
+	RedisRDB Format = 2
+	// A Redis AOF file, including one with an RDB preamble. Only `Import` reads
+	// it, replaying the commands that write string keys in database 0, like
+	// `SET`, `MSET`, `APPEND` and `DEL`.
+	RedisAOF Format = 3
+)
+
+// Returned by `Export` and `Import` for a format they don't support.
+var ErrUnknownFormat = errors.New("Unknown format")
+
+// A key/value pair as it's written by `Export` in JSON.
+type exportedEntry[K comparable, V any] struct {
+	Key   K `json:"key"`
+	Value V `json:"value"`
+}
+
+func (s *kvStore[K, V]) Export(w io.Writer, format Format) error {
+	data, err := s.snapshot()
+	if err != nil {
+		return err
+	}
+
+	// Sort entries by key, so exports of the same data are identical:
+	entries := make([]exportedEntry[K, V], 0, len(data))
+	sortKeys := make(map[K]string, len(data))
+	for k, v := range data {
+		if sortKeys[k], err = csvField(k); err != nil {
+			return err
+		}
+		entries = append(entries, exportedEntry[K, V]{k, v})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return sortKeys[entries[i].Key] < sortKeys[entries[j].Key]
+	})
+
+	switch format {
+	case JSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	case CSV:
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"key", "value"})
+		for _, e := range entries {
+			value, err := csvField(e.Value)
+			if err != nil {
+				return err
+			}
+			writer.Write([]string{sortKeys[e.Key], value})
+		}
+		writer.Flush()
+		return writer.Error()
+	case RedisRDB, RedisAOF:
+		return fmt.Errorf("%w: can only import Redis files", ErrUnknownFormat)
+	default:
+		return fmt.Errorf("%w: %d", ErrUnknownFormat, format)
+	}
+}
+
+func (s *kvStore[K, V]) Import(r io.Reader, format Format) error {
+	data := make(map[K]V)
+
+	switch format {
+	case JSON:
+		var entries []exportedEntry[K, V]
+		if err := json.NewDecoder(r).Decode(&entries); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			data[e.Key] = e.Value
+		}
+	case CSV:
+		rows, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return err
+		}
+		for i, row := range rows {
+			if i == 0 || len(row) != 2 {
+				continue
+			}
+
+			var k K
+			var v V
+			if err := parseCSVField(row[0], &k); err != nil {
+				return fmt.Errorf("Failed to parse key on row %d: %w", i+1, err)
+			}
+			if err := parseCSVField(row[1], &v); err != nil {
+				return fmt.Errorf("Failed to parse value on row %d: %w", i+1, err)
+			}
+			data[k] = v
+		}
+	case RedisRDB, RedisAOF:
+		strings := make(map[string]string)
+		read := readRedisRDB
+		if format == RedisAOF {
+			read = readRedisAOF
+		}
+		if err := read(bufio.NewReader(r), strings); err != nil {
+			return err
+		}
+
+		// Keys and values are parsed like CSV fields:
+		for key, value := range strings {
+			var k K
+			var v V
+			if err := parseCSVField(key, &k); err != nil {
+				return fmt.Errorf("Failed to parse key %q: %w", key, err)
+			}
+			if err := parseCSVField(value, &v); err != nil {
+				return fmt.Errorf("Failed to parse value of key %q: %w", key, err)
+			}
+			data[k] = v
+		}
+	default:
+		return fmt.Errorf("%w: %d", ErrUnknownFormat, format)
+	}
+
+	return s.SetMany(data)
+}
+
+// Formats a key or value as a CSV field.
+func csvField(value any) (string, error) {
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+// Parses a CSV field written by `csvField` into `target`.
+func parseCSVField(field string, target any) error {
+	if s, ok := target.(*string); ok {
+		*s = field
+		return nil
+	}
+
+	return json.Unmarshal([]byte(field), target)
+}
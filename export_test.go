@@ -0,0 +1,57 @@
+package kv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportAndImportJSON(t *testing.T) {
+	original, _ := NewStore[string, int]()
+	original.SetMany(map[string]int{"a": 1, "b": 2})
+
+	var exported bytes.Buffer
+	assert.NoError(t, original.Export(&exported, JSON))
+	assert.JSONEq(t, `[{"key": "a", "value": 1}, {"key": "b", "value": 2}]`, exported.String())
+
+	imported, _ := NewStore[string, int]()
+	imported.Set("c", 3)
+	assert.NoError(t, imported.Import(&exported, JSON))
+	assert.Equal(t, map[string]int{"a": 1, "b": 2, "c": 3}, imported.GetAll())
+}
+
+func TestExportAndImportCSV(t *testing.T) {
+	original, _ := NewStore[string, pet]()
+	original.Set("toby", pet{"Toby", "dog"})
+	original.Set("ziggy", pet{"Ziggy", "cat"})
+
+	var exported bytes.Buffer
+	assert.NoError(t, original.Export(&exported, CSV))
+	assert.Equal(t, strings.Join([]string{
+		`key,value`,
+		`toby,"{""Name"":""Toby"",""Species"":""dog""}"`,
+		`ziggy,"{""Name"":""Ziggy"",""Species"":""cat""}"`,
+		``,
+	}, "\n"), exported.String())
+
+	imported, _ := NewStore[string, pet]()
+	assert.NoError(t, imported.Import(&exported, CSV))
+	assert.Equal(t, original.GetAll(), imported.GetAll())
+}
+
+func TestImportInvalidCSV(t *testing.T) {
+	store, _ := NewStore[int, int]()
+
+	err := store.Import(strings.NewReader("key,value\nnot a number,1\n"), CSV)
+	assert.Error(t, err)
+	assert.Equal(t, 0, store.Len())
+}
+
+func TestUnknownFormat(t *testing.T) {
+	store, _ := NewStore[int, int]()
+
+	assert.ErrorIs(t, store.Export(&bytes.Buffer{}, Format(99)), ErrUnknownFormat)
+	assert.ErrorIs(t, store.Import(&bytes.Buffer{}, Format(99)), ErrUnknownFormat)
+}
@@ -0,0 +1,86 @@
+package kv
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+// The stores published with `PublishExpvar`, by name. Variables can't be
+// removed from `expvar`, so each name is published once, and reports whichever
+// store has it now, or nothing once that store is closed.
+var (
+	expvarsMu sync.Mutex
+	expvars   = make(map[string]*expvarStore)
+)
+
+// A store that publishes its statistics, identified by its core, which every
+// namespace of it shares.
+type expvarStore struct {
+	core  any
+	stats func() Stats
+}
+
+// Option that publishes the store's statistics as an `expvar` variable named
+// `name`, served as JSON at "/debug/vars" by `expvar`'s handler, for
+// deployments that don't need Prometheus: the number of sets, unsets and gets
+// made, how many gets missed, the size of the write-ahead log on disk in
+// bytes, how many updates are queued, and how many keys the store holds. Only
+// one open store can use a name at a time; once it's closed, the name reports
+// `null` until another store opens with it.
+func PublishExpvar(name string) Option {
+	return func(optsData *optionsData) {
+		optsData.expvarName = name
+	}
+}
+
+// Publishes the store's statistics under `name`, unless another open store
+// has it.
+func publishExpvar(name string, store *expvarStore) error {
+	expvarsMu.Lock()
+	defer expvarsMu.Unlock()
+
+	if current, found := expvars[name]; found && current != nil {
+		return fmt.Errorf("Another store already publishes the expvar %q", name)
+	}
+	if _, found := expvars[name]; !found {
+		if expvar.Get(name) != nil {
+			return fmt.Errorf("The expvar %q is already published", name)
+		}
+		expvar.Publish(name, expvar.Func(func() any { return expvarValue(name) }))
+	}
+
+	expvars[name] = store
+	return nil
+}
+
+// Stops reporting a closed store's statistics under `name`, if it has it.
+func unpublishExpvar(name string, core any) {
+	expvarsMu.Lock()
+	defer expvarsMu.Unlock()
+
+	if current := expvars[name]; current != nil && current.core == core {
+		expvars[name] = nil
+	}
+}
+
+// Gets the value of the variable `name`.
+func expvarValue(name string) any {
+	expvarsMu.Lock()
+	store := expvars[name]
+	expvarsMu.Unlock()
+	if store == nil {
+		return nil
+	}
+
+	s := store.stats()
+	return map[string]any{
+		"sets":         s.Operations["set"].Count,
+		"unsets":       s.Operations["unset"].Count,
+		"gets":         s.Operations["get"].Count,
+		"misses":       s.Misses,
+		"log_size":     s.LogSize,
+		"queue_length": s.QueueDepth,
+		"keys":         s.Keys,
+	}
+}
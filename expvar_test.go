@@ -0,0 +1,47 @@
+package kv
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Gets the value of a published expvar, decoded from JSON.
+func expvarJSON(name string) map[string]any {
+	var value map[string]any
+	json.Unmarshal([]byte(expvar.Get(name).String()), &value)
+	return value
+}
+
+func TestPublishExpvar(t *testing.T) {
+	defer removeLog()
+
+	store, err := NewStore[string, int](LogPath(logPath), PublishExpvar("kv_test"))
+	assert.NoError(t, err)
+	store.Set("a", 1)
+	store.Get("a")
+	store.Get("b")
+
+	value := expvarJSON("kv_test")
+	assert.Equal(t, 1.0, value["sets"])
+	assert.Equal(t, 2.0, value["gets"])
+	assert.Equal(t, 1.0, value["misses"])
+	assert.Equal(t, 1.0, value["keys"])
+	assert.Greater(t, value["log_size"], 0.0)
+
+	// Only one open store can use a name:
+	_, err = NewStore[string, int](PublishExpvar("kv_test"))
+	assert.Error(t, err)
+	assert.Equal(t, 1.0, expvarJSON("kv_test")["sets"])
+
+	// Once it's closed, another can:
+	store.Close()
+	assert.Equal(t, "null", expvar.Get("kv_test").String())
+	reopened, err := NewStore[string, int](LogPath(logPath), PublishExpvar("kv_test"))
+	assert.NoError(t, err)
+	defer reopened.Close()
+	assert.Equal(t, 0.0, expvarJSON("kv_test")["sets"])
+	assert.Equal(t, 1.0, expvarJSON("kv_test")["keys"])
+}
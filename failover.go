@@ -0,0 +1,113 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Returned by writes to a leader that a follower has been promoted over, so
+// writes made to it by clients that haven't failed over yet don't diverge from
+// the new leader.
+var ErrFenced = errors.New("Store was a replication leader, and has been replaced by a promoted follower")
+
+func (s *kvStore[K, V]) Promote(replicationAddr string) error {
+	if !s.following.Load() {
+		return errors.New("Cannot promote a store that isn't a replication follower")
+	}
+
+	var listener net.Listener
+	if replicationAddr != "" {
+		var err error
+		if listener, err = net.Listen("tcp", replicationAddr); err != nil {
+			return err
+		}
+	}
+
+	// Stop applying the old leader's stream, and fence it, before accepting
+	// writes:
+	epoch := s.currentEpoch() + 1
+	if !s.upstream.stop(epoch) {
+		if listener != nil {
+			listener.Close()
+		}
+		return errors.New("Store has already been promoted")
+	}
+	s.following.Store(false)
+	u := update[K, V]{UpdateType: promote, Epoch: epoch, append: s.appends()}
+	if err := s.queueUpdate(u).err; err != nil {
+		if listener != nil {
+			listener.Close()
+		}
+		return err
+	}
+
+	s.commit.Lock()
+	defer s.commit.Unlock()
+	select {
+	case <-s.closing:
+		if listener != nil {
+			listener.Close()
+		}
+		return ErrClosed
+	default:
+	}
+
+	if !s.options.readOnly {
+		s.background.Add(1)
+		go s.expireLeases()
+	}
+	if listener != nil {
+		s.listener = listener
+		go s.acceptReplicas()
+	}
+	return nil
+}
+
+// Gets the store's epoch, which starts at 0, and is one higher each time a
+// follower of its lineage is promoted.
+func (s *kvStore[K, V]) currentEpoch() uint64 {
+	s.commit.Lock()
+	defer s.commit.Unlock()
+
+	return s.epoch
+}
+
+// Handles a promoted follower's request to fence the store, its old leader.
+// If the follower's epoch is newer than the store's, the store stops
+// accepting writes and followers, and drops the followers it has, so none of
+// them carry on from it. The caller must hold `commit`.
+func (s *kvStore[K, V]) fence(line string) {
+	epoch, err := strconv.ParseUint(strings.TrimPrefix(line, "fence "), 10, 64)
+	if err != nil || epoch <= s.epoch {
+		return
+	}
+
+	s.fenced.Store(true)
+	s.dropReplicas()
+}
+
+// Stops following the leader, fencing it with the new epoch if it's still
+// connected, and waits until the follower has stopped applying its stream.
+// Returns false if it had already stopped.
+func (u *upstream) stop(epoch uint64) bool {
+	u.mu.Lock()
+	select {
+	case <-u.stopped:
+		u.mu.Unlock()
+		return false
+	default:
+	}
+
+	close(u.stopped)
+	if u.conn != nil {
+		fmt.Fprintf(u.conn, "fence %d\n", epoch)
+		u.conn.Close()
+	}
+	u.mu.Unlock()
+
+	<-u.done
+	return true
+}
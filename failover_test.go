@@ -0,0 +1,78 @@
+package kv
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromote(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	leader, _ := NewStore[string, string](ReplicationListener(listener))
+	defer leader.Close()
+	follower, _ := NewStore[string, string](FollowerOf(listener.Addr().String()))
+	defer follower.Close()
+	_, err = leader.SetWithConsistency("name", "ralph", ConsistencyAll)
+	assert.NoError(t, err)
+	eventually(t, func() bool {
+		_, found, _ := follower.GetWithConsistency("name", ConsistencyQuorum)
+		return found
+	})
+
+	// The promoted follower accepts writes, and followers of its own:
+	assert.NoError(t, follower.Promote("127.0.0.1:0"))
+	assert.Error(t, follower.Promote(""))
+	_, err = follower.Set("name", "ziggy")
+	assert.NoError(t, err)
+
+	addr := follower.(*kvStore[string, string]).listener.Addr().String()
+	second, _ := NewStore[string, string](FollowerOf(addr))
+	defer second.Close()
+	eventually(t, func() bool {
+		v, _, err := second.GetWithConsistency("name", ConsistencyQuorum)
+		return err == nil && v == "ziggy"
+	})
+
+	// The old leader is fenced, so it doesn't diverge from the new one:
+	eventually(t, func() bool {
+		_, err := leader.Set("name", "toby")
+		return err == ErrFenced
+	})
+	v, _ := follower.Get("name")
+	assert.Equal(t, "ziggy", v)
+}
+
+func TestFollowersRejectFencedLeaders(t *testing.T) {
+	defer removeLog()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	old, _ := NewStore[string, string](ReplicationListener(listener))
+	defer old.Close()
+	old.Set("name", "ralph")
+
+	// A follower that has seen a promotion keeps its epoch in its log:
+	promoted, _ := NewStore[string, string](LogPath(logPath), FollowerOf(listener.Addr().String()))
+	eventually(t, func() bool {
+		_, found, _ := promoted.GetWithConsistency("name", ConsistencyQuorum)
+		return found
+	})
+	assert.NoError(t, promoted.Promote(""))
+	promoted.Close()
+
+	// So it won't follow the old leader again:
+	other, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	stale, _ := NewStore[string, string](ReplicationListener(other))
+	defer stale.Close()
+	stale.Set("name", "toby")
+	follower, _ := NewStore[string, string](LogPath(logPath), FollowerOf(other.Addr().String()))
+	defer follower.Close()
+	_, _, err = follower.GetWithConsistency("name", ConsistencyQuorum)
+	assert.ErrorIs(t, err, ErrNoLeader)
+	v, _ := follower.Get("name")
+	assert.Equal(t, "ralph", v)
+}
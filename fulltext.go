@@ -0,0 +1,145 @@
+package kv
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Returned by `Search` for stores opened without `FullTextSearch`.
+var ErrNoTextIndex = errors.New("Store doesn't keep a full-text index")
+
+// How much a word appearing again in the same value adds to its relevance, and
+// how much long values are penalized for it, in ranking search results with
+// BM25.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// A shard's part of the full-text index of a namespace. Only read and written
+// by the shard's update loop, or while every shard is paused.
+type textIndex[K comparable] struct {
+	// How many times each word appears in the value of each key whose value
+	// contains it.
+	postings map[string]map[K]int
+	// How many words are in the value of each key.
+	lengths map[K]int
+	// The total of `lengths`.
+	words int
+}
+
+func newTextIndex[K comparable]() *textIndex[K] {
+	return &textIndex[K]{postings: make(map[string]map[K]int), lengths: make(map[K]int)}
+}
+
+func (idx *textIndex[K]) add(key K, text string) {
+	words := tokenize(text)
+	for _, word := range words {
+		keys, found := idx.postings[word]
+		if !found {
+			keys = make(map[K]int)
+			idx.postings[word] = keys
+		}
+		keys[key]++
+	}
+
+	idx.lengths[key] = len(words)
+	idx.words += len(words)
+}
+
+func (idx *textIndex[K]) remove(key K, text string) {
+	for _, word := range tokenize(text) {
+		delete(idx.postings[word], key)
+		if len(idx.postings[word]) == 0 {
+			delete(idx.postings, word)
+		}
+	}
+
+	idx.words -= idx.lengths[key]
+	delete(idx.lengths, key)
+}
+
+// Splits text into the words it's indexed and searched by: runs of letters and
+// digits, in lower case.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Gets a value that's a string, or of a type whose underlying type is.
+func textOf[V any](value V) string {
+	return reflect.ValueOf(&value).Elem().String()
+}
+
+func (s *kvStore[K, V]) Search(query string) (keys []K, err error) {
+	if !s.options.fullText {
+		return nil, ErrNoTextIndex
+	}
+
+	words := tokenize(query)
+	slices.Sort(words)
+	words = slices.Compact(words)
+
+	scores := make(map[K]float64)
+	pauseErr := s.exclusive(func() {
+		// Words are weighed by how rare they are across the whole namespace,
+		// not just the shard a key is in:
+		var values, totalWords int
+		matches := make(map[string]int, len(words))
+		for _, sh := range s.shards {
+			if text := sh.lookup(s.namespace).text; text != nil {
+				values += len(text.lengths)
+				totalWords += text.words
+				for _, word := range words {
+					matches[word] += len(text.postings[word])
+				}
+			}
+		}
+		if values == 0 {
+			return
+		}
+		averageLength := float64(totalWords) / float64(values)
+
+		for _, sh := range s.shards {
+			text := sh.lookup(s.namespace).text
+			if text == nil {
+				continue
+			}
+			for _, word := range words {
+				n := float64(matches[word])
+				idf := math.Log(1 + (float64(values)-n+0.5)/(n+0.5))
+				for k, count := range text.postings[word] {
+					tf := float64(count)
+					length := float64(text.lengths[k])
+					scores[k] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*length/averageLength))
+				}
+			}
+		}
+	})
+	if pauseErr != nil {
+		return nil, pauseErr
+	}
+
+	// Most relevant first, with ties in a stable order:
+	for k := range scores {
+		keys = append(keys, k)
+	}
+	sortKeys := make(map[K]string, len(keys))
+	for _, k := range keys {
+		sortKeys[k], _ = csvField(k)
+	}
+	slices.SortFunc(keys, func(a, b K) int {
+		if c := cmp.Compare(scores[b], scores[a]); c != 0 {
+			return c
+		}
+		return strings.Compare(sortKeys[a], sortKeys[b])
+	})
+
+	return keys, nil
+}
@@ -0,0 +1,76 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearch(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath), Shards(4), FullTextSearch())
+	store.SetMany(map[string]string{
+		"go":     "Go is an open source programming language.",
+		"rust":   "Rust is a language empowering everyone to build reliable software.",
+		"gopher": "The Go gopher: go, go, GO!",
+		"cat":    "A small domesticated carnivorous mammal.",
+	})
+
+	keys, err := store.Search("go")
+	assert.NoError(t, err)
+	// The value that's mostly about the word ranks highest:
+	assert.Equal(t, []string{"gopher", "go"}, keys)
+
+	keys, _ = store.Search("Language, software")
+	assert.Equal(t, []string{"rust", "go"}, keys)
+	keys, _ = store.Search("nothing")
+	assert.Empty(t, keys)
+
+	// The index follows the values as they change:
+	store.Set("cat", "Cats are not a programming language")
+	store.Unset("rust")
+	store.Rename("go", "golang")
+	keys, _ = store.Search("language")
+	assert.ElementsMatch(t, []string{"golang", "cat"}, keys)
+	keys, _ = store.Search("mammal")
+	assert.Empty(t, keys)
+	store.Close()
+
+	// It's rebuilt as the log is replayed:
+	replayed, _ := NewStore[string, string](LogPath(logPath), Shards(4), FullTextSearch())
+	keys, _ = replayed.Search("gopher")
+	assert.Equal(t, []string{"gopher"}, keys)
+
+	assert.NoError(t, replayed.Clear())
+	keys, _ = replayed.Search("gopher")
+	assert.Empty(t, keys)
+	replayed.Close()
+}
+
+func TestSearchNamespaces(t *testing.T) {
+	store, _ := NewStore[int, string](FullTextSearch())
+	defer store.Close()
+	docs := store.Namespace("docs")
+	store.Set(1, "hello world")
+	docs.Set(2, "hello there")
+
+	keys, _ := docs.Search("hello")
+	assert.Equal(t, []int{2}, keys)
+	keys, _ = store.Namespace("empty").Search("hello")
+	assert.Empty(t, keys)
+}
+
+func TestSearchWithoutIndex(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	defer store.Close()
+	_, err := store.Search("anything")
+	assert.ErrorIs(t, err, ErrNoTextIndex)
+
+	_, err = NewStore[string, int](FullTextSearch())
+	assert.Error(t, err)
+}
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"héllo", "wörld", "42", "x"}, tokenize("  Héllo, WÖRLD!42 x"))
+}
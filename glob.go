@@ -0,0 +1,91 @@
+package kv
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// Returned by `KeysMatching` for stores whose keys aren't strings.
+var ErrNotStringKeys = errors.New("Store's keys aren't strings")
+
+func (s *kvStore[K, V]) KeysMatching(pattern string) (keys []K, err error) {
+	if reflect.TypeFor[K]().Kind() != reflect.String {
+		return nil, ErrNotStringKeys
+	}
+
+	s.readView(nil, func(v view[K, V]) {
+		for _, values := range v.parts(s.namespace) {
+			for k := range values.keys() {
+				if matchGlob(pattern, reflect.ValueOf(k).String()) {
+					keys = append(keys, k)
+				}
+			}
+		}
+	})
+
+	return keys, nil
+}
+
+// Reports whether `name` matches the glob `pattern`, in which `*` matches any
+// run of characters, including `/`, `?` matches any single character, and `\`
+// matches the character after it literally.
+func matchGlob(pattern string, name string) bool {
+	// Where to carry on from if what follows the last `*` stops matching: the
+	// pattern after the `*`, and the name after one more character than the
+	// `*` matched last time.
+	starPattern, starName := -1, 0
+	p, n := 0, 0
+	for n < len(name) {
+		if p < len(pattern) {
+			c, width := utf8.DecodeRuneInString(pattern[p:])
+			switch c {
+			case '*':
+				starPattern, starName = p+width, n
+				p += width
+				continue
+			case '?':
+				_, nameWidth := utf8.DecodeRuneInString(name[n:])
+				p, n = p+width, n+nameWidth
+				continue
+			case '\\':
+				if p+width < len(pattern) {
+					p += width
+					c, width = utf8.DecodeRuneInString(pattern[p:])
+				}
+			}
+			if r, nameWidth := utf8.DecodeRuneInString(name[n:]); r == c {
+				p, n = p+width, n+nameWidth
+				continue
+			}
+		}
+		if starPattern < 0 {
+			return false
+		}
+		_, nameWidth := utf8.DecodeRuneInString(name[starName:])
+		starName += nameWidth
+		p, n = starPattern, starName
+	}
+
+	// Only stars can match what's left of the pattern, once the name runs out:
+	return strings.Trim(pattern[p:], "*") == ""
+}
+
+// Gets the literal start of a glob pattern, which every key it matches starts
+// with.
+func globPrefix(pattern string) string {
+	var prefix strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?':
+			return prefix.String()
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+		}
+		prefix.WriteByte(pattern[i])
+	}
+	return prefix.String()
+}
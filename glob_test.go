@@ -0,0 +1,59 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeysMatching(t *testing.T) {
+	store, _ := NewStore[string, int](Shards(4))
+	defer store.Close()
+	store.SetMany(map[string]int{"users/1": 1, "users/1/posts/7": 2, "users/22": 3, "orders/1": 4})
+	store.Namespace("other").Set("users/3", 5)
+
+	for pattern, expected := range map[string][]string{
+		"users/*":   {"users/1", "users/1/posts/7", "users/22"},
+		"users/?":   {"users/1"},
+		"*/1":       {"users/1", "orders/1"},
+		"*posts*":   {"users/1/posts/7"},
+		"orders/1":  {"orders/1"},
+		"users/1?*": {"users/1/posts/7"},
+		"nothing*":  nil,
+	} {
+		keys, err := store.KeysMatching(pattern)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, expected, keys, pattern)
+	}
+
+	numbers, _ := NewStore[int, int]()
+	defer numbers.Close()
+	_, err := numbers.KeysMatching("*")
+	assert.ErrorIs(t, err, ErrNotStringKeys)
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, c := range []struct {
+		pattern string
+		name    string
+		matches bool
+	}{
+		{"", "", true},
+		{"*", "", true},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+		{"??", "héé", false},
+		{"h??", "héé", true},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{`a\?`, "a?", true},
+		{"*?", "", false},
+		{"**a", "ba", true},
+	} {
+		assert.Equal(t, c.matches, matchGlob(c.pattern, c.name), "%q %q", c.pattern, c.name)
+	}
+
+	assert.Equal(t, "team-a:", globPrefix("team-a:*"))
+	assert.Equal(t, "a*b", globPrefix(`a\*b?`))
+	assert.Equal(t, "all", globPrefix("all"))
+}
@@ -0,0 +1,121 @@
+package kv
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// How long a store that's closing waits for the rest of its gossip cluster to
+// hear that it's leaving.
+const gossipLeaveTimeout = time.Second
+
+// Configuration for discovering the other nodes of a cluster by gossip.
+type GossipConfig struct {
+	// Memberlist settings for this node. `Config.Name` must be unique in the
+	// cluster, and `Config.BindAddr` and `Config.BindPort` set where it gossips.
+	// Defaults to `memberlist.DefaultLANConfig()`.
+	Config *memberlist.Config
+	// Gossip addresses of nodes already in the cluster, to join it through.
+	// Leave empty to start a new cluster.
+	Join []string
+	// The address other nodes should reach this node's `ServerListener` at.
+	// Defaults to the listener's address.
+	ServerAddr string
+}
+
+// A node in a store's gossip cluster.
+type Member struct {
+	Name string
+	// The address the node gossips on.
+	Addr string
+	// The address the node serves `kvclient` clients on, if it does.
+	ServerAddr string `json:",omitzero"`
+	// Whether the node is answering the cluster's health checks. A node that
+	// isn't is suspected of having failed, and is removed from the cluster if it
+	// doesn't recover.
+	Healthy bool
+}
+
+// Metadata a node gossips about itself.
+type memberMeta struct {
+	ServerAddr string `json:",omitzero"`
+}
+
+// Answers memberlist's requests for this node's metadata. The store doesn't
+// gossip anything else.
+type gossipDelegate struct {
+	meta []byte
+}
+
+func (d *gossipDelegate) NodeMeta(limit int) []byte {
+	if len(d.meta) > limit {
+		return nil
+	}
+	return d.meta
+}
+
+func (d *gossipDelegate) NotifyMsg([]byte)                           {}
+func (d *gossipDelegate) GetBroadcasts(overhead, limit int) [][]byte { return nil }
+func (d *gossipDelegate) LocalState(join bool) []byte                { return nil }
+func (d *gossipDelegate) MergeRemoteState(buf []byte, join bool)     {}
+
+// Starts gossiping with the cluster, joining it through `config.Join` if any of
+// those nodes are in it already.
+func (s *kvStore[K, V]) startGossip(config GossipConfig) error {
+	if config.Config == nil {
+		config.Config = memberlist.DefaultLANConfig()
+	}
+	if config.ServerAddr == "" && s.server != nil {
+		config.ServerAddr = s.server.Addr().String()
+	}
+
+	meta, err := json.Marshal(memberMeta{ServerAddr: config.ServerAddr})
+	if err != nil {
+		return err
+	}
+	// Copy the settings, so the caller's aren't changed:
+	memberConfig := *config.Config
+	memberConfig.Delegate = &gossipDelegate{meta: meta}
+
+	members, err := memberlist.Create(&memberConfig)
+	if err != nil {
+		return err
+	}
+	if len(config.Join) > 0 {
+		if _, err := members.Join(config.Join); err != nil {
+			members.Shutdown()
+			return err
+		}
+	}
+
+	s.members = members
+	return nil
+}
+
+func (s *kvStore[K, V]) Members() []Member {
+	if s.members == nil {
+		return nil
+	}
+
+	var members []Member
+	for _, node := range s.members.Members() {
+		meta := memberMeta{}
+		json.Unmarshal(node.Meta, &meta)
+		members = append(members, Member{
+			Name:       node.Name,
+			Addr:       node.Address(),
+			ServerAddr: meta.ServerAddr,
+			Healthy:    node.State == memberlist.StateAlive,
+		})
+	}
+
+	return members
+}
+
+// Tells the cluster this node is leaving, and stops gossiping.
+func (s *kvStore[K, V]) stopGossip() error {
+	s.members.Leave(gossipLeaveTimeout)
+	return s.members.Shutdown()
+}
@@ -0,0 +1,49 @@
+package kv
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/stretchr/testify/assert"
+)
+
+// Settings for a node that gossips on a free local port, quietly.
+func localGossip(name string) *memberlist.Config {
+	config := memberlist.DefaultLocalConfig()
+	config.Name = name
+	config.BindAddr = "127.0.0.1"
+	config.BindPort = 0
+	config.LogOutput = io.Discard
+	return config
+}
+
+func TestGossip(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	first, err := NewStore[string, string](ServerListener(listener), Gossip(GossipConfig{Config: localGossip("first")}))
+	assert.NoError(t, err)
+	defer first.Close()
+	assert.Equal(t, []Member{
+		{Name: "first", Addr: first.Members()[0].Addr, ServerAddr: listener.Addr().String(), Healthy: true},
+	}, first.Members())
+
+	second, err := NewStore[string, string](Gossip(GossipConfig{Config: localGossip("second"), Join: []string{first.Members()[0].Addr}}))
+	assert.NoError(t, err)
+
+	// Both nodes learn about each other:
+	assert.Len(t, second.Members(), 2)
+	assert.Eventually(t, func() bool { return len(first.Members()) == 2 }, time.Second, 10*time.Millisecond)
+
+	// A node that closes leaves the cluster:
+	second.Close()
+	assert.Eventually(t, func() bool { return len(first.Members()) == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestWithoutGossip(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	defer store.Close()
+	assert.Nil(t, store.Members())
+}
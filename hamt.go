@@ -0,0 +1,229 @@
+package kv
+
+import (
+	"hash/maphash"
+	"iter"
+	"math/bits"
+	"slices"
+	"sync/atomic"
+)
+
+// How many bits of a key's hash each level of a trie indexes.
+const hamtBits = 5
+
+// Keeps key/value pairs in a hash array mapped trie that's never changed in
+// place: each write copies the nodes on the path to its key, then swaps in the
+// new root. Readers load the root and walk the version of the trie it points
+// to, so `get` takes no lock and never waits on the update loop, nor sees a
+// write half done. This is the default storage.
+type hamtStorage[K comparable, V any] struct {
+	root atomic.Pointer[hamtRoot[K, V]]
+	seed maphash.Seed
+}
+
+// A version of a trie.
+type hamtRoot[K comparable, V any] struct {
+	node *hamtNode[K, V]
+	// How many keys the version holds.
+	size int
+}
+
+// A node of a trie, with a child for each of the hash segments set in its
+// bitmap, in order.
+type hamtNode[K comparable, V any] struct {
+	bitmap   uint32
+	children []hamtChild[K, V]
+}
+
+// Either a subtrie or the pairs of keys that share a hash. Pairs only share a
+// child once the whole of their hashes collide.
+type hamtChild[K comparable, V any] struct {
+	node  *hamtNode[K, V]
+	pairs []hamtPair[K, V]
+}
+
+type hamtPair[K comparable, V any] struct {
+	hash  uint64
+	key   K
+	value V
+}
+
+func newHAMTStorage[K comparable, V any]() *hamtStorage[K, V] {
+	s := &hamtStorage[K, V]{seed: maphash.MakeSeed()}
+	s.root.Store(&hamtRoot[K, V]{node: &hamtNode[K, V]{}})
+	return s
+}
+
+// Returns the bit of a node's bitmap for a hash, and the position of its child
+// among the node's children.
+func (n *hamtNode[K, V]) slot(hash uint64, shift uint) (bit uint32, position int) {
+	bit = 1 << ((hash >> shift) & (1<<hamtBits - 1))
+	return bit, bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+func (s *hamtStorage[K, V]) get(key K) (value V, found bool) {
+	hash := maphash.Comparable(s.seed, key)
+	n := s.root.Load().node
+	for shift := uint(0); ; shift += hamtBits {
+		bit, position := n.slot(hash, shift)
+		if n.bitmap&bit == 0 {
+			return value, false
+		}
+
+		child := n.children[position]
+		if child.node != nil {
+			n = child.node
+			continue
+		}
+		for _, p := range child.pairs {
+			if p.hash == hash && p.key == key {
+				return p.value, true
+			}
+		}
+		return value, false
+	}
+}
+
+func (s *hamtStorage[K, V]) put(key K, value V) error {
+	root := s.root.Load()
+	node, added := root.node.put(hamtPair[K, V]{maphash.Comparable(s.seed, key), key, value}, 0)
+	size := root.size
+	if added {
+		size++
+	}
+
+	s.root.Store(&hamtRoot[K, V]{node, size})
+	return nil
+}
+
+// Returns a copy of the node with a pair set in it, and whether its key is
+// new.
+func (n *hamtNode[K, V]) put(pair hamtPair[K, V], shift uint) (*hamtNode[K, V], bool) {
+	bit, position := n.slot(pair.hash, shift)
+	if n.bitmap&bit == 0 {
+		children := slices.Insert(slices.Clip(n.children), position, hamtChild[K, V]{pairs: []hamtPair[K, V]{pair}})
+		return &hamtNode[K, V]{n.bitmap | bit, children}, true
+	}
+
+	child := n.children[position]
+	added := true
+	switch {
+	case child.node != nil:
+		child.node, added = child.node.put(pair, shift+hamtBits)
+	case child.pairs[0].hash == pair.hash:
+		i := slices.IndexFunc(child.pairs, func(p hamtPair[K, V]) bool { return p.key == pair.key })
+		child.pairs = slices.Clone(child.pairs)
+		if i >= 0 {
+			child.pairs[i], added = pair, false
+		} else {
+			child.pairs = append(child.pairs, pair)
+		}
+	default:
+		// Split the pairs and the new one into a subtrie, by the next
+		// segment of their hashes:
+		split := &hamtNode[K, V]{}
+		split.bitmap, _ = split.slot(child.pairs[0].hash, shift+hamtBits)
+		split.children = []hamtChild[K, V]{child}
+		child = hamtChild[K, V]{}
+		child.node, _ = split.put(pair, shift+hamtBits)
+	}
+
+	children := slices.Clone(n.children)
+	children[position] = child
+	return &hamtNode[K, V]{n.bitmap, children}, added
+}
+
+func (s *hamtStorage[K, V]) remove(key K) {
+	root := s.root.Load()
+	node, removed := root.node.remove(maphash.Comparable(s.seed, key), key, 0)
+	if removed {
+		s.root.Store(&hamtRoot[K, V]{node, root.size - 1})
+	}
+}
+
+// Returns a copy of the node without a key, and whether the key was in it.
+// Subtries left with only a child of pairs are folded into their parent.
+func (n *hamtNode[K, V]) remove(hash uint64, key K, shift uint) (*hamtNode[K, V], bool) {
+	bit, position := n.slot(hash, shift)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+
+	child := n.children[position]
+	if child.node != nil {
+		node, removed := child.node.remove(hash, key, shift+hamtBits)
+		if !removed {
+			return n, false
+		}
+		if len(node.children) == 1 && node.children[0].node == nil {
+			child = node.children[0]
+		} else {
+			child.node = node
+		}
+	} else {
+		i := slices.IndexFunc(child.pairs, func(p hamtPair[K, V]) bool { return p.hash == hash && p.key == key })
+		if i < 0 {
+			return n, false
+		}
+		child.pairs = slices.Delete(slices.Clone(child.pairs), i, i+1)
+	}
+
+	if child.node == nil && len(child.pairs) == 0 {
+		return &hamtNode[K, V]{n.bitmap &^ bit, slices.Delete(slices.Clone(n.children), position, position+1)}, true
+	}
+	children := slices.Clone(n.children)
+	children[position] = child
+	return &hamtNode[K, V]{n.bitmap, children}, true
+}
+
+func (s *hamtStorage[K, V]) reset() {
+	s.root.Store(&hamtRoot[K, V]{node: &hamtNode[K, V]{}})
+}
+
+// Returns a storage that holds the trie's current version. Writes to `s` copy
+// the nodes they change, so they never change it.
+func (s *hamtStorage[K, V]) freeze() storage[K, V] {
+	frozen := &hamtStorage[K, V]{seed: s.seed}
+	frozen.root.Store(s.root.Load())
+	return frozen
+}
+
+func (s *hamtStorage[K, V]) len() int {
+	return s.root.Load().size
+}
+
+func (s *hamtStorage[K, V]) keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for k := range s.all() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// Visits every pair of the current version of the trie. Writes made while it's
+// visiting them aren't seen.
+func (s *hamtStorage[K, V]) all() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		s.root.Load().node.each(yield)
+	}
+}
+
+func (n *hamtNode[K, V]) each(yield func(K, V) bool) bool {
+	for _, child := range n.children {
+		if child.node != nil {
+			if !child.node.each(yield) {
+				return false
+			}
+			continue
+		}
+		for _, p := range child.pairs {
+			if !yield(p.key, p.value) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,106 @@
+package kv
+
+import (
+	"maps"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHAMTStorage(t *testing.T) {
+	s := newHAMTStorage[int, int]()
+	for i := range 5000 {
+		s.put(i, i)
+	}
+	for i := 0; i < 5000; i += 2 {
+		s.remove(i)
+	}
+	s.put(1, -1)
+	s.remove(-1)
+
+	assert.Equal(t, 2500, s.len())
+	v, found := s.get(1)
+	assert.True(t, found)
+	assert.Equal(t, -1, v)
+	_, found = s.get(2)
+	assert.False(t, found)
+	all := maps.Collect(s.all())
+	assert.Len(t, all, 2500)
+	assert.Equal(t, 4999, all[4999])
+
+	s.reset()
+	assert.Equal(t, 0, s.len())
+	_, found = s.get(1)
+	assert.False(t, found)
+}
+
+// Returns every pair of a trie.
+func hamtPairs[K comparable, V any](n *hamtNode[K, V]) map[K]V {
+	pairs := make(map[K]V)
+	n.each(func(k K, v V) bool {
+		pairs[k] = v
+		return true
+	})
+	return pairs
+}
+
+func TestHAMTStorageCollisions(t *testing.T) {
+	root := &hamtNode[string, string]{}
+
+	// Give "a" and "b" the same hash, and "c" one that only differs in its
+	// last segment:
+	root, _ = root.put(hamtPair[string, string]{42, "a", "A"}, 0)
+	root, _ = root.put(hamtPair[string, string]{42, "b", "B"}, 0)
+	root, _ = root.put(hamtPair[string, string]{42 | 1<<63, "c", "C"}, 0)
+	root, added := root.put(hamtPair[string, string]{42, "b", "BB"}, 0)
+	assert.False(t, added)
+	assert.Equal(t, map[string]string{"a": "A", "b": "BB", "c": "C"}, hamtPairs(root))
+
+	root, removed := root.remove(42, "a", 0)
+	assert.True(t, removed)
+	_, removed = root.remove(42, "a", 0)
+	assert.False(t, removed)
+	root, _ = root.remove(42|1<<63, "c", 0)
+
+	// The subtrie left with only "b" is folded into the root:
+	assert.Len(t, root.children, 1)
+	assert.Nil(t, root.children[0].node)
+	assert.Equal(t, map[string]string{"b": "BB"}, hamtPairs(root))
+}
+
+func TestHAMTStoragePersistent(t *testing.T) {
+	s := newHAMTStorage[string, int]()
+	s.put("a", 1)
+	version := s.root.Load()
+	s.put("a", 2)
+	s.put("b", 3)
+
+	// Writes don't change versions that readers might still hold:
+	assert.Equal(t, map[string]int{"a": 1}, hamtPairs(version.node))
+	assert.Equal(t, 1, version.size)
+}
+
+func TestGetDuringWrites(t *testing.T) {
+	store, _ := NewStore[int, pet](Shards(2))
+	defer store.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := range 10000 {
+			store.Set(i%100, pet{"Toby", "dog"})
+			store.Unset((i + 50) % 100)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := range 100000 {
+			if v, found := store.Get(i % 100); found {
+				assert.Equal(t, pet{"Toby", "dog"}, v)
+			}
+		}
+	}()
+	wg.Wait()
+}
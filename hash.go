@@ -0,0 +1,109 @@
+package kv
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Returned by the hash operations, `HSet`, `HGet` and `HDel`, for stores whose
+// values aren't maps with string keys.
+var ErrNotHash = errors.New("Store's values aren't maps with string keys")
+
+func (s *kvStore[K, V]) HSet(key K, fields V) (added int, err error) {
+	if !isHash[V]() {
+		return 0, ErrNotHash
+	}
+
+	result := s.write(s.newUpdate(setFields, key, fields))
+	if result.err != nil {
+		return 0, result.err
+	}
+	return lengthOf(result.value), nil
+}
+
+func (s *kvStore[K, V]) HGet(key K, fields ...string) (values V, err error) {
+	if !isHash[V]() {
+		return values, ErrNotHash
+	}
+
+	hash, _ := s.find(key)
+	h := reflect.ValueOf(hash)
+	v := reflect.MakeMapWithSize(h.Type(), len(fields))
+	for _, field := range fields {
+		k := reflect.ValueOf(field).Convert(h.Type().Key())
+		if value := h.MapIndex(k); value.IsValid() {
+			v.SetMapIndex(k, value)
+		}
+	}
+
+	return v.Interface().(V), nil
+}
+
+func (s *kvStore[K, V]) HDel(key K, fields ...string) (removed int, err error) {
+	if !isHash[V]() {
+		return 0, ErrNotHash
+	}
+
+	u := s.newUpdate(deleteFields, key, *new(V))
+	u.Fields = fields
+	result := s.write(u)
+	if result.err != nil {
+		return 0, result.err
+	}
+	return lengthOf(result.value), nil
+}
+
+// Reports whether a store's values are maps with string keys, which the hash
+// operations need.
+func isHash[V any]() bool {
+	t := reflect.TypeFor[V]()
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String
+}
+
+// Sets the fields of the hash `hash` that are in `fields`. Returns the hash
+// that leaves, which is a copy, since readers may still hold `hash`, and the
+// fields that weren't in it before.
+func withFields[V any](hash V, fields V) (result V, added V, err error) {
+	if !isHash[V]() {
+		return result, added, ErrNotHash
+	}
+
+	h, f := reflect.ValueOf(&hash).Elem(), reflect.ValueOf(&fields).Elem()
+	r := reflect.MakeMapWithSize(h.Type(), h.Len()+f.Len())
+	a := reflect.MakeMap(h.Type())
+	for it := h.MapRange(); it.Next(); {
+		r.SetMapIndex(it.Key(), it.Value())
+	}
+	for it := f.MapRange(); it.Next(); {
+		if !r.MapIndex(it.Key()).IsValid() {
+			a.SetMapIndex(it.Key(), it.Value())
+		}
+		r.SetMapIndex(it.Key(), it.Value())
+	}
+
+	return r.Interface().(V), a.Interface().(V), nil
+}
+
+// Deletes `fields` from the hash `hash`. Returns the hash that leaves, which is
+// a copy, and the fields that were deleted.
+func withoutFields[V any](hash V, fields []string) (result V, removed V, err error) {
+	if !isHash[V]() {
+		return result, removed, ErrNotHash
+	}
+
+	h := reflect.ValueOf(&hash).Elem()
+	r := reflect.MakeMapWithSize(h.Type(), h.Len())
+	d := reflect.MakeMap(h.Type())
+	for it := h.MapRange(); it.Next(); {
+		r.SetMapIndex(it.Key(), it.Value())
+	}
+	for _, field := range fields {
+		k := reflect.ValueOf(field).Convert(h.Type().Key())
+		if value := r.MapIndex(k); value.IsValid() {
+			d.SetMapIndex(k, value)
+			r.SetMapIndex(k, reflect.Value{})
+		}
+	}
+
+	return r.Interface().(V), d.Interface().(V), nil
+}
@@ -0,0 +1,62 @@
+package kv
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHash(t *testing.T) {
+	defer removeLog()
+
+	store, err := NewStore[string, map[string]string](LogPath(logPath))
+	assert.NoError(t, err)
+
+	added, err := store.HSet("user", map[string]string{"name": "ralph", "bio": strings.Repeat("a", 1000)})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, added)
+	held, _ := store.Get("user")
+	added, _ = store.HSet("user", map[string]string{"name": "toby", "city": "nyc"})
+	assert.Equal(t, 1, added)
+	// Values already read are left as they were:
+	assert.Equal(t, "ralph", held["name"])
+
+	values, err := store.HGet("user", "name", "city", "missing")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"name": "toby", "city": "nyc"}, values)
+
+	removed, err := store.HDel("user", "city", "missing")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	v, _ := store.Get("user")
+	assert.Len(t, v, 2)
+	store.HSet("empty", map[string]string{"a": "b"})
+	store.HDel("empty", "a")
+	_, found := store.Get("empty")
+	assert.False(t, found)
+	store.Close()
+
+	// The large field is only logged once, when it's set:
+	log, _ := os.ReadFile(logPath)
+	assert.Equal(t, 1, strings.Count(string(log), strings.Repeat("a", 1000)))
+
+	replayed, err := NewStore[string, map[string]string](LogPath(logPath))
+	assert.NoError(t, err)
+	defer replayed.Close()
+	values, _ = replayed.HGet("user", "name", "city")
+	assert.Equal(t, map[string]string{"name": "toby"}, values)
+	_, found = replayed.Get("empty")
+	assert.False(t, found)
+}
+
+func TestNotHash(t *testing.T) {
+	store, _ := NewStore[string, map[int]string]()
+	defer store.Close()
+
+	_, err := store.HSet("user", map[int]string{1: "a"})
+	assert.ErrorIs(t, err, ErrNotHash)
+	_, err = store.HDel("user", "a")
+	assert.ErrorIs(t, err, ErrNotHash)
+}
@@ -0,0 +1,82 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// How long `Healthy` waits for each shard's update loop to answer.
+const healthTimeout = 5 * time.Second
+
+func (s *kvStore[K, V]) Healthy() error {
+	select {
+	case <-s.closing:
+		return ErrClosed
+	default:
+	}
+
+	// Round-trip a no-op through every shard's queue, so a loop that's stuck
+	// applying an update is caught:
+	timeout := time.NewTimer(healthTimeout)
+	defer timeout.Stop()
+	for i, sh := range s.shards {
+		u := update[K, V]{UpdateType: ping, result: make(chan updateResult[V], 1)}
+		select {
+		case sh.updates <- u:
+		case <-s.closing:
+			return ErrClosed
+		case <-timeout.C:
+			return fmt.Errorf("Shard %d's update loop didn't receive a health check within %v", i, healthTimeout)
+		}
+		select {
+		case <-u.result:
+		case <-timeout.C:
+			return fmt.Errorf("Shard %d's update loop didn't answer a health check within %v", i, healthTimeout)
+		}
+	}
+
+	if s.fenced.Load() {
+		return ErrFenced
+	}
+	if s.appends() {
+		if err := s.Sync(); err != nil {
+			return fmt.Errorf("Write-ahead log isn't writable: %w", err)
+		}
+	}
+	return nil
+}
+
+// Returns an HTTP handler that answers liveness and readiness probes with the
+// result of `store.Healthy`: 200 and "ok" if it's healthy, and otherwise 503
+// and the error it returned.
+func HealthHandler(store interface{ Healthy() error }) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := store.Healthy(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// Starts serving `HealthHandler` at "/healthz" to HTTP clients that connect to
+// `listener`, until the store closes.
+func (s *kvStore[K, V]) serveHealth(listener net.Listener) {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", s.requireToken(HealthHandler(s)))
+	s.health = &http.Server{Handler: mux, ReadHeaderTimeout: healthTimeout}
+
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		if err := s.health.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.options.logger.Error("Stopped serving health checks", "error", err)
+		}
+	}()
+}
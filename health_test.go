@@ -0,0 +1,44 @@
+package kv
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthy(t *testing.T) {
+	defer removeLog()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	store, err := NewStore[string, int](LogPath(logPath), Shards(4), HealthListener(listener))
+	assert.NoError(t, err)
+	store.Set("a", 1)
+	assert.NoError(t, store.Healthy())
+
+	res, err := http.Get("http://" + listener.Addr().String() + "/healthz")
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "ok\n", string(body))
+
+	// Health checks don't change the store's data or revision:
+	assert.Equal(t, uint64(1), store.(*kvStore[string, int]).revision)
+	assert.Equal(t, map[string]int{"a": 1}, store.GetAll())
+
+	// A closed store isn't healthy, and stops answering health checks:
+	store.Close()
+	assert.ErrorIs(t, store.Healthy(), ErrClosed)
+	_, err = http.Get("http://" + listener.Addr().String() + "/healthz")
+	assert.Error(t, err)
+
+	recorder := httptest.NewRecorder()
+	HealthHandler(store).ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, ErrClosed.Error()+"\n", recorder.Body.String())
+}
@@ -0,0 +1,119 @@
+package kv
+
+import "errors"
+
+// Returned by `GetAt` when the revision is older than the history kept in the
+// write-ahead log, because the log has been compacted since, or because the
+// store started from a leader's snapshot.
+var ErrCompacted = errors.New("Revision is older than the log's history")
+
+// Stops `GetAt`, and other readers of the log, from reading the rest of it once
+// they've passed the revision they're looking for.
+var errPastRevision = errors.New("Past the revision")
+
+func (s *kvStore[K, V]) GetAt(key K, revision uint64) (value V, found bool, err error) {
+	if s.log == nil {
+		return value, false, errors.New("Cannot read history, store has no log")
+	}
+
+	// The log's files are opened holding `commit`, so nothing is being appended
+	// to them, then read without it, so writes carry on meanwhile. If the log is
+	// compacted while it's read, it's read again:
+	for {
+		s.commit.Lock()
+		files, err := s.log.openFiles()
+		s.commit.Unlock()
+		if err != nil {
+			return *new(V), false, err
+		}
+
+		value, found, err = s.readHistory(files, key, revision)
+		if err != errLogChanged {
+			return value, found, err
+		}
+	}
+}
+
+// Reads the value a key had at a revision from the log's files.
+func (s *kvStore[K, V]) readHistory(files logFiles, key K, revision uint64) (value V, found bool, err error) {
+	var latest uint64
+	err = s.log.scan(files, func(record []byte) error {
+		u, err := s.decodeUpdate(record)
+		if err != nil {
+			// Replaying the log skipped this record too:
+			return nil
+		}
+
+		// Number records the same way replaying them does:
+		if u.Revision == 0 {
+			u.Revision = latest + 1
+		}
+		// A log that starts with a truncate has no history before it:
+		if latest == 0 && u.UpdateType == truncate && revision < u.Revision {
+			return ErrCompacted
+		}
+		if u.Revision > revision {
+			return errPastRevision
+		}
+		// A snapshot is followed by whatever part of the log it covers that
+		// hadn't been trimmed yet:
+		if u.Revision < latest {
+			return nil
+		}
+		latest = u.Revision
+
+		switch u.UpdateType {
+		case set, unset:
+			if u.Namespace == s.namespace && u.Key == key {
+				value, found = u.Value, u.UpdateType == set
+			}
+		case appendValue, pushFront, pushBack, popFront, addMembers, removeMembers, addScored, setFields, deleteFields:
+			if u.Namespace == s.namespace && u.Key == key {
+				value, _, _ = applyDelta(u, value)
+				found = true
+			}
+		case rename:
+			if u.Namespace == s.namespace && u.Key == key {
+				value, found = *new(V), false
+			}
+			if u.Namespace == s.namespace && u.To == key {
+				value, found = u.Value, true
+			}
+		case truncate:
+			value, found = *new(V), false
+		case revokeLease:
+			for _, e := range u.Entries {
+				if e.Namespace == s.namespace && e.Key == key {
+					value, found = *new(V), false
+				}
+			}
+		case setMany, replaceAll, transaction:
+			if u.Namespace != s.namespace {
+				break
+			}
+			if u.UpdateType == replaceAll {
+				value, found = *new(V), false
+			}
+			for _, k := range u.Unsets {
+				if k == key {
+					value, found = *new(V), false
+				}
+			}
+			for _, e := range u.Entries {
+				if e.Key == key {
+					value, found = e.Value, true
+				}
+			}
+		}
+
+		return nil
+	})
+	if err == errPastRevision {
+		err = nil
+	}
+	if err != nil {
+		return *new(V), false, err
+	}
+
+	return value, found, nil
+}
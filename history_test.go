@@ -0,0 +1,120 @@
+package kv
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevisions(t *testing.T) {
+	store, _ := NewStore[string, string](Shards(4))
+	first, _ := store.Set("a", "a")
+	second, _ := store.Set("b", "b")
+	third, _ := store.Unset("a")
+	assert.Equal(t, uint64(1), first)
+	assert.Equal(t, uint64(2), second)
+	assert.Equal(t, uint64(3), third)
+}
+
+func TestGetAt(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath))
+	first, _ := store.Set("name", "Toby")
+	second, _ := store.Set("name", "Ralph")
+	store.Set("food", "pizza")
+	third, _ := store.Unset("name")
+
+	v, found, err := store.GetAt("name", first)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "Toby", v)
+
+	v, found, _ = store.GetAt("name", second+1)
+	assert.True(t, found)
+	assert.Equal(t, "Ralph", v)
+
+	_, found, _ = store.GetAt("name", third)
+	assert.False(t, found)
+	_, found, _ = store.GetAt("name", 0)
+	assert.False(t, found)
+
+	// Revisions carry on after the store restarts:
+	store.Close()
+	store, _ = NewStore[string, string](LogPath(logPath))
+	defer store.Close()
+	next, _ := store.Set("name", "Rex")
+	assert.Equal(t, third+1, next)
+	v, _, _ = store.GetAt("name", first)
+	assert.Equal(t, "Toby", v)
+
+	// And after the log is compacted, though history before it is gone:
+	assert.NoError(t, store.Compact())
+	_, _, err = store.GetAt("name", first)
+	assert.ErrorIs(t, err, ErrCompacted)
+	v, _, err = store.GetAt("name", next)
+	assert.NoError(t, err)
+	assert.Equal(t, "Rex", v)
+	last, _ := store.Set("food", "tacos")
+	assert.Equal(t, next+1, last)
+}
+
+func TestGetAtConcurrentWrites(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath), SegmentSize(4096), BlobThreshold(64))
+	defer store.Close()
+	name := strings.Repeat("Toby", 32)
+	store.Set("name", name)
+
+	// Reads of the log carry on while it's written to and compacted, including
+	// the blobs compactions remove:
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			v, found, err := store.GetAt("name", math.MaxUint64)
+			assert.NoError(t, err)
+			assert.True(t, found)
+			assert.Equal(t, name, v)
+		}
+	}()
+	for i := range 200 {
+		store.Set("food", strings.Repeat("pizza", i))
+		if i%20 == 0 {
+			assert.NoError(t, store.Compact())
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestGetAtNamespace(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath))
+	defer store.Close()
+	revision, _ := store.Namespace("pets").Set("name", "Toby")
+	store.SetMany(map[string]string{"name": "Alice"})
+
+	v, _, _ := store.Namespace("pets").GetAt("name", revision+1)
+	assert.Equal(t, "Toby", v)
+	v, _, _ = store.GetAt("name", revision+1)
+	assert.Equal(t, "Alice", v)
+}
+
+func TestGetAtWithoutLog(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	_, _, err := store.GetAt("name", 1)
+	assert.Error(t, err)
+}
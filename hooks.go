@@ -0,0 +1,133 @@
+package kv
+
+import "errors"
+
+// Functions the store calls from its update loop as it applies updates.
+type hooks[K comparable, V any] struct {
+	beforeSet  func(key K, value V) (V, error)
+	afterSet   func(key K, value V)
+	afterUnset func(key K)
+	// Set by `ResolveConflicts`.
+	resolveConflict func(key K, local, remote V) V
+	// Set by `Loader`. Unlike the others, it's called by `Get`, outside the
+	// update loop.
+	loader func(key K) (V, error)
+}
+
+// Gets the hooks set by options, checking they're for the store's key and value
+// types.
+func newHooks[K comparable, V any](options *optionsData) (hooks[K, V], error) {
+	h := hooks[K, V]{}
+	ok := true
+	if options.beforeSet != nil {
+		h.beforeSet, ok = options.beforeSet.(func(K, V) (V, error))
+	}
+	if ok && options.afterSet != nil {
+		h.afterSet, ok = options.afterSet.(func(K, V))
+	}
+	if ok && options.afterUnset != nil {
+		h.afterUnset, ok = options.afterUnset.(func(K))
+	}
+	if ok && options.resolveConflict != nil {
+		h.resolveConflict, ok = options.resolveConflict.(func(K, V, V) V)
+	}
+	if ok && options.loader != nil {
+		h.loader, ok = options.loader.(func(K) (V, error))
+	}
+	if !ok {
+		return h, errors.New("Hook doesn't match the store's key and value types")
+	}
+
+	return h, nil
+}
+
+// Calls the `OnBeforeSet` hook with every value that an update sets, replacing
+// each value with the one it returns.
+func (h hooks[K, V]) runBeforeSet(u *update[K, V]) error {
+	if h.beforeSet == nil {
+		return nil
+	}
+
+	switch u.UpdateType {
+	case set:
+		value, err := h.beforeSet(u.Key, u.Value)
+		if err != nil {
+			return err
+		}
+		u.Value = value
+	case rename:
+		value, err := h.beforeSet(u.To, u.Value)
+		if err != nil {
+			return err
+		}
+		u.Value = value
+	case setMany, transaction:
+		// Copy the entries, so the caller's update isn't changed:
+		entries := make([]entry[K, V], len(u.Entries))
+		for i, e := range u.Entries {
+			value, err := h.beforeSet(e.Key, e.Value)
+			if err != nil {
+				return err
+			}
+			entries[i] = entry[K, V]{Key: e.Key, Value: value}
+		}
+		u.Entries = entries
+	}
+
+	return nil
+}
+
+// Calls the `OnAfterSet` and `OnAfterUnset` hooks for an update that has been
+// applied.
+func (h hooks[K, V]) runAfter(u update[K, V]) {
+	switch {
+	case u.UpdateType == set && h.afterSet != nil:
+		h.afterSet(u.Key, u.Value)
+	case u.UpdateType == setMany && h.afterSet != nil:
+		for _, e := range u.Entries {
+			h.afterSet(e.Key, e.Value)
+		}
+	case u.UpdateType == transaction:
+		for _, k := range u.Unsets {
+			if h.afterUnset != nil {
+				h.afterUnset(k)
+			}
+		}
+		for _, e := range u.Entries {
+			if h.afterSet != nil {
+				h.afterSet(e.Key, e.Value)
+			}
+		}
+	case u.UpdateType == unset && h.afterUnset != nil:
+		h.afterUnset(u.Key)
+	case u.UpdateType == revokeLease && h.afterUnset != nil:
+		for _, e := range u.Entries {
+			h.afterUnset(e.Key)
+		}
+	case u.UpdateType == rename:
+		if h.afterUnset != nil {
+			h.afterUnset(u.Key)
+		}
+		if h.afterSet != nil {
+			h.afterSet(u.To, u.Value)
+		}
+	}
+}
+
+// Decides which version of a key a `merge` leaves the store with: the one
+// `ResolveConflicts` returns, if both versions are set to different values, or
+// else the one written last. Reports whether it differs from the store's own.
+func (h hooks[K, V]) resolveMerge(key K, ours, theirs Version[V]) (Version[V], bool) {
+	if h.resolveConflict == nil || ours.Deleted || theirs.Deleted {
+		if theirs.newerThan(ours) {
+			return theirs, true
+		}
+		return ours, false
+	}
+	if equal(ours.Value, theirs.Value) {
+		return ours, false
+	}
+
+	resolved := Version[V]{Value: h.resolveConflict(key, ours.Value, theirs.Value), Timestamp: max(ours.Timestamp, theirs.Timestamp)}
+	return resolved, !equal(resolved.Value, ours.Value) || resolved.Timestamp != ours.Timestamp
+}
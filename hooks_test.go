@@ -0,0 +1,72 @@
+package kv
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnBeforeSet(t *testing.T) {
+	invalid := errors.New("invalid")
+	store, err := NewStore[string, string](OnBeforeSet(func(key string, value string) (string, error) {
+		if value == "" {
+			return "", invalid
+		}
+		return strings.ToUpper(value), nil
+	}))
+	assert.NoError(t, err)
+
+	store.Set("name", "toby")
+	v, _ := store.Get("name")
+	assert.Equal(t, "TOBY", v)
+
+	_, err = store.Set("name", "")
+	assert.ErrorIs(t, err, invalid)
+	v, _ = store.Get("name")
+	assert.Equal(t, "TOBY", v)
+
+	assert.NoError(t, store.SetMany(map[string]string{"a": "a", "b": "b"}))
+	assert.Equal(t, map[string]string{"name": "TOBY", "a": "A", "b": "B"}, store.GetAll())
+	assert.ErrorIs(t, store.SetMany(map[string]string{"c": "c", "d": ""}), invalid)
+	_, found := store.Get("c")
+	assert.False(t, found)
+}
+
+func TestOnBeforeSetNotReplayed(t *testing.T) {
+	defer removeLog()
+
+	calls := 0
+	hook := OnBeforeSet(func(key string, value string) (string, error) {
+		calls++
+		return value + "!", nil
+	})
+	store, _ := NewStore[string, string](LogPath(logPath), hook)
+	store.Set("name", "toby")
+	store.Close()
+
+	store, _ = NewStore[string, string](LogPath(logPath), hook)
+	defer store.Close()
+	v, _ := store.Get("name")
+	assert.Equal(t, "toby!", v)
+	assert.Equal(t, 1, calls)
+}
+
+func TestOnAfterSetAndUnset(t *testing.T) {
+	var events []string
+	store, _ := NewStore[string, string](
+		OnAfterSet(func(key string, value string) { events = append(events, "set "+key+"="+value) }),
+		OnAfterUnset(func(key string) { events = append(events, "unset "+key) }),
+	)
+
+	store.Set("name", "toby")
+	store.CompareAndSwap("name", "ralph", "rex")
+	store.Unset("name")
+	assert.Equal(t, []string{"set name=toby", "unset name"}, events)
+}
+
+func TestHookTypeMismatch(t *testing.T) {
+	_, err := NewStore[string, int](OnAfterUnset(func(key int) {}))
+	assert.Error(t, err)
+}
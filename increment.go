@@ -0,0 +1,66 @@
+package kv
+
+import (
+	"errors"
+	"math"
+	"reflect"
+)
+
+// Returned by `Increment` and `Decrement` for stores whose values aren't
+// numbers.
+var ErrNotNumeric = errors.New("Store's values aren't numbers")
+
+// Returned by `Increment` and `Decrement` when the result doesn't fit in the
+// value's type.
+var ErrOutOfRange = errors.New("Result is out of range for the value's type")
+
+func (s *kvStore[K, V]) Increment(key K, delta V) (value V, err error) {
+	result := s.write(s.newUpdate(increment, key, delta))
+	return result.value, result.err
+}
+
+func (s *kvStore[K, V]) Decrement(key K, delta V) (value V, err error) {
+	result := s.write(s.newUpdate(decrement, key, delta))
+	return result.value, result.err
+}
+
+// Adds `delta` to `value`, or subtracts it if `subtract` is true, for values of
+// any integer or floating-point type.
+func addNumber[V any](value V, delta V, subtract bool) (V, error) {
+	v, d := reflect.ValueOf(&value).Elem(), reflect.ValueOf(delta)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		a, b := v.Int(), d.Int()
+		sum := a + b
+		if subtract {
+			sum = a - b
+		}
+		if subtract && (b > 0 && sum > a || b < 0 && sum < a) || !subtract && (b > 0 && sum < a || b < 0 && sum > a) || v.OverflowInt(sum) {
+			return value, ErrOutOfRange
+		}
+		v.SetInt(sum)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		a, b := v.Uint(), d.Uint()
+		sum := a + b
+		if subtract {
+			sum = a - b
+		}
+		if subtract && b > a || !subtract && sum < a || v.OverflowUint(sum) {
+			return value, ErrOutOfRange
+		}
+		v.SetUint(sum)
+	case reflect.Float32, reflect.Float64:
+		sum := v.Float() + d.Float()
+		if subtract {
+			sum = v.Float() - d.Float()
+		}
+		if math.IsInf(sum, 0) || v.OverflowFloat(sum) {
+			return value, ErrOutOfRange
+		}
+		v.SetFloat(sum)
+	default:
+		return value, ErrNotNumeric
+	}
+
+	return value, nil
+}
@@ -0,0 +1,76 @@
+package kv
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncrement(t *testing.T) {
+	defer removeLog()
+
+	store, err := NewStore[string, int](LogPath(logPath))
+	assert.NoError(t, err)
+
+	// Missing keys count as 0:
+	value, err := store.Increment("hits", 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, value)
+	value, _ = store.Decrement("hits", 7)
+	assert.Equal(t, -2, value)
+
+	// Concurrent increments aren't lost:
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Increment("hits", 1)
+		}()
+	}
+	wg.Wait()
+	value, _ = store.Get("hits")
+	assert.Equal(t, 98, value)
+	assert.Equal(t, uint64(102), store.(*kvStore[string, int]).revision)
+	store.Close()
+
+	// Each increment is logged as a set of its result:
+	replayed, err := NewStore[string, int](LogPath(logPath))
+	assert.NoError(t, err)
+	defer replayed.Close()
+	value, _ = replayed.Get("hits")
+	assert.Equal(t, 98, value)
+}
+
+func TestIncrementTypes(t *testing.T) {
+	floats, _ := NewStore[string, float64]()
+	defer floats.Close()
+	value, err := floats.Increment("total", 1.5)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, value)
+
+	bytes, _ := NewStore[string, uint8]()
+	defer bytes.Close()
+	bytes.Set("n", math.MaxUint8)
+	_, err = bytes.Increment("n", 1)
+	assert.ErrorIs(t, err, ErrOutOfRange)
+	_, err = bytes.Decrement("missing", 1)
+	assert.ErrorIs(t, err, ErrOutOfRange)
+	n, _ := bytes.Get("n")
+	assert.Equal(t, uint8(math.MaxUint8), n)
+
+	ints, _ := NewStore[string, int64]()
+	defer ints.Close()
+	ints.Set("n", math.MinInt64)
+	_, err = ints.Decrement("n", 1)
+	assert.ErrorIs(t, err, ErrOutOfRange)
+
+	strings, _ := NewStore[string, string]()
+	defer strings.Close()
+	_, err = strings.Increment("name", "a")
+	assert.ErrorIs(t, err, ErrNotNumeric)
+	_, found := strings.Get("name")
+	assert.False(t, found)
+}
@@ -0,0 +1,88 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Returned by `Index` when an index with the same name is already registered.
+var ErrIndexExists = errors.New("Index already exists")
+
+// Returned by `GetByIndex` when there is no index with the given name.
+var ErrNoIndex = errors.New("No such index")
+
+// A shard's part of a secondary index. Only read and written by the shard's
+// update loop, or while every shard is paused.
+type index[K comparable, V any] struct {
+	// Maps a value to the string it's indexed by.
+	indexer func(V) string
+	// The keys whose values are indexed by each string.
+	entries map[string]map[K]struct{}
+}
+
+func (idx *index[K, V]) add(key K, value V) {
+	indexed := idx.indexer(value)
+	keys, found := idx.entries[indexed]
+	if !found {
+		keys = make(map[K]struct{})
+		idx.entries[indexed] = keys
+	}
+
+	keys[key] = struct{}{}
+}
+
+func (idx *index[K, V]) remove(key K, value V) {
+	indexed := idx.indexer(value)
+	delete(idx.entries[indexed], key)
+	if len(idx.entries[indexed]) == 0 {
+		delete(idx.entries, indexed)
+	}
+}
+
+func (s *kvStore[K, V]) Index(name string, indexer func(V) string) error {
+	var err error
+	pauseErr := s.exclusive(func() {
+		if _, found := s.shards[0].lookup(s.namespace).indexes[name]; found {
+			err = fmt.Errorf("%w: %s", ErrIndexExists, name)
+			return
+		}
+
+		for _, sh := range s.shards {
+			b := sh.bucket(s.namespace)
+			idx := &index[K, V]{indexer: indexer, entries: make(map[string]map[K]struct{})}
+			for k, v := range b.values.all() {
+				idx.add(k, v)
+			}
+			b.indexes[name] = idx
+		}
+	})
+	if pauseErr != nil {
+		return pauseErr
+	}
+
+	return err
+}
+
+func (s *kvStore[K, V]) GetByIndex(name string, indexedValue string) (map[K]V, error) {
+	var values map[K]V
+	var err error
+	pauseErr := s.exclusive(func() {
+		if _, found := s.shards[0].lookup(s.namespace).indexes[name]; !found {
+			err = fmt.Errorf("%w: %s", ErrNoIndex, name)
+			return
+		}
+
+		values = make(map[K]V)
+		for _, sh := range s.shards {
+			b := sh.lookup(s.namespace)
+			for k := range b.indexes[name].entries[indexedValue] {
+				values[k], _ = b.values.get(k)
+			}
+		}
+	})
+	if pauseErr != nil {
+		return nil, pauseErr
+	}
+
+	return values, err
+}
@@ -0,0 +1,46 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type pet struct {
+	Name    string
+	Species string
+}
+
+func TestIndex(t *testing.T) {
+	store, _ := NewStore[string, pet](Shards(4))
+	store.Set("toby", pet{"Toby", "dog"})
+	store.Set("ziggy", pet{"Ziggy", "cat"})
+
+	// The index covers values set before it was registered:
+	err := store.Index("species", func(p pet) string { return p.Species })
+	assert.NoError(t, err)
+	store.Set("ralph", pet{"Ralph", "dog"})
+
+	dogs, err := store.GetByIndex("species", "dog")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]pet{"toby": {"Toby", "dog"}, "ralph": {"Ralph", "dog"}}, dogs)
+
+	// And is kept up to date as values change:
+	store.Set("toby", pet{"Toby", "cat"})
+	store.Unset("ralph")
+	dogs, _ = store.GetByIndex("species", "dog")
+	assert.Empty(t, dogs)
+	cats, _ := store.GetByIndex("species", "cat")
+	assert.Len(t, cats, 2)
+}
+
+func TestIndexErrors(t *testing.T) {
+	store, _ := NewStore[string, pet]()
+	store.Index("species", func(p pet) string { return p.Species })
+
+	err := store.Index("species", func(p pet) string { return p.Name })
+	assert.ErrorIs(t, err, ErrIndexExists)
+
+	_, err = store.GetByIndex("name", "Toby")
+	assert.ErrorIs(t, err, ErrNoIndex)
+}
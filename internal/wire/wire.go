@@ -0,0 +1,233 @@
+// Package wire defines the framed TCP protocol that a store serves, when it's
+// given a `ServerListener`, and that `kvclient` speaks. Each frame is a 4-byte,
+// big-endian length, followed by that many bytes of JSON: a `Request` from the
+// client, or the `Response` to it from the server. Requests on a connection are
+// answered one at a time, in order, except for `Watch`: the server answers it
+// with the watched entries, then sends a `Response` holding each change to them,
+// until either side closes the connection.
+package wire
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// The largest frame either side will read. Requests and responses for bulk
+// operations, like backups, can be much larger than a single value.
+const MaxFrameSize = 1 << 30
+
+// Operations a client can ask a server to carry out, named after the `KVStore`
+// methods they call.
+const (
+	Get               = "get"
+	Set               = "set"
+	Unset             = "unset"
+	GetAt             = "getAt"
+	GetAll            = "getAll"
+	Keys              = "keys"
+	Len               = "len"
+	CompareAndSwap    = "compareAndSwap"
+	SetIfNotExists    = "setIfNotExists"
+	GetMany           = "getMany"
+	SetMany           = "setMany"
+	GetByIndex        = "getByIndex"
+	Backup            = "backup"
+	Restore           = "restore"
+	Export            = "export"
+	Import            = "import"
+	Compact           = "compact"
+	Sync              = "sync"
+	ReplaySummary     = "replaySummary"
+	Stats             = "stats"
+	Healthy           = "healthy"
+	Watch             = "watch"
+	GrantLease        = "grantLease"
+	SetWithLease      = "setWithLease"
+	KeepAlive         = "keepAlive"
+	RevokeLease       = "revokeLease"
+	TryLock           = "tryLock"
+	Members           = "members"
+	Digest            = "digest"
+	DigestEntries     = "digestEntries"
+	Versions          = "versions"
+	GetVersioned      = "getVersioned"
+	Promote           = "promote"
+	Merge             = "merge"
+	Increment         = "increment"
+	Decrement         = "decrement"
+	GetAndDelete      = "getAndDelete"
+	GetAndSet         = "getAndSet"
+	Append            = "append"
+	LPush             = "lPush"
+	RPush             = "rPush"
+	LPop              = "lPop"
+	LRange            = "lRange"
+	SAdd              = "sAdd"
+	SRem              = "sRem"
+	SIsMember         = "sIsMember"
+	SMembers          = "sMembers"
+	ZAdd              = "zAdd"
+	ZRangeByScore     = "zRangeByScore"
+	ZRank             = "zRank"
+	HSet              = "hSet"
+	HGet              = "hGet"
+	HDel              = "hDel"
+	Publish           = "publish"
+	Subscribe         = "subscribe"
+	UnsetIf           = "unsetIf"
+	Clear             = "clear"
+	Rename            = "rename"
+	RenameIfNotExists = "renameIfNotExists"
+	List              = "list"
+	ListDesc          = "listDesc"
+	Scan              = "scan"
+	KeysMatching      = "keysMatching"
+	SearchKeys        = "searchKeys"
+	Search            = "search"
+	// Sets a key like `Set`, but is answered once it's synced to the log.
+	// Servers start consecutive pipelined sets before answering any of them.
+	SetPipelined = "setPipelined"
+	// Commits a transaction's staged writes: it sets `Entries`, and unsets
+	// `Keys`.
+	Commit = "commit"
+	// Authenticates the connection with a token, if the server requires one,
+	// before any other request on it.
+	Authenticate = "authenticate"
+)
+
+// A request for a server to call one of its store's methods. Keys and values
+// are JSON encoded, since the server and client must agree on their types.
+type Request struct {
+	Op string
+	// The namespace the operation applies to.
+	Namespace string          `json:",omitzero"`
+	Key       json.RawMessage `json:",omitzero"`
+	// The key to rename a key to.
+	To       json.RawMessage   `json:",omitzero"`
+	Value    json.RawMessage   `json:",omitzero"`
+	Expected json.RawMessage   `json:",omitzero"`
+	Keys     []json.RawMessage `json:",omitzero"`
+	Entries  []Entry           `json:",omitzero"`
+	Revision uint64            `json:",omitzero"`
+	// The index to look values up in, and the indexed value to look up.
+	Index   string `json:",omitzero"`
+	Indexed string `json:",omitzero"`
+	// The format of an export or import.
+	Format int `json:",omitzero"`
+	// The contents of a backup or import.
+	Data []byte `json:",omitzero"`
+	// The prefix of the keys to watch.
+	Prefix string `json:",omitzero"`
+	// The digest buckets to get the entries of.
+	Buckets []int `json:",omitzero"`
+	// The lease an operation is for, and the TTL of a lease to grant.
+	Lease uint64        `json:",omitzero"`
+	TTL   time.Duration `json:",omitzero"`
+	// The `kv.Consistency` of a get, set or unset.
+	Level uint8 `json:",omitzero"`
+	// The address a promoted follower listens for followers on.
+	Addr string `json:",omitzero"`
+	// The token a client authenticates with.
+	Token string `json:",omitzero"`
+	// The indexes of the first and last elements of a list to get, and the
+	// number of elements to pop.
+	Start int `json:",omitzero"`
+	Stop  int `json:",omitzero"`
+	Count int `json:",omitzero"`
+	// The pub/sub topic to publish to, or subscribe to.
+	Topic string `json:",omitzero"`
+	// The fields of a hash to get or delete.
+	Fields []string `json:",omitzero"`
+	// The lowest and highest scores of the members of a sorted set to get.
+	Min float64 `json:",omitzero"`
+	Max float64 `json:",omitzero"`
+	// The cursor of the page to list, whose size is `Count`.
+	Cursor string `json:",omitzero"`
+	// The cursor of the batch to scan, whose size is `Count`.
+	ScanCursor uint64 `json:",omitzero"`
+	// The glob pattern, or regular expression, of the keys to get.
+	Pattern string `json:",omitzero"`
+	// The words to search values for.
+	Query string `json:",omitzero"`
+}
+
+// The server's response to a request.
+type Response struct {
+	// The error the operation returned, if any.
+	Err      string            `json:",omitzero"`
+	OK       bool              `json:",omitzero"`
+	Value    json.RawMessage   `json:",omitzero"`
+	Revision uint64            `json:",omitzero"`
+	Keys     []json.RawMessage `json:",omitzero"`
+	Entries  []Entry           `json:",omitzero"`
+	Len      int               `json:",omitzero"`
+	Lease    uint64            `json:",omitzero"`
+	// A key's vector clock.
+	Clock map[string]uint64 `json:",omitzero"`
+	// The contents of a backup or export.
+	Data    []byte          `json:",omitzero"`
+	Summary *Summary        `json:",omitzero"`
+	Stats   json.RawMessage `json:",omitzero"`
+	Members json.RawMessage `json:",omitzero"`
+	Digest  json.RawMessage `json:",omitzero"`
+	// A change to the watched keys, as a JSON `kv.Change`.
+	Change json.RawMessage `json:",omitzero"`
+	// The cursor of the next page of a listing.
+	Cursor string `json:",omitzero"`
+	// The cursor of the next batch of a scan.
+	ScanCursor uint64 `json:",omitzero"`
+}
+
+// A key/value pair. For `Versions` and `Merge`, the value is a `kv.Version`.
+type Entry struct {
+	Key   json.RawMessage
+	Value json.RawMessage
+}
+
+// A store's replay summary, with the errors of skipped records as strings.
+type Summary struct {
+	Recovered int
+	Dropped   int
+	Skipped   []Skipped `json:",omitzero"`
+}
+
+// A record that was skipped when the store replayed its log.
+type Skipped struct {
+	Path string
+	Line int
+	Err  string
+}
+
+// Writes a frame holding `v`, encoded as JSON.
+func WriteFrame(w io.Writer, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(payload)), uint32(len(payload)))
+	_, err = w.Write(append(frame, payload...))
+	return err
+}
+
+// Reads a frame, and decodes the JSON in it into `v`.
+func ReadFrame(r io.Reader, v any) error {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > MaxFrameSize {
+		return errors.New("Frame is too large")
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(payload, v)
+}
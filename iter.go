@@ -0,0 +1,66 @@
+package kv
+
+import "iter"
+
+// How many entries a stream sends ahead of the ones its reader has received.
+const streamBufferSize = 64
+
+// A key/value pair sent by `Stream`.
+type Entry[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+func (s *kvStore[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for _, e := range s.entries() {
+			if !yield(e.Key, e.Value) {
+				return
+			}
+		}
+	}
+}
+
+func (s *kvStore[K, V]) Stream() <-chan Entry[K, V] {
+	entries := s.entries()
+	stream := make(chan Entry[K, V], streamBufferSize)
+	go func() {
+		defer close(stream)
+		for _, e := range entries {
+			select {
+			case stream <- Entry[K, V]{e.Key, e.Value}:
+			case <-s.closing:
+				return
+			}
+		}
+	}()
+
+	return stream
+}
+
+// Copies every key/value pair of the namespace to a slice, at a single point
+// in time. It's a slice, rather than a map, since it's only iterated over, and
+// the store can't stay paused while it is, in case the caller uses the store.
+func (s *kvStore[K, V]) entries() []entry[K, V] {
+	var entries []entry[K, V]
+	s.exclusive(func() {
+		entries = make([]entry[K, V], 0, s.len())
+		for _, sh := range s.shards {
+			for k, v := range sh.lookup(s.namespace).values.all() {
+				entries = append(entries, entry[K, V]{Key: k, Value: v})
+			}
+		}
+	})
+
+	return entries
+}
+
+func (s *kvStore[K, V]) KeysIter() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for _, k := range s.Keys() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package kv
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAll(t *testing.T) {
+	store, _ := NewStore[string, int](Shards(4))
+	defer store.Close()
+	store.SetMany(map[string]int{"a": 1, "b": 2, "c": 3})
+	store.Namespace("other").Set("d", 4)
+
+	all := store.All()
+	assert.Equal(t, map[string]int{"a": 1, "b": 2, "c": 3}, maps.Collect(all))
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, slices.Collect(store.KeysIter()))
+
+	// The loop can write to the store, without changing what it's iterating:
+	for k, v := range all {
+		store.Set(k+k, v)
+	}
+	assert.Equal(t, 6, store.Len())
+
+	// Each iteration takes a new snapshot:
+	assert.Len(t, maps.Collect(all), 6)
+
+	n := 0
+	for range store.KeysIter() {
+		n++
+		break
+	}
+	assert.Equal(t, 1, n)
+}
+
+func TestStream(t *testing.T) {
+	store, _ := NewStore[int, int](Shards(4))
+	for i := range 200 {
+		store.Set(i, i*i)
+	}
+
+	stream := store.Stream()
+	// Writes made once it has been called aren't streamed:
+	store.Set(1000, 0)
+	received := make(map[int]int)
+	for e := range stream {
+		received[e.Key] = e.Value
+	}
+	assert.Len(t, received, 200)
+	assert.Equal(t, 81, received[9])
+
+	// A stream that's abandoned stops when the store closes:
+	abandoned := store.Stream()
+	<-abandoned
+	store.Close()
+	n := 1
+	for range abandoned {
+		n++
+	}
+	assert.Less(t, n, 200)
+}
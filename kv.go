@@ -1,88 +1,1060 @@
 package kv
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"os"
+	"hash/maphash"
+	"io"
+	"iter"
+	"net"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/hashicorp/raft"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Returned by any operation on a store after `Close` has been called.
+var ErrClosed = errors.New("Store is closed")
+
+// Returned by writes to a store opened with `RejectWhenQueueFull` when their
+// shard's update queue is full.
+var ErrQueueFull = errors.New("Update queue is full")
+
+// Returned by `Set` and `Unset` on a replication follower, which only accepts
+// updates streamed from its leader.
+var ErrFollower = errors.New("Store is a replication follower and cannot accept writes")
+
+// Returned by every update to a store opened with `ReadOnly`.
+var ErrReadOnly = errors.New("Store is read-only")
+
 // A key/value store that stores its data in-memory, and optionally in a file.
 // When storing to a file, its data will be durable between restarts.
 type KVStore[K comparable, V any] interface {
 	// Gets a value from the store using the provided key. If there is no matching
-	// key in the store, `found` will be false.
+	// key in the store, `found` will be false, unless the store has a `Loader`
+	// that loads it.
 	Get(key K) (value V, found bool)
 
-	// Sets a key/value pair in the store. Returns an error if it failed.
-	Set(key K, value V) error
+	// Sets a key/value pair in the store. Returns the update's revision, or an
+	// error if it failed.
+	Set(key K, value V) (revision uint64, err error)
+
+	// Unsets a key/value pair in the store. Returns the update's revision, or an
+	// error if it failed.
+	Unset(key K) (revision uint64, err error)
+
+	// Sets a key/value pair without waiting for the update to be applied. The
+	// update is queued before `SetAsync` returns, so updates made one after
+	// another are still applied in order. The returned channel receives the
+	// update's error, or nil, once it's applied, and can be ignored.
+	SetAsync(key K, value V) <-chan error
 
-	// Unsets a key/value pair in the store. REturns an error if it failed.
-	Unset(key K) error
+	// Sets a key/value pair without waiting for the update, like `SetAsync`,
+	// and returns a `Future` that resolves once the update has been applied,
+	// and synced to the write-ahead log if the store has one. Syncs are shared by the writes waiting for
+	// them, so keeping many writes in flight is much faster than making them one
+	// at a time, and they're still applied in the order they were made.
+	SetPipelined(key K, value V) Future
 
-	// Gets all data in the store as a map.
+	// Sets a key/value pair like `Set`, and on a replication leader, waits
+	// until as many of its followers as `level` asks for have applied it.
+	// Returns `ErrNotEnoughReplicas` if they don't in time, though the leader
+	// has still applied it. In a Raft cluster, every write is already committed
+	// by a quorum.
+	SetWithConsistency(key K, value V, level Consistency) (revision uint64, err error)
+
+	// Unsets a key/value pair like `Unset`, waiting for followers like
+	// `SetWithConsistency`.
+	UnsetWithConsistency(key K, level Consistency) (revision uint64, err error)
+
+	// Gets a value like `Get`. On a replication follower, any `level` but
+	// `ConsistencyOne` first waits until the follower has caught up with
+	// everything its leader had applied, so it sees every write that finished
+	// before the read started. Returns `ErrNoLeader` if it can't.
+	GetWithConsistency(key K, level Consistency) (value V, found bool, err error)
+
+	// Promotes a replication follower to leader, so an operator can fail over
+	// to it when its leader fails. The follower stops applying the leader's
+	// stream, and starts accepting writes, and if `replicationAddr` isn't "",
+	// followers on that address. If the old leader is still reachable, it's
+	// fenced: it returns `ErrFenced` from writes, and drops its followers, who
+	// must be pointed at the new leader with `FollowerOf`. Followers of the new
+	// leader never follow a leader that was fenced.
+	Promote(replicationAddr string) error
+
+	// Gets the value a key had as of a revision, by reading through the
+	// write-ahead log. Every update is assigned the next revision in sequence,
+	// across all keys. `found` is false if the key wasn't in the store at that
+	// revision. Returns `ErrCompacted` if the revision is older than the log's
+	// history, or an error if the store has no log.
+	GetAt(key K, revision uint64) (value V, found bool, err error)
+
+	// Gets a copy of all data in the store as a map, taken at a single point in
+	// time. Changing the map doesn't change the store, and later updates to the
+	// store don't change the map. With the default storage, shards are only
+	// paused while a view of their data is taken, and writes carry on while
+	// it's copied; with other storages, every shard stays paused until the
+	// copy is done.
 	GetAll() map[K]V
+
+	// Gets the key/value pairs that `match` returns true for, taken at a single
+	// point in time, like `GetAll`, but without copying the pairs that don't
+	// match. With storages other than the default one, `match` is called while
+	// every shard is paused, so it should be quick, and mustn't use the store.
+	Find(match func(key K, value V) bool) map[K]V
+
+	// Gets a key/value pair that `match` returns true for, like `Find`, but
+	// stops at the first one it finds. Which pair that is, if several match,
+	// isn't defined. Returns false if none match.
+	FindFirst(match func(key K, value V) bool) (key K, value V, found bool)
+
+	// Calls `fn` with each key/value pair, taken at a single point in time, in
+	// no particular order, until it returns false. Like `Find`, it's called
+	// while every shard is paused. `Aggregate` builds on it to fold the pairs
+	// into a result.
+	Range(fn func(key K, value V) bool)
+
+	// Counts the key/value pairs that `match` returns true for, like `Find`,
+	// without copying any of them.
+	Count(match func(key K, value V) bool) int
+
+	// Gets a page of at most `limit` key/value pairs, taken at a single point
+	// in time, starting after the page that returned `cursor`, or at the start
+	// if it's empty. Pages are ordered by key: string keys in byte order, and
+	// other keys by their JSON encoding. Returns the cursor of the next page,
+	// which is empty after the last one. A key that's there throughout a
+	// listing is in exactly one of its pages, however the store changes
+	// between them.
+	List(cursor string, limit int) (page map[K]V, next string, err error)
+
+	// Gets a page of key/value pairs like `List`, but in the opposite order,
+	// starting from the last key, such as to get the latest entries when keys
+	// start with a timestamp. Its cursors can only be given back to `ListDesc`.
+	ListDesc(cursor string, limit int) (page map[K]V, next string, err error)
+
+	// Gets a batch of about `count` key/value pairs, starting from `cursor`, or
+	// from the start if it's 0, like Redis's `SCAN`. Returns the cursor to get
+	// the next batch with, which is 0 once every key has been scanned. Writes
+	// between batches don't cause a key to be visited twice, and a key that's
+	// there throughout a scan is always visited. The order isn't defined, and
+	// cursors are only valid for the store that returned them, until it closes.
+	Scan(cursor uint64, count int) (pairs map[K]V, next uint64, err error)
+
+	// Yields every key/value pair, taken at a single point in time each time
+	// it's iterated over, in no particular order. The pairs are copied to a
+	// slice, rather than a map, before the first is yielded, so the loop can
+	// use the store.
+	All() iter.Seq2[K, V]
+
+	// Yields every key, taken at a single point in time each time it's
+	// iterated over, like `All`.
+	KeysIter() iter.Seq[K]
+
+	// Sends every key/value pair, taken at a single point in time when it's
+	// called, in no particular order, then closes the channel. Only a few
+	// entries are sent ahead of the reader. If the reader stops early, the
+	// rest are dropped once the store is closed.
+	Stream() <-chan Entry[K, V]
+
+	// Gets every key in the store, in no particular order, taken at a single
+	// point in time, like `GetAll`.
+	Keys() []K
+
+	// Gets the keys that match the glob `pattern`, in no particular order. `*`
+	// matches any run of characters, including `/`, `?` any one character, and
+	// `\` escapes the character after it. Returns `ErrNotStringKeys` if the
+	// store's keys aren't strings. Like `Keys`, they're taken at a single point
+	// in time.
+	KeysMatching(pattern string) (keys []K, err error)
+
+	// Yields the keys that `pattern` matches, in no particular order, from the
+	// keys the store had when it was called. String keys are matched as they
+	// are, and other keys as JSON.
+	SearchKeys(pattern *regexp.Regexp) iter.Seq[K]
+
+	// Gets the number of keys in the store at a single point in time, like
+	// `Keys`.
+	Len() int
+
+	// Sets a key to `value` only if its current value is `expected`, as a
+	// single atomic update. Returns whether the value was swapped; a key that
+	// isn't in the store is never swapped.
+	CompareAndSwap(key K, expected V, value V) (swapped bool, err error)
+
+	// Unsets a key only if its current value is `expected`, as a single atomic
+	// update, so a lock is only released by its owner, for example. Returns
+	// whether the key was unset.
+	UnsetIf(key K, expected V) (deleted bool, err error)
+
+	// Moves a key's value to `newKey`, replacing any value it has, as a single
+	// atomic update, which is written to the log as one record. The value keeps
+	// the lease it's attached to. Returns `ErrKeyNotFound` if `oldKey` isn't in
+	// the store.
+	Rename(oldKey K, newKey K) error
+
+	// Renames a key like `Rename`, but only if `newKey` isn't in the store
+	// already. Returns whether the key was renamed.
+	RenameIfNotExists(oldKey K, newKey K) (renamed bool, err error)
+
+	// Sets a key/value pair only if the key isn't already in the store, as a
+	// single atomic update. Returns whether the value was set.
+	SetIfNotExists(key K, value V) (set bool, err error)
+
+	// Unsets a key, and returns the value it had, as a single atomic update, so
+	// only one caller gets each value, such as a job claimed from a queue.
+	// `found` is false, and nothing is written, if the key isn't in the store.
+	GetAndDelete(key K) (value V, found bool, err error)
+
+	// Appends `values` to a key's value, as a single atomic update: concatenates
+	// it, for strings, or appends its elements, for slices, including
+	// `[]byte`. A key that isn't in the store counts as empty. Only `values` is
+	// written to the log, rather than the whole result. Returns
+	// `ErrNotAppendable` unless the store's values are strings or slices.
+	Append(key K, values V) (revision uint64, err error)
+
+	// Inserts the elements of `values` at the front of a key's list, in the
+	// order they're given, as a single atomic update, and returns the list's
+	// new length. A key that isn't in the store counts as an empty list. Only
+	// the pushed elements are written to the log. Returns `ErrNotList` unless
+	// the store's values are slices.
+	LPush(key K, values V) (length int, err error)
+
+	// Adds the elements of `values` to the back of a key's list, like `LPush`
+	// does to its front.
+	RPush(key K, values V) (length int, err error)
+
+	// Removes up to `count` elements from the front of a key's list, as a
+	// single atomic update, and returns them. A list that's left empty is
+	// unset; a key that isn't in the store has nothing to pop. Together with
+	// `RPush`, makes a durable first-in, first-out queue.
+	LPop(key K, count int) (values V, err error)
+
+	// Gets the elements of a key's list from index `start` to `stop`,
+	// inclusive. Negative indexes count back from the end, so `LRange(key, 0,
+	// -1)` gets the whole list. Indexes past the end are clipped to it.
+	LRange(key K, start int, stop int) (values V, err error)
+
+	// Adds the elements of `members` to a key's set, as a single atomic update,
+	// and returns how many weren't in it already. A set is a slice of distinct
+	// elements, in the order they were added. A key that isn't in the store
+	// counts as an empty set. Only the members given are written to the log.
+	// Returns `ErrNotSet` unless the store's values are slices of comparable
+	// elements.
+	SAdd(key K, members V) (added int, err error)
+
+	// Removes the elements of `members` from a key's set, as a single atomic
+	// update, and returns how many were in it. A set that's left empty is
+	// unset.
+	SRem(key K, members V) (removed int, err error)
+
+	// Reports whether every element of `members` is in a key's set.
+	SIsMember(key K, members V) (isMember bool, err error)
+
+	// Gets the members of a key's set.
+	SMembers(key K) (members V, err error)
+
+	// Adds `members` to a key's sorted set, or moves the ones that are already
+	// in it to their new score, as a single atomic update, and returns how many
+	// weren't in it. A sorted set is a slice of `Scored` members, kept in order
+	// of score, and members with the same score in the order they were added.
+	// A key that isn't in the store counts as an empty sorted set. Only the
+	// members given are written to the log. Returns `ErrNotSortedSet` unless
+	// the store's values are slices of `Scored` members.
+	ZAdd(key K, members V) (added int, err error)
+
+	// Gets the members of a key's sorted set with scores from `min` to `max`,
+	// inclusive, in order of score.
+	ZRangeByScore(key K, min float64, max float64) (members V, err error)
+
+	// Gets the rank of each of `members` in a key's sorted set: its position
+	// in order of score, from 0, or -1 if it isn't in the set. Only the members'
+	// `Member` is used, not their `Score`.
+	ZRank(key K, members V) (ranks []int, err error)
+
+	// Sets `fields` in a key's hash, as a single atomic update, and returns how
+	// many weren't in it already. A hash is a map with string keys, its
+	// fields, whose fields can be set and deleted without rewriting the whole
+	// map: only the fields given are written to the log. A key that isn't in
+	// the store counts as an empty hash. Returns `ErrNotHash` unless the
+	// store's values are maps with string keys.
+	HSet(key K, fields V) (added int, err error)
+
+	// Gets `fields` from a key's hash. Fields that aren't in it are left out
+	// of the map returned.
+	HGet(key K, fields ...string) (values V, err error)
+
+	// Deletes `fields` from a key's hash, as a single atomic update, and
+	// returns how many were in it. A hash that's left empty is unset.
+	HDel(key K, fields ...string) (removed int, err error)
+
+	// Sends `message` to every current subscriber to `topic`, in the store's
+	// namespace, and returns how many there were. Messages aren't written to
+	// the log, or kept for subscribers that start later; they're lost if no one
+	// is subscribed. Subscribers that have fallen far behind are dropped.
+	Publish(topic string, message V) (receivers int, err error)
+
+	// Subscribes to the messages published to `topic`, in the store's
+	// namespace, from now on. Close the subscription when it's no longer
+	// needed.
+	Subscribe(topic string) (Subscription[V], error)
+
+	// Sets a key/value pair, and returns the value the key had before, as a
+	// single atomic update. `found` is false if the key wasn't in the store.
+	GetAndSet(key K, value V) (old V, found bool, err error)
+
+	// Gets a value from the store, or if the key isn't in the store, calls
+	// `loader` to compute a value and sets it. Concurrent calls for the same
+	// missing key share a single call to `loader`. If `loader` returns an error,
+	// nothing is set and the error is returned. If it panics, the calls sharing
+	// it return an error, and the panic carries on in the one that called it.
+	GetOrCompute(key K, loader func() (V, error)) (value V, err error)
+
+	// Adds `delta` to a key's value, as a single atomic update, and returns the
+	// result. A key that isn't in the store counts as 0. Returns
+	// `ErrNotNumeric` unless the store's values are integers or floats, and
+	// `ErrOutOfRange` if the result doesn't fit in their type.
+	Increment(key K, delta V) (value V, err error)
+
+	// Subtracts `delta` from a key's value, like `Increment`.
+	Decrement(key K, delta V) (value V, err error)
+
+	// Sets a key to the value `fn` returns, given the key's current value, and
+	// whether it's in the store, as a single atomic update, and returns the
+	// new value. If `fn` returns an error, nothing is set and the error is
+	// returned. `fn` runs in the update loop of the key's shard, so it must be
+	// quick, and mustn't use the store. On a Raft cluster, or a remote store,
+	// it runs on the caller's side, and may be called again if the key changes
+	// before its result is set.
+	Update(key K, fn func(old V, found bool) (V, error)) (value V, err error)
+
+	// Gets the values of several keys at once, from a consistent view of the
+	// store. Keys that aren't in the store are left out of the result.
+	GetMany(keys []K) map[K]V
+
+	// Sets several key/value pairs as a single atomic update, written to the
+	// log as a single record.
+	SetMany(entries map[K]V) error
+
+	// Starts a transaction, which stages sets and unsets until it's committed,
+	// then applies them as a single atomic update, written to the log as a
+	// single record, or discards them if it's rolled back. Committing doesn't
+	// check whether the keys the transaction read have changed since; compare
+	// and swap them for that.
+	BeginTxn() Txn[K, V]
+
+	// Removes every key/value pair in the store's namespace, as a single atomic
+	// update, written to the log as a single record rather than an unset for
+	// each key. Keys in other namespaces are kept.
+	Clear() error
+
+	// Grants a lease that expires `ttl` after it's granted, or after it was
+	// last kept alive. When it expires, or is revoked, every key attached to it
+	// is unset, as a single atomic update. Leases are kept in the write-ahead
+	// log, but their TTLs start over when the store is reopened.
+	GrantLease(ttl time.Duration) (LeaseID, error)
+
+	// Sets a key/value pair, like `Set`, and attaches the key to a lease.
+	// Setting the key again without the lease, or unsetting it, detaches it.
+	// Returns `ErrNoLease` if the lease has expired.
+	SetWithLease(key K, value V, lease LeaseID) (revision uint64, err error)
+
+	// Starts a lease's TTL over, so it doesn't expire yet.
+	KeepAlive(lease LeaseID) error
+
+	// Revokes a lease before it expires, and unsets every key attached to it.
+	RevokeLease(lease LeaseID) error
+
+	// Takes a lock, for mutual exclusion between processes that share the
+	// store, by setting `key` to `owner` if it isn't in the store, and attaching
+	// it to a new lease with the given TTL, as a single atomic update. Returns
+	// the lease, and whether the lock was taken; if the key is already set,
+	// someone else holds the lock. Keep the lease alive to hold the lock for
+	// longer than its TTL.
+	TryLock(key K, owner V, ttl time.Duration) (lease LeaseID, locked bool, err error)
+
+	// Takes a lock like `TryLock`, waiting for whoever holds it to unlock it,
+	// or for their lease to expire.
+	Lock(key K, owner V, ttl time.Duration) (lease LeaseID, err error)
+
+	// Releases a lock by revoking its lease, which unsets its key. Returns
+	// `ErrNoLease` if the lease has expired, in which case the lock may have
+	// been taken by someone else since.
+	Unlock(lease LeaseID) error
+
+	// Registers a secondary index over the store's values. `indexer` maps each
+	// value to the string it's indexed by. The index covers every value already
+	// in the store, and is kept up to date as values change. Indexes are held in
+	// memory only, so they need to be registered again when the store restarts.
+	Index(name string, indexer func(V) string) error
+
+	// Gets every key/value pair whose value is indexed by `indexedValue` in the
+	// named index.
+	GetByIndex(name string, indexedValue string) (map[K]V, error)
+
+	// Gets the keys whose values contain any of the words in `query`, most
+	// relevant first, ranked with BM25. Words are runs of letters and digits,
+	// matched regardless of case. Returns `ErrNoTextIndex` unless the store was
+	// opened with `FullTextSearch`.
+	Search(query string) (keys []K, err error)
+
+	// Writes a consistent snapshot of every key/value pair in the store to `w`,
+	// as a stream of JSON entries. The store can keep taking writes while the
+	// backup is written.
+	Backup(w io.Writer) error
+
+	// Replaces every key/value pair in the store with a backup written by
+	// `Backup`, as a single atomic update.
+	Restore(r io.Reader) error
+
+	// Writes every key/value pair in the store to `w` in the given format, for
+	// reporting or moving the data to another system.
+	Export(w io.Writer, format Format) error
+
+	// Reads key/value pairs written in the given format, such as by `Export`,
+	// and sets them all as a single atomic update. Keys that aren't in the
+	// import are left as they are.
+	Import(r io.Reader, format Format) error
+
+	// Flushes every update written to the write-ahead log so far to disk, and
+	// waits for it to get there, so they survive a crash of the machine, not just
+	// of the process. Does nothing if the store has no log.
+	Sync() error
+
+	// Rewrites the write-ahead log so it only holds the records needed to
+	// recreate the store's current state, so it's smaller and faster to replay.
+	Compact() error
+
+	// Summarizes how many records were recovered from the write-ahead log when
+	// the store was opened, and how many were dropped from its corrupt tail.
+	ReplaySummary() ReplaySummary
+
+	// Gets statistics about the store, for monitoring it.
+	Stats() Stats
+
+	// Checks that the store can serve requests, for liveness and readiness
+	// probes: that every shard's update loop answers a no-op sent through its
+	// queue, that the store isn't fenced, and that its write-ahead log, if it
+	// has one, can be flushed to disk. Returns nil if it's healthy.
+	Healthy() error
+
+	// Summarizes the namespace's data as a `Digest`, which `SyncWith` compares
+	// with another store's to find the keys they disagree on.
+	Digest() Digest
+
+	// Gets the key/value pairs in the given buckets of the namespace's digest.
+	DigestEntries(buckets []int) map[K]V
+
+	// Makes the namespace's data match `other`'s, such as a replica that has
+	// been out of touch, by comparing their digests, and only
+	// copying the keys in buckets that differ. Keys that `other` doesn't have are
+	// unset. Changes made to either store while they're compared may not be
+	// copied until the next sync.
+	SyncWith(other KVStore[K, V]) error
+
+	// Gets every key in the namespace with its value and the time it was
+	// written, including keys that have been unset, for `Merge`.
+	Versions() map[K]Version[V]
+
+	// Merges `other`'s data into the namespace, keeping whichever version of
+	// each key was written last, so stores that take writes independently
+	// converge once they've merged each other. Both stores must use
+	// `LastWriterWins`.
+	Merge(other KVStore[K, V]) error
+
+	// Gets the value for a given key, like `Get`, along with its vector
+	// clock, for stores that use `VectorClocks`. Comparing the clocks of two
+	// versions of a key tells whether one was written after the other, or
+	// whether they conflict. An unset key keeps its clock.
+	GetVersioned(key K) (value V, clock VectorClock, found bool)
+
+	// Gets the nodes in the store's gossip cluster, including this one, or nil
+	// if the store doesn't gossip.
+	Members() []Member
+
+	// Gets a view of the store scoped to a namespace. Every namespace has its
+	// own keys, so the same key can hold a different value in each, but they all
+	// share the store's write-ahead log, followers and cluster. The store itself
+	// is the namespace named "". Namespaces aren't nested: calling `Namespace` on
+	// a view gets a sibling namespace, not a child of it.
+	Namespace(name string) KVStore[K, V]
+
+	// Stops the store, closing its write-ahead log and any replication
+	// connections. Subsequent updates return `ErrClosed`. Closing any namespace
+	// closes the whole store.
+	Close() error
 }
 
-// Underlying implementation of the key/value store.
+// Underlying implementation of the key/value store: a view of one namespace of
+// the shared `core`.
 type kvStore[K comparable, V any] struct {
-	// The backing key/value map.
-	data map[K]V
-	// A singular update queue, implemented as a channel, that receives
-	// update messages and applies them to the store.
-	updates chan (update[K, V])
+	*core[K, V]
+	// The namespace that this view reads and writes.
+	namespace string
+	// The client of one of the store's servers that the view was made for, or
+	// nil for the program the store is embedded in.
+	caller *caller
+}
+
+// State shared by every namespace of a store.
+type core[K comparable, V any] struct {
+	// The store's key space, partitioned by the hash of each key. Each shard
+	// has its own singular update queue, so updates to keys in different
+	// shards are applied in parallel, while updates to the same key are still
+	// applied one at a time, in the order they're received.
+	shards []*shard[K, V]
+	// Seeds the hash used to assign keys to shards, and to order them for
+	// `Scan`.
+	seed maphash.Seed
 	// `log` is a write-ahead log where the store writes all updates so they can be
 	// replayed, providing durability between restarts.
-	log *os.File
+	log *writeAheadLog
+	// The memory-mapped file that values are kept in, if the store doesn't keep
+	// them in memory.
+	values *valueFile
+	// Keeps the store's data on disk, if it uses `LSMStorage`.
+	lsm *lsmEngine
+	// The result of replaying `log` when the store was opened.
+	replaySummary ReplaySummary
+	// How long replaying `log` took.
+	replayDuration time.Duration
+	// Counts and times operations, for `Stats`.
+	metrics *metrics
+	// Serializes writes to the log and to followers, which all shards share.
+	// Shards only hold it to number a batch and write it to the log, then to
+	// record and publish the batch once they've applied it to their data, so
+	// they apply batches in parallel.
+	commit sync.Mutex
+	// The revision of the last update applied to the store. Guarded by `commit`.
+	revision uint64
+	// The revision of the last update written to the log, which is ahead of
+	// `revision` while batches are being applied. Guarded by `commit`.
+	logged uint64
+	// Each batch written to the log is given the next ticket, and waits for
+	// `turn` to reach it before it's recorded and published, so batches are
+	// published in the order they were logged. Guarded by `commit`, which
+	// `turns` waits on.
+	tickets uint64
+	turn    uint64
+	turns   *sync.Cond
+	// The revision of the last update known to be synced to the write-ahead
+	// log. Read without holding `commit`, and written while holding it.
+	synced atomic.Uint64
+	// The number of updates applied since the last snapshot. Guarded by `commit`.
+	sinceSnapshot int
+	// How many bytes had been appended to the log when the last snapshot was
+	// taken. Guarded by `commit`.
+	appendedAtSnapshot uint64
+	// How far background compactions have got.
+	compaction compactionProgress
+	// Hands jobs of encoding values to the store's `EncodeWorkers`, which
+	// receive them only while they're idle. Nil if it has none.
+	encoders chan func()
+	// Asks the snapshot goroutine to take a snapshot.
+	snapshotRequests chan struct{}
+	// Serializes snapshots and compactions, which both replace parts of the log.
+	maintenance sync.Mutex
 	// Options for the store.
 	options *optionsData
+	// Functions called as updates are applied.
+	hooks hooks[K, V]
+	// Publishers that changes are sent to, and whether they're being sent yet.
+	// `publishing` is guarded by `commit`.
+	publications []*publication[K, V]
+	publishing   bool
+	// Remote clients watching changes to the store. Guarded by `commit`.
+	watchers map[*watcher[K, V]]struct{}
+	// Subscriptions to the store's pub/sub topics.
+	topics topics[V]
+	// The store's leases, and the lease each leased key is attached to. Guarded
+	// by `commit`.
+	leases map[LeaseID]*lease[K]
+	leased map[namespacedKey[K]]LeaseID
+	// Timestamps updates, and the time each key was last written, for stores
+	// that use `LastWriterWins`. Guarded by `commit`.
+	clock      hybridClock
+	timestamps map[namespacedKey[K]]uint64
+	// The vector clock of each key, for stores that use `VectorClocks`.
+	// Guarded by `commit`.
+	clocks map[namespacedKey[K]]VectorClock
+	// `GetOrCompute` loaders that are currently running.
+	computations computations[namespacedKey[K], V]
+	// Followers currently receiving this store's update stream. Guarded by
+	// `commit`.
+	replicas map[*replica[K, V]]struct{}
+	// Closed, and replaced, whenever a follower acknowledges an update or
+	// disconnects, to wake writes waiting for them. Guarded by `ackMu`.
+	ackMu sync.Mutex
+	acked chan struct{}
+	// A follower's connection to its leader.
+	upstream upstream
+	// Whether the store is a follower that accepts updates from its leader,
+	// rather than writes, until it's promoted.
+	following atomic.Bool
+	// Whether a follower has been promoted over the store, as `fence` says.
+	fenced atomic.Bool
+	// The number of times a follower has been promoted in the store's lineage,
+	// to fence old leaders. Guarded by `commit`.
+	epoch uint64
+	// The index of the last Raft log entry applied to the store, which its own
+	// log records too, so entries it already has aren't applied again when
+	// Raft replays them. Guarded by `commit`.
+	raftIndex uint64
+	// The Raft node that commits updates when the store runs in clustered mode.
+	raft *raft.Raft
+	// Accepts connections from followers when the store is a replication leader.
+	listener net.Listener
+	// Accepts connections from `kvclient` clients.
+	server net.Listener
+	// Accepts connections from memcached clients.
+	memcached net.Listener
+	// Answers health checks over HTTP.
+	health *http.Server
+	// Gossips with the other nodes of the store's cluster.
+	members *memberlist.Memberlist
+	// Closed to signal every goroutine owned by the store to stop.
+	closing chan struct{}
+	// Tracks the shards' `readUpdates` goroutines.
+	loops sync.WaitGroup
+	// Result channels for queued updates, reused so that writes don't each
+	// allocate one. Each is buffered, so an update loop never waits for its
+	// result to be read.
+	results sync.Pool
+	// Closed once every `readUpdates` goroutine has stopped, so nothing waits
+	// on an update that was left in a queue.
+	stopped chan struct{}
+	// Tracks background goroutines that use the log, like the one that takes
+	// snapshots.
+	background sync.WaitGroup
+	closeOnce  sync.Once
 }
 
+// The most updates a shard applies, and writes to the log, as one batch.
+const maxBatchSize = 128
+
 // Enum of all types of updates to the store.
 type updateType uint8
 
 const (
 	set   updateType = 0
 	unset updateType = 1
+	// Removes every key from the store, in every namespace. Sent to followers
+	// before a snapshot so they discard any state the leader no longer has.
+	truncate updateType = 2
+	// Registers a follower with the store. Never written to the log.
+	subscribe updateType = 3
+	// Pauses a shard's update loop so another goroutine can access every shard
+	// at once. Never written to the log.
+	pause updateType = 4
+	// Sets a key only if its current value is `Expected`. Logged as a `set`.
+	compareAndSwap updateType = 5
+	// Sets a key only if it isn't already in the store. Logged as a `set`.
+	setIfNotExists updateType = 6
+	// Sets every key/value pair in `Entries`.
+	setMany updateType = 7
+	// Replaces every key/value pair in the namespace with `Entries`.
+	replaceAll updateType = 8
+	// Grants a lease with the given `TTL`. Its ID is the update's revision.
+	grantLease updateType = 9
+	// Revokes a lease, unsetting the keys attached to it, which are resolved
+	// into `Entries` when it's applied.
+	revokeLease updateType = 10
+	// Sets or unsets a key, as `Deleted` says, only if the version at
+	// `Timestamp` is newer than the key's. Logged as a `set` or `unset`.
+	merge updateType = 11
+	// Marks the point in a leader's update stream a follower asked to catch
+	// up to. Never written to the log.
+	checkpoint updateType = 12
+	// Starts a new `Epoch`, as a follower is promoted to leader.
+	promote updateType = 13
+	// Round-trips through a shard's update loop, for `Healthy`. Never written
+	// to the log.
+	ping updateType = 14
+	// Adds `Value` to a key's number, or subtracts it. Logged as a `set` of the
+	// result.
+	increment updateType = 15
+	decrement updateType = 16
+	// Sets a key to the value its `modify` function returns for its current
+	// one. Logged as a `set`.
+	modify updateType = 17
+	// Unsets a key, reporting the value it had. Logged as an `unset`, or not at
+	// all if the key isn't in the store.
+	getAndDelete updateType = 18
+	// Sets a key, reporting the value it had. Logged as a `set`.
+	getAndSet updateType = 19
+	// Appends `Value` to a key's string or slice. Logged as it is, so the log
+	// only holds what was appended, and applied as a `set` of the result.
+	appendValue updateType = 20
+	// Pushes the elements of `Value` onto the front or back of a key's list, or
+	// pops `Count` elements off its front. Logged as they are, like
+	// `appendValue`, unless a pop empties the list, which is logged as an
+	// `unset`.
+	pushFront updateType = 21
+	pushBack  updateType = 22
+	popFront  updateType = 23
+	// Adds the elements of `Value` to a key's set, or removes them from it.
+	// Logged as they are, unless removing them empties the set.
+	addMembers    updateType = 24
+	removeMembers updateType = 25
+	// Adds the members of `Value` to a key's sorted set, or moves them to
+	// their new score. Logged as it is.
+	addScored updateType = 26
+	// Sets the fields of `Value` in a key's hash, or deletes its `Fields`.
+	// Logged as they are, unless deleting fields empties the hash.
+	setFields    updateType = 27
+	deleteFields updateType = 28
+	// Unsets a key if it has the value `Expected`. Logged as an `unset`.
+	unsetIf updateType = 29
+	// Moves a key's value to the key `To`, unsetting the key. Logged as it is,
+	// with the value it moves as `Value`, so it's a single record.
+	rename updateType = 30
+	// Renames a key only if `To` isn't in the store. Logged as a `rename`.
+	renameIfNotExists updateType = 31
+	// Unsets every key in `Unsets`, and sets every key/value pair in `Entries`,
+	// as a committed transaction.
+	transaction updateType = 32
 )
 
 // Request to update the state of the store.
 type update[K comparable, V any] struct {
 	UpdateType updateType
-	Key        K
-	Value      V
-	append     bool
-	result     chan (updateResult)
+	// The namespace the update applies to.
+	Namespace string `json:",omitzero"`
+	// The update's place in the store's history, assigned when it's applied.
+	Revision uint64 `json:",omitzero"`
+	Key      K
+	Value    V
+	// The key a `rename` moves its key's value to.
+	To K `json:",omitzero"`
+	// The value a conditional update expects the key to have.
+	Expected V `json:",omitzero"`
+	// The key/value pairs set by a `setMany` or `transaction` update.
+	Entries []entry[K, V] `json:",omitzero"`
+	// The keys unset by a `transaction` update.
+	Unsets []K `json:",omitzero"`
+	// The lease a `set` attaches its key to, or that a lease update is for,
+	// and the TTL of a lease being granted. A `set` with a TTL grants the lease
+	// it attaches its key to.
+	Lease LeaseID       `json:",omitzero"`
+	TTL   time.Duration `json:",omitzero"`
+	// When the update was made, for stores that use `LastWriterWins`, and
+	// whether a `merge` unsets its key.
+	Timestamp uint64 `json:",omitzero"`
+	Deleted   bool   `json:",omitzero"`
+	// The node that made the update, for stores that use `VectorClocks`, or
+	// the vector clock a `merge` leaves its key with.
+	Node  string      `json:",omitzero"`
+	Clock VectorClock `json:",omitzero"`
+	// The epoch a `promote` starts, or that a `truncate` starting a snapshot's
+	// updates was taken in.
+	Epoch uint64 `json:",omitzero"`
+	// The index of the Raft log entry the update was committed at, for stores
+	// in a Raft cluster, or that a `truncate` starting a snapshot's updates
+	// was taken at.
+	RaftIndex uint64 `json:",omitzero"`
+	// The number of elements a `popFront` pops, and the fields a
+	// `deleteFields` deletes.
+	Count  int      `json:",omitzero"`
+	Fields []string `json:",omitzero"`
+	append bool
+	// The update's `Value`, already encoded as JSON by an encoding worker, if
+	// it was.
+	encodedValue []byte
+	result       chan (updateResult[V])
+	// The follower to register, for `subscribe` updates.
+	replica *replica[K, V]
+	// The barrier to wait at, for `pause` updates.
+	barrier *barrier
+	// The function that computes a key's new value, for `modify` updates.
+	modify func(old V, found bool) (V, error)
+	// The span of a write made on a traced store.
+	span trace.Span
+	// When a write made on this store was queued. Zero for updates replayed
+	// from the log, or streamed from a leader.
+	queued time.Time
+}
+
+// A key/value pair.
+type entry[K comparable, V any] struct {
+	// The pair's namespace, only set in snapshots of the whole store.
+	Namespace string `json:",omitzero"`
+	Key       K
+	Value     V
+}
+
+// An append, push, pop, or change to a set's or sorted set's members, or to a
+// hash's fields, as it was made, and the value it resolved to.
+type delta[V any] struct {
+	updateType updateType
+	value      V
+	count      int
+	fields     []string
+	result     V
 }
 
 // The result of an update operation.
-type updateResult struct {
+type updateResult[V any] struct {
 	ok  bool
 	err error
+	// The key's value before the update, for updates that report it, or after
+	// it, for increments, and whether it was in the store before.
+	value V
+	found bool
+	// The revision assigned to the update, if it was applied.
+	revision uint64
 }
 
-// Instantiates an empty store and starts a goroutine to read
-// messages sent to the `updates` queue.
-func NewStore[K comparable, V any](options ...option) (KVStore[K, V], error) {
-	store := kvStore[K, V]{
-		data:    make(map[K]V),
-		updates: make(chan (update[K, V])),
-		options: applyOptions(options...),
+// Instantiates an empty store and starts a goroutine for each shard to read
+// messages sent to its `updates` queue.
+func NewStore[K comparable, V any](options ...Option) (KVStore[K, V], error) {
+	store := kvStore[K, V]{core: &core[K, V]{
+		seed:             maphash.MakeSeed(),
+		options:          applyOptions(options...),
+		replicas:         make(map[*replica[K, V]]struct{}),
+		acked:            make(chan struct{}),
+		watchers:         make(map[*watcher[K, V]]struct{}),
+		leases:           make(map[LeaseID]*lease[K]),
+		leased:           make(map[namespacedKey[K]]LeaseID),
+		timestamps:       make(map[namespacedKey[K]]uint64),
+		clocks:           make(map[namespacedKey[K]]VectorClock),
+		metrics:          newMetrics(),
+		snapshotRequests: make(chan struct{}, 1),
+		closing:          make(chan struct{}),
+		stopped:          make(chan struct{}),
+		compaction:       compactionProgress{unpaced: make(chan struct{})},
+		results:          sync.Pool{New: func() any { return make(chan updateResult[V], 1) }},
+	}}
+	store.turns = sync.NewCond(&store.commit)
+
+	if store.options.node != "" && !store.options.lastWriterWins {
+		return nil, errors.New("Cannot use VectorClocks without LastWriterWins")
+	}
+	if store.options.fullText && reflect.TypeFor[V]().Kind() != reflect.String {
+		return nil, errors.New("Cannot use FullTextSearch unless values are strings")
+	}
+	if _, ok := any(update[K, V]{}).(update[string, []byte]); store.options.codec == BytesCodec && !ok {
+		return nil, errors.New("Cannot use BytesCodec unless keys are strings and values are byte slices")
+	}
+	if store.options.encodeWorkers > 0 && store.options.codec != JSONCodec {
+		return nil, errors.New("Cannot use EncodeWorkers unless records are encoded with JSONCodec")
+	}
+	store.upstream.stopped = make(chan struct{})
+	store.upstream.done = make(chan struct{})
+	hooks, err := newHooks[K, V](store.options)
+	if err != nil {
+		return nil, err
+	}
+	store.hooks = hooks
+	if store.options.loaderTTL != 0 && hooks.loader == nil {
+		return nil, errors.New("Cannot use LoaderTTL without Loader")
+	}
+	if store.options.loaderTTL < 0 {
+		return nil, errLeaseTTL
+	}
+	if store.publications, err = newPublications[K, V](store.options); err != nil {
+		return nil, err
+	}
+
+	// Keep values in memory, in an arena, in a memory-mapped file or in an LSM
+	// tree, with the hottest of them also in memory if the rest are on disk:
+	newStorage := func() storage[K, V] { return newHAMTStorage[K, V]() }
+	if store.options.mmapPath != "" {
+		values, err := openValueFile(store.options.mmapPath)
+		if err != nil {
+			return nil, err
+		}
+
+		store.values = values
+		newStorage = func() storage[K, V] {
+			var filter *bloomFilter[K]
+			if store.options.bloomKeys > 0 {
+				// Each shard holds its share of the keys:
+				keys := max(store.options.bloomKeys/store.options.shards, 1)
+				filter = newBloomFilter[K](keys, store.options.bloomFalsePositiveRate)
+			}
+			return newFileStorage[K, V](values, filter)
+		}
+	}
+	if store.options.arena {
+		if store.options.mmapPath != "" || store.options.lsmDir != "" {
+			store.Close()
+			return nil, errors.New("Cannot use ArenaStorage with MmapStorage or LSMStorage")
+		}
+		// Keys are looked up by their encoding, which only strings and integers
+		// have exactly one of:
+		switch reflect.TypeFor[K]().Kind() {
+		case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			store.Close()
+			return nil, errors.New("Cannot use ArenaStorage unless keys are strings or integers")
+		}
+		newStorage = func() storage[K, V] { return newArenaStorage[K, V]() }
+	}
+	if store.options.lsmDir != "" {
+		if store.values != nil {
+			store.Close()
+			return nil, errors.New("Cannot use both MmapStorage and LSMStorage")
+		}
+
+		falsePositiveRate := defaultFalsePositiveRate
+		if store.options.bloomKeys > 0 {
+			falsePositiveRate = store.options.bloomFalsePositiveRate
+		}
+		lsm, err := openLSM(store.options.lsmDir, store.options.memtableSize, falsePositiveRate)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+
+		store.lsm = lsm
+		newStorage = func() storage[K, V] { return newLSMStorage[K, V](lsm) }
+	}
+	if store.options.hotEntries > 0 {
+		if store.values == nil && store.lsm == nil {
+			store.Close()
+			return nil, errors.New("Cannot use HotEntries without MmapStorage or LSMStorage")
+		}
+
+		// Each shard keeps its share of the hot keys:
+		capacity := max(store.options.hotEntries/store.options.shards, 1)
+		cold := newStorage
+		newStorage = func() storage[K, V] { return newTieredStorage(cold(), capacity) }
 	}
 
-	// Start receiving updates:
-	go store.readUpdates()
+	// Start the workers that encode values for the update loops, then start
+	// receiving updates:
+	if store.options.encodeWorkers > 0 {
+		store.encoders = make(chan func())
+		for range store.options.encodeWorkers {
+			store.background.Add(1)
+			go store.encodeValues()
+		}
+	}
+	store.shards = make([]*shard[K, V], store.options.shards)
+	for i := range store.shards {
+		store.shards[i] = newShard(newStorage, store.options.queueSize, store.options.fullText)
+		store.loops.Add(1)
+		go store.readUpdates(store.shards[i])
+	}
 
 	// If a path to a write-ahead log was specified, replay it:
 	if store.options.logPath != "" {
-		log, err := os.OpenFile(store.options.logPath, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0600)
+		log, err := openLog(store.options.logPath, store.options)
 		if err != nil {
+			store.Close()
 			return nil, err
 		}
 
 		store.log = log
+		started := time.Now()
 		if err := store.replayUpdatesFromLog(); err != nil {
+			store.Close()
+			return nil, err
+		}
+		store.replayDuration = time.Since(started)
+		store.options.logger.Info("Opened write-ahead log",
+			"path", store.options.logPath,
+			"recovered", store.replaySummary.Recovered,
+			"revision", store.revision,
+			"duration", store.replayDuration,
+		)
+
+		background := store.options.snapshotEvery > 0 || store.options.snapshotInterval > 0 || store.options.autoCompact > 0
+		if background && !store.options.readOnly {
+			store.background.Add(1)
+			go store.takeSnapshots()
+		}
+		if store.log.buffer != nil && store.options.logFlushInterval > 0 {
+			store.background.Add(1)
+			go store.flushLog()
+		}
+	}
+
+	// Publish changes from here on:
+	store.startPublishing()
+
+	// Publish statistics:
+	if store.options.expvarName != "" {
+		if err := publishExpvar(store.options.expvarName, &expvarStore{store.core, store.Stats}); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+
+	// Archive the store:
+	if store.options.archive != nil && store.options.archiveInterval > 0 {
+		format, err := newLogFormat(store.options)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		store.background.Add(1)
+		go store.archive(format)
+	}
+
+	// Expire leases, including those replayed from the log:
+	if !store.options.readOnly && store.options.leaderAddr == "" {
+		store.background.Add(1)
+		go store.expireLeases()
+	}
+
+	// Join a Raft cluster:
+	if store.options.raftConfig != nil {
+		if err := store.startRaft(*store.options.raftConfig); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+
+	// Start streaming updates to followers, or from a leader:
+	if store.options.replicationListener != nil {
+		store.listener = store.options.replicationListener
+		go store.acceptReplicas()
+	}
+	if store.options.leaderAddr != "" {
+		store.following.Store(true)
+		if err := store.followLeader(); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+
+	// Serve clients:
+	if store.options.serverListener != nil {
+		store.server = store.secure(store.options.serverListener)
+		store.background.Add(1)
+		go store.acceptClients()
+	}
+	if store.options.memcachedListener != nil {
+		if err := checkMemcachedTypes[K, V](); err != nil {
+			store.Close()
+			return nil, err
+		}
+		if err := store.checkMemcachedAuth(); err != nil {
+			store.Close()
+			return nil, err
+		}
+		store.memcached = store.secure(store.options.memcachedListener)
+		store.background.Add(1)
+		go store.acceptMemcached()
+	}
+	if store.options.healthListener != nil {
+		store.serveHealth(store.secure(store.options.healthListener))
+	}
+
+	// Join the cluster once the store is ready to serve it:
+	if store.options.gossipConfig != nil {
+		if err := store.startGossip(*store.options.gossipConfig); err != nil {
+			store.Close()
 			return nil, err
 		}
 	}
@@ -91,53 +1063,448 @@ func NewStore[K comparable, V any](options ...option) (KVStore[K, V], error) {
 }
 
 func (s *kvStore[K, V]) Get(key K) (value V, found bool) {
-	value, found = s.data[key]
+	if value, found = s.find(key); found || s.hooks.loader == nil {
+		return value, found
+	}
+
+	value, err := s.compute(key, func() (V, error) { return s.hooks.loader(key) }, s.options.loaderTTL)
+	return value, err == nil
+}
+
+// Gets a value from the store, without calling `Loader` if it's missing.
+func (s *kvStore[K, V]) find(key K) (value V, found bool) {
+	started := time.Now()
+	span := s.startSpan("get", key)
+	value, found = s.shardFor(key).lookup(s.namespace).values.get(key)
+	s.metrics.observe("get", time.Since(started))
+	if !found {
+		s.metrics.misses.Add(1)
+	}
+	if span != nil {
+		span.SetAttributes(attribute.Bool("kv.found", found), attribute.Int("kv.value.size", valueSize(value)))
+		span.End()
+	}
 	return value, found
 }
 
-func (s *kvStore[K, V]) Set(key K, value V) error {
-	append := s.log != nil
-	return s.queueUpdate(update[K, V]{0, key, value, append, make(chan (updateResult))})
+func (s *kvStore[K, V]) Set(key K, value V) (revision uint64, err error) {
+	result := s.write(s.newUpdate(set, key, value))
+	return result.revision, result.err
+}
+
+func (s *kvStore[K, V]) SetAsync(key K, value V) <-chan error {
+	errs := make(chan error, 1)
+	wait, err := s.startWrite(s.newUpdate(set, key, value))
+	if err != nil {
+		errs <- err
+		return errs
+	}
+
+	go func() { errs <- wait().err }()
+	return errs
 }
 
-func (s *kvStore[K, V]) Unset(key K) error {
-	append := s.log != nil
+func (s *kvStore[K, V]) Unset(key K) (revision uint64, err error) {
 	var zeroValue V
-	return s.queueUpdate(update[K, V]{1, key, zeroValue, append, make(chan (updateResult))})
+	result := s.write(s.newUpdate(unset, key, zeroValue))
+	return result.revision, result.err
 }
 
 func (s *kvStore[K, V]) GetAll() map[K]V {
-	return s.data
+	all, _ := s.snapshot()
+	return all
+}
+
+func (s *kvStore[K, V]) Find(match func(key K, value V) bool) map[K]V {
+	found := make(map[K]V)
+	s.readView(nil, func(v view[K, V]) {
+		for _, values := range v.parts(s.namespace) {
+			for k, v := range values.all() {
+				if match(k, v) {
+					found[k] = v
+				}
+			}
+		}
+	})
+
+	return found
+}
+
+func (s *kvStore[K, V]) FindFirst(match func(key K, value V) bool) (key K, value V, found bool) {
+	s.readView(nil, func(v view[K, V]) {
+		for _, values := range v.parts(s.namespace) {
+			for k, v := range values.all() {
+				if match(k, v) {
+					key, value, found = k, v, true
+					return
+				}
+			}
+		}
+	})
+
+	return key, value, found
+}
+
+func (s *kvStore[K, V]) Keys() []K {
+	var keys []K
+	s.readView(nil, func(v view[K, V]) {
+		for _, values := range v.parts(s.namespace) {
+			keys = slices.Grow(keys, values.len())
+			for k := range values.keys() {
+				keys = append(keys, k)
+			}
+		}
+	})
+
+	return keys
 }
 
-// Sends an update to the `updates` channel and waits for the result.
-func (s *kvStore[K, V]) queueUpdate(u update[K, V]) error {
-	s.updates <- u
-	result := <-u.result
+func (s *kvStore[K, V]) Len() int {
+	var n int
+	s.readView(nil, func(v view[K, V]) {
+		for _, values := range v.parts(s.namespace) {
+			n += values.len()
+		}
+	})
+	return n
+}
 
-	if !result.ok && result.err != nil {
-		return result.err
+// Counts the namespace's keys in every shard. The caller must have paused every
+// shard.
+func (s *kvStore[K, V]) len() int {
+	n := 0
+	for _, sh := range s.shards {
+		n += sh.lookup(s.namespace).values.len()
 	}
 
+	return n
+}
+
+func (s *kvStore[K, V]) CompareAndSwap(key K, expected V, value V) (swapped bool, err error) {
+	u := s.newUpdate(compareAndSwap, key, value)
+	u.Expected = expected
+	result := s.write(u)
+	return result.ok, result.err
+}
+
+func (s *kvStore[K, V]) UnsetIf(key K, expected V) (deleted bool, err error) {
+	u := s.newUpdate(unsetIf, key, *new(V))
+	u.Expected = expected
+	result := s.write(u)
+	return result.ok, result.err
+}
+
+func (s *kvStore[K, V]) SetIfNotExists(key K, value V) (set bool, err error) {
+	result := s.write(s.newUpdate(setIfNotExists, key, value))
+	return result.ok, result.err
+}
+
+func (s *kvStore[K, V]) GetAndDelete(key K) (value V, found bool, err error) {
+	result := s.write(s.newUpdate(getAndDelete, key, *new(V)))
+	return result.value, result.found, result.err
+}
+
+func (s *kvStore[K, V]) GetAndSet(key K, value V) (old V, found bool, err error) {
+	result := s.write(s.newUpdate(getAndSet, key, value))
+	return result.value, result.found, result.err
+}
+
+func (s *kvStore[K, V]) GetMany(keys []K) map[K]V {
+	values := make(map[K]V, len(keys))
+	s.exclusive(func() {
+		for _, k := range keys {
+			if v, found := s.shardFor(k).lookup(s.namespace).values.get(k); found {
+				values[k] = v
+			}
+		}
+	})
+
+	return values
+}
+
+func (s *kvStore[K, V]) SetMany(entries map[K]V) error {
+	u := s.newUpdate(setMany, *new(K), *new(V))
+	u.Entries = make([]entry[K, V], 0, len(entries))
+	for k, v := range entries {
+		u.Entries = append(u.Entries, entry[K, V]{Key: k, Value: v})
+	}
+
+	return s.write(u).err
+}
+
+func (s *kvStore[K, V]) Clear() error {
+	// Replacing the namespace with no entries at all clears it:
+	return s.write(s.newUpdate(replaceAll, *new(K), *new(V))).err
+}
+
+func (s *kvStore[K, V]) Sync() error {
+	s.commit.Lock()
+	defer s.commit.Unlock()
+
+	select {
+	case <-s.closing:
+		return ErrClosed
+	default:
+	}
+	if s.log == nil || s.options.readOnly {
+		return nil
+	}
+
+	synced := s.revision
+	if err := s.log.sync(); err != nil {
+		return err
+	}
+	s.synced.Store(synced)
 	return nil
 }
 
-// Appends an update to the write-ahead log.
-func (s *kvStore[K, V]) appendUpdate(u update[K, V]) error {
-	if s.log == nil {
-		return errors.New("Failed to append update, store has no log")
+func (s *kvStore[K, V]) ReplaySummary() ReplaySummary {
+	return s.replaySummary
+}
+
+func (s *kvStore[K, V]) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.options.logger.Info("Closing store")
+		close(s.compaction.unpaced)
+		if s.members != nil {
+			s.stopGossip()
+		}
+		if s.raft != nil {
+			err = s.raft.Shutdown().Error()
+		}
+		// The update loops must still be running to take a snapshot:
+		if s.options.snapshotOnClose && s.log != nil && !s.options.readOnly {
+			if snapshotErr := s.snapshotLog(); err == nil {
+				err = snapshotErr
+			}
+		}
+		close(s.closing)
+		if s.options.expvarName != "" {
+			unpublishExpvar(s.options.expvarName, s.core)
+		}
+		// `Promote` can start listening for followers while the store runs:
+		s.commit.Lock()
+		if s.listener != nil {
+			s.listener.Close()
+		}
+		s.commit.Unlock()
+		if s.server != nil {
+			s.server.Close()
+		}
+		if s.memcached != nil {
+			s.memcached.Close()
+		}
+		if s.health != nil {
+			s.health.Close()
+		}
+
+		// Wait for the update loops to stop before closing the log under them:
+		s.loops.Wait()
+		close(s.stopped)
+		s.commit.Lock()
+		s.dropReplicas()
+		s.stopPublishing()
+		s.commit.Unlock()
+		s.topics.close()
+		s.background.Wait()
+		if s.log != nil {
+			if closeErr := s.log.close(); err == nil {
+				err = closeErr
+			}
+		}
+		if s.values != nil {
+			if closeErr := s.values.close(); err == nil {
+				err = closeErr
+			}
+		}
+		if s.lsm != nil {
+			if closeErr := s.lsm.close(); err == nil {
+				err = closeErr
+			}
+		}
+		if closer, ok := s.options.auditor.(io.Closer); ok {
+			if closeErr := closer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			s.options.logger.Error("Failed to close store cleanly", "error", err)
+		} else {
+			s.options.logger.Info("Closed store")
+		}
+	})
+
+	return err
+}
+
+// Builds an update to the view's namespace that is appended to the write-ahead
+// log if the store has one.
+func (s *kvStore[K, V]) newUpdate(updateType updateType, key K, value V) update[K, V] {
+	return update[K, V]{
+		UpdateType: updateType,
+		Namespace:  s.namespace,
+		Key:        key,
+		Value:      value,
+		append:     s.appends(),
 	}
+}
 
-	json, err := json.Marshal(u)
+// Applies an update requested by a caller. Followers reject it, and in clustered
+// mode it's proposed to the cluster rather than queued directly.
+func (s *kvStore[K, V]) write(u update[K, V]) updateResult[V] {
+	wait, err := s.startWrite(u)
 	if err != nil {
-		return errors.New("Failed to marshal update into JSON for the log")
+		return updateResult[V]{err: err}
 	}
 
-	if _, err := s.log.WriteString(fmt.Sprintf("%s\n", string(json))); err != nil {
-		return err
+	return wait()
+}
+
+// Starts applying an update requested by a caller, like `write`, and returns a
+// function that waits for its result. Single-key updates are already queued
+// when it returns, so updates started one after another are applied in order.
+func (s *kvStore[K, V]) startWrite(u update[K, V]) (wait func() updateResult[V], err error) {
+	switch {
+	case s.options.readOnly:
+		err = ErrReadOnly
+	case s.following.Load():
+		err = ErrFollower
+	case s.fenced.Load():
+		err = ErrFenced
+	}
+	if err != nil {
+		s.audit(u, updateResult[V]{err: err})
+		return nil, err
 	}
 
-	return nil
+	started := time.Now()
+	if s.options.tracer != nil {
+		u.span = s.startSpan(operationNames[u.UpdateType], u.Key, attribute.Int("kv.value.size", valueSize(u.Value)))
+	}
+	u.queued = started
+	var queued func() updateResult[V]
+	if s.raft != nil {
+		queued, err = s.propose(u)
+	} else {
+		queued, err = s.enqueue(u, s.options.rejectWhenQueueFull)
+	}
+	if err != nil {
+		endSpan(u.span, err)
+		s.audit(u, updateResult[V]{err: err})
+		return nil, err
+	}
+
+	return func() updateResult[V] {
+		result := queued()
+		s.metrics.observe(operationNames[u.UpdateType], time.Since(started))
+		endSpan(u.span, result.err)
+		s.audit(u, result)
+		return result
+	}, nil
+}
+
+// Sends an update to the `updates` channel of the shard that owns its key, and
+// waits for the result. Updates that affect every shard are applied while
+// all of them are paused instead. The result's `ok` is false if the update
+// failed, or if it was conditional and its condition wasn't met.
+func (s *kvStore[K, V]) queueUpdate(u update[K, V]) updateResult[V] {
+	wait, err := s.enqueue(u, false)
+	if err != nil {
+		return updateResult[V]{err: err}
+	}
+
+	return wait()
+}
+
+// Sends an update to its shard's `updates` channel, like `queueUpdate`, and
+// returns a function that waits for its result. Updates that affect every shard
+// are applied before it returns. If `reject` is set, it fails with
+// `ErrQueueFull` rather than wait for room in a full queue.
+func (s *kvStore[K, V]) enqueue(u update[K, V], reject bool) (wait func() updateResult[V], err error) {
+	switch u.UpdateType {
+	case truncate, subscribe, setMany, replaceAll, grantLease, revokeLease, promote, rename, renameIfNotExists, transaction:
+		var result updateResult[V]
+		if err := s.exclusive(func() { result = s.apply(nil, u) }); err != nil {
+			return nil, err
+		}
+		return func() updateResult[V] { return result }, nil
+	default:
+		// Updates that weren't given a result channel borrow one from the pool,
+		// and return it once their result has been read:
+		pooled := u.result == nil
+		if pooled {
+			u.result = s.results.Get().(chan updateResult[V])
+		}
+		results := u.result
+		if err := s.send(u, reject); err != nil {
+			if pooled {
+				s.results.Put(results)
+			}
+			return nil, err
+		}
+
+		return func() updateResult[V] {
+			select {
+			case result := <-results:
+				if pooled {
+					s.results.Put(results)
+				}
+				return result
+			case <-s.stopped:
+			}
+			// The loop may have applied the update just before it stopped:
+			select {
+			case result := <-results:
+				return result
+			default:
+				return updateResult[V]{err: ErrClosed}
+			}
+		}, nil
+	}
+}
+
+// Sends an update to the `updates` channel of the shard that owns its key. If
+// `reject` is set, it fails with `ErrQueueFull` rather than wait for room in a
+// full queue.
+func (s *kvStore[K, V]) send(u update[K, V], reject bool) error {
+	sh := s.shardFor(u.Key)
+	s.metrics.queued.Add(1)
+	defer s.metrics.queued.Add(-1)
+
+	select {
+	case sh.updates <- u:
+		return nil
+	case <-s.closing:
+		return ErrClosed
+	default:
+		s.metrics.queueFull.Add(1)
+		if reject {
+			return ErrQueueFull
+		}
+	}
+
+	select {
+	case sh.updates <- u:
+		return nil
+	case <-s.closing:
+		return ErrClosed
+	}
+}
+
+// Reports whether updates are appended to the write-ahead log: the store has
+// one, and didn't open it read-only.
+func (s *kvStore[K, V]) appends() bool {
+	return s.log != nil && !s.options.readOnly
+}
+
+// Appends updates, already encoded, to the write-ahead log in a single write.
+func (s *kvStore[K, V]) appendUpdates(records [][]byte) error {
+	if s.log == nil {
+		return errors.New("Failed to append update, store has no log")
+	}
+
+	return s.log.append(records...)
 }
 
 // "Replays" the store's write-ahead log by reading update data from the log and
@@ -147,44 +1514,587 @@ func (s *kvStore[K, V]) replayUpdatesFromLog() error {
 		return errors.New("Cannot replay updates, store has no log")
 	}
 
-	scanner := bufio.NewScanner(s.log)
-	result := make(chan (updateResult))
-	for scanner.Scan() {
-		update := update[K, V]{}
-		updateJson := scanner.Text()
-		if err := json.Unmarshal([]byte(updateJson), &update); err != nil {
+	var span trace.Span
+	if s.options.tracer != nil {
+		_, span = s.options.tracer.Start(context.Background(), "kv.wal.replay")
+	}
+
+	// The log may still have records that a snapshot covers, if the store
+	// stopped before trimming them. They're skipped, since appends and other
+	// updates that change part of a value can't be applied twice. Records at
+	// the snapshot's revision are only its own if they can be in a snapshot:
+	var covered uint64
+	result := make(chan (updateResult[V]))
+	summary, err := s.log.replay(func(record []byte) error {
+		update, err := s.decodeUpdate(record)
+		if err != nil {
 			return err
 		}
+		switch {
+		case update.UpdateType == truncate:
+			covered = update.Revision
+		case update.Revision == 0 || update.Revision > covered:
+		case update.Revision < covered:
+			return nil
+		case update.UpdateType != set && update.UpdateType != unset && update.UpdateType != grantLease:
+			return nil
+		}
 
 		update.result = result
 		s.queueUpdate(update)
+		return nil
+	})
+
+	s.replaySummary = summary
+	if summary.Dropped > 0 {
+		s.options.logger.Warn("Truncated corrupt tail of write-ahead log", "dropped", summary.Dropped)
+	}
+	for _, skipped := range summary.Skipped {
+		s.options.logger.Warn("Skipped bad record in write-ahead log", "path", skipped.Path, "line", skipped.Line, "error", skipped.Err)
+	}
+	if err != nil {
+		s.options.logger.Error("Failed to replay write-ahead log", "error", err)
 	}
+	if span != nil {
+		span.SetAttributes(
+			attribute.Int("kv.wal.recovered", summary.Recovered),
+			attribute.Int("kv.wal.dropped", summary.Dropped),
+			attribute.Int("kv.wal.skipped", len(summary.Skipped)),
+		)
+		endSpan(span, err)
+	}
+	return err
+}
 
-	return nil
+// Reads updates from a shard's singular update queue. This ensures that only
+// one update to the shard is processed at a time, in the order they're received.
+// Updates that are already waiting are applied together as a batch, which is
+// written to the log in a single write.
+func (s *kvStore[K, V]) readUpdates(sh *shard[K, V]) {
+	defer s.loops.Done()
+
+	for {
+		var first update[K, V]
+		select {
+		case first = <-sh.updates:
+		case <-s.closing:
+			return
+		}
+
+		batch, paused := s.collectBatch(sh, first)
+		if len(batch) > 0 {
+			s.preencode(batch)
+			results := s.applyBatch(sh, batch)
+			for i, u := range batch {
+				u.result <- results[i]
+			}
+			// The next batch reuses the slice, without holding on to values:
+			clear(batch)
+			sh.batch = batch[:0]
+		}
+		if paused != nil {
+			paused.barrier.wait()
+		}
+	}
 }
 
-// Reads updates from the store's singular update queue. This ensures that only
-// one update is processed at a time, in the order they're received.
-func (s *kvStore[K, V]) readUpdates() {
-	for update := range s.updates {
-		if update.append {
-			err := s.appendUpdate(update)
+// Collects a batch of updates, starting with `first`: every update already
+// waiting in the shard's queue, or that arrives within the store's group
+// commit window, up to `maxBatchSize`. If it receives a `pause` update, the
+// batch ends there, and the pause is returned to be waited on once the batch
+// has been applied.
+func (s *kvStore[K, V]) collectBatch(sh *shard[K, V], first update[K, V]) (batch []update[K, V], paused *update[K, V]) {
+	if first.UpdateType == pause {
+		paused := first
+		return nil, &paused
+	}
+
+	batch = append(sh.batch, first)
+	var window <-chan time.Time
+	if s.options.groupCommitWindow > 0 {
+		timer := time.NewTimer(s.options.groupCommitWindow)
+		defer timer.Stop()
+		window = timer.C
+	}
+
+	for len(batch) < maxBatchSize {
+		var u update[K, V]
+		if window == nil {
+			select {
+			case u = <-sh.updates:
+			default:
+				return batch, nil
+			}
+		} else {
+			select {
+			case u = <-sh.updates:
+			case <-window:
+				return batch, nil
+			case <-s.closing:
+				return batch, nil
+			}
+		}
+
+		if u.UpdateType == pause {
+			paused := u
+			return batch, &paused
+		}
+		batch = append(batch, u)
+	}
+
+	return batch, nil
+}
+
+// Logs an update, streams it to followers, and applies it. The caller must have
+// exclusive access to the shards the update affects: either it's the update
+// loop of `sh`, the shard that owns the update's key, or it has paused every
+// shard with `exclusive`, in which case `sh` is nil.
+func (s *kvStore[K, V]) apply(sh *shard[K, V], u update[K, V]) updateResult[V] {
+	return s.applyBatch(sh, []update[K, V]{u})[0]
+}
+
+// A key's value as of the updates earlier in a batch, which haven't been
+// applied to its shard yet.
+type pendingValue[V any] struct {
+	value     V
+	found     bool
+	timestamp uint64
+	clock     VectorClock
+}
+
+// Applies a batch of updates, like `apply`, and returns each one's result. The
+// whole batch is written to the log in a single write before any of it is
+// applied, so if writing it fails, none of it is.
+//
+// Only numbering the batch and writing it to the log hold `commit`. Then the
+// shard's sets and unsets are applied to its data without it, while other
+// shards apply their own batches, and finally the batch's leases, timestamps,
+// followers, hooks and changes are updated in turn, in the order batches were
+// logged.
+func (s *kvStore[K, V]) applyBatch(sh *shard[K, V], batch []update[K, V]) []updateResult[V] {
+	s.commit.Lock()
+
+	// Writes made on this store have waited since they were queued. Updates
+	// replayed from the log, or streamed from a leader, weren't queued, so
+	// they aren't timed. Each write's phases are counted under the operation
+	// it was made as, even once it's resolved into a set or an unset:
+	started := time.Now()
+	operations := make([]string, len(batch))
+	for i, u := range batch {
+		if !u.queued.IsZero() {
+			operations[i] = operationNames[u.UpdateType]
+			s.metrics.observePhase(operations[i], queueWait, started.Sub(u.queued))
+		}
+	}
+
+	results := make([]updateResult[V], len(batch))
+	pending := make(map[namespacedKey[K]]pendingValue[V])
+	current := func(u update[K, V]) (V, bool) {
+		if p, found := pending[namespacedKey[K]{u.Namespace, u.Key}]; found {
+			return p.value, p.found
+		}
+		return sh.lookup(u.Namespace).values.get(u.Key)
+	}
+	version := func(u update[K, V]) Version[V] {
+		k := namespacedKey[K]{u.Namespace, u.Key}
+		if p, found := pending[k]; found {
+			return Version[V]{Value: p.value, Deleted: !p.found, Timestamp: p.timestamp, Clock: p.clock}
+		}
+		value, found := sh.lookup(u.Namespace).values.get(u.Key)
+		return Version[V]{Value: value, Deleted: !found, Timestamp: s.timestamps[k], Clock: s.clocks[k]}
+	}
+
+	// Resolve and marshal every update, and number them. Updates that report a
+	// value when they're applied are given it, and whether the key was in the
+	// store, while they're resolved:
+	reported := make([]V, len(batch))
+	existed := make([]bool, len(batch))
+	// The appends, pushes, pops and changes to sets and hashes, which are
+	// resolved into a `set` of their result, but logged as they were made:
+	deltas := make(map[int]delta[V])
+	var applied []int
+	var records [][]byte
+	var logged [][]byte
+	revision := s.logged
+	for i := range batch {
+		update := &batch[i]
+		if update.UpdateType == subscribe {
+			s.addReplica(update.replica)
+			results[i] = updateResult[V]{ok: true}
+			continue
+		}
+		if update.UpdateType == ping {
+			results[i] = updateResult[V]{ok: true}
+			continue
+		}
+
+		// Conditional updates are resolved into the unconditional update they
+		// make, which is what gets logged, or are dropped if their condition
+		// isn't met:
+		switch update.UpdateType {
+		case compareAndSwap:
+			value, found := current(*update)
+			if !found || !equal(value, update.Expected) {
+				results[i] = updateResult[V]{ok: false, value: value}
+				continue
+			}
+			update.UpdateType = set
+		case unsetIf:
+			value, found := current(*update)
+			if !found || !equal(value, update.Expected) {
+				results[i] = updateResult[V]{ok: false, value: value}
+				continue
+			}
+			update.UpdateType, update.Expected = unset, *new(V)
+		case rename, renameIfNotExists:
+			// Renames are applied with every shard paused, on their own:
+			value, found := s.shardFor(update.Key).lookup(update.Namespace).values.get(update.Key)
+			if !found {
+				results[i] = updateResult[V]{err: ErrKeyNotFound}
+				continue
+			}
+			if _, exists := s.shardFor(update.To).lookup(update.Namespace).values.get(update.To); exists && update.UpdateType == renameIfNotExists {
+				results[i] = updateResult[V]{ok: false}
+				continue
+			}
+			update.UpdateType, update.Value = rename, value
+		case setIfNotExists:
+			if value, found := current(*update); found {
+				results[i] = updateResult[V]{ok: false, value: value}
+				continue
+			}
+			update.UpdateType = set
+		case increment, decrement:
+			value, _ := current(*update)
+			sum, err := addNumber(value, update.Value, update.UpdateType == decrement)
 			if err != nil {
-				update.result <- updateResult{false, err}
+				results[i] = updateResult[V]{err: err, value: value}
 				continue
 			}
+			update.UpdateType, update.Value = set, sum
+			reported[i] = sum
+		case modify:
+			old, found := current(*update)
+			value, err := callModify(update.modify, old, found)
+			if err != nil {
+				results[i] = updateResult[V]{err: err, value: old}
+				continue
+			}
+			update.UpdateType, update.Value = set, value
+			reported[i] = value
+		case getAndDelete:
+			value, found := current(*update)
+			if !found {
+				results[i] = updateResult[V]{ok: false}
+				continue
+			}
+			update.UpdateType = unset
+			reported[i], existed[i] = value, true
+		case getAndSet:
+			reported[i], existed[i] = current(*update)
+			update.UpdateType = set
+		case appendValue, pushFront, pushBack, popFront, addMembers, removeMembers, addScored, setFields, deleteFields:
+			value, _ := current(*update)
+			result, affected, err := applyDelta(*update, value)
+			if err != nil {
+				results[i] = updateResult[V]{err: err}
+				continue
+			}
+			reported[i] = result
+			d := delta[V]{updateType: update.UpdateType, value: update.Value, count: update.Count, fields: update.Fields, result: result}
+			update.UpdateType, update.Value, update.Count, update.Fields = set, result, 0, nil
+			switch d.updateType {
+			case popFront, addMembers, removeMembers, deleteFields:
+				// Nothing is written if nothing was popped, added or removed,
+				// and a list, set or hash that's left empty is unset:
+				reported[i] = affected
+				if lengthOf(affected) == 0 {
+					results[i] = updateResult[V]{ok: false}
+					continue
+				}
+				if lengthOf(result) == 0 {
+					update.UpdateType, update.Value = unset, *new(V)
+					break
+				}
+			case addScored, setFields:
+				reported[i] = affected
+			}
+			deltas[i] = d
+		case merge:
+			ours := version(*update)
+			theirs := Version[V]{Value: update.Value, Deleted: update.Deleted, Timestamp: update.Timestamp, Clock: update.Clock}
+			winner, changed := s.resolveMerge(update.Key, ours, theirs)
+			if !changed {
+				results[i] = updateResult[V]{ok: false, value: ours.Value}
+				continue
+			}
+			update.UpdateType, update.Value, update.Deleted, update.Timestamp, update.Clock = set, winner.Value, winner.Deleted, winner.Timestamp, winner.Clock
+			if update.Deleted {
+				update.UpdateType, update.Value, update.Deleted = unset, *new(V), false
+			}
+		}
+
+		// Updates to leases are resolved against the leases the store has:
+		switch {
+		case update.UpdateType == set && update.Lease != 0 && update.TTL == 0:
+			if _, found := s.leases[update.Lease]; !found {
+				results[i] = updateResult[V]{err: ErrNoLease}
+				continue
+			}
+		case update.UpdateType == revokeLease:
+			l, found := s.leases[update.Lease]
+			if !found {
+				results[i] = updateResult[V]{err: ErrNoLease}
+				continue
+			}
+			update.Entries = make([]entry[K, V], 0, len(l.keys))
+			for k := range l.keys {
+				update.Entries = append(update.Entries, entry[K, V]{Namespace: k.namespace, Key: k.key})
+			}
+		}
+
+		// Updates that are replayed, or streamed from a leader, keep the
+		// revision they were first assigned, and have already been through
+		// `OnBeforeSet`:
+		if update.Revision == 0 {
+			if err := s.hooks.runBeforeSet(update); err != nil {
+				results[i] = updateResult[V]{err: err}
+				continue
+			}
+			update.Revision = revision + 1
+			if s.options.lastWriterWins && update.Timestamp == 0 {
+				update.Timestamp = s.clock.now()
+			}
+			if s.options.node != "" && update.Clock == nil {
+				update.Node = s.options.node
+			}
+		}
+		s.clock.observe(update.Timestamp)
+		if (update.UpdateType == grantLease || update.TTL > 0) && update.Lease == 0 {
+			update.Lease = LeaseID(update.Revision)
+		}
+
+		// Encode the update once, for both the log and any followers. Appends
+		// are encoded as they were made, unless `OnBeforeSet` changed their
+		// result:
+		var record []byte
+		if update.append || len(s.replicas) > 0 {
+			encoding := *update
+			if d, found := deltas[i]; found && update.UpdateType == set && equal(update.Value, d.result) {
+				encoding.UpdateType, encoding.Value, encoding.Count, encoding.Fields = d.updateType, d.value, d.count, d.fields
+			}
+			encoded, err := s.encodeUpdate(encoding)
+			if err != nil {
+				s.options.logger.Error("Failed to encode update", "error", err)
+				results[i] = updateResult[V]{err: errors.New("Failed to encode update for the log")}
+				continue
+			}
+			record = encoded
+		}
+		if update.append {
+			logged = append(logged, record)
 		}
 
 		switch update.UpdateType {
-		case set:
-			s.data[update.Key] = update.Value
-			update.result <- updateResult{true, nil}
-		case unset:
-			delete(s.data, update.Key)
-			update.result <- updateResult{true, nil}
-		default:
-			err := fmt.Errorf("Unknown update type %d", update.UpdateType)
-			update.result <- updateResult{false, err}
+		case set, unset:
+			k := namespacedKey[K]{update.Namespace, update.Key}
+			clock := s.clocks[k]
+			if p, found := pending[k]; found {
+				clock = p.clock
+			}
+			pending[k] = pendingValue[V]{update.Value, update.UpdateType == set, update.Timestamp, update.nextClock(clock)}
+		}
+		revision = update.Revision
+		applied = append(applied, i)
+		records = append(records, record)
+	}
+
+	span := s.traceBatch(batch, len(logged))
+	if len(logged) > 0 {
+		appending := time.Now()
+		err := s.appendUpdates(logged)
+		appended := time.Now()
+		for _, i := range applied {
+			if batch[i].append && operations[i] != "" {
+				s.metrics.observePhase(operations[i], appendPhase, appended.Sub(appending))
+			}
+		}
+		endSpan(span, err)
+		if err != nil {
+			s.options.logger.Error("Failed to append updates to write-ahead log", "updates", len(logged), "error", err)
+			for _, i := range applied {
+				results[i] = updateResult[V]{err: err}
+			}
+			s.commit.Unlock()
+			return results
+		}
+	}
+	if len(applied) == 0 {
+		s.commit.Unlock()
+		return results
+	}
+	s.logged = revision
+	ticket := s.tickets
+	s.tickets++
+	s.commit.Unlock()
+
+	// Only the shard's own update loop changes its data, so its sets and unsets
+	// are applied without holding `commit`. Updates that affect every shard are
+	// applied below, while the other shards are paused:
+	stored := make([]bool, len(batch))
+	errs := make([]error, len(batch))
+	applying := make([]time.Duration, len(batch))
+	for _, i := range applied {
+		if sh == nil || batch[i].UpdateType != set && batch[i].UpdateType != unset {
+			continue
+		}
+		started := time.Now()
+		errs[i], stored[i] = s.store(sh, batch[i]), true
+		applying[i] = time.Since(started)
+	}
+
+	s.commit.Lock()
+	defer s.commit.Unlock()
+	for s.turn != ticket {
+		s.turns.Wait()
+	}
+
+	for j, i := range applied {
+		update := batch[i]
+		started := time.Now()
+		err := errs[i]
+		if err == nil && stored[i] {
+			s.track(update)
+		} else if err == nil {
+			err = s.mutate(sh, update)
+		}
+		if err != nil {
+			s.options.logger.Error("Failed to apply update", "revision", update.Revision, "error", err)
+			results[i] = updateResult[V]{err: err}
+			continue
+		}
+
+		s.revision = update.Revision
+		s.raftIndex = max(s.raftIndex, update.RaftIndex)
+		s.broadcast(records[j])
+		s.hooks.runAfter(update)
+		s.queueChanges(update)
+		if operations[i] != "" {
+			s.metrics.observePhase(operations[i], applyPhase, applying[i]+time.Since(started))
 		}
+		results[i] = updateResult[V]{ok: true, revision: update.Revision, value: reported[i], found: existed[i]}
 	}
+	s.countForSnapshot(len(applied))
+	s.countForCompaction()
+	s.turn++
+	s.turns.Broadcast()
+
+	return results
+}
+
+// Changes the store's data according to an unconditional update.
+func (s *kvStore[K, V]) mutate(sh *shard[K, V], update update[K, V]) error {
+	switch update.UpdateType {
+	case set, unset:
+		if err := s.store(sh, update); err != nil {
+			return err
+		}
+		s.track(update)
+	case truncate:
+		for _, sh := range s.shards {
+			for _, b := range sh.namespaces() {
+				b.reset()
+			}
+		}
+		clear(s.leases)
+		clear(s.leased)
+		clear(s.timestamps)
+		clear(s.clocks)
+		s.epoch = max(s.epoch, update.Epoch)
+	case setMany:
+		return s.putEntries(update)
+	case transaction:
+		return s.applyTransaction(update)
+	case rename:
+		return s.moveKey(update)
+	case replaceAll:
+		for _, sh := range s.shards {
+			b := sh.bucket(update.Namespace)
+			for k := range b.values.keys() {
+				s.stamp(namespacedKey[K]{update.Namespace, k}, update)
+			}
+			b.reset()
+		}
+		for k := range s.leased {
+			if k.namespace == update.Namespace {
+				s.attach(k, 0)
+			}
+		}
+		return s.putEntries(update)
+	case grantLease:
+		s.grant(update.Lease, update.TTL, update.Namespace)
+	case promote:
+		s.epoch = update.Epoch
+	case revokeLease:
+		for _, e := range update.Entries {
+			s.shardFor(e.Key).lookup(e.Namespace).remove(e.Key)
+			s.attach(namespacedKey[K]{e.Namespace, e.Key}, 0)
+			s.stamp(namespacedKey[K]{e.Namespace, e.Key}, update)
+		}
+		delete(s.leases, update.Lease)
+	default:
+		return fmt.Errorf("Unknown update type %d", update.UpdateType)
+	}
+
+	return nil
+}
+
+// Changes a shard's data according to a `set` or an `unset`. Called by the
+// shard's update loop without holding `commit`, or while every shard is paused.
+func (s *kvStore[K, V]) store(sh *shard[K, V], update update[K, V]) error {
+	if update.UpdateType == unset {
+		sh.lookup(update.Namespace).remove(update.Key)
+		return nil
+	}
+
+	return sh.bucket(update.Namespace).put(update.Key, update.Value)
+}
+
+// Updates the lease and timestamp of a key that a `set` or an `unset` has
+// changed. The caller must hold `commit`.
+func (s *kvStore[K, V]) track(update update[K, V]) {
+	k := namespacedKey[K]{update.Namespace, update.Key}
+	if update.UpdateType == unset {
+		s.attach(k, 0)
+		s.stamp(k, update)
+		return
+	}
+
+	// A lock is set with the lease it's granted:
+	if update.TTL > 0 {
+		s.grant(update.Lease, update.TTL, update.Namespace)
+	}
+	s.attach(k, update.Lease)
+	s.stamp(k, update)
+}
+
+// Sets every entry of a `setMany`, `replaceAll` or `transaction` update in its
+// namespace, in the shards that own their keys, at the update's timestamp, and
+// detaches them from any leases. The caller must have paused every shard.
+func (s *kvStore[K, V]) putEntries(update update[K, V]) error {
+	for _, e := range update.Entries {
+		if err := s.shardFor(e.Key).bucket(update.Namespace).put(e.Key, e.Value); err != nil {
+			return err
+		}
+		s.attach(namespacedKey[K]{update.Namespace, e.Key}, 0)
+		s.stamp(namespacedKey[K]{update.Namespace, e.Key}, update)
+	}
+
+	return nil
+}
+
+// Reports whether two values are deeply equal. Values don't need to be
+// comparable with `==`, so this works for any value type.
+func equal[V any](a, b V) bool {
+	return reflect.DeepEqual(a, b)
 }
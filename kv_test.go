@@ -1,9 +1,17 @@
 package kv
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
 	"os"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/qsymmachus/ranger"
 	"github.com/stretchr/testify/assert"
@@ -44,7 +52,7 @@ func TestConcurrentUpdates(t *testing.T) {
 	for _, val := range testData {
 		wg.Add(1)
 		go func(v int, wg *sync.WaitGroup) {
-			err := store.Set("value", v)
+			_, err := store.Set("value", v)
 			assert.NoError(t, err)
 			wg.Done()
 		}(val, &wg)
@@ -64,11 +72,14 @@ func TestWriteAheadLog(t *testing.T) {
 	first.Set("b", "b")
 	first.Set("c", "c")
 	first.Unset("b")
+	want := first.GetAll()
+	first.Close()
 
 	// Replay the log into a second store:
 	second, err := NewStore[string, string](LogPath((logPath)))
 	assert.NoError(t, err)
-	assert.Equal(t, first.GetAll(), second.GetAll())
+	defer second.Close()
+	assert.Equal(t, want, second.GetAll())
 	v, ok := second.Get("a")
 	assert.Equal(t, "a", v)
 	v, ok = second.Get("c")
@@ -77,6 +88,527 @@ func TestWriteAheadLog(t *testing.T) {
 	assert.False(t, ok)
 }
 
+func TestCompareAndSwap(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	store.Set("name", "Toby")
+
+	swapped, err := store.CompareAndSwap("name", "Ralph", "Ziggy")
+	assert.NoError(t, err)
+	assert.False(t, swapped)
+
+	swapped, err = store.CompareAndSwap("name", "Toby", "Ziggy")
+	assert.NoError(t, err)
+	assert.True(t, swapped)
+	v, _ := store.Get("name")
+	assert.Equal(t, "Ziggy", v)
+
+	// Missing keys are never swapped, even if the zero value is expected:
+	swapped, _ = store.CompareAndSwap("missing", "", "Ziggy")
+	assert.False(t, swapped)
+}
+
+func TestUnsetIf(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath))
+	store.Set("lock", "owner-1")
+
+	deleted, err := store.UnsetIf("lock", "owner-2")
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+	_, found := store.Get("lock")
+	assert.True(t, found)
+
+	deleted, err = store.UnsetIf("lock", "owner-1")
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+	_, found = store.Get("lock")
+	assert.False(t, found)
+
+	// Missing keys are never unset, even if the zero value is expected:
+	deleted, _ = store.UnsetIf("missing", "")
+	assert.False(t, deleted)
+	store.Close()
+
+	replayed, _ := NewStore[string, string](LogPath(logPath))
+	defer replayed.Close()
+	_, found = replayed.Get("lock")
+	assert.False(t, found)
+}
+
+// Test that callers can use CompareAndSwap to implement optimistic concurrency.
+func TestConcurrentCompareAndSwap(t *testing.T) {
+	store, _ := NewStore[string, int]()
+	store.Set("counter", 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(wg *sync.WaitGroup) {
+			defer wg.Done()
+			for {
+				current, _ := store.Get("counter")
+				if swapped, _ := store.CompareAndSwap("counter", current, current+1); swapped {
+					return
+				}
+			}
+		}(&wg)
+	}
+	wg.Wait()
+
+	v, _ := store.Get("counter")
+	assert.Equal(t, 100, v)
+}
+
+func TestSetIfNotExists(t *testing.T) {
+	store, _ := NewStore[string, string]()
+
+	set, err := store.SetIfNotExists("name", "Toby")
+	assert.NoError(t, err)
+	assert.True(t, set)
+
+	set, err = store.SetIfNotExists("name", "Ralph")
+	assert.NoError(t, err)
+	assert.False(t, set)
+	v, _ := store.Get("name")
+	assert.Equal(t, "Toby", v)
+}
+
+func TestGetAndDelete(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	store.Set("job", "send-email")
+
+	v, found, err := store.GetAndDelete("job")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "send-email", v)
+	_, found = store.Get("job")
+	assert.False(t, found)
+
+	// Deleting a missing key doesn't write anything:
+	_, found, err = store.GetAndDelete("job")
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, uint64(2), store.(*kvStore[string, string]).revision)
+}
+
+func TestGetAndSet(t *testing.T) {
+	store, _ := NewStore[string, string]()
+
+	old, found, err := store.GetAndSet("name", "Toby")
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, "", old)
+
+	old, found, err = store.GetAndSet("name", "Ralph")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "Toby", old)
+	v, _ := store.Get("name")
+	assert.Equal(t, "Ralph", v)
+}
+
+// Test that each value is claimed by exactly one of many concurrent callers.
+func TestConcurrentGetAndDelete(t *testing.T) {
+	store, _ := NewStore[int, int](Shards(4))
+	for i := range 20 {
+		store.Set(i, i)
+	}
+
+	var wg sync.WaitGroup
+	claimed := make(chan int, 200)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 20 {
+				if v, found, _ := store.GetAndDelete(i); found {
+					claimed <- v
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(claimed)
+
+	var values []int
+	for v := range claimed {
+		values = append(values, v)
+	}
+	assert.ElementsMatch(t, ranger.Int(0, 19), values)
+}
+
+// Test that only one of many concurrent callers wins SetIfNotExists, which is
+// what makes it usable as a lock.
+func TestConcurrentSetIfNotExists(t *testing.T) {
+	store, _ := NewStore[string, int](Shards(4))
+
+	var wg sync.WaitGroup
+	wins := make(chan int, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int, wg *sync.WaitGroup) {
+			defer wg.Done()
+			if set, _ := store.SetIfNotExists("lock", i); set {
+				wins <- i
+			}
+		}(i, &wg)
+	}
+	wg.Wait()
+	close(wins)
+
+	assert.Len(t, wins, 1)
+	winner := <-wins
+	v, _ := store.Get("lock")
+	assert.Equal(t, winner, v)
+}
+
+func TestSetManyAndGetMany(t *testing.T) {
+	defer os.Remove(logPath)
+
+	store, _ := NewStore[string, int](Shards(4), LogPath(logPath))
+	err := store.SetMany(map[string]int{"a": 1, "b": 2, "c": 3})
+	assert.NoError(t, err)
+
+	values := store.GetMany([]string{"a", "c", "missing"})
+	assert.Equal(t, map[string]int{"a": 1, "c": 3}, values)
+
+	// The whole batch is written as a single record, after the log's header, and
+	// replayed from it:
+	log, _ := os.ReadFile(logPath)
+	assert.Equal(t, 2, strings.Count(string(log), "\n"))
+	want := store.GetAll()
+	store.Close()
+	replayed, err := NewStore[string, int](LogPath(logPath))
+	assert.NoError(t, err)
+	defer replayed.Close()
+	assert.Equal(t, want, replayed.GetAll())
+}
+
+func TestClear(t *testing.T) {
+	defer os.Remove(logPath)
+
+	store, _ := NewStore[string, int](Shards(4), LogPath(logPath))
+	for i := range 10 {
+		store.Set(fmt.Sprint(i), i)
+	}
+	other := store.Namespace("other")
+	other.Set("kept", 1)
+
+	assert.NoError(t, store.Clear())
+	assert.Equal(t, 0, store.Len())
+	assert.Equal(t, 1, other.Len())
+
+	// The clear is a single record, after the header and the sets:
+	log, _ := os.ReadFile(logPath)
+	assert.Equal(t, 13, strings.Count(string(log), "\n"))
+	store.Close()
+	replayed, _ := NewStore[string, int](LogPath(logPath))
+	defer replayed.Close()
+	assert.Equal(t, 0, replayed.Len())
+	assert.Equal(t, 1, replayed.Namespace("other").Len())
+}
+
+func TestSetAsync(t *testing.T) {
+	store, _ := NewStore[string, int](Shards(4))
+
+	var errs []<-chan error
+	for i := range 100 {
+		errs = append(errs, store.SetAsync("counter", i))
+	}
+	for _, err := range errs {
+		assert.NoError(t, <-err)
+	}
+
+	// Updates were applied in the order they were made:
+	v, _ := store.Get("counter")
+	assert.Equal(t, 99, v)
+
+	store.Close()
+	assert.ErrorIs(t, <-store.SetAsync("counter", 100), ErrClosed)
+}
+
+func TestApplyBatch(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	s := store.(*kvStore[string, string])
+
+	// Conditional updates see the updates before them in the same batch:
+	batch := []update[string, string]{
+		s.newUpdate(set, "name", "Toby"),
+		s.newUpdate(compareAndSwap, "name", "Ralph"),
+		s.newUpdate(setIfNotExists, "name", "Rex"),
+		s.newUpdate(unset, "name", ""),
+		s.newUpdate(setIfNotExists, "name", "Ziggy"),
+	}
+	batch[1].Expected = "Toby"
+
+	var results []updateResult[string]
+	s.exclusive(func() { results = s.applyBatch(s.shards[0], batch) })
+	assert.True(t, results[0].ok)
+	assert.True(t, results[1].ok)
+	assert.False(t, results[2].ok)
+	assert.Equal(t, "Ralph", results[2].value)
+	assert.True(t, results[3].ok)
+	assert.True(t, results[4].ok)
+	assert.Equal(t, uint64(4), results[4].revision)
+
+	v, _ := store.Get("name")
+	assert.Equal(t, "Ziggy", v)
+}
+
+func TestGroupCommit(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[int, int](LogPath(logPath), GroupCommitWindow(time.Millisecond))
+	var wg sync.WaitGroup
+	for i := range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.Set(i, i)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	store.Close()
+
+	replayed, _ := NewStore[int, int](LogPath(logPath))
+	defer replayed.Close()
+	assert.Equal(t, 100, replayed.Len())
+	assert.Equal(t, ReplaySummary{Recovered: 100}, replayed.ReplaySummary())
+}
+
+func TestGetAllIsACopy(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	store.Set("a", "a")
+
+	all := store.GetAll()
+	all["a"] = "changed"
+	all["b"] = "b"
+	store.Set("c", "c")
+
+	v, _ := store.Get("a")
+	assert.Equal(t, "a", v)
+	_, found := store.Get("b")
+	assert.False(t, found)
+	assert.Equal(t, map[string]string{"a": "changed", "b": "b"}, all)
+}
+
+func TestFind(t *testing.T) {
+	store, _ := NewStore[string, int](Shards(4))
+	defer store.Close()
+	for i := range 10 {
+		store.Set(fmt.Sprint(i), i)
+	}
+	store.Namespace("other").Set("20", 20)
+
+	even := store.Find(func(key string, value int) bool { return value%2 == 0 })
+	assert.Equal(t, map[string]int{"0": 0, "2": 2, "4": 4, "6": 6, "8": 8}, even)
+	assert.Empty(t, store.Find(func(key string, value int) bool { return value > 10 }))
+
+	key, value, found := store.FindFirst(func(key string, value int) bool { return value > 6 })
+	assert.True(t, found)
+	assert.Greater(t, value, 6)
+	assert.Equal(t, fmt.Sprint(value), key)
+	_, _, found = store.FindFirst(func(key string, value int) bool { return value > 10 })
+	assert.False(t, found)
+}
+
+func TestKeysAndLen(t *testing.T) {
+	store, _ := NewStore[string, int](Shards(4))
+	assert.Empty(t, store.Keys())
+	assert.Equal(t, 0, store.Len())
+
+	store.SetMany(map[string]int{"a": 1, "b": 2, "c": 3})
+	store.Unset("b")
+	assert.ElementsMatch(t, []string{"a", "c"}, store.Keys())
+	assert.Equal(t, 2, store.Len())
+}
+
+func TestShards(t *testing.T) {
+	defer os.Remove(logPath)
+
+	store, _ := NewStore[int, int](Shards(8), LogPath(logPath))
+	testData := ranger.Int(1, 1000)
+
+	var wg sync.WaitGroup
+	for _, val := range testData {
+		wg.Add(1)
+		go func(v int, wg *sync.WaitGroup) {
+			_, err := store.Set(v, v)
+			assert.NoError(t, err)
+			wg.Done()
+		}(val, &wg)
+	}
+	wg.Wait()
+	store.Unset(1)
+
+	all := store.GetAll()
+	assert.Len(t, all, 999)
+	for _, k := range testData[1:] {
+		v, found := store.Get(k)
+		assert.True(t, found)
+		assert.Equal(t, k, v)
+	}
+	store.Close()
+
+	// The log can be replayed into a store with a different number of shards:
+	replayed, err := NewStore[int, int](Shards(3), LogPath(logPath))
+	assert.NoError(t, err)
+	defer replayed.Close()
+	assert.Equal(t, all, replayed.GetAll())
+}
+
+func TestReadView(t *testing.T) {
+	store, _ := NewStore[int, int](Shards(4))
+	defer store.Close()
+	s := store.(*kvStore[int, int])
+	for i := 0; i < 10; i++ {
+		store.Set(i, i)
+	}
+
+	// Writes carry on while a view is read, without changing it:
+	read := false
+	err := s.readView(nil, func(v view[int, int]) {
+		read = true
+		_, err := store.Set(1, 100)
+		assert.NoError(t, err)
+		_, err = store.Set(10, 10)
+		assert.NoError(t, err)
+
+		all := make(map[int]int)
+		for _, values := range v.parts(s.namespace) {
+			for k, value := range values.all() {
+				all[k] = value
+			}
+		}
+		assert.Len(t, all, 10)
+		assert.Equal(t, 1, all[1])
+	})
+	assert.NoError(t, err)
+	assert.True(t, read)
+
+	assert.Equal(t, 11, store.Len())
+	assert.Len(t, store.Keys(), 11)
+	value, _ := store.Get(1)
+	assert.Equal(t, 100, value)
+}
+
+// A storage whose puts wait until `release` is closed, after closing `putting`.
+type blockingStorage[K comparable, V any] struct {
+	storage[K, V]
+	putting, release chan struct{}
+}
+
+func (s *blockingStorage[K, V]) put(key K, value V) error {
+	close(s.putting)
+	<-s.release
+	return s.storage.put(key, value)
+}
+
+func TestShardsApplyInParallel(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[int, int](Shards(2), LogPath(logPath))
+	defer store.Close()
+	s := store.(*kvStore[int, int])
+	other := 1
+	for s.shardFor(other) == s.shardFor(0) {
+		other++
+	}
+
+	// Holds up applying a write to key 0's shard, once it's been logged:
+	blocked := &blockingStorage[int, int]{s.shardFor(0).namespaces()[""].values, make(chan struct{}), make(chan struct{})}
+	s.shardFor(0).namespaces()[""].values = blocked
+	errs := store.SetAsync(0, 0)
+	<-blocked.putting
+
+	// The other shard still logs and applies writes meanwhile, though they're
+	// only published once the write logged before them is:
+	published := store.SetAsync(other, other)
+	assert.Eventually(t, func() bool {
+		_, found := store.Get(other)
+		return found
+	}, time.Second, time.Millisecond)
+	select {
+	case <-published:
+		t.Fatal("write was published before an earlier one")
+	default:
+	}
+
+	close(blocked.release)
+	assert.NoError(t, <-errs)
+	assert.NoError(t, <-published)
+	assert.Equal(t, map[int]int{0: 0, other: other}, store.GetAll())
+}
+
+func TestQueueSize(t *testing.T) {
+	store, _ := NewStore[int, int](QueueSize(2), RejectWhenQueueFull())
+	defer store.Close()
+	s := store.(*kvStore[int, int])
+
+	// Holds the update loop paused, so writes stay in its queue. `Stats` would
+	// wait for it to resume, so the queue is checked directly:
+	paused, release := make(chan struct{}), make(chan struct{})
+	go s.exclusive(func() {
+		close(paused)
+		<-release
+	})
+	<-paused
+
+	var wg sync.WaitGroup
+	for _, n := range []int{1, 2} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.Set(n, n)
+			assert.NoError(t, err)
+		}()
+	}
+	assert.Eventually(t, func() bool { return len(s.shards[0].updates) == 2 }, time.Second, time.Millisecond)
+
+	_, err := store.Set(3, 3)
+	assert.Equal(t, ErrQueueFull, err)
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, map[int]int{1: 1, 2: 2}, store.GetAll())
+	stats := store.Stats()
+	assert.Equal(t, uint64(1), stats.QueueFull)
+	assert.Zero(t, stats.QueueDepth)
+}
+
+func TestCloseWithQueuedUpdates(t *testing.T) {
+	store, _ := NewStore[int, int](QueueSize(8))
+	s := store.(*kvStore[int, int])
+
+	paused, release := make(chan struct{}), make(chan struct{})
+	go s.exclusive(func() {
+		close(paused)
+		<-release
+	})
+	<-paused
+
+	var wg sync.WaitGroup
+	for _, n := range ranger.Int(1, 8) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Updates still queued when the store closes are never applied:
+			if _, err := store.Set(n, n); err != nil {
+				assert.Equal(t, ErrClosed, err)
+			}
+		}()
+	}
+	assert.Eventually(t, func() bool { return len(s.shards[0].updates) == 8 }, time.Second, time.Millisecond)
+
+	closed := make(chan error)
+	go func() { closed <- store.Close() }()
+	close(release)
+	assert.NoError(t, <-closed)
+	wg.Wait()
+}
+
 func BenchmarkWithoutLog(b *testing.B) {
 	store, _ := NewStore[int, int]()
 
@@ -93,6 +625,7 @@ func BenchmarkWithLog(b *testing.B) {
 	defer os.Remove(logPath)
 
 	store, _ := NewStore[int, int](LogPath(logPath))
+	defer store.Close()
 
 	for i := 0; i < b.N; i++ {
 		testData := ranger.Int(1, 10000)
@@ -102,3 +635,87 @@ func BenchmarkWithLog(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkSet(b *testing.B) {
+	store, _ := NewStore[int, int]()
+	defer store.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		store.Set(i%10000, i)
+	}
+}
+
+func BenchmarkSetWithLog(b *testing.B) {
+	defer os.Remove(logPath)
+
+	store, _ := NewStore[int, int](LogPath(logPath))
+	defer store.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		store.Set(i%10000, i)
+	}
+}
+
+func BenchmarkParallelSetWithShards(b *testing.B) {
+	store, _ := NewStore[int, int](Shards(runtime.GOMAXPROCS(0)))
+	defer store.Close()
+
+	b.ReportAllocs()
+	var n atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := int(n.Add(1))
+			store.Set(i%10000, i)
+		}
+	})
+}
+
+func BenchmarkWithShards(b *testing.B) {
+	store, _ := NewStore[int, int](Shards(8))
+
+	for i := 0; i < b.N; i++ {
+		testData := ranger.Int(1, 10000)
+
+		var wg sync.WaitGroup
+		for _, n := range testData {
+			wg.Add(1)
+			go func(n int) {
+				store.Set(n, n)
+				wg.Done()
+			}(n)
+		}
+		wg.Wait()
+	}
+}
+
+func TestLogger(t *testing.T) {
+	defer removeLog()
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	store, _ := NewStore[int, int](LogPath(logPath), Logger(logger))
+	store.Set(1, 1)
+	store.Compact()
+	store.Close()
+	replayed, _ := NewStore[int, int](LogPath(logPath), Logger(logger))
+	replayed.Close()
+
+	var events []string
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var event struct{ Msg string }
+		json.Unmarshal([]byte(line), &event)
+		events = append(events, event.Msg)
+	}
+	assert.Equal(t, []string{
+		"Opened write-ahead log",
+		"Compacted write-ahead log",
+		"Closing store",
+		"Closed store",
+		"Opened write-ahead log",
+		"Closing store",
+		"Closed store",
+	}, events)
+	assert.Contains(t, logs.String(), `"revision":1`)
+}
@@ -0,0 +1,1170 @@
+// Package kvclient connects to a remote `kv` store, served with the
+// `kv.ServerListener` option, and implements `kv.KVStore` on top of it, so code
+// written against the interface works whether the store is embedded or remote.
+//
+//	store, err := kvclient.Dial[string, string]("localhost:7001")
+//	revision, err := store.Set("name", "ralph")
+//
+// The client's key and value types must match the server's, since keys and
+// values are sent as JSON.
+package kvclient
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qsymmachus/kv"
+	"github.com/qsymmachus/kv/internal/wire"
+)
+
+// Returned by `Index`, which can't be called on a remote store, since its
+// indexer function can't be sent to the server.
+var ErrUnsupported = errors.New("Operation isn't supported by remote stores")
+
+// How long `Lock` waits before trying again to take a lock that's held.
+const lockRetryInterval = 10 * time.Millisecond
+
+// Errors the server can return that callers may check for with `errors.Is`.
+var knownErrors = []error{
+	kv.ErrClosed,
+	kv.ErrFollower,
+	kv.ErrReadOnly,
+	kv.ErrIndexExists,
+	kv.ErrNoIndex,
+	kv.ErrCompacted,
+	kv.ErrUnknownFormat,
+	kv.ErrNoLease,
+	kv.ErrNotEnoughReplicas,
+	kv.ErrNoLeader,
+	kv.ErrFenced,
+	kv.ErrUnauthorized,
+	kv.ErrNotNumeric,
+	kv.ErrOutOfRange,
+	kv.ErrNotAppendable,
+	kv.ErrNotList,
+	kv.ErrNotSet,
+	kv.ErrNotSortedSet,
+	kv.ErrNotHash,
+	kv.ErrKeyNotFound,
+	kv.ErrInvalidCursor,
+	kv.ErrNotStringKeys,
+	kv.ErrNoTextIndex,
+	kv.ErrQueueFull,
+}
+
+// A connection to a server, shared by every namespace of a client.
+type conn struct {
+	// Guards the connection, so one request is sent, and its response read, at
+	// a time.
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+	// Server addresses of the nodes to connect to instead, if the connection
+	// fails, for clients of a cluster. `conn` is nil after a failure, until the
+	// next request reconnects.
+	cluster []string
+	// How to connect to the nodes of a cluster, or to the server again, for
+	// subscriptions.
+	options options
+	// Closed when the client is closed, to stop streams that haven't been read
+	// to the end.
+	closed chan struct{}
+	// Pipelined sets that have been sent, but whose responses haven't been
+	// read yet, oldest first.
+	pending []*future
+}
+
+// A remote store, or a namespace of one.
+type client[K comparable, V any] struct {
+	*conn
+	// The namespace that this view reads and writes.
+	namespace string
+}
+
+// Connects to the store served at `addr`.
+func Dial[K comparable, V any](addr string, options ...Option) (kv.KVStore[K, V], error) {
+	opts := applyOptions(options)
+	c, reader, writer, err := opts.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client[K, V]{conn: &conn{conn: c, reader: reader, writer: writer, options: opts, closed: make(chan struct{})}}, nil
+}
+
+// Sends a request to the server, in the client's namespace, and waits for its
+// response. Errors returned by the store are returned as errors too.
+func (c *client[K, V]) call(req wire.Request) (wire.Response, error) {
+	req.Namespace = c.namespace
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Responses come back in the order requests were sent:
+	c.drain()
+	if c.conn.conn == nil {
+		if err := c.reconnect(); err != nil {
+			return wire.Response{}, err
+		}
+	}
+	res, err := c.roundTrip(req)
+	if err != nil {
+		// The request may or may not have been applied, so it isn't sent again,
+		// but the next one is sent to another node:
+		if c.cluster != nil {
+			c.conn.conn.Close()
+			c.conn.conn = nil
+		}
+		return wire.Response{}, err
+	}
+	if res.Err != "" {
+		return res, remoteError(res.Err)
+	}
+
+	return res, nil
+}
+
+// Sends a request on the connection, and reads the response to it. The caller
+// must hold `mu`.
+func (c *conn) roundTrip(req wire.Request) (wire.Response, error) {
+	if err := wire.WriteFrame(c.writer, req); err != nil {
+		return wire.Response{}, err
+	}
+	if err := c.writer.Flush(); err != nil {
+		return wire.Response{}, err
+	}
+
+	var res wire.Response
+	err := wire.ReadFrame(c.reader, &res)
+	return res, err
+}
+
+// Turns an error message from the server back into an error, which matches one
+// of the store's exported errors, if it was one.
+func remoteError(message string) error {
+	for _, known := range knownErrors {
+		if message == known.Error() {
+			return known
+		}
+		if detail, found := strings.CutPrefix(message, known.Error()+": "); found {
+			return fmt.Errorf("%w: %s", known, detail)
+		}
+	}
+
+	return errors.New(message)
+}
+
+// Encodes a key or value for a request.
+func encode(v any) (json.RawMessage, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.New("Failed to encode request")
+	}
+
+	return raw, nil
+}
+
+// Decodes a key or value in a response, if the response has it.
+func decode(raw json.RawMessage, v any) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return errors.New("Failed to decode response, its types may not match the store's")
+	}
+
+	return nil
+}
+
+func decodeEntries[K comparable, V any](entries []wire.Entry) (map[K]V, error) {
+	data := make(map[K]V, len(entries))
+	for _, e := range entries {
+		var k K
+		var v V
+		if err := decode(e.Key, &k); err != nil {
+			return nil, err
+		}
+		if err := decode(e.Value, &v); err != nil {
+			return nil, err
+		}
+		data[k] = v
+	}
+
+	return data, nil
+}
+
+// Builds a request for an operation on a key, and optionally a value.
+func (c *client[K, V]) keyRequest(op string, key K, values ...V) (wire.Request, error) {
+	req := wire.Request{Op: op}
+	raw, err := encode(key)
+	if err != nil {
+		return req, err
+	}
+	req.Key = raw
+
+	if len(values) > 0 {
+		if req.Value, err = encode(values[0]); err != nil {
+			return req, err
+		}
+	}
+
+	return req, nil
+}
+
+// Gets a value from the remote store. If the request fails, `found` is false.
+func (c *client[K, V]) Get(key K) (value V, found bool) {
+	req, err := c.keyRequest(wire.Get, key)
+	if err != nil {
+		return value, false
+	}
+	res, err := c.call(req)
+	if err != nil || !res.OK {
+		return value, false
+	}
+	if err := decode(res.Value, &value); err != nil {
+		return *new(V), false
+	}
+
+	return value, true
+}
+
+// Gets a value from the remote store, as consistent with the server's leader
+// as `level` asks for. Unlike `Get`, it returns the request's error, if any.
+func (c *client[K, V]) GetWithConsistency(key K, level kv.Consistency) (value V, found bool, err error) {
+	req, err := c.keyRequest(wire.Get, key)
+	if err != nil {
+		return value, false, err
+	}
+	req.Level = uint8(level)
+	res, err := c.call(req)
+	if err != nil || !res.OK {
+		return value, false, err
+	}
+	if err := decode(res.Value, &value); err != nil {
+		return *new(V), false, err
+	}
+
+	return value, true, nil
+}
+
+// Gets a value from the remote store, and its vector clock. If the request
+// fails, `found` is false, and the clock is nil.
+func (c *client[K, V]) GetVersioned(key K) (value V, clock kv.VectorClock, found bool) {
+	req, err := c.keyRequest(wire.GetVersioned, key)
+	if err != nil {
+		return value, nil, false
+	}
+	res, err := c.call(req)
+	if err != nil {
+		return value, nil, false
+	}
+	if res.OK {
+		if err := decode(res.Value, &value); err != nil {
+			return *new(V), nil, false
+		}
+	}
+
+	return value, res.Clock, res.OK
+}
+
+func (c *client[K, V]) Set(key K, value V) (revision uint64, err error) {
+	return c.SetWithConsistency(key, value, kv.ConsistencyOne)
+}
+
+func (c *client[K, V]) Unset(key K) (revision uint64, err error) {
+	return c.UnsetWithConsistency(key, kv.ConsistencyOne)
+}
+
+func (c *client[K, V]) SetWithConsistency(key K, value V, level kv.Consistency) (revision uint64, err error) {
+	req, err := c.keyRequest(wire.Set, key, value)
+	if err != nil {
+		return 0, err
+	}
+	req.Level = uint8(level)
+	res, err := c.call(req)
+	return res.Revision, err
+}
+
+func (c *client[K, V]) UnsetWithConsistency(key K, level kv.Consistency) (revision uint64, err error) {
+	req, err := c.keyRequest(wire.Unset, key)
+	if err != nil {
+		return 0, err
+	}
+	req.Level = uint8(level)
+	res, err := c.call(req)
+	return res.Revision, err
+}
+
+// Sets a key/value pair from a new goroutine. Unlike an embedded store's
+// `SetAsync`, updates made one after another may reach the server out of order.
+func (c *client[K, V]) SetAsync(key K, value V) <-chan error {
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c.Set(key, value)
+		errs <- err
+	}()
+
+	return errs
+}
+
+func (c *client[K, V]) GetAt(key K, revision uint64) (value V, found bool, err error) {
+	req, err := c.keyRequest(wire.GetAt, key)
+	if err != nil {
+		return value, false, err
+	}
+	req.Revision = revision
+
+	res, err := c.call(req)
+	if err != nil || !res.OK {
+		return value, false, err
+	}
+	if err := decode(res.Value, &value); err != nil {
+		return *new(V), false, err
+	}
+
+	return value, true, nil
+}
+
+// Gets a copy of all data in the remote store. If the request fails, the map
+// is empty.
+func (c *client[K, V]) GetAll() map[K]V {
+	res, err := c.call(wire.Request{Op: wire.GetAll})
+	if err != nil {
+		return map[K]V{}
+	}
+	data, err := decodeEntries[K, V](res.Entries)
+	if err != nil {
+		return map[K]V{}
+	}
+
+	return data
+}
+
+func (c *client[K, V]) List(cursor string, limit int) (page map[K]V, next string, err error) {
+	return c.list(wire.List, cursor, limit)
+}
+
+func (c *client[K, V]) ListDesc(cursor string, limit int) (page map[K]V, next string, err error) {
+	return c.list(wire.ListDesc, cursor, limit)
+}
+
+func (c *client[K, V]) Scan(cursor uint64, count int) (pairs map[K]V, next uint64, err error) {
+	res, err := c.call(wire.Request{Op: wire.Scan, ScanCursor: cursor, Count: count})
+	if err != nil {
+		return nil, 0, err
+	}
+	pairs, err = decodeEntries[K, V](res.Entries)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return pairs, res.ScanCursor, nil
+}
+
+func (c *client[K, V]) list(op string, cursor string, limit int) (page map[K]V, next string, err error) {
+	res, err := c.call(wire.Request{Op: op, Cursor: cursor, Count: limit})
+	if err != nil {
+		return nil, "", err
+	}
+	page, err = decodeEntries[K, V](res.Entries)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return page, res.Cursor, nil
+}
+
+// Yields a copy of all data in the remote store, fetched each time it's iterated
+// over. If the request fails, there's none.
+func (c *client[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for k, v := range c.GetAll() {
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Sends a copy of all data in the remote store, which is fetched in full before
+// it's sent. If the request fails, the channel is closed straight away.
+func (c *client[K, V]) Stream() <-chan kv.Entry[K, V] {
+	data := c.GetAll()
+	stream := make(chan kv.Entry[K, V])
+	go func() {
+		defer close(stream)
+		for k, v := range data {
+			select {
+			case stream <- kv.Entry[K, V]{Key: k, Value: v}:
+			case <-c.closed:
+				return
+			}
+		}
+	}()
+
+	return stream
+}
+
+// Yields every key in the remote store, fetched each time it's iterated over.
+// If the request fails, there are none.
+func (c *client[K, V]) KeysIter() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for _, k := range c.Keys() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// Gets every key in the remote store. If the request fails, there are none.
+func (c *client[K, V]) Keys() []K {
+	res, err := c.call(wire.Request{Op: wire.Keys})
+	if err != nil {
+		return nil
+	}
+
+	keys := make([]K, len(res.Keys))
+	for i, raw := range res.Keys {
+		if err := decode(raw, &keys[i]); err != nil {
+			return nil
+		}
+	}
+
+	return keys
+}
+
+func (c *client[K, V]) KeysMatching(pattern string) (keys []K, err error) {
+	return c.callForKeys(wire.Request{Op: wire.KeysMatching, Pattern: pattern})
+}
+
+// Yields the keys of the remote store that `pattern` matches. The server finds
+// them all before any are yielded. If the request fails, there are none.
+func (c *client[K, V]) SearchKeys(pattern *regexp.Regexp) iter.Seq[K] {
+	return func(yield func(K) bool) {
+		res, err := c.call(wire.Request{Op: wire.SearchKeys, Pattern: pattern.String()})
+		if err != nil {
+			return
+		}
+		for _, raw := range res.Keys {
+			var k K
+			if decode(raw, &k) != nil || !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+func (c *client[K, V]) Search(query string) (keys []K, err error) {
+	return c.callForKeys(wire.Request{Op: wire.Search, Query: query})
+}
+
+// Gets the number of keys in the remote store. If the request fails, it's 0.
+func (c *client[K, V]) Len() int {
+	res, _ := c.call(wire.Request{Op: wire.Len})
+	return res.Len
+}
+
+func (c *client[K, V]) CompareAndSwap(key K, expected V, value V) (swapped bool, err error) {
+	req, err := c.keyRequest(wire.CompareAndSwap, key, value)
+	if err != nil {
+		return false, err
+	}
+	if req.Expected, err = encode(expected); err != nil {
+		return false, err
+	}
+
+	res, err := c.call(req)
+	return res.OK, err
+}
+
+func (c *client[K, V]) Rename(oldKey K, newKey K) error {
+	req, err := c.renameRequest(wire.Rename, oldKey, newKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.call(req)
+	return err
+}
+
+func (c *client[K, V]) RenameIfNotExists(oldKey K, newKey K) (renamed bool, err error) {
+	req, err := c.renameRequest(wire.RenameIfNotExists, oldKey, newKey)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := c.call(req)
+	return res.OK, err
+}
+
+func (c *client[K, V]) renameRequest(op string, oldKey K, newKey K) (wire.Request, error) {
+	req, err := c.keyRequest(op, oldKey)
+	if err != nil {
+		return req, err
+	}
+	req.To, err = encode(newKey)
+	return req, err
+}
+
+func (c *client[K, V]) UnsetIf(key K, expected V) (deleted bool, err error) {
+	req, err := c.keyRequest(wire.UnsetIf, key)
+	if err != nil {
+		return false, err
+	}
+	if req.Expected, err = encode(expected); err != nil {
+		return false, err
+	}
+
+	res, err := c.call(req)
+	return res.OK, err
+}
+
+func (c *client[K, V]) SetIfNotExists(key K, value V) (set bool, err error) {
+	req, err := c.keyRequest(wire.SetIfNotExists, key, value)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := c.call(req)
+	return res.OK, err
+}
+
+func (c *client[K, V]) GetAndDelete(key K) (value V, found bool, err error) {
+	req, err := c.keyRequest(wire.GetAndDelete, key)
+	if err != nil {
+		return value, false, err
+	}
+
+	res, err := c.call(req)
+	if err != nil || !res.OK {
+		return value, false, err
+	}
+	err = decode(res.Value, &value)
+	return value, true, err
+}
+
+func (c *client[K, V]) GetAndSet(key K, value V) (old V, found bool, err error) {
+	req, err := c.keyRequest(wire.GetAndSet, key, value)
+	if err != nil {
+		return old, false, err
+	}
+
+	res, err := c.call(req)
+	if err != nil || !res.OK {
+		return old, false, err
+	}
+	err = decode(res.Value, &old)
+	return old, true, err
+}
+
+func (c *client[K, V]) Append(key K, values V) (revision uint64, err error) {
+	req, err := c.keyRequest(wire.Append, key, values)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := c.call(req)
+	return res.Revision, err
+}
+
+func (c *client[K, V]) LPush(key K, values V) (length int, err error) {
+	return c.push(wire.LPush, key, values)
+}
+
+func (c *client[K, V]) RPush(key K, values V) (length int, err error) {
+	return c.push(wire.RPush, key, values)
+}
+
+// Sends values to add to a key's list, set or hash, and gets the length the
+// server responds with.
+func (c *client[K, V]) push(op string, key K, values V) (length int, err error) {
+	req, err := c.keyRequest(op, key, values)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := c.call(req)
+	return res.Len, err
+}
+
+func (c *client[K, V]) LPop(key K, count int) (values V, err error) {
+	req, err := c.keyRequest(wire.LPop, key)
+	if err != nil {
+		return values, err
+	}
+	req.Count = count
+
+	return c.callForValue(req)
+}
+
+func (c *client[K, V]) LRange(key K, start int, stop int) (values V, err error) {
+	req, err := c.keyRequest(wire.LRange, key)
+	if err != nil {
+		return values, err
+	}
+	req.Start, req.Stop = start, stop
+
+	return c.callForValue(req)
+}
+
+func (c *client[K, V]) SAdd(key K, members V) (added int, err error) {
+	return c.push(wire.SAdd, key, members)
+}
+
+func (c *client[K, V]) SRem(key K, members V) (removed int, err error) {
+	return c.push(wire.SRem, key, members)
+}
+
+func (c *client[K, V]) SIsMember(key K, members V) (isMember bool, err error) {
+	req, err := c.keyRequest(wire.SIsMember, key, members)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := c.call(req)
+	return res.OK, err
+}
+
+func (c *client[K, V]) SMembers(key K) (members V, err error) {
+	req, err := c.keyRequest(wire.SMembers, key)
+	if err != nil {
+		return members, err
+	}
+
+	return c.callForValue(req)
+}
+
+func (c *client[K, V]) ZAdd(key K, members V) (added int, err error) {
+	return c.push(wire.ZAdd, key, members)
+}
+
+func (c *client[K, V]) ZRangeByScore(key K, min float64, max float64) (members V, err error) {
+	req, err := c.keyRequest(wire.ZRangeByScore, key)
+	if err != nil {
+		return members, err
+	}
+	// JSON can't encode infinities, so unbounded ranges are sent as the
+	// largest finite ones:
+	req.Min, req.Max = math.Max(min, -math.MaxFloat64), math.Min(max, math.MaxFloat64)
+
+	return c.callForValue(req)
+}
+
+func (c *client[K, V]) ZRank(key K, members V) (ranks []int, err error) {
+	req, err := c.keyRequest(wire.ZRank, key, members)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.call(req)
+	if err != nil {
+		return nil, err
+	}
+	err = decode(res.Value, &ranks)
+	return ranks, err
+}
+
+func (c *client[K, V]) HSet(key K, fields V) (added int, err error) {
+	return c.push(wire.HSet, key, fields)
+}
+
+func (c *client[K, V]) HGet(key K, fields ...string) (values V, err error) {
+	req, err := c.keyRequest(wire.HGet, key)
+	if err != nil {
+		return values, err
+	}
+	req.Fields = fields
+
+	return c.callForValue(req)
+}
+
+func (c *client[K, V]) HDel(key K, fields ...string) (removed int, err error) {
+	req, err := c.keyRequest(wire.HDel, key)
+	if err != nil {
+		return 0, err
+	}
+	req.Fields = fields
+
+	res, err := c.call(req)
+	return res.Len, err
+}
+
+// Makes a request whose response is a value, and decodes it.
+func (c *client[K, V]) callForValue(req wire.Request) (value V, err error) {
+	res, err := c.call(req)
+	if err != nil {
+		return value, err
+	}
+	err = decode(res.Value, &value)
+	return value, err
+}
+
+// Makes a request whose response is a list of keys, and decodes them.
+func (c *client[K, V]) callForKeys(req wire.Request) (keys []K, err error) {
+	res, err := c.call(req)
+	if err != nil {
+		return nil, err
+	}
+
+	keys = make([]K, len(res.Keys))
+	for i, raw := range res.Keys {
+		if err := decode(raw, &keys[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+func (c *client[K, V]) Increment(key K, delta V) (value V, err error) {
+	return c.add(wire.Increment, key, delta)
+}
+
+func (c *client[K, V]) Decrement(key K, delta V) (value V, err error) {
+	return c.add(wire.Decrement, key, delta)
+}
+
+// Adds to, or subtracts from, a key's value on the server, and decodes the
+// result.
+func (c *client[K, V]) add(op string, key K, delta V) (value V, err error) {
+	req, err := c.keyRequest(op, key, delta)
+	if err != nil {
+		return value, err
+	}
+
+	return c.callForValue(req)
+}
+
+// Gets a value, or computes and sets it if it's missing. `loader` runs on the
+// client, so concurrent calls from different clients may each call it, but only
+// the first value to be set is kept, and returned to all of them.
+func (c *client[K, V]) GetOrCompute(key K, loader func() (V, error)) (value V, err error) {
+	if value, found := c.Get(key); found {
+		return value, nil
+	}
+
+	value, err = loader()
+	if err != nil {
+		return *new(V), err
+	}
+	set, err := c.SetIfNotExists(key, value)
+	if err != nil {
+		return *new(V), err
+	}
+	if !set {
+		// Another caller set it first:
+		if existing, found := c.Get(key); found {
+			return existing, nil
+		}
+	}
+
+	return value, nil
+}
+
+// Updates a key with `fn`, which runs on the client, by swapping its result in
+// for the value it was given. If another caller changes the key first, `fn` is
+// called again with the new value.
+func (c *client[K, V]) Update(key K, fn func(old V, found bool) (V, error)) (value V, err error) {
+	for {
+		old, found := c.Get(key)
+		if value, err = fn(old, found); err != nil {
+			return value, err
+		}
+
+		var swapped bool
+		if found {
+			swapped, err = c.CompareAndSwap(key, old, value)
+		} else {
+			swapped, err = c.SetIfNotExists(key, value)
+		}
+		if err != nil || swapped {
+			return value, err
+		}
+	}
+}
+
+// Gets the values of several keys at once. If the request fails, the map is
+// empty.
+func (c *client[K, V]) GetMany(keys []K) map[K]V {
+	req := wire.Request{Op: wire.GetMany, Keys: make([]json.RawMessage, len(keys))}
+	for i, k := range keys {
+		raw, err := encode(k)
+		if err != nil {
+			return map[K]V{}
+		}
+		req.Keys[i] = raw
+	}
+
+	res, err := c.call(req)
+	if err != nil {
+		return map[K]V{}
+	}
+	data, err := decodeEntries[K, V](res.Entries)
+	if err != nil {
+		return map[K]V{}
+	}
+
+	return data
+}
+
+func (c *client[K, V]) SetMany(entries map[K]V) error {
+	req := wire.Request{Op: wire.SetMany, Entries: make([]wire.Entry, 0, len(entries))}
+	for k, v := range entries {
+		key, err := encode(k)
+		if err != nil {
+			return err
+		}
+		value, err := encode(v)
+		if err != nil {
+			return err
+		}
+		req.Entries = append(req.Entries, wire.Entry{Key: key, Value: value})
+	}
+
+	_, err := c.call(req)
+	return err
+}
+
+func (c *client[K, V]) Clear() error {
+	_, err := c.call(wire.Request{Op: wire.Clear})
+	return err
+}
+
+func (c *client[K, V]) GrantLease(ttl time.Duration) (kv.LeaseID, error) {
+	res, err := c.call(wire.Request{Op: wire.GrantLease, TTL: ttl})
+	return kv.LeaseID(res.Lease), err
+}
+
+func (c *client[K, V]) SetWithLease(key K, value V, lease kv.LeaseID) (revision uint64, err error) {
+	req, err := c.keyRequest(wire.SetWithLease, key, value)
+	if err != nil {
+		return 0, err
+	}
+	req.Lease = uint64(lease)
+
+	res, err := c.call(req)
+	return res.Revision, err
+}
+
+func (c *client[K, V]) KeepAlive(lease kv.LeaseID) error {
+	_, err := c.call(wire.Request{Op: wire.KeepAlive, Lease: uint64(lease)})
+	return err
+}
+
+func (c *client[K, V]) RevokeLease(lease kv.LeaseID) error {
+	_, err := c.call(wire.Request{Op: wire.RevokeLease, Lease: uint64(lease)})
+	return err
+}
+
+func (c *client[K, V]) TryLock(key K, owner V, ttl time.Duration) (lease kv.LeaseID, locked bool, err error) {
+	req, err := c.keyRequest(wire.TryLock, key, owner)
+	if err != nil {
+		return 0, false, err
+	}
+	req.TTL = ttl
+
+	res, err := c.call(req)
+	return kv.LeaseID(res.Lease), res.OK, err
+}
+
+// Takes a lock, trying again every so often while it's held. The client waits,
+// rather than the server, so other requests on the connection aren't held up.
+func (c *client[K, V]) Lock(key K, owner V, ttl time.Duration) (lease kv.LeaseID, err error) {
+	for {
+		lease, locked, err := c.TryLock(key, owner, ttl)
+		if err != nil || locked {
+			return lease, err
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+func (c *client[K, V]) Unlock(lease kv.LeaseID) error {
+	return c.RevokeLease(lease)
+}
+
+// Returns `ErrUnsupported`. Register indexes on the server's store instead; they
+// can still be queried with `GetByIndex`.
+func (c *client[K, V]) Index(name string, indexer func(V) string) error {
+	return ErrUnsupported
+}
+
+// Gets the pairs `match` returns true for. Functions can't be sent to the
+// server, so every pair is copied to the client, and matched there.
+func (c *client[K, V]) Find(match func(key K, value V) bool) map[K]V {
+	found := make(map[K]V)
+	for k, v := range c.GetAll() {
+		if match(k, v) {
+			found[k] = v
+		}
+	}
+
+	return found
+}
+
+// Gets a pair `match` returns true for, from a copy of every pair, like `Find`.
+func (c *client[K, V]) FindFirst(match func(key K, value V) bool) (key K, value V, found bool) {
+	for k, v := range c.GetAll() {
+		if match(k, v) {
+			return k, v, true
+		}
+	}
+
+	return key, value, false
+}
+
+// Calls `fn` with each pair, from a copy of every pair, like `Find`.
+func (c *client[K, V]) Range(fn func(key K, value V) bool) {
+	for k, v := range c.GetAll() {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+// Counts the pairs `match` returns true for, from a copy of every pair, like
+// `Find`.
+func (c *client[K, V]) Count(match func(key K, value V) bool) int {
+	n := 0
+	for k, v := range c.GetAll() {
+		if match(k, v) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (c *client[K, V]) GetByIndex(name string, indexedValue string) (map[K]V, error) {
+	res, err := c.call(wire.Request{Op: wire.GetByIndex, Index: name, Indexed: indexedValue})
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeEntries[K, V](res.Entries)
+}
+
+func (c *client[K, V]) Backup(w io.Writer) error {
+	res, err := c.call(wire.Request{Op: wire.Backup})
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(res.Data)
+	return err
+}
+
+func (c *client[K, V]) Restore(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.call(wire.Request{Op: wire.Restore, Data: data})
+	return err
+}
+
+func (c *client[K, V]) Export(w io.Writer, format kv.Format) error {
+	res, err := c.call(wire.Request{Op: wire.Export, Format: int(format)})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, bytes.NewReader(res.Data))
+	return err
+}
+
+func (c *client[K, V]) Import(r io.Reader, format kv.Format) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.call(wire.Request{Op: wire.Import, Format: int(format), Data: data})
+	return err
+}
+
+func (c *client[K, V]) Compact() error {
+	_, err := c.call(wire.Request{Op: wire.Compact})
+	return err
+}
+
+func (c *client[K, V]) Sync() error {
+	_, err := c.call(wire.Request{Op: wire.Sync})
+	return err
+}
+
+// Gets the remote store's replay summary. If the request fails, it's empty.
+func (c *client[K, V]) ReplaySummary() kv.ReplaySummary {
+	res, err := c.call(wire.Request{Op: wire.ReplaySummary})
+	if err != nil || res.Summary == nil {
+		return kv.ReplaySummary{}
+	}
+
+	summary := kv.ReplaySummary{Recovered: res.Summary.Recovered, Dropped: res.Summary.Dropped}
+	for _, skipped := range res.Summary.Skipped {
+		summary.Skipped = append(summary.Skipped, kv.SkippedRecord{Path: skipped.Path, Line: skipped.Line, Err: remoteError(skipped.Err)})
+	}
+
+	return summary
+}
+
+// Gets the remote store's statistics. If the request fails, they're empty.
+func (c *client[K, V]) Stats() kv.Stats {
+	res, err := c.call(wire.Request{Op: wire.Stats})
+	if err != nil {
+		return kv.Stats{}
+	}
+
+	stats := kv.Stats{}
+	decode(res.Stats, &stats)
+	return stats
+}
+
+// Checks that the remote store is healthy. Fails if it can't be reached, too.
+func (c *client[K, V]) Healthy() error {
+	_, err := c.call(wire.Request{Op: wire.Healthy})
+	return err
+}
+
+func (c *client[K, V]) Digest() kv.Digest {
+	res, err := c.call(wire.Request{Op: wire.Digest})
+	if err != nil {
+		return kv.Digest{}
+	}
+
+	digest := kv.Digest{}
+	decode(res.Digest, &digest)
+	return digest
+}
+
+func (c *client[K, V]) DigestEntries(buckets []int) map[K]V {
+	res, err := c.call(wire.Request{Op: wire.DigestEntries, Buckets: buckets})
+	if err != nil {
+		return nil
+	}
+
+	entries, _ := decodeEntries[K, V](res.Entries)
+	return entries
+}
+
+// Makes the remote store's data match `other`'s. The digests are compared on
+// the client, so only the keys that differ are sent to the server.
+func (c *client[K, V]) SyncWith(other kv.KVStore[K, V]) error {
+	buckets := c.Digest().Differences(other.Digest())
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	ours := c.DigestEntries(buckets)
+	theirs := other.DigestEntries(buckets)
+	changed := make(map[K]V)
+	for k, v := range theirs {
+		if mine, found := ours[k]; !found || !reflect.DeepEqual(mine, v) {
+			changed[k] = v
+		}
+	}
+
+	if len(changed) > 0 {
+		if err := c.SetMany(changed); err != nil {
+			return err
+		}
+	}
+	for k := range ours {
+		if _, found := theirs[k]; !found {
+			if _, err := c.Unset(k); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *client[K, V]) Versions() map[K]kv.Version[V] {
+	res, err := c.call(wire.Request{Op: wire.Versions})
+	if err != nil {
+		return nil
+	}
+
+	versions, _ := decodeEntries[K, kv.Version[V]](res.Entries)
+	return versions
+}
+
+// Merges `other`'s data into the remote store. `other`'s versions are sent to
+// the server, which keeps whichever version of each key was written last.
+func (c *client[K, V]) Merge(other kv.KVStore[K, V]) error {
+	versions := other.Versions()
+	req := wire.Request{Op: wire.Merge, Entries: make([]wire.Entry, 0, len(versions))}
+	for k, version := range versions {
+		key, err := encode(k)
+		if err != nil {
+			return err
+		}
+		value, err := encode(version)
+		if err != nil {
+			return err
+		}
+		req.Entries = append(req.Entries, wire.Entry{Key: key, Value: value})
+	}
+
+	_, err := c.call(req)
+	return err
+}
+
+// Promotes the remote store, if it's a replication follower, to leader. It
+// listens for followers on `replicationAddr`, on the server's host.
+func (c *client[K, V]) Promote(replicationAddr string) error {
+	_, err := c.call(wire.Request{Op: wire.Promote, Addr: replicationAddr})
+	return err
+}
+
+func (c *client[K, V]) Members() []kv.Member {
+	res, err := c.call(wire.Request{Op: wire.Members})
+	if err != nil {
+		return nil
+	}
+
+	var members []kv.Member
+	decode(res.Members, &members)
+	return members
+}
+
+// Gets a view of the remote store scoped to a namespace. It shares the client's
+// connection.
+func (c *client[K, V]) Namespace(name string) kv.KVStore[K, V] {
+	return &client[K, V]{conn: c.conn, namespace: name}
+}
+
+// Closes the connection to the server, in every namespace. The remote store
+// keeps running.
+func (c *client[K, V]) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A client of a cluster doesn't reconnect once it's closed:
+	c.cluster = nil
+	select {
+	case <-c.closed:
+	default:
+		close(c.closed)
+	}
+	if c.conn.conn == nil {
+		return nil
+	}
+	return c.conn.conn.Close()
+}
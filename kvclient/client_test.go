@@ -0,0 +1,587 @@
+package kvclient
+
+import (
+	"bytes"
+	"maps"
+	"math"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qsymmachus/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+// Starts a store that serves clients, and connects a client to it.
+func serve[K comparable, V any](t *testing.T, options ...kv.Option) (server kv.KVStore[K, V], client kv.KVStore[K, V]) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	server, err = kv.NewStore[K, V](append(options, kv.ServerListener(listener))...)
+	assert.NoError(t, err)
+	client, err = Dial[K, V](listener.Addr().String())
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+func TestSetAndGet(t *testing.T) {
+	server, client := serve[string, int](t)
+
+	revision, err := client.Set("a", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), revision)
+	client.Set("b", 2)
+
+	v, found := client.Get("a")
+	assert.True(t, found)
+	assert.Equal(t, 1, v)
+	_, found = client.Get("missing")
+	assert.False(t, found)
+
+	// Updates made through the client are made to the server's store:
+	v, _ = server.Get("b")
+	assert.Equal(t, 2, v)
+
+	_, err = client.Unset("a")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"b": 2}, client.GetAll())
+	assert.Equal(t, []string{"b"}, client.Keys())
+	assert.Equal(t, 1, client.Len())
+	assert.NoError(t, <-client.SetAsync("c", 3))
+}
+
+func TestConditionalUpdates(t *testing.T) {
+	_, client := serve[string, string](t)
+
+	set, err := client.SetIfNotExists("lock", "owner-1")
+	assert.NoError(t, err)
+	assert.True(t, set)
+	set, _ = client.SetIfNotExists("lock", "owner-2")
+	assert.False(t, set)
+
+	swapped, err := client.CompareAndSwap("lock", "owner-1", "owner-3")
+	assert.NoError(t, err)
+	assert.True(t, swapped)
+	swapped, _ = client.CompareAndSwap("lock", "owner-1", "owner-4")
+	assert.False(t, swapped)
+
+	v, err := client.GetOrCompute("lock", func() (string, error) { return "computed", nil })
+	assert.NoError(t, err)
+	assert.Equal(t, "owner-3", v)
+	v, _ = client.GetOrCompute("missing", func() (string, error) { return "computed", nil })
+	assert.Equal(t, "computed", v)
+}
+
+func TestGetAndDelete(t *testing.T) {
+	_, client := serve[string, string](t)
+	client.Set("job", "send-email")
+
+	v, found, err := client.GetAndDelete("job")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "send-email", v)
+	_, found, err = client.GetAndDelete("job")
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestGetAndSet(t *testing.T) {
+	_, client := serve[string, string](t)
+
+	_, found, err := client.GetAndSet("name", "ralph")
+	assert.NoError(t, err)
+	assert.False(t, found)
+	old, found, err := client.GetAndSet("name", "ziggy")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "ralph", old)
+}
+
+func TestIncrement(t *testing.T) {
+	_, client := serve[string, int](t)
+
+	value, err := client.Increment("hits", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, value)
+	value, err = client.Decrement("hits", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, value)
+
+	_, strings := serve[string, string](t)
+	_, err = strings.Increment("name", "a")
+	assert.ErrorIs(t, err, kv.ErrNotNumeric)
+}
+
+func TestAppend(t *testing.T) {
+	_, client := serve[string, []int](t)
+
+	client.Append("list", []int{1, 2})
+	_, err := client.Append("list", []int{3})
+	assert.NoError(t, err)
+	v, _ := client.Get("list")
+	assert.Equal(t, []int{1, 2, 3}, v)
+
+	_, numbers := serve[string, int](t)
+	_, err = numbers.Append("n", 1)
+	assert.ErrorIs(t, err, kv.ErrNotAppendable)
+}
+
+func TestList(t *testing.T) {
+	_, client := serve[string, []string](t)
+
+	length, err := client.RPush("queue", []string{"b", "c"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, length)
+	length, _ = client.LPush("queue", []string{"a"})
+	assert.Equal(t, 3, length)
+	values, err := client.LRange("queue", 1, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, values)
+	values, err = client.LPop("queue", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, values)
+
+	_, numbers := serve[string, int](t)
+	_, err = numbers.RPush("queue", 1)
+	assert.ErrorIs(t, err, kv.ErrNotList)
+}
+
+func TestSet(t *testing.T) {
+	_, client := serve[string, []string](t)
+
+	added, err := client.SAdd("tags", []string{"go", "db"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, added)
+	removed, err := client.SRem("tags", []string{"db"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	isMember, err := client.SIsMember("tags", []string{"go"})
+	assert.NoError(t, err)
+	assert.True(t, isMember)
+	members, err := client.SMembers("tags")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"go"}, members)
+
+	_, numbers := serve[string, int](t)
+	_, err = numbers.SAdd("tags", 1)
+	assert.ErrorIs(t, err, kv.ErrNotSet)
+}
+
+func TestSortedSet(t *testing.T) {
+	_, client := serve[string, []kv.Scored[string]](t)
+
+	added, err := client.ZAdd("scores", []kv.Scored[string]{{Member: "ann", Score: 2}, {Member: "bob", Score: 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, added)
+	members, err := client.ZRangeByScore("scores", math.Inf(-1), math.Inf(1))
+	assert.NoError(t, err)
+	assert.Equal(t, []kv.Scored[string]{{Member: "bob", Score: 1}, {Member: "ann", Score: 2}}, members)
+	ranks, err := client.ZRank("scores", []kv.Scored[string]{{Member: "ann"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, ranks)
+
+	_, numbers := serve[string, int](t)
+	_, err = numbers.ZAdd("scores", 1)
+	assert.ErrorIs(t, err, kv.ErrNotSortedSet)
+}
+
+func TestHash(t *testing.T) {
+	_, client := serve[string, map[string]int](t)
+
+	added, err := client.HSet("counts", map[string]int{"a": 1, "b": 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, added)
+	removed, err := client.HDel("counts", "a")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	values, err := client.HGet("counts", "a", "b")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"b": 2}, values)
+
+	_, numbers := serve[string, int](t)
+	_, err = numbers.HSet("counts", 1)
+	assert.ErrorIs(t, err, kv.ErrNotHash)
+}
+
+func TestRename(t *testing.T) {
+	_, client := serve[string, string](t)
+	client.Set("a", "value")
+	client.Set("c", "taken")
+
+	assert.NoError(t, client.Rename("a", "b"))
+	v, _ := client.Get("b")
+	assert.Equal(t, "value", v)
+	renamed, err := client.RenameIfNotExists("b", "c")
+	assert.NoError(t, err)
+	assert.False(t, renamed)
+	assert.ErrorIs(t, client.Rename("a", "b"), kv.ErrKeyNotFound)
+}
+
+func TestClear(t *testing.T) {
+	_, client := serve[string, string](t)
+	client.Set("a", "1")
+	client.Set("b", "2")
+
+	assert.NoError(t, client.Clear())
+	assert.Equal(t, 0, client.Len())
+}
+
+func TestListPages(t *testing.T) {
+	_, client := serve[string, int](t)
+	client.SetMany(map[string]int{"a": 1, "b": 2, "c": 3})
+
+	page, next, err := client.List("", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, page)
+	page, next, err = client.List(next, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"c": 3}, page)
+	assert.Empty(t, next)
+
+	_, _, err = client.List("!", 2)
+	assert.ErrorIs(t, err, kv.ErrInvalidCursor)
+
+	page, next, err = client.ListDesc("", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"c": 3, "b": 2}, page)
+	page, _, _ = client.ListDesc(next, 2)
+	assert.Equal(t, map[string]int{"a": 1}, page)
+}
+
+func TestScan(t *testing.T) {
+	_, client := serve[string, int](t)
+	client.SetMany(map[string]int{"a": 1, "b": 2, "c": 3})
+
+	scanned := make(map[string]int)
+	for cursor := uint64(0); ; {
+		pairs, next, err := client.Scan(cursor, 2)
+		assert.NoError(t, err)
+		maps.Copy(scanned, pairs)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, map[string]int{"a": 1, "b": 2, "c": 3}, scanned)
+}
+
+func TestKeysMatching(t *testing.T) {
+	_, client := serve[string, int](t)
+	client.SetMany(map[string]int{"users/1": 1, "users/2": 2, "orders/1": 3})
+
+	keys, err := client.KeysMatching("users/*")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"users/1", "users/2"}, keys)
+
+	_, numbers := serve[int, int](t)
+	_, err = numbers.KeysMatching("*")
+	assert.ErrorIs(t, err, kv.ErrNotStringKeys)
+}
+
+func TestSearchKeys(t *testing.T) {
+	_, client := serve[string, int](t)
+	client.SetMany(map[string]int{"user:1": 1, "user:22": 2, "order:1": 3})
+
+	keys := slices.Collect(client.SearchKeys(regexp.MustCompile(`^user:\d+$`)))
+	assert.ElementsMatch(t, []string{"user:1", "user:22"}, keys)
+}
+
+func TestSearch(t *testing.T) {
+	_, client := serve[string, string](t, kv.FullTextSearch())
+	client.Set("a", "the quick brown fox")
+	client.Set("b", "the lazy dog")
+
+	keys, err := client.Search("fox")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, keys)
+
+	_, plain := serve[string, string](t)
+	_, err = plain.Search("fox")
+	assert.ErrorIs(t, err, kv.ErrNoTextIndex)
+}
+
+func TestCountAndAggregate(t *testing.T) {
+	_, client := serve[string, int](t)
+	client.SetMany(map[string]int{"a": 1, "b": 2, "c": 3})
+
+	assert.Equal(t, 2, client.Count(func(key string, value int) bool { return value > 1 }))
+	assert.Equal(t, 6, kv.Aggregate(client, 0, func(sum int, key string, value int) int { return sum + value }))
+	found := kv.Fold(client, false, func(found bool, key string, value int) (bool, bool) { return value == 2, value != 2 })
+	assert.True(t, found)
+}
+
+func TestAll(t *testing.T) {
+	_, client := serve[string, int](t)
+	client.SetMany(map[string]int{"a": 1, "b": 2})
+
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, maps.Collect(client.All()))
+	assert.ElementsMatch(t, []string{"a", "b"}, slices.Collect(client.KeysIter()))
+
+	streamed := make(map[string]int)
+	for e := range client.Stream() {
+		streamed[e.Key] = e.Value
+	}
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, streamed)
+}
+
+func TestTxn(t *testing.T) {
+	server, client := serve[string, int](t)
+	client.SetMany(map[string]int{"a": 1, "b": 2})
+
+	txn := client.BeginTxn()
+	txn.Set("a", 10)
+	txn.Unset("b")
+	v, _ := txn.Get("a")
+	assert.Equal(t, 10, v)
+	v, _ = server.Get("a")
+	assert.Equal(t, 1, v)
+
+	revision, err := txn.Commit()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), revision)
+	assert.Equal(t, map[string]int{"a": 10}, server.GetAll())
+	_, err = txn.Commit()
+	assert.ErrorIs(t, err, kv.ErrTxnDone)
+
+	rolledBack := client.BeginTxn()
+	rolledBack.Set("a", 0)
+	assert.NoError(t, rolledBack.Rollback())
+	v, _ = server.Get("a")
+	assert.Equal(t, 10, v)
+}
+
+func TestFind(t *testing.T) {
+	_, client := serve[string, int](t)
+	client.SetMany(map[string]int{"a": 1, "b": 2, "c": 3})
+
+	odd := client.Find(func(key string, value int) bool { return value%2 == 1 })
+	assert.Equal(t, map[string]int{"a": 1, "c": 3}, odd)
+	key, _, found := client.FindFirst(func(key string, value int) bool { return value == 2 })
+	assert.True(t, found)
+	assert.Equal(t, "b", key)
+}
+
+func TestUnsetIf(t *testing.T) {
+	_, client := serve[string, string](t)
+	client.Set("lock", "owner-1")
+
+	deleted, err := client.UnsetIf("lock", "owner-2")
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+	deleted, err = client.UnsetIf("lock", "owner-1")
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+	_, found := client.Get("lock")
+	assert.False(t, found)
+}
+
+func TestUpdate(t *testing.T) {
+	_, client := serve[string, int](t)
+
+	for range 3 {
+		_, err := client.Update("count", func(old int, found bool) (int, error) {
+			return old + 2, nil
+		})
+		assert.NoError(t, err)
+	}
+	v, _ := client.Get("count")
+	assert.Equal(t, 6, v)
+}
+
+func TestSetManyAndGetMany(t *testing.T) {
+	_, client := serve[int, string](t)
+
+	assert.NoError(t, client.SetMany(map[int]string{1: "one", 2: "two", 3: "three"}))
+	assert.Equal(t, map[int]string{1: "one", 3: "three"}, client.GetMany([]int{1, 3, 4}))
+}
+
+func TestIndexes(t *testing.T) {
+	server, client := serve[string, string](t)
+
+	// Indexes are registered on the server, and queried through the client:
+	assert.ErrorIs(t, client.Index("first", func(v string) string { return v[:1] }), ErrUnsupported)
+	server.Index("first", func(v string) string { return v[:1] })
+	client.Set("a", "apple")
+	client.Set("b", "banana")
+
+	values, err := client.GetByIndex("first", "a")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "apple"}, values)
+	_, err = client.GetByIndex("missing", "a")
+	assert.ErrorIs(t, err, kv.ErrNoIndex)
+}
+
+func TestBackupAndExport(t *testing.T) {
+	_, client := serve[string, string](t)
+	client.Set("name", "ralph")
+
+	var backup bytes.Buffer
+	assert.NoError(t, client.Backup(&backup))
+	client.Set("name", "ziggy")
+	assert.NoError(t, client.Restore(&backup))
+	v, _ := client.Get("name")
+	assert.Equal(t, "ralph", v)
+
+	var exported bytes.Buffer
+	assert.NoError(t, client.Export(&exported, kv.CSV))
+	assert.Equal(t, "key,value\nname,ralph\n", exported.String())
+	assert.NoError(t, client.Import(strings.NewReader(`[{"key": "food", "value": "pizza"}]`), kv.JSON))
+	assert.Equal(t, 2, client.Len())
+}
+
+func TestNamespaces(t *testing.T) {
+	server, client := serve[string, string](t)
+
+	users := client.Namespace("users")
+	users.Set("alice", "Alice")
+
+	v, found := server.Namespace("users").Get("alice")
+	assert.True(t, found)
+	assert.Equal(t, "Alice", v)
+	_, found = client.Get("alice")
+	assert.False(t, found)
+}
+
+func TestRemoteErrors(t *testing.T) {
+	_, client := serve[string, string](t)
+
+	// The server's store has no log:
+	assert.ErrorContains(t, client.Compact(), "store has no log")
+	_, _, err := client.GetAt("name", 1)
+	assert.ErrorContains(t, err, "store has no log")
+
+	// Errors the store exports can be checked for:
+	assert.ErrorIs(t, client.Export(&bytes.Buffer{}, kv.Format(99)), kv.ErrUnknownFormat)
+}
+
+func TestServerClosed(t *testing.T) {
+	server, client := serve[string, string](t)
+
+	server.Close()
+	_, err := client.Set("name", "ralph")
+	assert.Error(t, err)
+}
+
+func TestHealthy(t *testing.T) {
+	server, client := serve[string, string](t)
+
+	assert.NoError(t, client.Healthy())
+	server.Close()
+	assert.Error(t, client.Healthy())
+}
+
+func TestLeases(t *testing.T) {
+	server, client := serve[string, string](t)
+
+	lease, err := client.GrantLease(time.Hour)
+	assert.NoError(t, err)
+	_, err = client.SetWithLease("services/api", "10.0.0.1", lease)
+	assert.NoError(t, err)
+	assert.NoError(t, client.KeepAlive(lease))
+
+	assert.NoError(t, client.RevokeLease(lease))
+	assert.Equal(t, 0, server.Len())
+	assert.ErrorIs(t, client.KeepAlive(lease), kv.ErrNoLease)
+}
+
+func TestLocks(t *testing.T) {
+	_, client := serve[string, string](t)
+
+	lease, locked, err := client.TryLock("locks/jobs", "worker-1", time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, locked)
+	_, locked, _ = client.TryLock("locks/jobs", "worker-2", time.Hour)
+	assert.False(t, locked)
+
+	assert.NoError(t, client.Unlock(lease))
+	lease, err = client.Lock("locks/jobs", "worker-2", time.Hour)
+	assert.NoError(t, err)
+	owner, _ := client.Get("locks/jobs")
+	assert.Equal(t, "worker-2", owner)
+}
+
+func TestSyncWith(t *testing.T) {
+	server, client := serve[string, int](t)
+	local, _ := kv.NewStore[string, int]()
+	defer local.Close()
+
+	server.SetMany(map[string]int{"a": 1, "b": 2})
+	local.SetMany(map[string]int{"b": 3, "c": 4})
+
+	// A local store can be synced from a remote one, and the other way around:
+	assert.NoError(t, local.SyncWith(client))
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, local.GetAll())
+	local.Set("d", 5)
+	assert.NoError(t, client.SyncWith(local))
+	assert.Equal(t, map[string]int{"a": 1, "b": 2, "d": 5}, server.GetAll())
+}
+
+func TestMerge(t *testing.T) {
+	server, client := serve[string, string](t, kv.LastWriterWins())
+	local, _ := kv.NewStore[string, string](kv.LastWriterWins())
+	defer local.Close()
+
+	server.Set("name", "ralph")
+	time.Sleep(2 * time.Millisecond)
+	local.Set("name", "ziggy")
+	local.Set("food", "pizza")
+
+	assert.NoError(t, client.Merge(local))
+	assert.NoError(t, local.Merge(client))
+	assert.Equal(t, map[string]string{"name": "ziggy", "food": "pizza"}, server.GetAll())
+	assert.Equal(t, server.GetAll(), local.GetAll())
+}
+
+func TestGetVersioned(t *testing.T) {
+	server, client := serve[string, string](t, kv.LastWriterWins(), kv.VectorClocks("server"))
+
+	server.Set("name", "ralph")
+	value, clock, found := client.GetVersioned("name")
+	assert.Equal(t, "ralph", value)
+	assert.True(t, found)
+	_, expected, _ := server.GetVersioned("name")
+	assert.Equal(t, expected, clock)
+}
+
+func TestConsistency(t *testing.T) {
+	_, client := serve[string, string](t)
+
+	// A store without followers has nothing to wait for:
+	_, err := client.SetWithConsistency("name", "ralph", kv.ConsistencyAll)
+	assert.NoError(t, err)
+	v, found, err := client.GetWithConsistency("name", kv.ConsistencyQuorum)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "ralph", v)
+	_, err = client.UnsetWithConsistency("name", kv.ConsistencyQuorum)
+	assert.NoError(t, err)
+}
+
+func TestSetPipelined(t *testing.T) {
+	server, client := serve[string, int](t)
+
+	var futures []kv.Future
+	for n := range 300 {
+		futures = append(futures, client.SetPipelined("a", n))
+		if n == 150 {
+			// Other requests are answered after the pipelined sets before them:
+			v, _ := client.Get("a")
+			assert.Equal(t, 150, v)
+		}
+	}
+	for i, f := range futures {
+		revision, err := f.Wait()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(i+1), revision)
+	}
+	v, _ := server.Get("a")
+	assert.Equal(t, 299, v)
+}
@@ -0,0 +1,71 @@
+package kvclient
+
+import (
+	"errors"
+	"net"
+
+	"github.com/qsymmachus/kv"
+	"github.com/qsymmachus/kv/internal/wire"
+)
+
+// Returned when none of a cluster's nodes can be connected to.
+var ErrNoNodes = errors.New("No node in the cluster is reachable")
+
+// Connects to a cluster of stores that gossip with each other, through the
+// first of `addrs` that can be connected to, which are addresses the nodes
+// serve clients on. The client learns the rest of the cluster's nodes from the
+// one it connects to, and if its connection fails, connects to another healthy
+// node for the next request. The request that failed isn't sent again, since
+// it may have been applied. Every node is connected to with `options`.
+func DialCluster[K comparable, V any](addrs []string, options ...Option) (kv.KVStore[K, V], error) {
+	c := &conn{cluster: addrs, options: applyOptions(options), closed: make(chan struct{})}
+	if err := c.reconnect(); err != nil {
+		return nil, err
+	}
+
+	return &client[K, V]{conn: c}, nil
+}
+
+// Connects to the first node of the cluster that can be connected to, and
+// learns the cluster's healthy nodes from it, to fail over to next time. The
+// caller must hold `mu`.
+func (c *conn) reconnect() error {
+	if c.cluster == nil {
+		return net.ErrClosed
+	}
+
+	for _, addr := range c.cluster {
+		nc, reader, writer, err := c.options.dial(addr)
+		if err != nil {
+			continue
+		}
+		c.conn, c.reader, c.writer = nc, reader, writer
+
+		res, err := c.roundTrip(wire.Request{Op: wire.Members})
+		if err != nil {
+			nc.Close()
+			c.conn = nil
+			continue
+		}
+		var members []kv.Member
+		decode(res.Members, &members)
+		if addrs := serverAddrs(members); len(addrs) > 0 {
+			c.cluster = addrs
+		}
+		return nil
+	}
+
+	return ErrNoNodes
+}
+
+// Gets the addresses that a cluster's healthy members serve clients on.
+func serverAddrs(members []kv.Member) []string {
+	var addrs []string
+	for _, m := range members {
+		if m.Healthy && m.ServerAddr != "" {
+			addrs = append(addrs, m.ServerAddr)
+		}
+	}
+
+	return addrs
+}
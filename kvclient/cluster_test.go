@@ -0,0 +1,59 @@
+package kvclient
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/qsymmachus/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+// Starts a store that serves clients and gossips with the nodes at `join`.
+func serveNode(t *testing.T, name string, join ...string) (kv.KVStore[string, string], string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	config := memberlist.DefaultLocalConfig()
+	config.Name = name
+	config.BindAddr = "127.0.0.1"
+	config.BindPort = 0
+	config.LogOutput = io.Discard
+	store, err := kv.NewStore[string, string](kv.ServerListener(listener), kv.Gossip(kv.GossipConfig{Config: config, Join: join}))
+	assert.NoError(t, err)
+
+	t.Cleanup(func() { store.Close() })
+	return store, listener.Addr().String()
+}
+
+func TestDialCluster(t *testing.T) {
+	first, firstAddr := serveNode(t, "first")
+	second, _ := serveNode(t, "second", first.Members()[0].Addr)
+
+	client, err := DialCluster[string, string]([]string{firstAddr})
+	assert.NoError(t, err)
+	defer client.Close()
+	assert.Len(t, client.Members(), 2)
+	client.Set("name", "ralph")
+	_, found := first.Get("name")
+	assert.True(t, found)
+
+	// Once the node it's connected to fails, the client moves to another:
+	first.Close()
+	_, err = client.Set("name", "ziggy")
+	assert.Error(t, err)
+	_, err = client.Set("name", "toby")
+	assert.NoError(t, err)
+	v, _ := second.Get("name")
+	assert.Equal(t, "toby", v)
+}
+
+func TestDialClusterUnreachable(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := listener.Addr().String()
+	listener.Close()
+
+	_, err := DialCluster[string, string]([]string{addr})
+	assert.ErrorIs(t, err, ErrNoNodes)
+}
@@ -0,0 +1,77 @@
+package kvclient
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+
+	"github.com/qsymmachus/kv/internal/wire"
+)
+
+// Options for connecting to a server.
+type options struct {
+	// `tlsConfig` connects over TLS, if it is set, for servers given
+	// `kv.ServerTLS`.
+	tlsConfig *tls.Config
+	// `token` is presented to servers given `kv.AuthToken`.
+	token string
+}
+
+type Option func(*options)
+
+func applyOptions(opts []Option) options {
+	o := options{}
+	for _, option := range opts {
+		option(&o)
+	}
+	return o
+}
+
+// Option that connects to the server over TLS with `config`. To authenticate
+// the client by its certificate, set the config's `Certificates`.
+func TLS(config *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = config
+	}
+}
+
+// Option that authenticates the client to a server that requires `token`.
+func Token(token string) Option {
+	return func(o *options) {
+		o.token = token
+	}
+}
+
+// Connects to the server at `addr`, and authenticates, if there's a token to
+// authenticate with.
+func (o options) dial(addr string) (nc net.Conn, reader *bufio.Reader, writer *bufio.Writer, err error) {
+	if o.tlsConfig != nil {
+		nc, err = tls.Dial("tcp", addr, o.tlsConfig)
+	} else {
+		nc, err = net.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	reader, writer = bufio.NewReader(nc), bufio.NewWriter(nc)
+	if o.token == "" {
+		return nc, reader, writer, nil
+	}
+
+	var res wire.Response
+	err = wire.WriteFrame(writer, wire.Request{Op: wire.Authenticate, Token: o.token})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = wire.ReadFrame(reader, &res)
+	}
+	if err == nil && res.Err != "" {
+		err = remoteError(res.Err)
+	}
+	if err != nil {
+		nc.Close()
+		return nil, nil, nil, err
+	}
+	return nc, reader, writer, nil
+}
@@ -0,0 +1,163 @@
+package kvclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qsymmachus/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+// Issues a certificate for 127.0.0.1, signed by itself, and returns it with a
+// pool that trusts it.
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool
+}
+
+// Starts a store that serves clients with `options`, and returns its address.
+func serveWith(t *testing.T, options ...kv.Option) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server, err := kv.NewStore[string, string](append(options, kv.ServerListener(listener))...)
+	assert.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+func TestToken(t *testing.T) {
+	addr := serveWith(t, kv.AuthToken("secret"))
+
+	_, err := Dial[string, string](addr, Token("wrong"))
+	assert.ErrorIs(t, err, kv.ErrUnauthorized)
+	_, err = Watch[string, string](addr, "", "", Token("wrong"))
+	assert.ErrorIs(t, err, kv.ErrUnauthorized)
+
+	// Without a token, the first request is refused:
+	client, err := Dial[string, string](addr)
+	assert.NoError(t, err)
+	_, err = client.Set("name", "ralph")
+	assert.ErrorIs(t, err, kv.ErrUnauthorized)
+	client.Close()
+
+	client, err = Dial[string, string](addr, Token("secret"))
+	assert.NoError(t, err)
+	defer client.Close()
+	_, err = client.Set("name", "ralph")
+	assert.NoError(t, err)
+	watcher, err := Watch[string, string](addr, "", "", Token("secret"))
+	assert.NoError(t, err)
+	defer watcher.Close()
+	assert.Equal(t, map[string]string{"name": "ralph"}, watcher.Entries)
+}
+
+func TestTLS(t *testing.T) {
+	cert, pool := selfSigned(t)
+	addr := serveWith(t, kv.ServerTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}))
+
+	// Clients without a certificate are turned away:
+	client, err := Dial[string, string](addr, TLS(&tls.Config{RootCAs: pool}))
+	if err == nil {
+		_, err = client.Set("name", "ralph")
+		client.Close()
+	}
+	assert.Error(t, err)
+
+	client, err = Dial[string, string](addr, TLS(&tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}))
+	assert.NoError(t, err)
+	defer client.Close()
+	_, err = client.Set("name", "ralph")
+	assert.NoError(t, err)
+	v, _ := client.Get("name")
+	assert.Equal(t, "ralph", v)
+}
+
+func TestACL(t *testing.T) {
+	addr := serveWith(t, kv.AuthToken("root"), kv.ACL(map[string][]kv.Grant{
+		"team-a": {{Namespace: "team-a", Access: kv.AccessWrite}},
+		"viewer": {{Namespace: "*", Prefix: "public:", Access: kv.AccessRead}},
+	}))
+
+	root, err := Dial[string, string](addr, Token("root"))
+	assert.NoError(t, err)
+	defer root.Close()
+	root.Namespace("team-b").Set("public:motd", "hello")
+
+	teamA, err := Dial[string, string](addr, Token("team-a"))
+	assert.NoError(t, err)
+	defer teamA.Close()
+	_, err = teamA.Namespace("team-a").Set("name", "ralph")
+	assert.NoError(t, err)
+	_, err = teamA.Namespace("team-b").Set("name", "ziggy")
+	assert.ErrorIs(t, err, kv.ErrUnauthorized)
+	_, found := teamA.Namespace("team-b").Get("public:motd")
+	assert.False(t, found)
+	assert.ErrorIs(t, teamA.Sync(), kv.ErrUnauthorized)
+
+	// Denied requests leave the connection open:
+	v, _ := teamA.Namespace("team-a").Get("name")
+	assert.Equal(t, "ralph", v)
+
+	viewer, err := Dial[string, string](addr, Token("viewer"))
+	assert.NoError(t, err)
+	defer viewer.Close()
+	v, _ = viewer.Namespace("team-b").Get("public:motd")
+	assert.Equal(t, "hello", v)
+	_, err = viewer.Namespace("team-b").Set("public:motd", "bye")
+	assert.ErrorIs(t, err, kv.ErrUnauthorized)
+	assert.NoError(t, root.Sync())
+}
+
+func TestAuditedIdentity(t *testing.T) {
+	var mu sync.Mutex
+	var identities []string
+	auditor := kv.AuditorFunc(func(event kv.AuditEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		identities = append(identities, event.Identity)
+		return nil
+	})
+	addr := serveWith(t, kv.AuthToken("secret"), kv.Audit(auditor))
+
+	client, err := Dial[string, string](addr, Token("secret"))
+	assert.NoError(t, err)
+	defer client.Close()
+	client.Set("name", "ralph")
+	client.Namespace("users").Unset("name")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, identities, 2)
+	assert.Regexp(t, "^token:[0-9a-f]{16}$", identities[0])
+	assert.Equal(t, identities[0], identities[1])
+}
@@ -0,0 +1,96 @@
+package kvclient
+
+import (
+	"github.com/qsymmachus/kv"
+	"github.com/qsymmachus/kv/internal/wire"
+)
+
+// The most pipelined sets a client sends before it reads their responses, so
+// neither end fills its socket's buffer waiting for the other.
+const maxPipelined = 128
+
+// A pipelined set made through a client, which is resolved when its response
+// is read.
+type future struct {
+	conn     *conn
+	done     bool
+	revision uint64
+	err      error
+}
+
+func (f *future) Wait() (revision uint64, err error) {
+	if f.conn != nil {
+		f.conn.mu.Lock()
+		defer f.conn.mu.Unlock()
+		if !f.done {
+			f.conn.drain()
+		}
+	}
+
+	return f.revision, f.err
+}
+
+// Sends a set to the server without waiting for its response, so the server
+// can apply many of them, and sync them to its log, together. Responses are
+// read once a future is waited on, or before the client's next request.
+func (c *client[K, V]) SetPipelined(key K, value V) kv.Future {
+	req, err := c.keyRequest(wire.SetPipelined, key, value)
+	if err != nil {
+		return &future{done: true, err: err}
+	}
+	req.Namespace = c.namespace
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.pending) >= maxPipelined {
+		c.drain()
+	}
+	if c.conn.conn == nil {
+		if err := c.reconnect(); err != nil {
+			return &future{done: true, err: err}
+		}
+	}
+	if err := wire.WriteFrame(c.writer, req); err != nil {
+		return &future{done: true, err: err}
+	}
+	if err := c.writer.Flush(); err != nil {
+		return &future{done: true, err: err}
+	}
+
+	f := &future{conn: c.conn}
+	c.pending = append(c.pending, f)
+	return f
+}
+
+// Reads the response to every pipelined set that's been sent, in order, and
+// resolves their futures. The caller must hold `mu`.
+func (c *conn) drain() {
+	pending := c.pending
+	c.pending = nil
+
+	var err error
+	for _, f := range pending {
+		f.done = true
+		if err != nil {
+			f.err = err
+			continue
+		}
+
+		var res wire.Response
+		if err = wire.ReadFrame(c.reader, &res); err != nil {
+			f.err = err
+			continue
+		}
+		f.revision = res.Revision
+		if res.Err != "" {
+			f.err = remoteError(res.Err)
+		}
+	}
+
+	// Like any other failed request, the next one is sent to another node:
+	if err != nil && c.cluster != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
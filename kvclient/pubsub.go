@@ -0,0 +1,97 @@
+package kvclient
+
+import (
+	"net"
+	"sync"
+
+	"github.com/qsymmachus/kv"
+	"github.com/qsymmachus/kv/internal/wire"
+)
+
+// A subscription to a remote store's topic, with a connection of its own.
+type subscription[V any] struct {
+	conn     net.Conn
+	messages chan V
+	// Closed when the subscription is, so a message that isn't received
+	// doesn't hold up the goroutine reading them.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (sub *subscription[V]) Messages() <-chan V {
+	return sub.messages
+}
+
+func (sub *subscription[V]) Close() error {
+	sub.closeOnce.Do(func() { close(sub.done) })
+	return sub.conn.Close()
+}
+
+func (c *client[K, V]) Publish(topic string, message V) (receivers int, err error) {
+	value, err := encode(message)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := c.call(wire.Request{Op: wire.Publish, Topic: topic, Value: value})
+	return res.Len, err
+}
+
+// Subscribes over a new connection to the server the client is connected to.
+// The subscription ends, and its channel is closed, if the connection fails.
+func (c *client[K, V]) Subscribe(topic string) (kv.Subscription[V], error) {
+	c.mu.Lock()
+	if c.conn.conn == nil {
+		if err := c.reconnect(); err != nil {
+			c.mu.Unlock()
+			return nil, err
+		}
+	}
+	addr, options := c.conn.conn.RemoteAddr().String(), c.options
+	c.mu.Unlock()
+
+	nc, reader, writer, err := options.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := wire.WriteFrame(writer, wire.Request{Op: wire.Subscribe, Namespace: c.namespace, Topic: topic}); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if err := writer.Flush(); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	var res wire.Response
+	if err := wire.ReadFrame(reader, &res); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if res.Err != "" {
+		nc.Close()
+		return nil, remoteError(res.Err)
+	}
+
+	sub := &subscription[V]{conn: nc, messages: make(chan V, 1024), done: make(chan struct{})}
+	go func() {
+		defer close(sub.messages)
+		defer nc.Close()
+		for {
+			var res wire.Response
+			if err := wire.ReadFrame(reader, &res); err != nil || res.Err != "" {
+				return
+			}
+			var message V
+			if err := decode(res.Value, &message); err != nil {
+				return
+			}
+			select {
+			case sub.messages <- message:
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+
+	return sub, nil
+}
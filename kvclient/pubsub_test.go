@@ -0,0 +1,34 @@
+package kvclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPubSub(t *testing.T) {
+	server, client := serve[string, string](t)
+
+	sub, err := client.Subscribe("news")
+	assert.NoError(t, err)
+	defer sub.Close()
+	local, _ := server.Subscribe("news")
+	defer local.Close()
+
+	// Remote and local subscribers both get messages, whoever publishes them:
+	receivers, err := client.Publish("news", "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, receivers)
+	server.Publish("news", "world")
+
+	for _, want := range []string{"hello", "world"} {
+		select {
+		case message := <-sub.Messages():
+			assert.Equal(t, want, message)
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for message")
+		}
+		assert.Equal(t, want, <-local.Messages())
+	}
+}
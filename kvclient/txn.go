@@ -0,0 +1,103 @@
+package kvclient
+
+import (
+	"sync"
+
+	"github.com/qsymmachus/kv"
+	"github.com/qsymmachus/kv/internal/wire"
+)
+
+// A write staged by a transaction, in the client until it's committed.
+type staged[V any] struct {
+	value   V
+	deleted bool
+}
+
+// A transaction on a remote store. Writes are staged in the client, and sent
+// to the server in a single request when the transaction is committed.
+type txn[K comparable, V any] struct {
+	client *client[K, V]
+	// Guards the fields below, so a transaction can be shared by goroutines.
+	mu     sync.Mutex
+	writes map[K]staged[V]
+	done   bool
+}
+
+func (c *client[K, V]) BeginTxn() kv.Txn[K, V] {
+	return &txn[K, V]{client: c, writes: make(map[K]staged[V])}
+}
+
+func (t *txn[K, V]) Get(key K) (value V, found bool) {
+	t.mu.Lock()
+	w, found := t.writes[key]
+	t.mu.Unlock()
+	if found {
+		return w.value, !w.deleted
+	}
+
+	return t.client.Get(key)
+}
+
+func (t *txn[K, V]) Set(key K, value V) error {
+	return t.stage(key, staged[V]{value: value})
+}
+
+func (t *txn[K, V]) Unset(key K) error {
+	return t.stage(key, staged[V]{deleted: true})
+}
+
+func (t *txn[K, V]) stage(key K, w staged[V]) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return kv.ErrTxnDone
+	}
+	t.writes[key] = w
+	return nil
+}
+
+func (t *txn[K, V]) Commit() (revision uint64, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return 0, kv.ErrTxnDone
+	}
+	t.done = true
+	if len(t.writes) == 0 {
+		return 0, nil
+	}
+
+	req := wire.Request{Op: wire.Commit}
+	for k, w := range t.writes {
+		key, err := encode(k)
+		if err != nil {
+			return 0, err
+		}
+		if w.deleted {
+			req.Keys = append(req.Keys, key)
+			continue
+		}
+		value, err := encode(w.value)
+		if err != nil {
+			return 0, err
+		}
+		req.Entries = append(req.Entries, wire.Entry{Key: key, Value: value})
+	}
+
+	res, err := t.client.call(req)
+	return res.Revision, err
+}
+
+func (t *txn[K, V]) Rollback() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return kv.ErrTxnDone
+	}
+	t.done = true
+	t.writes = nil
+	return nil
+}
@@ -0,0 +1,78 @@
+package kvclient
+
+import (
+	"bufio"
+	"net"
+
+	"github.com/qsymmachus/kv"
+	"github.com/qsymmachus/kv/internal/wire"
+)
+
+// A stream of changes to a remote store's keys, started by `Watch`.
+type Watcher[K comparable, V any] struct {
+	// The watched keys and their values when the watch started, and the
+	// revision the store was at then. Every change after that revision is
+	// streamed, so applying them in order to `Entries` keeps it in sync.
+	Entries  map[K]V
+	Revision uint64
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+// Watches the keys starting with `prefix`, in `namespace` ("" for the default
+// one), of the store served at `addr`. Each watch has a connection of its own.
+// If the watcher falls far behind the store, the server stops the watch, and
+// `Next` returns an error; to carry on, start a new one.
+func Watch[K comparable, V any](addr string, namespace string, prefix string, options ...Option) (*Watcher[K, V], error) {
+	c, reader, writer, err := applyOptions(options).dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher[K, V]{conn: c, reader: reader}
+
+	if err := wire.WriteFrame(writer, wire.Request{Op: wire.Watch, Namespace: namespace, Prefix: prefix}); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if err := writer.Flush(); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	var res wire.Response
+	if err := wire.ReadFrame(w.reader, &res); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if res.Err != "" {
+		c.Close()
+		return nil, remoteError(res.Err)
+	}
+	if w.Entries, err = decodeEntries[K, V](res.Entries); err != nil {
+		c.Close()
+		return nil, err
+	}
+	w.Revision = res.Revision
+
+	return w, nil
+}
+
+// Waits for the next change to the watched keys.
+func (w *Watcher[K, V]) Next() (kv.Change[K, V], error) {
+	var change kv.Change[K, V]
+	var res wire.Response
+	if err := wire.ReadFrame(w.reader, &res); err != nil {
+		return change, err
+	}
+	if res.Err != "" {
+		return change, remoteError(res.Err)
+	}
+
+	err := decode(res.Change, &change)
+	return change, err
+}
+
+// Stops the watch.
+func (w *Watcher[K, V]) Close() error {
+	return w.conn.Close()
+}
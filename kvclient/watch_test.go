@@ -0,0 +1,49 @@
+package kvclient
+
+import (
+	"net"
+	"testing"
+
+	"github.com/qsymmachus/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server, _ := kv.NewStore[string, string](kv.ServerListener(listener))
+	defer server.Close()
+	server.Set("user:alice", "Alice")
+	server.Set("session:alice", "token")
+
+	watcher, err := Watch[string, string](listener.Addr().String(), "", "user:")
+	assert.NoError(t, err)
+	defer watcher.Close()
+
+	// The watch starts from the watched keys' current values:
+	assert.Equal(t, map[string]string{"user:alice": "Alice"}, watcher.Entries)
+	assert.Equal(t, uint64(2), watcher.Revision)
+
+	// Only changes to watched keys, in the watched namespace, are streamed:
+	server.Set("session:bob", "token")
+	server.Namespace("admins").Set("user:carol", "Carol")
+	server.Set("user:bob", "Bob")
+	server.Unset("user:alice")
+
+	change, err := watcher.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, kv.ChangeSet, change.Type)
+	assert.Equal(t, "user:bob", change.Key)
+	assert.Equal(t, "Bob", change.Value)
+	assert.Equal(t, uint64(5), change.Revision)
+
+	change, _ = watcher.Next()
+	assert.Equal(t, kv.ChangeUnset, change.Type)
+	assert.Equal(t, "user:alice", change.Key)
+	assert.Equal(t, uint64(6), change.Revision)
+
+	// The stream ends when the store is closed:
+	server.Close()
+	_, err = watcher.Next()
+	assert.Error(t, err)
+}
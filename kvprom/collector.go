@@ -0,0 +1,129 @@
+// Package kvprom exports a `kv` store's statistics as Prometheus metrics, so
+// it can be monitored with standard dashboards.
+//
+//	prometheus.MustRegister(kvprom.Collector(store))
+//
+// The metrics are read from the store's `Stats` each time they're collected.
+// To tell several stores apart, register each with its own labels, using
+// `prometheus.WrapRegistererWith`.
+package kvprom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/qsymmachus/kv"
+)
+
+var (
+	operationsDesc = prometheus.NewDesc(
+		"kv_operations_total",
+		"Operations made on the store since it opened, by operation.",
+		[]string{"operation"}, nil,
+	)
+	latencyDesc = prometheus.NewDesc(
+		"kv_operation_duration_seconds",
+		"How long operations on the store took, by operation.",
+		[]string{"operation"}, nil,
+	)
+	phaseLatencyDesc = prometheus.NewDesc(
+		"kv_write_phase_duration_seconds",
+		"How long writes' updates spent in each phase of being written, by operation and phase: queue, append or apply.",
+		[]string{"operation", "phase"}, nil,
+	)
+	missesDesc = prometheus.NewDesc(
+		"kv_get_misses_total",
+		"Gets of keys that weren't in the store.",
+		nil, nil,
+	)
+	queueDepthDesc = prometheus.NewDesc(
+		"kv_queue_depth",
+		"Updates waiting for their shard's update loop.",
+		nil, nil,
+	)
+	queueFullDesc = prometheus.NewDesc(
+		"kv_queue_full_total",
+		"Updates that found their shard's update queue full.",
+		nil, nil,
+	)
+	logBytesDesc = prometheus.NewDesc(
+		"kv_log_written_bytes_total",
+		"Bytes appended to the write-ahead log since the store opened.",
+		nil, nil,
+	)
+	compactionRunningDesc = prometheus.NewDesc(
+		"kv_compaction_running",
+		"Whether a background compaction is running.",
+		nil, nil,
+	)
+	compactionWrittenDesc = prometheus.NewDesc(
+		"kv_compaction_written_bytes",
+		"Bytes the running background compaction has written, or the last one wrote.",
+		nil, nil,
+	)
+	compactionsDesc = prometheus.NewDesc(
+		"kv_compactions_total",
+		"Background compactions finished since the store opened.",
+		nil, nil,
+	)
+	keysDesc = prometheus.NewDesc(
+		"kv_keys",
+		"Keys in the store.",
+		nil, nil,
+	)
+)
+
+// Collects a store's metrics.
+type collector struct {
+	store interface{ Stats() kv.Stats }
+}
+
+// Returns a `prometheus.Collector` for the statistics of `store`, which may be
+// an embedded store, a remote one connected to with `kvclient`, or a
+// namespace, whose key count is its own.
+func Collector(store interface{ Stats() kv.Stats }) prometheus.Collector {
+	return &collector{store: store}
+}
+
+func (c *collector) Describe(descs chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{operationsDesc, latencyDesc, phaseLatencyDesc, missesDesc, queueDepthDesc, queueFullDesc, logBytesDesc, compactionRunningDesc, compactionWrittenDesc, compactionsDesc, keysDesc} {
+		descs <- desc
+	}
+}
+
+func (c *collector) Collect(metrics chan<- prometheus.Metric) {
+	stats := c.store.Stats()
+
+	for operation, op := range stats.Operations {
+		metrics <- prometheus.MustNewConstMetric(operationsDesc, prometheus.CounterValue, float64(op.Count), operation)
+		metrics <- histogram(latencyDesc, op.Latency, operation)
+
+		// Reads don't have phases:
+		if op.QueueWait.Buckets == nil {
+			continue
+		}
+		metrics <- histogram(phaseLatencyDesc, op.QueueWait, operation, "queue")
+		metrics <- histogram(phaseLatencyDesc, op.Append, operation, "append")
+		metrics <- histogram(phaseLatencyDesc, op.Apply, operation, "apply")
+	}
+
+	metrics <- prometheus.MustNewConstMetric(missesDesc, prometheus.CounterValue, float64(stats.Misses))
+	metrics <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(stats.QueueDepth))
+	metrics <- prometheus.MustNewConstMetric(queueFullDesc, prometheus.CounterValue, float64(stats.QueueFull))
+	metrics <- prometheus.MustNewConstMetric(logBytesDesc, prometheus.CounterValue, float64(stats.LogBytes))
+	running := 0.0
+	if stats.Compaction.Running {
+		running = 1
+	}
+	metrics <- prometheus.MustNewConstMetric(compactionRunningDesc, prometheus.GaugeValue, running)
+	metrics <- prometheus.MustNewConstMetric(compactionWrittenDesc, prometheus.GaugeValue, float64(stats.Compaction.Written))
+	metrics <- prometheus.MustNewConstMetric(compactionsDesc, prometheus.CounterValue, float64(stats.Compaction.Completed))
+	metrics <- prometheus.MustNewConstMetric(keysDesc, prometheus.GaugeValue, float64(stats.Keys))
+}
+
+// Converts one of a store's histograms to a Prometheus one.
+func histogram(desc *prometheus.Desc, h kv.Histogram, labels ...string) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(h.Buckets))
+	for _, bucket := range h.Buckets {
+		buckets[bucket.UpperBound.Seconds()] = bucket.Count
+	}
+	return prometheus.MustNewConstHistogram(desc, h.Count, h.Sum.Seconds(), buckets, labels...)
+}
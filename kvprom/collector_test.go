@@ -0,0 +1,63 @@
+package kvprom
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/qsymmachus/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	store, _ := kv.NewStore[string, int]()
+	defer store.Close()
+	store.Set("a", 1)
+	store.Set("b", 2)
+	store.Get("c")
+
+	registry := prometheus.NewPedanticRegistry()
+	assert.NoError(t, registry.Register(Collector(store)))
+
+	err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP kv_get_misses_total Gets of keys that weren't in the store.
+# TYPE kv_get_misses_total counter
+kv_get_misses_total 1
+# HELP kv_compactions_total Background compactions finished since the store opened.
+# TYPE kv_compactions_total counter
+kv_compactions_total 0
+# HELP kv_keys Keys in the store.
+# TYPE kv_keys gauge
+kv_keys 2
+`), "kv_get_misses_total", "kv_compactions_total", "kv_keys")
+	assert.NoError(t, err)
+
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		switch family.GetName() {
+		case "kv_operations_total":
+			for _, metric := range family.GetMetric() {
+				if metric.GetLabel()[0].GetValue() == "set" {
+					assert.Equal(t, 2.0, metric.GetCounter().GetValue())
+				}
+			}
+		case "kv_write_phase_duration_seconds":
+			// The store has no log, so nothing is appended to one:
+			counts := map[string]uint64{"queue": 2, "append": 0, "apply": 2}
+			for _, metric := range family.GetMetric() {
+				if metric.GetLabel()[0].GetValue() == "set" {
+					phase := metric.GetLabel()[1].GetValue()
+					assert.Equal(t, counts[phase], metric.GetHistogram().GetSampleCount(), phase)
+				}
+			}
+		case "kv_operation_duration_seconds":
+			for _, metric := range family.GetMetric() {
+				if metric.GetLabel()[0].GetValue() == "get" {
+					assert.Equal(t, uint64(1), metric.GetHistogram().GetSampleCount())
+				}
+			}
+		}
+	}
+}
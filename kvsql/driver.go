@@ -0,0 +1,164 @@
+// Package kvsql is a read-only `database/sql` driver for `kv` stores, so tools
+// that talk SQL can inspect a store's keys and values, and report on them.
+//
+//	db := kvsql.Open(store)
+//	rows, err := db.Query("SELECT key, value FROM kv WHERE key LIKE 'user:%' ORDER BY key LIMIT 10")
+//
+// A store is a table named `kv`, with a `key` and a `value` column; each of its
+// namespaces is a table named by the namespace's name, in double quotes.
+// Strings, integers, floats and bools are returned as they are, and anything
+// else as JSON. Statements are `SELECT`s of `*`, `COUNT(*)`, or the two
+// columns, with optional `WHERE` conditions comparing a column with `=`, `!=`,
+// `LIKE` or `IN`, joined by `AND`, and `ORDER BY` and `LIMIT` clauses.
+//
+// The driver is also registered as "kv", to connect to a store served with the
+// `kv.ServerListener` option, at the address given as the data source name:
+//
+//	db, err := sql.Open("kv", "localhost:7001")
+package kvsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+
+	"github.com/qsymmachus/kv"
+	"github.com/qsymmachus/kv/kvclient"
+)
+
+// Returned for statements that would write to the store, and transactions.
+var ErrReadOnly = errors.New("The kv SQL driver is read-only")
+
+func init() {
+	sql.Register("kv", remoteDriver{})
+}
+
+// Gets every entry of a namespace, or of the store itself if it's "".
+type source func(namespace string) []entry
+
+// Returns a source that reads from `store`.
+func sourceOf[K comparable, V any](store kv.KVStore[K, V]) source {
+	return func(namespace string) []entry {
+		data := store.GetAll()
+		if namespace != "" {
+			data = store.Namespace(namespace).GetAll()
+		}
+
+		entries := make([]entry, 0, len(data))
+		for k, v := range data {
+			entries = append(entries, entry{column(k), column(v)})
+		}
+		return entries
+	}
+}
+
+// Returns a database that reads from `store`. Closing it leaves the store open.
+func Open[K comparable, V any](store kv.KVStore[K, V]) *sql.DB {
+	return sql.OpenDB(&connector{source: sourceOf(store)})
+}
+
+// Connects to an embedded store.
+type connector struct {
+	source source
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{source: c.source}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return remoteDriver{}
+}
+
+// Connects to remote stores, by their server addresses. Every key and value is
+// read as JSON.
+type remoteDriver struct{}
+
+func (remoteDriver) Open(addr string) (driver.Conn, error) {
+	store, err := kvclient.Dial[any, any](addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{source: sourceOf(store), closer: store}, nil
+}
+
+// A connection to a store.
+type conn struct {
+	source source
+	// Closes the connection to a remote store.
+	closer io.Closer
+}
+
+func (c *conn) Prepare(statement string) (driver.Stmt, error) {
+	q, err := parse(statement)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stmt{conn: c, query: q}, nil
+}
+
+func (c *conn) Close() error {
+	if c.closer != nil {
+		return c.closer.Close()
+	}
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return nil, ErrReadOnly
+}
+
+// A prepared `SELECT` statement.
+type stmt struct {
+	conn  *conn
+	query *query
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return s.query.placeholders
+}
+
+func (s *stmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, ErrReadOnly
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	columns, values, err := s.query.run(s.conn.source(s.query.namespace), args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rows{columns: columns, values: values}, nil
+}
+
+// The results of a query, which are all read before they're returned.
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,79 @@
+package kvsql
+
+import (
+	"database/sql"
+	"net"
+	"testing"
+
+	"github.com/qsymmachus/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+// Reads every row of a query's results, as key/value pairs.
+func queryPairs(t *testing.T, db *sql.DB, statement string, args ...any) [][2]any {
+	rows, err := db.Query(statement, args...)
+	assert.NoError(t, err)
+	defer rows.Close()
+
+	var pairs [][2]any
+	for rows.Next() {
+		var pair [2]any
+		assert.NoError(t, rows.Scan(&pair[0], &pair[1]))
+		pairs = append(pairs, pair)
+	}
+	assert.NoError(t, rows.Err())
+	return pairs
+}
+
+func TestOpen(t *testing.T) {
+	store, _ := kv.NewStore[string, int]()
+	defer store.Close()
+	store.SetMany(map[string]int{"user:1": 10, "user:2": 30, "user:3": 20, "order:1": 5})
+
+	db := Open(store)
+	defer db.Close()
+
+	assert.Equal(t, [][2]any{{"user:1", int64(10)}, {"user:2", int64(30)}, {"user:3", int64(20)}},
+		queryPairs(t, db, "SELECT key, value FROM kv WHERE key LIKE 'user:%'"))
+	assert.Equal(t, [][2]any{{"user:2", int64(30)}, {"user:3", int64(20)}},
+		queryPairs(t, db, "SELECT * FROM kv WHERE key LIKE 'user:_' ORDER BY value DESC LIMIT 2"))
+	assert.Equal(t, [][2]any{{"order:1", int64(5)}},
+		queryPairs(t, db, "SELECT * FROM kv WHERE key = ?", "order:1"))
+
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM kv WHERE value IN (10, 20)").Scan(&count))
+	assert.Equal(t, 2, count)
+
+	// Namespaces are tables of their own:
+	store.Namespace("archive").Set("user:4", 40)
+	assert.Equal(t, [][2]any{{"user:4", int64(40)}}, queryPairs(t, db, `SELECT * FROM "archive"`))
+}
+
+func TestReadOnly(t *testing.T) {
+	store, _ := kv.NewStore[string, int]()
+	defer store.Close()
+	db := Open(store)
+	defer db.Close()
+
+	_, err := db.Exec("DELETE FROM kv")
+	assert.Error(t, err)
+	_, err = db.Exec("SELECT * FROM kv")
+	assert.ErrorIs(t, err, ErrReadOnly)
+	_, err = db.Begin()
+	assert.ErrorIs(t, err, ErrReadOnly)
+}
+
+func TestRemoteDriver(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	store, _ := kv.NewStore[string, map[string]string](kv.ServerListener(listener))
+	defer store.Close()
+	store.Set("toby", map[string]string{"kind": "dog"})
+
+	db, err := sql.Open("kv", listener.Addr().String())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Values that aren't strings, numbers or bools are returned as JSON:
+	assert.Equal(t, [][2]any{{"toby", `{"kind":"dog"}`}}, queryPairs(t, db, "SELECT key, value FROM kv"))
+}
@@ -0,0 +1,455 @@
+package kvsql
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A key/value pair, as the values of the `key` and `value` columns.
+type entry struct {
+	key   driver.Value
+	value driver.Value
+}
+
+// Converts a key or value to a column value: strings, integers, floats and
+// bools as they are, and anything else as JSON.
+func column(v any) driver.Value {
+	switch v := v.(type) {
+	case string, int64, float64, bool:
+		return v
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case []byte:
+		return string(v)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(encoded)
+	}
+}
+
+// A parsed `SELECT` statement.
+type query struct {
+	// The columns selected, or nil for `COUNT(*)`.
+	columns []string
+	// The namespace read, or "" for the store itself.
+	namespace  string
+	conditions []condition
+	orderBy    string
+	descending bool
+	limit      int
+	// The number of `?` placeholders in the statement.
+	placeholders int
+}
+
+// A condition in a `WHERE` clause, comparing a column with one or more
+// values.
+type condition struct {
+	column   string
+	operator string
+	values   []operand
+}
+
+// A literal in a statement, or a placeholder for the argument at `arg`.
+type operand struct {
+	value driver.Value
+	arg   int
+}
+
+// Splits a statement into tokens: keywords and identifiers, which are upper
+// cased, quoted strings, which keep their quote, numbers, and symbols.
+func tokenize(statement string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(statement); {
+		c := rune(statement[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '\'' || c == '"':
+			// Quotes are escaped by doubling them:
+			var text strings.Builder
+			text.WriteRune(c)
+			j := i + 1
+			for {
+				if j >= len(statement) {
+					return nil, errors.New("Unterminated string in statement")
+				}
+				if rune(statement[j]) == c {
+					if j+1 < len(statement) && rune(statement[j+1]) == c {
+						text.WriteRune(c)
+						j += 2
+						continue
+					}
+					break
+				}
+				text.WriteByte(statement[j])
+				j++
+			}
+			tokens = append(tokens, text.String())
+			i = j + 1
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(statement) && (unicode.IsLetter(rune(statement[j])) || unicode.IsDigit(rune(statement[j])) || statement[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, strings.ToUpper(statement[i:j]))
+			i = j
+		case unicode.IsDigit(c) || c == '-' || c == '.':
+			j := i + 1
+			for j < len(statement) && (unicode.IsDigit(rune(statement[j])) || statement[j] == '.' || statement[j] == 'e' || statement[j] == 'E') {
+				j++
+			}
+			tokens = append(tokens, statement[i:j])
+			i = j
+		case strings.HasPrefix(statement[i:], "!=") || strings.HasPrefix(statement[i:], "<>"):
+			tokens = append(tokens, "!=")
+			i += 2
+		case strings.ContainsRune("*,()=?;", c):
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			return nil, fmt.Errorf("Unexpected %q in statement", c)
+		}
+	}
+
+	return tokens, nil
+}
+
+// Parses a statement of the form:
+//
+//	SELECT <* | COUNT(*) | key, value> FROM <kv | namespace>
+//	[WHERE <column> <= | != | LIKE | IN> <values> [AND ...]]
+//	[ORDER BY <column> [ASC | DESC]] [LIMIT <n>]
+func parse(statement string) (*query, error) {
+	tokens, err := tokenize(statement)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	q := &query{limit: -1}
+
+	if !p.accept("SELECT") {
+		return nil, errors.New("Only SELECT statements are supported")
+	}
+	switch {
+	case p.accept("*"):
+		q.columns = []string{"key", "value"}
+	case p.accept("COUNT"):
+		if !p.accept("(") || !p.accept("*") || !p.accept(")") {
+			return nil, errors.New("Expected COUNT(*)")
+		}
+	default:
+		for {
+			name, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			q.columns = append(q.columns, name)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+
+	if !p.accept("FROM") {
+		return nil, errors.New("Expected FROM")
+	}
+	table := p.next()
+	switch {
+	case table == "KV":
+	case strings.HasPrefix(table, `"`):
+		q.namespace = table[1:]
+	default:
+		return nil, fmt.Errorf("Unknown table %q, expected kv, or a namespace's name in double quotes", table)
+	}
+
+	if p.accept("WHERE") {
+		for {
+			c, err := p.condition(q)
+			if err != nil {
+				return nil, err
+			}
+			q.conditions = append(q.conditions, c)
+			if !p.accept("AND") {
+				break
+			}
+		}
+	}
+
+	if p.accept("ORDER") {
+		if !p.accept("BY") {
+			return nil, errors.New("Expected ORDER BY")
+		}
+		if q.orderBy, err = p.column(); err != nil {
+			return nil, err
+		}
+		if p.accept("DESC") {
+			q.descending = true
+		} else {
+			p.accept("ASC")
+		}
+	}
+
+	if p.accept("LIMIT") {
+		if q.limit, err = strconv.Atoi(p.next()); err != nil || q.limit < 0 {
+			return nil, errors.New("Expected a number after LIMIT")
+		}
+	}
+
+	p.accept(";")
+	if token := p.next(); token != "" {
+		return nil, fmt.Errorf("Unexpected %q in statement", token)
+	}
+	return q, nil
+}
+
+// Reads tokens, one at a time.
+type parser struct {
+	tokens []string
+}
+
+// Returns the next token, or "" once there are none left.
+func (p *parser) next() string {
+	if len(p.tokens) == 0 {
+		return ""
+	}
+
+	token := p.tokens[0]
+	p.tokens = p.tokens[1:]
+	return token
+}
+
+// Reads the next token if it's `token`.
+func (p *parser) accept(token string) bool {
+	if len(p.tokens) > 0 && p.tokens[0] == token {
+		p.tokens = p.tokens[1:]
+		return true
+	}
+	return false
+}
+
+// Reads the name of a column.
+func (p *parser) column() (string, error) {
+	switch token := p.next(); token {
+	case "KEY", "VALUE":
+		return strings.ToLower(token), nil
+	default:
+		return "", fmt.Errorf("Unknown column %q, expected key or value", token)
+	}
+}
+
+func (p *parser) condition(q *query) (condition, error) {
+	name, err := p.column()
+	if err != nil {
+		return condition{}, err
+	}
+	c := condition{column: name, operator: p.next()}
+
+	switch c.operator {
+	case "=", "!=", "LIKE":
+		value, err := p.operand(q)
+		if err != nil {
+			return condition{}, err
+		}
+		c.values = []operand{value}
+	case "IN":
+		if !p.accept("(") {
+			return condition{}, errors.New("Expected a list of values after IN")
+		}
+		for {
+			value, err := p.operand(q)
+			if err != nil {
+				return condition{}, err
+			}
+			c.values = append(c.values, value)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if !p.accept(")") {
+			return condition{}, errors.New("Expected ) after a list of values")
+		}
+	default:
+		return condition{}, fmt.Errorf("Unknown operator %q", c.operator)
+	}
+
+	return c, nil
+}
+
+// Reads a literal, or a placeholder.
+func (p *parser) operand(q *query) (operand, error) {
+	token := p.next()
+	switch {
+	case token == "?":
+		q.placeholders++
+		return operand{arg: q.placeholders}, nil
+	case strings.HasPrefix(token, "'"):
+		return operand{value: token[1:]}, nil
+	case token == "TRUE" || token == "FALSE":
+		return operand{value: token == "TRUE"}, nil
+	}
+
+	if n, err := strconv.ParseInt(token, 10, 64); err == nil {
+		return operand{value: n}, nil
+	}
+	if f, err := strconv.ParseFloat(token, 64); err == nil {
+		return operand{value: f}, nil
+	}
+	return operand{}, fmt.Errorf("Expected a value, got %q", token)
+}
+
+// Runs the query against a namespace's entries, with the arguments for its
+// placeholders.
+func (q *query) run(entries []entry, args []driver.Value) (columns []string, rows [][]driver.Value, err error) {
+	if len(args) != q.placeholders {
+		return nil, nil, fmt.Errorf("Expected %d arguments, got %d", q.placeholders, len(args))
+	}
+
+	var matched []entry
+	for _, e := range entries {
+		if q.matches(e, args) {
+			matched = append(matched, e)
+		}
+	}
+
+	if q.columns == nil {
+		return []string{"count"}, [][]driver.Value{{int64(len(matched))}}, nil
+	}
+
+	// Rows are ordered by key unless they're ordered by value, so results are
+	// the same each time:
+	orderBy := q.orderBy
+	if orderBy == "" {
+		orderBy = "key"
+	}
+	slices.SortStableFunc(matched, func(a, b entry) int {
+		order := compare(a.get(orderBy), b.get(orderBy))
+		if order == 0 {
+			order = compare(a.key, b.key)
+		}
+		if q.descending {
+			return -order
+		}
+		return order
+	})
+	if q.limit >= 0 && len(matched) > q.limit {
+		matched = matched[:q.limit]
+	}
+
+	for _, e := range matched {
+		row := make([]driver.Value, len(q.columns))
+		for i, name := range q.columns {
+			row[i] = e.get(name)
+		}
+		rows = append(rows, row)
+	}
+	return q.columns, rows, nil
+}
+
+// Gets the value of a column.
+func (e entry) get(name string) driver.Value {
+	if name == "key" {
+		return e.key
+	}
+	return e.value
+}
+
+// Reports whether an entry meets every condition.
+func (q *query) matches(e entry, args []driver.Value) bool {
+	for _, c := range q.conditions {
+		v := e.get(c.column)
+
+		met := false
+		for _, o := range c.values {
+			operand := o.value
+			if o.arg > 0 {
+				operand = column(args[o.arg-1])
+			}
+
+			switch c.operator {
+			case "LIKE":
+				met = like(text(v), text(operand))
+			case "!=":
+				met = compare(v, operand) != 0
+			default:
+				met = met || compare(v, operand) == 0
+			}
+		}
+		if !met {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Compares two column values: as numbers if they both are, and otherwise as
+// text.
+func compare(a driver.Value, b driver.Value) int {
+	x, aNumber := number(a)
+	y, bNumber := number(b)
+	switch {
+	case aNumber && bNumber && x < y:
+		return -1
+	case aNumber && bNumber && x > y:
+		return 1
+	case aNumber && bNumber:
+		return 0
+	default:
+		return strings.Compare(text(a), text(b))
+	}
+}
+
+func number(v driver.Value) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+func text(v driver.Value) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// Reports whether `s` matches a `LIKE` pattern, where `%` matches any run of
+// characters, and `_` any one character.
+func like(s string, pattern string) bool {
+	if pattern == "" {
+		return s == ""
+	}
+
+	switch pattern[0] {
+	case '%':
+		for i := 0; i <= len(s); i++ {
+			if like(s[i:], pattern[1:]) {
+				return true
+			}
+		}
+		return false
+	case '_':
+		return s != "" && like(s[1:], pattern[1:])
+	default:
+		return s != "" && s[0] == pattern[0] && like(s[1:], pattern[1:])
+	}
+}
@@ -0,0 +1,64 @@
+package kvsql
+
+import (
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	q, err := parse(`select value from "my ""pets""" where key in ('a', ?) and value != 1.5 order by key desc limit 3;`)
+	assert.NoError(t, err)
+	assert.Equal(t, &query{
+		columns:   []string{"value"},
+		namespace: `my "pets"`,
+		conditions: []condition{
+			{column: "key", operator: "IN", values: []operand{{value: "a"}, {arg: 1}}},
+			{column: "value", operator: "!=", values: []operand{{value: 1.5}}},
+		},
+		orderBy:      "key",
+		descending:   true,
+		limit:        3,
+		placeholders: 1,
+	}, q)
+
+	for _, statement := range []string{
+		"UPDATE kv SET value = 1",
+		"SELECT name FROM kv",
+		"SELECT * FROM users",
+		"SELECT * FROM kv WHERE key > 'a'",
+		"SELECT * FROM kv WHERE key = 'a",
+		"SELECT * FROM kv LIMIT ten",
+		"SELECT * FROM kv GROUP BY key",
+	} {
+		_, err := parse(statement)
+		assert.Error(t, err, statement)
+	}
+}
+
+func TestRun(t *testing.T) {
+	entries := []entry{{"b", int64(2)}, {"a", int64(10)}, {"c", "x"}}
+
+	q, _ := parse("SELECT * FROM kv WHERE value != ?")
+	columns, rows, err := q.run(entries, []driver.Value{int64(2)})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"key", "value"}, columns)
+	assert.Equal(t, [][]driver.Value{{"a", int64(10)}, {"c", "x"}}, rows)
+
+	// Numbers are compared as numbers:
+	q, _ = parse("SELECT key FROM kv WHERE value IN (2, 10) ORDER BY value")
+	_, rows, _ = q.run(entries, nil)
+	assert.Equal(t, [][]driver.Value{{"b"}, {"a"}}, rows)
+
+	_, _, err = q.run(entries, []driver.Value{"extra"})
+	assert.Error(t, err)
+}
+
+func TestLike(t *testing.T) {
+	assert.True(t, like("user:1", "user:%"))
+	assert.True(t, like("user:1", "%:_"))
+	assert.True(t, like("", "%"))
+	assert.False(t, like("user:10", "user:_"))
+	assert.False(t, like("order:1", "user:%"))
+}
@@ -0,0 +1,142 @@
+package kv
+
+import (
+	"errors"
+	"time"
+)
+
+// Returned when a key is attached to, or a lease is kept alive or revoked, with
+// a lease that doesn't exist, or has already expired.
+var ErrNoLease = errors.New("Lease doesn't exist or has expired")
+
+// Returned when a lease is asked for with a TTL that isn't positive.
+var errLeaseTTL = errors.New("Lease TTL must be positive")
+
+// Identifies a lease granted by `GrantLease`. A lease's ID is the revision of
+// the update that granted it, so it's never reused.
+type LeaseID uint64
+
+// How often the store checks for leases that have expired.
+const leaseCheckInterval = 50 * time.Millisecond
+
+// A lease, and the keys attached to it.
+type lease[K comparable] struct {
+	ttl time.Duration
+	// The namespace the lease was granted in. Only clients that can write to it
+	// may keep the lease alive, or revoke it, through a server.
+	namespace string
+	expires   time.Time
+	keys      map[namespacedKey[K]]struct{}
+}
+
+func (s *kvStore[K, V]) GrantLease(ttl time.Duration) (LeaseID, error) {
+	if ttl <= 0 {
+		return 0, errLeaseTTL
+	}
+
+	u := s.newUpdate(grantLease, *new(K), *new(V))
+	u.TTL = ttl
+	result := s.write(u)
+	return LeaseID(result.revision), result.err
+}
+
+func (s *kvStore[K, V]) SetWithLease(key K, value V, id LeaseID) (revision uint64, err error) {
+	u := s.newUpdate(set, key, value)
+	u.Lease = id
+	result := s.write(u)
+	return result.revision, result.err
+}
+
+func (s *kvStore[K, V]) KeepAlive(id LeaseID) error {
+	s.commit.Lock()
+	defer s.commit.Unlock()
+
+	l, found := s.leases[id]
+	if !found {
+		return ErrNoLease
+	}
+	l.expires = time.Now().Add(l.ttl)
+	return nil
+}
+
+func (s *kvStore[K, V]) RevokeLease(id LeaseID) error {
+	u := s.newUpdate(revokeLease, *new(K), *new(V))
+	u.Lease = id
+	return s.write(u).err
+}
+
+// Adds a lease, unless the store has it already: a lease that's in a snapshot
+// is granted again when the part of the log the snapshot covers is replayed
+// after it. The caller must hold `commit`.
+func (s *kvStore[K, V]) grant(id LeaseID, ttl time.Duration, namespace string) {
+	if _, found := s.leases[id]; found {
+		return
+	}
+
+	s.leases[id] = &lease[K]{
+		ttl:       ttl,
+		namespace: namespace,
+		expires:   time.Now().Add(ttl),
+		keys:      make(map[namespacedKey[K]]struct{}),
+	}
+}
+
+// Returns the namespace a lease was granted in, and whether the store has it.
+func (s *kvStore[K, V]) leaseNamespace(id LeaseID) (string, bool) {
+	s.commit.Lock()
+	defer s.commit.Unlock()
+
+	l, found := s.leases[id]
+	if !found {
+		return "", false
+	}
+	return l.namespace, true
+}
+
+// Attaches a key to a lease, detaching it from the lease it was attached to
+// before, if any. A lease of 0 only detaches it. The caller must hold `commit`.
+func (s *kvStore[K, V]) attach(k namespacedKey[K], id LeaseID) {
+	if previous, found := s.leased[k]; found {
+		delete(s.leases[previous].keys, k)
+		delete(s.leased, k)
+	}
+	if l, found := s.leases[id]; found {
+		l.keys[k] = struct{}{}
+		s.leased[k] = id
+	}
+}
+
+// Revokes leases as they expire, until the store is closed. Followers leave it
+// to their leader, whose revocations are streamed to them, and in clustered mode
+// only the leader's revocations are committed.
+func (s *kvStore[K, V]) expireLeases() {
+	defer s.background.Done()
+
+	ticker := time.NewTicker(leaseCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closing:
+			return
+		case now := <-ticker.C:
+			var expired []LeaseID
+			s.commit.Lock()
+			for id, l := range s.leases {
+				if now.After(l.expires) {
+					expired = append(expired, id)
+				}
+			}
+			s.commit.Unlock()
+
+			for _, id := range expired {
+				// A lease that's already been revoked isn't an error:
+				switch err := s.RevokeLease(id); {
+				case err == nil:
+					s.options.logger.Info("Lease expired", "lease", id)
+				case !errors.Is(err, ErrNoLease):
+					s.options.logger.Error("Failed to revoke expired lease", "lease", id, "error", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package kv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseExpires(t *testing.T) {
+	recorder := &changeRecorder[string, string]{}
+	store, _ := NewStore[string, string](PublishChanges[string, string](recorder))
+	defer store.Close()
+
+	lease, err := store.GrantLease(100 * time.Millisecond)
+	assert.NoError(t, err)
+	store.SetWithLease("services/api", "10.0.0.1", lease)
+	store.Namespace("users").SetWithLease("alice", "Alice", lease)
+	store.Set("config", "debug")
+
+	// Every key attached to the lease is unset once it expires, as a single
+	// update:
+	assert.Eventually(t, func() bool {
+		return len(recorder.published()) == 5
+	}, time.Second, 10*time.Millisecond)
+	changes := recorder.published()
+	assert.Equal(t, ChangeUnset, changes[3].Type)
+	assert.Equal(t, ChangeUnset, changes[4].Type)
+	assert.Equal(t, changes[3].Revision, changes[4].Revision)
+	_, found := store.Namespace("users").Get("alice")
+	assert.False(t, found)
+	_, found = store.Get("config")
+	assert.True(t, found)
+
+	_, err = store.SetWithLease("services/api", "10.0.0.1", lease)
+	assert.ErrorIs(t, err, ErrNoLease)
+	assert.ErrorIs(t, store.KeepAlive(lease), ErrNoLease)
+}
+
+func TestKeepAlive(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	defer store.Close()
+
+	lease, _ := store.GrantLease(150 * time.Millisecond)
+	store.SetWithLease("services/api", "10.0.0.1", lease)
+	for range 4 {
+		time.Sleep(75 * time.Millisecond)
+		assert.NoError(t, store.KeepAlive(lease))
+	}
+
+	_, found := store.Get("services/api")
+	assert.True(t, found)
+}
+
+func TestRevokeLease(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	defer store.Close()
+
+	lease, _ := store.GrantLease(time.Hour)
+	store.SetWithLease("a", "1", lease)
+	store.SetWithLease("b", "2", lease)
+	// Setting a key without the lease detaches it:
+	store.Set("b", "3")
+
+	assert.NoError(t, store.RevokeLease(lease))
+	assert.Equal(t, map[string]string{"b": "3"}, store.GetAll())
+	assert.ErrorIs(t, store.RevokeLease(lease), ErrNoLease)
+}
+
+func TestLeaseReplay(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath))
+	lease, _ := store.GrantLease(time.Hour)
+	store.SetWithLease("a", "1", lease)
+	revoked, _ := store.GrantLease(time.Hour)
+	store.SetWithLease("b", "2", revoked)
+	store.RevokeLease(revoked)
+	store.Close()
+
+	// Leases, and the keys attached to them, are replayed from the log:
+	replayed, _ := NewStore[string, string](LogPath(logPath))
+	assert.Equal(t, map[string]string{"a": "1"}, replayed.GetAll())
+	assert.NoError(t, replayed.KeepAlive(lease))
+	assert.ErrorIs(t, replayed.KeepAlive(revoked), ErrNoLease)
+
+	// And survive compaction:
+	assert.NoError(t, replayed.Compact())
+	replayed.Close()
+	compacted, _ := NewStore[string, string](LogPath(logPath))
+	defer compacted.Close()
+	assert.NoError(t, compacted.RevokeLease(lease))
+	assert.Equal(t, 0, compacted.Len())
+}
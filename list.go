@@ -0,0 +1,115 @@
+package kv
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Returned by the list operations, `LPush`, `RPush`, `LPop` and `LRange`, for
+// stores whose values aren't slices.
+var ErrNotList = errors.New("Store's values aren't slices")
+
+var errPopCount = errors.New("Count of elements to pop must be positive")
+
+func (s *kvStore[K, V]) LPush(key K, values V) (length int, err error) {
+	return s.push(pushFront, key, values)
+}
+
+func (s *kvStore[K, V]) RPush(key K, values V) (length int, err error) {
+	return s.push(pushBack, key, values)
+}
+
+func (s *kvStore[K, V]) push(updateType updateType, key K, values V) (length int, err error) {
+	if !isList[V]() {
+		return 0, ErrNotList
+	}
+
+	result := s.write(s.newUpdate(updateType, key, values))
+	if result.err != nil {
+		return 0, result.err
+	}
+	return lengthOf(result.value), nil
+}
+
+func (s *kvStore[K, V]) LPop(key K, count int) (values V, err error) {
+	if !isList[V]() {
+		return values, ErrNotList
+	}
+	if count <= 0 {
+		return values, errPopCount
+	}
+
+	u := s.newUpdate(popFront, key, *new(V))
+	u.Count = count
+	result := s.write(u)
+	return result.value, result.err
+}
+
+func (s *kvStore[K, V]) LRange(key K, start int, stop int) (values V, err error) {
+	if !isList[V]() {
+		return values, ErrNotList
+	}
+
+	list, _ := s.find(key)
+	v := reflect.ValueOf(list)
+	n := v.Len()
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	stop = min(stop, n-1)
+	if n == 0 || start > stop {
+		return values, nil
+	}
+
+	// Capped, so appending to the range can't write over the list:
+	return v.Slice3(start, stop+1, stop+1).Interface().(V), nil
+}
+
+// Gets the length of a string or slice.
+func lengthOf[V any](value V) int {
+	return reflect.ValueOf(&value).Elem().Len()
+}
+
+// Reports whether a store's values are slices, which the list operations need.
+func isList[V any]() bool {
+	return reflect.TypeFor[V]().Kind() == reflect.Slice
+}
+
+// Applies an update that changes part of a value, rather than replacing it: an
+// append, a push, a pop, or a change to a set's or sorted set's members, or to
+// a hash's fields. Returns the value it leaves the key with, and the elements
+// or fields it popped, added or removed, for pops and changes to sets and
+// hashes.
+func applyDelta[K comparable, V any](u update[K, V], value V) (result V, affected V, err error) {
+	switch u.UpdateType {
+	case appendValue, pushBack:
+		result, err = appendValues(value, u.Value)
+	case pushFront:
+		result, err = appendValues(u.Value, value)
+	case popFront:
+		v := reflect.ValueOf(&value).Elem()
+		if v.Kind() != reflect.Slice {
+			return result, affected, ErrNotList
+		}
+		// Values are never changed in place, since pushes copy them, so what's
+		// left and what was popped can share the list's memory:
+		n := min(u.Count, v.Len())
+		affected = v.Slice3(0, n, n).Interface().(V)
+		result = v.Slice(n, v.Len()).Interface().(V)
+	case addMembers:
+		result, affected, err = withMembers(value, u.Value)
+	case removeMembers:
+		result, affected, err = withoutMembers(value, u.Value)
+	case addScored:
+		result, affected, err = withScores(value, u.Value)
+	case setFields:
+		result, affected, err = withFields(value, u.Value)
+	case deleteFields:
+		result, affected, err = withoutFields(value, u.Fields)
+	}
+
+	return result, affected, err
+}
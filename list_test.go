@@ -0,0 +1,109 @@
+package kv
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestList(t *testing.T) {
+	store, _ := NewStore[string, []string]()
+	defer store.Close()
+
+	length, err := store.RPush("list", []string{"b", "c"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, length)
+	length, _ = store.LPush("list", []string{"a"})
+	assert.Equal(t, 3, length)
+	store.RPush("list", []string{"d"})
+
+	values, err := store.LRange("list", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, values)
+	values, _ = store.LRange("list", 1, 2)
+	assert.Equal(t, []string{"b", "c"}, values)
+	values, _ = store.LRange("list", -2, 10)
+	assert.Equal(t, []string{"c", "d"}, values)
+	values, _ = store.LRange("list", 3, 1)
+	assert.Empty(t, values)
+	values, _ = store.LRange("missing", 0, -1)
+	assert.Empty(t, values)
+
+	values, err = store.LPop("list", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, values)
+	values, _ = store.LPop("list", 5)
+	assert.Equal(t, []string{"b", "c", "d"}, values)
+
+	// Emptied lists are unset:
+	_, found := store.Get("list")
+	assert.False(t, found)
+	values, err = store.LPop("list", 1)
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+
+	_, err = store.LPop("list", 0)
+	assert.Error(t, err)
+}
+
+func TestListNotSlice(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	defer store.Close()
+
+	_, err := store.RPush("list", "a")
+	assert.ErrorIs(t, err, ErrNotList)
+	_, err = store.LPop("list", 1)
+	assert.ErrorIs(t, err, ErrNotList)
+	_, err = store.LRange("list", 0, -1)
+	assert.ErrorIs(t, err, ErrNotList)
+}
+
+// Test that a list can be used as a durable queue, that's consumed
+// concurrently and survives a restart.
+func TestQueue(t *testing.T) {
+	defer removeLog()
+
+	store, err := NewStore[string, []int](LogPath(logPath), Shards(4))
+	assert.NoError(t, err)
+	for i := range 100 {
+		store.RPush("jobs", []int{i})
+	}
+
+	var wg sync.WaitGroup
+	popped := make(chan int, 100)
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				jobs, _ := store.LPop("jobs", 1)
+				for _, job := range jobs {
+					popped <- job
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(popped)
+	var jobs []int
+	for job := range popped {
+		jobs = append(jobs, job)
+	}
+	assert.Len(t, jobs, 50)
+	jobs, _ = store.LRange("jobs", 0, 0)
+	assert.Equal(t, []int{50}, jobs)
+	store.Close()
+
+	// Pushes and pops are logged as they were made, not as the whole list:
+	log, _ := os.ReadFile(logPath)
+	assert.NotContains(t, string(log), "[0,1")
+
+	replayed, err := NewStore[string, []int](LogPath(logPath))
+	assert.NoError(t, err)
+	defer replayed.Close()
+	jobs, _ = replayed.LRange("jobs", 0, -1)
+	assert.Len(t, jobs, 50)
+	assert.Equal(t, 50, jobs[0])
+}
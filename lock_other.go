@@ -0,0 +1,10 @@
+//go:build !unix
+
+package kv
+
+import "os"
+
+// Advisory locks aren't supported on this platform, so logs are never locked.
+func tryLock(file *os.File) error {
+	return nil
+}
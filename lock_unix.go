@@ -0,0 +1,20 @@
+//go:build unix
+
+package kv
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Takes an exclusive advisory lock on a file, without waiting for it. Returns
+// `ErrLogLocked` if another process, or another open store, holds it.
+func tryLock(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrLogLocked
+	}
+
+	return err
+}
@@ -0,0 +1,351 @@
+package kv
+
+import (
+	"encoding/json"
+	"fmt"
+	"iter"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// The size, in bytes, that a memtable grows to before it's flushed to an
+// SSTable, unless the store sets one with `MemtableSize`.
+const defaultMemtableSize = 4 << 20
+
+// Once a bucket has more than this many SSTables, they're compacted into one.
+const maxTables = 4
+
+// Keeps the SSTables of every bucket in a store, each bucket in its own
+// subdirectory of `dir`.
+type lsmEngine struct {
+	dir               string
+	memtableSize      int
+	falsePositiveRate float64
+	mu                sync.Mutex
+	// Every bucket's storage, so their tables can be closed with the store.
+	// Guarded by `mu`.
+	storages []interface{ close() }
+	// Tracks background compactions.
+	compactions sync.WaitGroup
+}
+
+// Empties `dir`, or creates it if it doesn't exist.
+func openLSM(dir string, memtableSize int, falsePositiveRate float64) (*lsmEngine, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &lsmEngine{dir: dir, memtableSize: memtableSize, falsePositiveRate: falsePositiveRate}, nil
+}
+
+// Waits for compactions to finish, then closes every table and removes `dir`.
+func (e *lsmEngine) close() error {
+	e.compactions.Wait()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, s := range e.storages {
+		s.close()
+	}
+
+	return os.RemoveAll(e.dir)
+}
+
+// Keeps a bucket's key/value pairs in a log-structured merge tree: writes go to
+// an in-memory memtable, which is flushed to a new SSTable once it's full, and
+// SSTables are compacted in the background once there are too many of them.
+// Keys and values are encoded as JSON, and tables are sorted by encoded key.
+type lsmStorage[K comparable, V any] struct {
+	engine *lsmEngine
+	dir    string
+	// The encoded size of the memtable's keys and values.
+	memtableBytes int
+	// The number of keys in the bucket.
+	count int
+	// Guards the fields below, which `Get` reads while the update loop and
+	// background compactions change them. The update loop is the only writer
+	// of the memtable, so it reads it without `mu`.
+	mu sync.RWMutex
+	// Writes that haven't been flushed yet, by encoded key.
+	memtable map[string]memtableEntry[K, V]
+	// Every SSTable, oldest first.
+	tables []*sstable
+	// Incremented whenever the bucket is reset, so a compaction that started
+	// before can tell its result is out of date.
+	generation int
+	compacting bool
+	// The number of the next table file.
+	nextTable int
+}
+
+// A write in a memtable: either a value, or a tombstone recording that the key
+// was removed.
+type memtableEntry[K comparable, V any] struct {
+	key       K
+	value     V
+	encoded   []byte
+	tombstone bool
+}
+
+func newLSMStorage[K comparable, V any](engine *lsmEngine) *lsmStorage[K, V] {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+
+	s := &lsmStorage[K, V]{
+		engine:   engine,
+		dir:      filepath.Join(engine.dir, fmt.Sprintf("%06d", len(engine.storages))),
+		memtable: make(map[string]memtableEntry[K, V]),
+	}
+	engine.storages = append(engine.storages, s)
+	return s
+}
+
+func (s *lsmStorage[K, V]) get(key K) (value V, found bool) {
+	encoded, err := json.Marshal(key)
+	if err != nil {
+		return value, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if e, found := s.memtable[string(encoded)]; found {
+		return e.value, !e.tombstone
+	}
+
+	// Newer tables shadow older ones:
+	for i := len(s.tables) - 1; i >= 0; i-- {
+		record, found, err := s.tables[i].get(encoded)
+		if err != nil || !found {
+			continue
+		}
+		if record.tombstone {
+			return value, false
+		}
+
+		err = json.Unmarshal(record.value, &value)
+		return value, err == nil
+	}
+
+	return value, false
+}
+
+func (s *lsmStorage[K, V]) put(key K, value V) error {
+	encodedKey, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if _, found := s.get(key); !found {
+		s.count++
+	}
+	s.mu.Lock()
+	s.memtable[string(encodedKey)] = memtableEntry[K, V]{key: key, value: value, encoded: encodedValue}
+	s.mu.Unlock()
+	s.memtableBytes += len(encodedKey) + len(encodedValue)
+	if s.memtableBytes >= s.engine.memtableSize {
+		return s.flush()
+	}
+
+	return nil
+}
+
+// Writes a tombstone for the key. Flushing can fail, but the key is removed
+// from the memtable either way, and flushed with the next write.
+func (s *lsmStorage[K, V]) remove(key K) {
+	if _, found := s.get(key); !found {
+		return
+	}
+
+	encodedKey, _ := json.Marshal(key)
+	s.count--
+	s.mu.Lock()
+	s.memtable[string(encodedKey)] = memtableEntry[K, V]{key: key, tombstone: true}
+	s.mu.Unlock()
+	s.memtableBytes += len(encodedKey)
+	if s.memtableBytes >= s.engine.memtableSize {
+		s.flush()
+	}
+}
+
+func (s *lsmStorage[K, V]) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.generation++
+	for _, t := range s.tables {
+		t.remove()
+	}
+	s.tables = nil
+	s.memtable = make(map[string]memtableEntry[K, V])
+	s.memtableBytes = 0
+	s.count = 0
+}
+
+func (s *lsmStorage[K, V]) len() int {
+	return s.count
+}
+
+func (s *lsmStorage[K, V]) keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for k := range s.all() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// Merges the memtable and every table, skipping removed keys.
+func (s *lsmStorage[K, V]) all() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
+		sources := []iter.Seq[tableRecord]{s.memtableRecords()}
+		for i := len(s.tables) - 1; i >= 0; i-- {
+			sources = append(sources, s.tables[i].records())
+		}
+
+		for record := range mergeRecords(sources) {
+			if record.tombstone {
+				continue
+			}
+
+			var k K
+			var v V
+			if json.Unmarshal(record.key, &k) != nil || json.Unmarshal(record.value, &v) != nil {
+				continue
+			}
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Iterates over the memtable as records, sorted by key.
+func (s *lsmStorage[K, V]) memtableRecords() iter.Seq[tableRecord] {
+	return func(yield func(tableRecord) bool) {
+		for _, key := range slices.Sorted(maps.Keys(s.memtable)) {
+			e := s.memtable[key]
+			if !yield(tableRecord{key: []byte(key), value: e.encoded, tombstone: e.tombstone}) {
+				return
+			}
+		}
+	}
+}
+
+// Writes the memtable to a new SSTable and empties it, then starts compacting
+// the tables in the background if there are too many.
+func (s *lsmStorage[K, V]) flush() error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	path := s.tablePath()
+	s.mu.Unlock()
+
+	t, err := writeTable(path, s.memtableRecords(), len(s.memtable), s.engine.falsePositiveRate)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.tables = append(s.tables, t)
+	s.memtable = make(map[string]memtableEntry[K, V])
+	s.memtableBytes = 0
+	s.startCompaction()
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Starts compacting the tables in the background, if there are too many and
+// they aren't being compacted already. The caller must hold `mu`.
+func (s *lsmStorage[K, V]) startCompaction() {
+	if len(s.tables) <= maxTables || s.compacting {
+		return
+	}
+
+	s.compacting = true
+	s.engine.compactions.Add(1)
+	go s.compact(slices.Clone(s.tables), s.generation)
+}
+
+// Merges tables into one, dropping overwritten values. The inputs are always
+// the oldest tables, so tombstones have nothing left to shadow and are dropped
+// too. If the compaction fails, the tables are left as they are, and it's tried
+// again after the next flush. Tables flushed while it runs are compacted by the
+// next one, which starts straight away if there are too many of them.
+func (s *lsmStorage[K, V]) compact(inputs []*sstable, generation int) {
+	defer s.engine.compactions.Done()
+
+	sources := make([]iter.Seq[tableRecord], 0, len(inputs))
+	count := 0
+	for i := len(inputs) - 1; i >= 0; i-- {
+		sources = append(sources, inputs[i].records())
+		count += inputs[i].count
+	}
+	live := func(yield func(tableRecord) bool) {
+		for record := range mergeRecords(sources) {
+			if !record.tombstone && !yield(record) {
+				return
+			}
+		}
+	}
+
+	s.mu.Lock()
+	path := s.tablePath()
+	s.mu.Unlock()
+
+	merged, err := writeTable(path, live, count, s.engine.falsePositiveRate)
+	for _, t := range inputs {
+		if err == nil {
+			err = t.err
+		}
+	}
+
+	s.mu.Lock()
+	s.compacting = false
+	if err != nil || s.generation != generation {
+		s.mu.Unlock()
+		if merged != nil {
+			merged.remove()
+		}
+		return
+	}
+	s.tables = append([]*sstable{merged}, s.tables[len(inputs):]...)
+	s.startCompaction()
+	s.mu.Unlock()
+
+	for _, t := range inputs {
+		t.remove()
+	}
+}
+
+// Returns the path for a new table. The caller must hold `mu`.
+func (s *lsmStorage[K, V]) tablePath() string {
+	s.nextTable++
+	return filepath.Join(s.dir, fmt.Sprintf("%06d.sst", s.nextTable))
+}
+
+func (s *lsmStorage[K, V]) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.tables {
+		t.file.Close()
+	}
+	s.tables = nil
+}
@@ -0,0 +1,143 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const lsmDir = "./test.lsm"
+
+func TestLSMStorage(t *testing.T) {
+	store, err := NewStore[string, int](LSMStorage(lsmDir), MemtableSize(256))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	// Write enough to flush many tables, and compact them:
+	expected := make(map[string]int)
+	for i := range 1000 {
+		key := fmt.Sprintf("key-%d", i%300)
+		store.Set(key, i)
+		expected[key] = i
+	}
+	for i := 0; i < 300; i += 3 {
+		key := fmt.Sprintf("key-%d", i)
+		store.Unset(key)
+		delete(expected, key)
+	}
+
+	for key, value := range expected {
+		v, found := store.Get(key)
+		assert.True(t, found, key)
+		assert.Equal(t, value, v, key)
+	}
+	_, found := store.Get("key-0")
+	assert.False(t, found)
+	assert.Equal(t, len(expected), store.Len())
+	assert.Equal(t, expected, store.GetAll())
+	assert.Len(t, store.Keys(), len(expected))
+}
+
+func TestLSMStorageCompaction(t *testing.T) {
+	store, _ := NewStore[int, int](LSMStorage(lsmDir), MemtableSize(64))
+	defer store.Close()
+
+	for i := range 500 {
+		store.Set(i%10, i)
+	}
+
+	sh := store.(*kvStore[int, int]).shards[0]
+	lsm := sh.namespaces()[""].values.(*lsmStorage[int, int])
+	eventually(t, func() bool {
+		lsm.mu.RLock()
+		defer lsm.mu.RUnlock()
+		return len(lsm.tables) <= maxTables+1 && !lsm.compacting
+	})
+	for i := range 10 {
+		v, _ := store.Get(i)
+		assert.Equal(t, 490+i, v)
+	}
+}
+
+func TestLSMStorageConcurrentReads(t *testing.T) {
+	store, _ := NewStore[int, int](LSMStorage(lsmDir), MemtableSize(256))
+	defer store.Close()
+
+	// Gets carry on while the update loop writes to the memtable, flushes it
+	// and compacts the tables:
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for range 2 {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				runtime.Gosched()
+				for k := range 100 {
+					if v, found := store.Get(k); found && v%100 != k {
+						assert.Fail(t, "Got another key's value", k)
+					}
+				}
+			}
+		}()
+	}
+	for i := range 1000 {
+		store.Set(i%100, i)
+		if i%5 == 0 {
+			store.Unset((i + 50) % 100)
+		}
+	}
+	close(done)
+	readers.Wait()
+}
+
+func TestLSMStorageRestore(t *testing.T) {
+	store, _ := NewStore[string, string](LSMStorage(lsmDir), MemtableSize(64))
+	defer store.Close()
+	for i := range 100 {
+		store.Set(fmt.Sprint(i), "old")
+	}
+
+	var backup bytes.Buffer
+	other, _ := NewStore[string, string]()
+	other.Set("a", "new")
+	other.Backup(&backup)
+
+	assert.NoError(t, store.Restore(&backup))
+	assert.Equal(t, map[string]string{"a": "new"}, store.GetAll())
+	assert.Equal(t, 1, store.Len())
+}
+
+func TestLSMStorageReplaysLog(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath), LSMStorage(lsmDir), MemtableSize(64))
+	for i := range 100 {
+		store.Set(fmt.Sprint(i), fmt.Sprint(i))
+	}
+	store.Close()
+
+	_, err := os.Stat(lsmDir)
+	assert.True(t, os.IsNotExist(err))
+
+	store, _ = NewStore[string, string](LogPath(logPath), LSMStorage(lsmDir), MemtableSize(64))
+	defer store.Close()
+	assert.Equal(t, 100, store.Len())
+	v, _ := store.Get("42")
+	assert.Equal(t, "42", v)
+}
+
+func TestLSMAndMmapStorage(t *testing.T) {
+	_, err := NewStore[string, string](LSMStorage(lsmDir), MmapStorage(mmapPath))
+	assert.Error(t, err)
+}
@@ -0,0 +1,241 @@
+package kv
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// The longest key the memcached protocol allows.
+const maxMemcachedKey = 250
+
+// The largest value memcached clients can set, unless the store sets one with
+// `MemcachedMaxValueSize`.
+const defaultMemcachedMaxValue = 1 << 20
+
+// Checks that a store's types can be served over the memcached protocol, whose
+// keys are strings. Values can be of any type: strings and byte slices are sent
+// as they are, and anything else as JSON.
+func checkMemcachedTypes[K comparable, V any]() error {
+	if _, ok := any(*new(K)).(string); !ok {
+		return errors.New("Memcached protocol requires string keys")
+	}
+
+	return nil
+}
+
+// Checks that memcached clients can be authenticated, if the store requires
+// clients to. The memcached protocol has no way to send a token, so they must
+// present a certificate that `ServerTLS` verifies.
+func (s *kvStore[K, V]) checkMemcachedAuth() error {
+	if !s.requiresToken() {
+		return nil
+	}
+	if config := s.options.serverTLS; config == nil || config.ClientAuth != tls.RequireAndVerifyClientCert {
+		return errors.New("Cannot serve memcached clients with AuthToken or ACL unless ServerTLS verifies their certificates")
+	}
+
+	return nil
+}
+
+// Accepts connections from memcached clients until the listener is closed.
+func (s *kvStore[K, V]) acceptMemcached() {
+	defer s.background.Done()
+
+	for {
+		conn, err := s.memcached.Accept()
+		if err != nil {
+			return
+		}
+
+		s.background.Add(1)
+		go s.serveMemcached(conn)
+	}
+}
+
+// Answers a memcached client's commands until it disconnects, sends `quit`, or
+// the store is closed.
+func (s *kvStore[K, V]) serveMemcached(conn net.Conn) {
+	defer s.background.Done()
+	defer s.closeWithStore(conn)()
+
+	// Writes are audited as the client's:
+	client := &kvStore[K, V]{core: s.core, namespace: s.namespace, caller: callerOf(conn, "")}
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			fmt.Fprint(w, "ERROR\r\n")
+		} else if fields[0] == "quit" {
+			return
+		} else if err := client.memcachedCommand(fields, r, w); err != nil {
+			return
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Runs a single memcached command and writes its reply. Returns an error only
+// if the connection can't be used any more.
+func (s *kvStore[K, V]) memcachedCommand(fields []string, r *bufio.Reader, w *bufio.Writer) error {
+	// Replies are left out for commands that end with `noreply`:
+	noreply := len(fields) > 1 && fields[len(fields)-1] == "noreply"
+	if noreply {
+		fields = fields[:len(fields)-1]
+	}
+	reply := func(format string, args ...any) {
+		if !noreply {
+			fmt.Fprintf(w, format+"\r\n", args...)
+		}
+	}
+
+	switch fields[0] {
+	case "get":
+		for _, key := range fields[1:] {
+			value, found := s.Get(any(key).(K))
+			if !found {
+				continue
+			}
+			data, err := memcachedValue(value)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "VALUE %s 0 %d\r\n", key, len(data))
+			w.Write(data)
+			w.WriteString("\r\n")
+		}
+		w.WriteString("END\r\n")
+
+	case "set":
+		// set <key> <flags> <exptime> <bytes>
+		if len(fields) != 5 {
+			reply("CLIENT_ERROR bad command line format")
+			return nil
+		}
+		size, err := strconv.Atoi(fields[4])
+		if err != nil || size < 0 {
+			reply("CLIENT_ERROR bad command line format")
+			return nil
+		}
+		// Values that can't be set are skipped without being read into
+		// memory, so the next command is read after them:
+		if len(fields[1]) > maxMemcachedKey {
+			reply("CLIENT_ERROR key is too long")
+			return skipMemcachedData(r, w, size)
+		}
+		if size > s.options.memcachedMaxValue {
+			reply("SERVER_ERROR object too large for cache")
+			return skipMemcachedData(r, w, size)
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		if string(data[size:]) != "\r\n" {
+			reply("CLIENT_ERROR bad data chunk")
+			return nil
+		}
+
+		value, err := parseMemcachedValue[V](data[:size])
+		if err != nil {
+			reply("CLIENT_ERROR %v", err)
+			return nil
+		}
+		if _, err := s.Set(any(fields[1]).(K), value); err != nil {
+			reply("SERVER_ERROR %v", err)
+			return nil
+		}
+		reply("STORED")
+
+	case "delete":
+		if len(fields) != 2 {
+			reply("CLIENT_ERROR bad command line format")
+			return nil
+		}
+		// Whether the key was there is decided by the same update that
+		// deletes it, so only one of the clients deleting it at once is told
+		// it was deleted:
+		_, found, err := s.GetAndDelete(any(fields[1]).(K))
+		if err != nil {
+			reply("SERVER_ERROR %v", err)
+			return nil
+		}
+		if !found {
+			reply("NOT_FOUND")
+			return nil
+		}
+		reply("DELETED")
+
+	case "flush_all":
+		if err := s.Clear(); err != nil {
+			reply("SERVER_ERROR %v", err)
+			return nil
+		}
+		reply("OK")
+
+	case "version":
+		w.WriteString("VERSION kv\r\n")
+
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+
+	return nil
+}
+
+// Sends the reply to a `set` that's refused, then reads past its data block,
+// and the line ending after it. The reply is sent first, so a client that
+// hasn't sent the block yet isn't left waiting for it.
+func skipMemcachedData(r *bufio.Reader, w *bufio.Writer, size int) error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, 2)
+	return err
+}
+
+// Turns a value into the bytes sent to a memcached client.
+func memcachedValue[V any](value V) ([]byte, error) {
+	switch v := any(value).(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	default:
+		return json.Marshal(v)
+	}
+}
+
+// Turns the bytes sent by a memcached client into a value.
+func parseMemcachedValue[V any](data []byte) (V, error) {
+	var value V
+	switch v := any(&value).(type) {
+	case *string:
+		*v = string(data)
+	case *[]byte:
+		*v = append([]byte(nil), data...)
+	default:
+		if err := json.Unmarshal(data, v); err != nil {
+			return value, errors.New("Value doesn't match the store's type")
+		}
+	}
+
+	return value, nil
+}
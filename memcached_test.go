@@ -0,0 +1,193 @@
+package kv
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Starts a store that serves the memcached protocol, and connects to it.
+func memcachedConn[K comparable, V any](t *testing.T, options ...Option) (KVStore[K, V], net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	store, err := NewStore[K, V](append(options, MemcachedListener(listener))...)
+	assert.NoError(t, err)
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+		store.Close()
+	})
+	return store, conn
+}
+
+func TestMemcached(t *testing.T) {
+	store, conn := memcachedConn[string, string](t)
+	r := bufio.NewReader(conn)
+	send := func(command string, replyLines int) string {
+		fmt.Fprint(conn, command)
+		reply := ""
+		for range replyLines {
+			line, err := r.ReadString('\n')
+			assert.NoError(t, err)
+			reply += line
+		}
+		return reply
+	}
+
+	assert.Equal(t, "STORED\r\n", send("set name 0 0 5\r\nralph\r\n", 1))
+	v, _ := store.Get("name")
+	assert.Equal(t, "ralph", v)
+
+	store.Set("food", "pizza")
+	assert.Equal(t, "VALUE name 0 5\r\nralph\r\nVALUE food 0 5\r\npizza\r\nEND\r\n", send("get name missing food\r\n", 5))
+
+	assert.Equal(t, "DELETED\r\n", send("delete name\r\n", 1))
+	assert.Equal(t, "NOT_FOUND\r\n", send("delete name\r\n", 1))
+	assert.Equal(t, "END\r\n", send("get name\r\n", 1))
+
+	// Commands with `noreply` have no reply:
+	assert.Equal(t, "OK\r\n", send("set name 0 0 1 noreply\r\nx\r\nflush_all\r\n", 1))
+	assert.Equal(t, 0, store.Len())
+
+	assert.Equal(t, "CLIENT_ERROR bad data chunk\r\n", send("set name 0 0 1\r\nxy\r\n", 1))
+	assert.Equal(t, "ERROR\r\n", send("frobnicate\r\n", 1))
+}
+
+func TestMemcachedValueSize(t *testing.T) {
+	store, conn := memcachedConn[string, string](t, MemcachedMaxValueSize(8))
+	r := bufio.NewReader(conn)
+	send := func(command string) string {
+		fmt.Fprint(conn, command)
+		reply, err := r.ReadString('\n')
+		assert.NoError(t, err)
+		return reply
+	}
+
+	// Refused values are skipped, and the next command is read after them:
+	assert.Equal(t, "SERVER_ERROR object too large for cache\r\n", send("set name 0 0 9\r\nralphralp\r\n"))
+	assert.Equal(t, "CLIENT_ERROR key is too long\r\n", send("set "+strings.Repeat("k", 251)+" 0 0 1\r\nx\r\n"))
+	assert.Equal(t, "STORED\r\n", send("set name 0 0 8\r\nralphral\r\n"))
+	v, _ := store.Get("name")
+	assert.Equal(t, "ralphral", v)
+	assert.Equal(t, 1, store.Len())
+
+	// Sizes too large to allocate are refused before any data is sent:
+	assert.Equal(t, "SERVER_ERROR object too large for cache\r\n", send("set name 0 0 99999999999999999\r\n"))
+}
+
+func TestMemcachedConcurrentDeletes(t *testing.T) {
+	store, conn := memcachedConn[string, string](t, QueueSize(10))
+	store.Set("name", "ralph")
+
+	// Holds the update loop paused until every client's delete is queued:
+	s := store.(*kvStore[string, string])
+	paused, release := make(chan struct{}), make(chan struct{})
+	go s.exclusive(func() {
+		close(paused)
+		<-release
+	})
+	<-paused
+
+	// Only one of the clients deleting a key at once deletes it:
+	replies := make(chan string)
+	for range 10 {
+		c, err := net.Dial("tcp", conn.RemoteAddr().String())
+		assert.NoError(t, err)
+		defer c.Close()
+		go func() {
+			fmt.Fprint(c, "delete name\r\n")
+			reply, _ := bufio.NewReader(c).ReadString('\n')
+			replies <- reply
+		}()
+	}
+	assert.Eventually(t, func() bool { return len(s.shards[0].updates) == 10 }, time.Second, time.Millisecond)
+	close(release)
+
+	deleted := 0
+	for range 10 {
+		if <-replies == "DELETED\r\n" {
+			deleted++
+		}
+	}
+	assert.Equal(t, 1, deleted)
+}
+
+func TestMemcachedDeleteWithLoader(t *testing.T) {
+	var calls atomic.Int32
+	store, conn := memcachedConn[string, string](t, Loader(func(key string) (string, error) {
+		calls.Add(1)
+		return "loaded", nil
+	}))
+	r := bufio.NewReader(conn)
+
+	// Deleting a missing key doesn't load it first:
+	fmt.Fprint(conn, "delete name\r\n")
+	reply, err := r.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "NOT_FOUND\r\n", reply)
+	assert.Equal(t, int32(0), calls.Load())
+	assert.Equal(t, 0, store.Len())
+}
+
+func TestMemcachedAuth(t *testing.T) {
+	open := func(options ...Option) (KVStore[string, string], error) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		t.Cleanup(func() { listener.Close() })
+		return NewStore[string, string](append(options, MemcachedListener(listener))...)
+	}
+
+	// Memcached clients can't present a token, so they must be authenticated
+	// by their certificates:
+	_, err := open(AuthToken("secret"))
+	assert.Error(t, err)
+	_, err = open(ACL(map[string][]Grant{"team": {{Namespace: "*", Access: AccessRead}}}))
+	assert.Error(t, err)
+	_, err = open(AuthToken("secret"), ServerTLS(&tls.Config{ClientAuth: tls.VerifyClientCertIfGiven}))
+	assert.Error(t, err)
+
+	store, err := open(AuthToken("secret"), ServerTLS(&tls.Config{ClientAuth: tls.RequireAndVerifyClientCert}))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	// A client without a certificate can't set anything:
+	conn, err := net.Dial("tcp", store.(*kvStore[string, string]).memcached.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	fmt.Fprint(conn, "set name 0 0 5\r\nralph\r\n")
+	reply, _ := bufio.NewReader(conn).ReadString('\n')
+	assert.NotEqual(t, "STORED\r\n", reply)
+	assert.Equal(t, 0, store.Len())
+}
+
+func TestMemcachedJSONValues(t *testing.T) {
+	store, conn := memcachedConn[string, []int](t)
+	r := bufio.NewReader(conn)
+
+	fmt.Fprint(conn, "set numbers 0 0 7\r\n[1,2,3]\r\n")
+	line, _ := r.ReadString('\n')
+	assert.Equal(t, "STORED\r\n", line)
+	v, _ := store.Get("numbers")
+	assert.Equal(t, []int{1, 2, 3}, v)
+
+	fmt.Fprint(conn, "set numbers 0 0 3\r\nabc\r\n")
+	line, _ = r.ReadString('\n')
+	assert.Equal(t, "CLIENT_ERROR Value doesn't match the store's type\r\n", line)
+}
+
+func TestMemcachedKeyType(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+
+	_, err := NewStore[int, string](MemcachedListener(listener))
+	assert.Error(t, err)
+}
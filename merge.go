@@ -0,0 +1,152 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"maps"
+	"time"
+)
+
+// A key's value, or its removal, and when it was written, as `Merge` compares
+// them.
+type Version[V any] struct {
+	Value V `json:",omitzero"`
+	// Whether the key was unset, rather than set.
+	Deleted bool `json:",omitzero"`
+	// The hybrid logical clock time of the write, or 0 if the store doesn't use
+	// `LastWriterWins`.
+	Timestamp uint64
+	// The version's vector clock, if the store uses `VectorClocks`.
+	Clock VectorClock `json:",omitzero"`
+}
+
+// Reports whether a version wins over another. The one written later wins;
+// two versions written at the same time are told apart by preferring removals,
+// then the larger JSON encoding of their values, so every store picks the same
+// one.
+func (v Version[V]) newerThan(other Version[V]) bool {
+	switch {
+	case v.Timestamp != other.Timestamp:
+		return v.Timestamp > other.Timestamp
+	case v.Deleted || other.Deleted:
+		return v.Deleted && !other.Deleted
+	default:
+		mine, _ := json.Marshal(v.Value)
+		theirs, _ := json.Marshal(other.Value)
+		return bytes.Compare(mine, theirs) > 0
+	}
+}
+
+// A hybrid logical clock. Its timestamps hold the wall clock time, in
+// milliseconds, in their high 48 bits, and a counter in their low 16, so they
+// stay close to real time, but always increase, even if the wall clock goes
+// backwards, and are later than any timestamp the store has seen from other
+// stores. Guarded by `commit`.
+type hybridClock struct {
+	last uint64
+}
+
+// Gets a new timestamp, later than every one before it.
+func (c *hybridClock) now() uint64 {
+	c.last = max(c.last+1, uint64(time.Now().UnixMilli())<<16)
+	return c.last
+}
+
+// Moves the clock forward to a timestamp from another store, or from the log.
+func (c *hybridClock) observe(timestamp uint64) {
+	c.last = max(c.last, timestamp)
+}
+
+// Records when a key was last set or unset by an update, and its vector clock,
+// for stores that use `LastWriterWins`. Unset keys keep their timestamps, as
+// tombstones, so merging an older version of them doesn't bring them back. The
+// caller must hold `commit`.
+func (s *kvStore[K, V]) stamp(k namespacedKey[K], u update[K, V]) {
+	if !s.options.lastWriterWins {
+		return
+	}
+
+	s.timestamps[k] = u.Timestamp
+	if clock := u.nextClock(s.clocks[k]); clock != nil {
+		s.clocks[k] = clock
+	}
+}
+
+// Decides which version of a key a `merge` leaves the store with. If the store
+// uses `VectorClocks`, a version written after the other wins, and versions
+// written concurrently are resolved like they are without them, by
+// `ResolveConflicts` or by the last write, and then have seen both. Reports
+// whether the winner differs from the store's own version.
+func (s *kvStore[K, V]) resolveMerge(key K, ours, theirs Version[V]) (Version[V], bool) {
+	if s.options.node == "" {
+		return s.hooks.resolveMerge(key, ours, theirs)
+	}
+
+	switch {
+	case maps.Equal(ours.Clock, theirs.Clock) || theirs.Clock.Before(ours.Clock):
+		return ours, false
+	case ours.Clock.Before(theirs.Clock):
+		return theirs, true
+	default:
+		winner, _ := s.hooks.resolveMerge(key, ours, theirs)
+		winner.Clock = ours.Clock.merged(theirs.Clock)
+		return winner, true
+	}
+}
+
+func (s *kvStore[K, V]) Versions() map[K]Version[V] {
+	versions := make(map[K]Version[V])
+	s.exclusive(func() {
+		s.commit.Lock()
+		defer s.commit.Unlock()
+
+		for _, sh := range s.shards {
+			for k, v := range sh.lookup(s.namespace).values.all() {
+				nk := namespacedKey[K]{s.namespace, k}
+				versions[k] = Version[V]{Value: v, Timestamp: s.timestamps[nk], Clock: maps.Clone(s.clocks[nk])}
+			}
+		}
+		for k, timestamp := range s.timestamps {
+			if _, found := versions[k.key]; k.namespace == s.namespace && !found {
+				versions[k.key] = Version[V]{Deleted: true, Timestamp: timestamp, Clock: maps.Clone(s.clocks[k])}
+			}
+		}
+	})
+
+	return versions
+}
+
+func (s *kvStore[K, V]) Merge(other KVStore[K, V]) error {
+	return s.mergeVersions(other.Versions())
+}
+
+// Merges versions of keys from another store, keeping whichever version of
+// each key was written last.
+func (s *kvStore[K, V]) mergeVersions(versions map[K]Version[V]) error {
+	if !s.options.lastWriterWins {
+		return errors.New("Cannot merge, store doesn't use LastWriterWins")
+	}
+
+	// Queue every version before waiting for any of them, so they're applied in
+	// batches. Each result is buffered, so a shard doesn't wait for it to be read:
+	var waits []func() updateResult[V]
+	for k, version := range versions {
+		u := s.newUpdate(merge, k, version.Value)
+		u.Deleted, u.Timestamp, u.Clock = version.Deleted, version.Timestamp, version.Clock
+		wait, err := s.startWrite(u)
+		if err != nil {
+			return err
+		}
+		waits = append(waits, wait)
+	}
+
+	var err error
+	for _, wait := range waits {
+		if result := wait(); result.err != nil && err == nil {
+			err = result.err
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,107 @@
+package kv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+	east, _ := NewStore[string, string](LastWriterWins())
+	defer east.Close()
+	west, _ := NewStore[string, string](LastWriterWins())
+	defer west.Close()
+
+	// Both stores take writes independently. The clocks only order writes
+	// that are at least a millisecond apart:
+	east.Set("name", "ralph")
+	east.Set("food", "pizza")
+	time.Sleep(2 * time.Millisecond)
+	west.Set("name", "ziggy")
+	west.Set("color", "green")
+	east.Unset("food")
+	time.Sleep(2 * time.Millisecond)
+	west.Set("food", "tacos")
+
+	assert.NoError(t, east.Merge(west))
+	assert.NoError(t, west.Merge(east))
+
+	// They converge on the last write to each key:
+	assert.Equal(t, map[string]string{"name": "ziggy", "color": "green", "food": "tacos"}, east.GetAll())
+	assert.Equal(t, east.GetAll(), west.GetAll())
+
+	// Including removals, which aren't undone by older versions:
+	time.Sleep(2 * time.Millisecond)
+	east.Unset("color")
+	assert.NoError(t, east.Merge(west))
+	assert.NoError(t, west.Merge(east))
+	_, found := west.Get("color")
+	assert.False(t, found)
+	assert.True(t, east.Versions()["color"].Deleted)
+}
+
+func TestMergeReplay(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath), LastWriterWins())
+	store.Set("name", "ralph")
+	store.Unset("food")
+	before := store.Versions()
+	assert.NoError(t, store.Compact())
+	store.Close()
+
+	// Timestamps, and tombstones, are kept in the log:
+	replayed, _ := NewStore[string, string](LogPath(logPath), LastWriterWins())
+	defer replayed.Close()
+	assert.Equal(t, before, replayed.Versions())
+
+	// And the clock carries on from them:
+	replayed.Set("name", "ziggy")
+	assert.Greater(t, replayed.Versions()["name"].Timestamp, before["name"].Timestamp)
+}
+
+func TestMergeRequiresClock(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	defer store.Close()
+	other, _ := NewStore[string, string](LastWriterWins())
+	defer other.Close()
+
+	assert.Error(t, store.Merge(other))
+}
+
+func TestVersionNewerThan(t *testing.T) {
+	assert.True(t, Version[string]{Value: "a", Timestamp: 2}.newerThan(Version[string]{Value: "b", Timestamp: 1}))
+	assert.False(t, Version[string]{Value: "b", Timestamp: 1}.newerThan(Version[string]{Value: "a", Timestamp: 2}))
+
+	// Ties are broken the same way by both sides:
+	assert.True(t, Version[string]{Deleted: true, Timestamp: 1}.newerThan(Version[string]{Value: "a", Timestamp: 1}))
+	assert.True(t, Version[string]{Value: "b", Timestamp: 1}.newerThan(Version[string]{Value: "a", Timestamp: 1}))
+	assert.False(t, Version[string]{Value: "a", Timestamp: 1}.newerThan(Version[string]{Value: "b", Timestamp: 1}))
+}
+
+func TestResolveConflicts(t *testing.T) {
+	largest := ResolveConflicts(func(key string, local, remote int) int { return max(local, remote) })
+	east, _ := NewStore[string, int](LastWriterWins(), largest)
+	defer east.Close()
+	west, _ := NewStore[string, int](LastWriterWins(), largest)
+	defer west.Close()
+
+	east.Set("visits", 5)
+	east.Set("likes", 1)
+	time.Sleep(2 * time.Millisecond)
+	west.Set("visits", 3)
+	west.Unset("likes")
+
+	assert.NoError(t, east.Merge(west))
+	assert.NoError(t, west.Merge(east))
+
+	// Values set on both sides are resolved, and removals are still merged by
+	// the last write:
+	assert.Equal(t, map[string]int{"visits": 5}, east.GetAll())
+	assert.Equal(t, east.GetAll(), west.GetAll())
+	assert.Equal(t, east.Versions(), west.Versions())
+
+	_, err := NewStore[string, string](ResolveConflicts(func(key string, local, remote int) int { return local }))
+	assert.Error(t, err)
+}
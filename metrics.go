@@ -0,0 +1,201 @@
+package kv
+
+import (
+	"maps"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// The upper bounds of the buckets that operations' latencies are counted in.
+var latencyBuckets = []time.Duration{
+	time.Microsecond, 2500 * time.Nanosecond, 5 * time.Microsecond,
+	10 * time.Microsecond, 25 * time.Microsecond, 50 * time.Microsecond,
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second,
+}
+
+// The names operations are counted under in `Stats`, by the type of update
+// they make. Updates that callers don't make directly aren't counted.
+var operationNames = map[updateType]string{
+	set:               "set",
+	unset:             "unset",
+	compareAndSwap:    "compare_and_swap",
+	unsetIf:           "unset_if",
+	rename:            "rename",
+	renameIfNotExists: "rename_if_not_exists",
+	setIfNotExists:    "set_if_not_exists",
+	setMany:           "set_many",
+	transaction:       "transaction",
+	replaceAll:        "replace_all",
+	grantLease:        "grant_lease",
+	revokeLease:       "revoke_lease",
+	merge:             "merge",
+	increment:         "increment",
+	decrement:         "decrement",
+	modify:            "update",
+	getAndDelete:      "get_and_delete",
+	getAndSet:         "get_and_set",
+	appendValue:       "append",
+	pushFront:         "lpush",
+	pushBack:          "rpush",
+	popFront:          "lpop",
+	addMembers:        "sadd",
+	removeMembers:     "srem",
+	addScored:         "zadd",
+	setFields:         "hset",
+	deleteFields:      "hdel",
+}
+
+// Counts the operations made on a store, and how long they took. Each
+// operation's metrics are only ever added to, so they're updated without
+// locks.
+type metrics struct {
+	operations map[string]*operationMetrics
+	// Gets of keys that weren't in the store.
+	misses atomic.Uint64
+	// Updates waiting to be received by a shard's update loop.
+	queued atomic.Int64
+	// Updates that found their shard's update queue full.
+	queueFull atomic.Uint64
+}
+
+type operationMetrics struct {
+	// How long operations took, from the moment they were called until they
+	// returned.
+	latency *histogram
+	// How long the updates of writes took in each of the phases of being
+	// written. Nil for reads.
+	phases []*histogram
+}
+
+// The phases of writing an update that are timed.
+type writePhase int
+
+const (
+	// Waiting for its shard's update loop to start applying it, in its queue
+	// or in a batch that's being collected.
+	queueWait writePhase = iota
+	// Waiting for its batch to be appended to the write-ahead log.
+	appendPhase
+	// Being applied to the store, once it's logged.
+	applyPhase
+	writePhases
+)
+
+// Counts observations of durations in `latencyBuckets`.
+type histogram struct {
+	count atomic.Uint64
+	// The number of observations in each of `latencyBuckets`, and a last
+	// bucket for slower ones.
+	buckets []atomic.Uint64
+	// The total of every observation.
+	nanoseconds atomic.Int64
+}
+
+func newHistogram() *histogram {
+	return &histogram{buckets: make([]atomic.Uint64, len(latencyBuckets)+1)}
+}
+
+func newMetrics() *metrics {
+	m := &metrics{operations: make(map[string]*operationMetrics)}
+	for _, name := range slices.Collect(maps.Values(operationNames)) {
+		op := &operationMetrics{latency: newHistogram()}
+		for range writePhases {
+			op.phases = append(op.phases, newHistogram())
+		}
+		m.operations[name] = op
+	}
+	m.operations["get"] = &operationMetrics{latency: newHistogram()}
+
+	return m
+}
+
+// Counts an operation that took `latency`.
+func (m *metrics) observe(operation string, latency time.Duration) {
+	if op, found := m.operations[operation]; found {
+		op.latency.observe(latency)
+	}
+}
+
+// Counts a write's update that spent `took` in one of the phases of being
+// written.
+func (m *metrics) observePhase(operation string, phase writePhase, took time.Duration) {
+	if op, found := m.operations[operation]; found && op.phases != nil {
+		op.phases[phase].observe(took)
+	}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	bucket := len(latencyBuckets)
+	for i, bound := range latencyBuckets {
+		if d <= bound {
+			bucket = i
+			break
+		}
+	}
+	h.count.Add(1)
+	h.buckets[bucket].Add(1)
+	h.nanoseconds.Add(int64(d))
+}
+
+// Statistics about one kind of operation.
+type OperationStats struct {
+	// How many of them have been made since the store opened.
+	Count uint64
+	// How long they took, from the moment they were called until they
+	// returned.
+	Latency Histogram
+	// For writes, how long their updates waited for their shard's update loop
+	// to start applying them, in its queue or in a batch being collected.
+	QueueWait Histogram
+	// For writes, how long their updates waited for their batch to be
+	// appended to the write-ahead log. Empty if the store has no log.
+	Append Histogram
+	// For writes, how long their updates took to apply to the store once
+	// they were logged.
+	Apply Histogram
+}
+
+// How many observations fell into each of a set of buckets.
+type Histogram struct {
+	// The number of observations at or below each bucket's upper bound, so
+	// each bucket counts every observation in the buckets before it too, like
+	// a Prometheus histogram. Observations greater than the last bound are
+	// only counted in `Count`.
+	Buckets []HistogramBucket
+	Count   uint64
+	Sum     time.Duration
+}
+
+type HistogramBucket struct {
+	UpperBound time.Duration
+	Count      uint64
+}
+
+// Summarizes an operation's metrics as they are now.
+func (op *operationMetrics) stats() OperationStats {
+	stats := OperationStats{Count: op.latency.count.Load(), Latency: op.latency.stats()}
+	if op.phases != nil {
+		stats.QueueWait = op.phases[queueWait].stats()
+		stats.Append = op.phases[appendPhase].stats()
+		stats.Apply = op.phases[applyPhase].stats()
+	}
+
+	return stats
+}
+
+func (h *histogram) stats() Histogram {
+	stats := Histogram{Sum: time.Duration(h.nanoseconds.Load())}
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		cumulative += h.buckets[i].Load()
+		stats.Buckets = append(stats.Buckets, HistogramBucket{bound, cumulative})
+	}
+	stats.Count = cumulative + h.buckets[len(latencyBuckets)].Load()
+
+	return stats
+}
@@ -0,0 +1,214 @@
+package kv
+
+import (
+	"encoding/json"
+	"iter"
+	"maps"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// The size, in bytes, that a value file is first mapped at. Once it's full, the
+// mapping doubles in size.
+const initialMapSize = 1 << 20
+
+// The location of a value in a `valueFile`.
+type valueRef struct {
+	offset int
+	length int
+}
+
+// A file of encoded values, mapped into memory. Values are only ever appended,
+// so an overwritten value's space isn't reused until the store is reopened.
+type valueFile struct {
+	path string
+	file *os.File
+	// The latest mapping of the file, which covers every value written so far.
+	// It's swapped for a bigger one as the file grows, while reads may be using
+	// it, so it's accessed atomically.
+	mapped atomic.Pointer[[]byte]
+	// Every mapping of the file made so far. Older mappings stay mapped until
+	// the file is closed, since reads that loaded them may still be running.
+	mappings [][]byte
+	// The number of bytes written to the file.
+	size int
+	// Guards `mappings`, `size` and growing the file.
+	mu sync.Mutex
+}
+
+// Creates the file at `path`, or empties it if it already exists, and maps it
+// into memory.
+func openValueFile(path string) (*valueFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &valueFile{path: path, file: file}
+	if err := f.grow(initialMapSize); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Extends the file to `capacity` bytes, and maps the whole of it.
+func (f *valueFile) grow(capacity int) error {
+	if err := f.file.Truncate(int64(capacity)); err != nil {
+		return err
+	}
+
+	mapped, err := mapFile(f.file, capacity)
+	if err != nil {
+		return err
+	}
+
+	f.mappings = append(f.mappings, mapped)
+	f.mapped.Store(&mapped)
+	return nil
+}
+
+// Appends a value to the file, growing it if needed. Shards write to it in
+// parallel, so writes are serialized by `mu`.
+func (f *valueFile) write(value []byte) (valueRef, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	mapped := *f.mapped.Load()
+	if f.size+len(value) > len(mapped) {
+		capacity := len(mapped) * 2
+		for capacity < f.size+len(value) {
+			capacity *= 2
+		}
+		if err := f.grow(capacity); err != nil {
+			return valueRef{}, err
+		}
+		mapped = *f.mapped.Load()
+	}
+
+	copy(mapped[f.size:], value)
+	ref := valueRef{offset: f.size, length: len(value)}
+	f.size += len(value)
+	return ref, nil
+}
+
+// Returns the encoded value at `ref`, without copying it.
+func (f *valueFile) read(ref valueRef) []byte {
+	mapped := *f.mapped.Load()
+	return mapped[ref.offset : ref.offset+ref.length]
+}
+
+// Unmaps the file, then closes and removes it.
+func (f *valueFile) close() error {
+	var err error
+	for _, mapped := range f.mappings {
+		if unmapErr := unmapFile(mapped); err == nil {
+			err = unmapErr
+		}
+	}
+	f.mappings = nil
+
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	if removeErr := os.Remove(f.path); err == nil {
+		err = removeErr
+	}
+
+	return err
+}
+
+// Keeps a bucket's values in a `valueFile`, as JSON, and only their keys and
+// locations in memory.
+type fileStorage[K comparable, V any] struct {
+	file *valueFile
+	refs map[K]valueRef
+	// Rules out keys that were never put, before looking them up. Nil if the
+	// store has no Bloom filter.
+	filter *bloomFilter[K]
+	// Guards `refs` and `filter`, which `Get` reads while the update loop
+	// writes to them.
+	mu sync.RWMutex
+}
+
+func newFileStorage[K comparable, V any](file *valueFile, filter *bloomFilter[K]) *fileStorage[K, V] {
+	return &fileStorage[K, V]{file: file, refs: make(map[K]valueRef), filter: filter}
+}
+
+// Decodes the value for a key. Values are only ever decoded from JSON that
+// `put` encoded, so decoding them doesn't fail.
+func (s *fileStorage[K, V]) get(key K) (value V, found bool) {
+	s.mu.RLock()
+	if s.filter != nil && !s.filter.mayContain(key) {
+		s.mu.RUnlock()
+		return value, false
+	}
+	ref, found := s.refs[key]
+	s.mu.RUnlock()
+	if !found {
+		return value, false
+	}
+
+	json.Unmarshal(s.file.read(ref), &value)
+	return value, true
+}
+
+func (s *fileStorage[K, V]) put(key K, value V) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	ref, err := s.file.write(encoded)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs[key] = ref
+	if s.filter != nil {
+		s.filter.add(key)
+	}
+	return nil
+}
+
+func (s *fileStorage[K, V]) remove(key K) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.refs, key)
+}
+
+func (s *fileStorage[K, V]) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.refs)
+	if s.filter != nil {
+		s.filter.reset()
+	}
+}
+
+func (s *fileStorage[K, V]) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.refs)
+}
+
+// Only called by the update loop, or while it's paused, so it doesn't need
+// `mu`, and nor does `all`.
+func (s *fileStorage[K, V]) keys() iter.Seq[K] {
+	return maps.Keys(s.refs)
+}
+
+func (s *fileStorage[K, V]) all() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for k := range s.refs {
+			v, _ := s.get(k)
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package kv
+
+import (
+	"errors"
+	"os"
+)
+
+func mapFile(file *os.File, size int) ([]byte, error) {
+	return nil, errors.New("Memory-mapped storage isn't supported on this platform")
+}
+
+func unmapFile(mapped []byte) error {
+	return nil
+}
@@ -0,0 +1,106 @@
+package kv
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const mmapPath = "./test.values"
+
+func TestMmapStorage(t *testing.T) {
+	store, err := NewStore[string, string](MmapStorage(mmapPath), Shards(4))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	store.Set("name", "Toby")
+	store.Set("food", "pizza")
+	store.Set("name", "Ralph")
+	store.Unset("food")
+
+	v, found := store.Get("name")
+	assert.True(t, found)
+	assert.Equal(t, "Ralph", v)
+	_, found = store.Get("food")
+	assert.False(t, found)
+	assert.Equal(t, map[string]string{"name": "Ralph"}, store.GetAll())
+	assert.Equal(t, 1, store.Len())
+}
+
+func TestMmapStorageGrows(t *testing.T) {
+	store, _ := NewStore[int, string](MmapStorage(mmapPath))
+	defer store.Close()
+
+	// Write more than the initial mapping holds:
+	value := strings.Repeat("x", 4096)
+	for i := 0; i < 2*initialMapSize/len(value); i++ {
+		store.Set(i, fmt.Sprint(i, value))
+	}
+
+	v, _ := store.Get(0)
+	assert.Equal(t, fmt.Sprint(0, value), v)
+	v, _ = store.Get(511)
+	assert.Equal(t, fmt.Sprint(511, value), v)
+}
+
+func TestMmapStorageReplaysLog(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, pet](LogPath(logPath), MmapStorage(mmapPath))
+	store.Set("toby", pet{"Toby", "dog"})
+	store.Close()
+
+	// The value file is removed when the store closes:
+	_, err := os.Stat(mmapPath)
+	assert.True(t, os.IsNotExist(err))
+
+	store, _ = NewStore[string, pet](LogPath(logPath), MmapStorage(mmapPath))
+	defer store.Close()
+	v, _ := store.Get("toby")
+	assert.Equal(t, pet{"Toby", "dog"}, v)
+
+	assert.NoError(t, store.Index("species", func(p pet) string { return p.Species }))
+	dogs, _ := store.GetByIndex("species", "dog")
+	assert.Equal(t, map[string]pet{"toby": {"Toby", "dog"}}, dogs)
+}
+
+func TestMmapStorageConcurrentReads(t *testing.T) {
+	store, _ := NewStore[int, int](MmapStorage(mmapPath), BloomFilter(1000, 0.01))
+	defer store.Close()
+
+	// Gets carry on while the update loop sets and unsets keys:
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for range 2 {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				runtime.Gosched()
+				for k := range 100 {
+					if v, found := store.Get(k); found && v%100 != k {
+						assert.Fail(t, "Got another key's value", k)
+					}
+				}
+			}
+		}()
+	}
+	for i := range 1000 {
+		store.Set(i%100, i)
+		if i%5 == 0 {
+			store.Unset((i + 50) % 100)
+		}
+	}
+	close(done)
+	readers.Wait()
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package kv
+
+import (
+	"os"
+	"syscall"
+)
+
+// Maps the first `size` bytes of a file into memory, shared with the file so
+// writes to the mapping are written to it.
+func mapFile(file *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+}
+
+func unmapFile(mapped []byte) error {
+	return syscall.Munmap(mapped)
+}
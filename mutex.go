@@ -0,0 +1,39 @@
+package kv
+
+import "time"
+
+// How long `Lock` waits before trying again to take a lock that's held.
+const tryLockInterval = 10 * time.Millisecond
+
+func (s *kvStore[K, V]) TryLock(key K, owner V, ttl time.Duration) (lease LeaseID, locked bool, err error) {
+	if ttl <= 0 {
+		return 0, false, errLeaseTTL
+	}
+
+	// Setting the key and granting its lease are a single update, so the lock
+	// can't be left without a lease to expire it:
+	u := s.newUpdate(setIfNotExists, key, owner)
+	u.TTL = ttl
+	result := s.write(u)
+	if !result.ok {
+		return 0, false, result.err
+	}
+
+	return LeaseID(result.revision), true, nil
+}
+
+func (s *kvStore[K, V]) Lock(key K, owner V, ttl time.Duration) (lease LeaseID, err error) {
+	for {
+		lease, locked, err := s.TryLock(key, owner, ttl)
+		if err != nil || locked {
+			return lease, err
+		}
+		if !s.sleep(tryLockInterval) {
+			return 0, ErrClosed
+		}
+	}
+}
+
+func (s *kvStore[K, V]) Unlock(lease LeaseID) error {
+	return s.RevokeLease(lease)
+}
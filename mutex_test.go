@@ -0,0 +1,79 @@
+package kv
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTryLock(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	defer store.Close()
+
+	lease, locked, err := store.TryLock("locks/jobs", "worker-1", time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, locked)
+	_, locked, _ = store.TryLock("locks/jobs", "worker-2", time.Hour)
+	assert.False(t, locked)
+	owner, _ := store.Get("locks/jobs")
+	assert.Equal(t, "worker-1", owner)
+
+	assert.NoError(t, store.Unlock(lease))
+	assert.ErrorIs(t, store.Unlock(lease), ErrNoLease)
+	_, locked, _ = store.TryLock("locks/jobs", "worker-2", time.Hour)
+	assert.True(t, locked)
+}
+
+func TestLockExcludes(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	defer store.Close()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	holders, most := 0, 0
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lease, err := store.Lock("locks/jobs", "worker", time.Hour)
+			assert.NoError(t, err)
+
+			mu.Lock()
+			holders++
+			most = max(most, holders)
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			holders--
+			mu.Unlock()
+
+			assert.NoError(t, store.Unlock(lease))
+		}()
+	}
+	wg.Wait()
+
+	// Only one goroutine held the lock at a time:
+	assert.Equal(t, 1, most)
+}
+
+func TestLockExpires(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath))
+	store.TryLock("locks/jobs", "worker-1", 50*time.Millisecond)
+
+	// A lock whose holder stops keeping it alive is released:
+	lease, err := store.Lock("locks/jobs", "worker-2", time.Hour)
+	assert.NoError(t, err)
+	store.Close()
+
+	// Locks are replayed from the log, with their leases:
+	replayed, _ := NewStore[string, string](LogPath(logPath))
+	defer replayed.Close()
+	owner, _ := replayed.Get("locks/jobs")
+	assert.Equal(t, "worker-2", owner)
+	assert.NoError(t, replayed.Unlock(lease))
+	assert.Equal(t, 0, replayed.Len())
+}
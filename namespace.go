@@ -0,0 +1,11 @@
+package kv
+
+// A key within a namespace.
+type namespacedKey[K comparable] struct {
+	namespace string
+	key       K
+}
+
+func (s *kvStore[K, V]) Namespace(name string) KVStore[K, V] {
+	return &kvStore[K, V]{core: s.core, namespace: name, caller: s.caller}
+}
@@ -0,0 +1,118 @@
+package kv
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaces(t *testing.T) {
+	store, _ := NewStore[string, string](Shards(4))
+	users := store.Namespace("users")
+	pets := store.Namespace("pets")
+
+	store.Set("name", "root")
+	users.Set("name", "Alice")
+	pets.Set("name", "Toby")
+	pets.Set("species", "dog")
+
+	// The same key holds a different value in each namespace:
+	v, _ := store.Get("name")
+	assert.Equal(t, "root", v)
+	v, _ = users.Get("name")
+	assert.Equal(t, "Alice", v)
+	v, _ = pets.Get("name")
+	assert.Equal(t, "Toby", v)
+
+	_, found := users.Get("species")
+	assert.False(t, found)
+	assert.Equal(t, 2, pets.Len())
+	assert.Equal(t, map[string]string{"name": "Alice"}, users.GetAll())
+	assert.Equal(t, map[string]string{}, store.Namespace("empty").GetAll())
+
+	// Views of the same namespace share its data:
+	v, _ = store.Namespace("users").Get("name")
+	assert.Equal(t, "Alice", v)
+	v, _ = users.Namespace("").Get("name")
+	assert.Equal(t, "root", v)
+
+	pets.Unset("name")
+	v, _ = store.Get("name")
+	assert.Equal(t, "root", v)
+}
+
+func TestNamespaceRestore(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	pets := store.Namespace("pets")
+	store.Set("a", "a")
+	pets.Set("b", "b")
+
+	var backup bytes.Buffer
+	assert.NoError(t, pets.Backup(&backup))
+	pets.Set("c", "c")
+
+	// Restoring a namespace leaves the others alone:
+	assert.NoError(t, pets.Restore(&backup))
+	assert.Equal(t, map[string]string{"b": "b"}, pets.GetAll())
+	assert.Equal(t, map[string]string{"a": "a"}, store.GetAll())
+}
+
+func TestNamespaceIndexes(t *testing.T) {
+	store, _ := NewStore[string, pet]()
+	pets := store.Namespace("pets")
+	store.Set("toby", pet{"Toby", "dog"})
+	pets.Set("rex", pet{"Rex", "dog"})
+
+	assert.NoError(t, pets.Index("species", func(p pet) string { return p.Species }))
+	dogs, err := pets.GetByIndex("species", "dog")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]pet{"rex": {"Rex", "dog"}}, dogs)
+
+	_, err = store.GetByIndex("species", "dog")
+	assert.ErrorIs(t, err, ErrNoIndex)
+}
+
+func TestNamespacesShareLog(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath))
+	store.Set("name", "root")
+	store.Namespace("users").Set("name", "Alice")
+	store.Close()
+
+	store, _ = NewStore[string, string](LogPath(logPath))
+	v, _ := store.Get("name")
+	assert.Equal(t, "root", v)
+	v, _ = store.Namespace("users").Get("name")
+	assert.Equal(t, "Alice", v)
+
+	// Compaction keeps every namespace:
+	assert.NoError(t, store.Compact())
+	store.Close()
+
+	store, _ = NewStore[string, string](LogPath(logPath))
+	defer store.Close()
+	v, _ = store.Namespace("users").Get("name")
+	assert.Equal(t, "Alice", v)
+}
+
+func TestNamespaceReplication(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	leader, _ := NewStore[string, string](ReplicationListener(listener))
+	defer leader.Close()
+	leader.Namespace("users").Set("a", "a")
+
+	follower, err := NewStore[string, string](FollowerOf(listener.Addr().String()))
+	assert.NoError(t, err)
+	defer follower.Close()
+
+	leader.Namespace("users").Set("b", "b")
+	eventually(t, func() bool {
+		return len(follower.Namespace("users").GetAll()) == 2
+	})
+	assert.Equal(t, 0, follower.Len())
+}
@@ -0,0 +1,106 @@
+package kv
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Publishes changes to a NATS subject, as JSON, using the NATS client protocol.
+// It connects when it's first used, and reconnects after a failed publish.
+type natsPublisher[K comparable, V any] struct {
+	addr    string
+	subject string
+	// Guards `conn`, which is nil until the publisher connects.
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// Returns a `Publisher` that publishes each change as a JSON message to
+// `subject` on the NATS server at `addr`, such as "localhost:4222". Use it with
+// `PublishChanges`. It doesn't support authentication or TLS; for those, or
+// for another broker like Kafka, wrap a client library's producer in a
+// `PublisherFunc`.
+func NATSPublisher[K comparable, V any](addr string, subject string) Publisher[K, V] {
+	return &natsPublisher[K, V]{addr: addr, subject: subject}
+}
+
+func (p *natsPublisher[K, V]) Publish(change Change[K, V]) error {
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(p.conn, "PUB %s %d\r\n%s\r\n", p.subject, len(payload), payload); err != nil {
+		p.conn.Close()
+		p.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+// Connects to the server, which greets the client with an `INFO` line, and
+// starts answering its pings. The caller must hold `mu`.
+func (p *natsPublisher[K, V]) connect() error {
+	conn, err := net.Dial("tcp", p.addr)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	info, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(info, "INFO ") {
+		conn.Close()
+		return errors.New("Failed to connect to NATS, the server didn't send INFO")
+	}
+	if _, err := fmt.Fprint(conn, "CONNECT {\"verbose\":false,\"pedantic\":false}\r\n"); err != nil {
+		conn.Close()
+		return err
+	}
+
+	p.conn = conn
+	go p.answerPings(conn, r)
+	return nil
+}
+
+// Reads what the server sends, answering each `PING` so it doesn't drop the
+// connection, until the connection is closed.
+func (p *natsPublisher[K, V]) answerPings(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		if strings.TrimSpace(line) == "PING" {
+			p.mu.Lock()
+			fmt.Fprint(conn, "PONG\r\n")
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *natsPublisher[K, V]) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
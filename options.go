@@ -1,5 +1,14 @@
 package kv
 
+import (
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
 // Options for the key/value store.
 type optionsData struct {
 	// `logPath` points to a write-ahead log to make the store durable. If it is set,
@@ -7,22 +16,776 @@ type optionsData struct {
 	// initial state. It will write all subsequent updates to the log to provide a
 	// durability guarantee.
 	logPath string
+	// `readOnly` opens the write-ahead log without ever writing to it, and makes
+	// the store reject every update.
+	readOnly bool
+	// `lockTimeout` is how long the store waits for another store to release its
+	// lock on the write-ahead log. If it is 0, it doesn't wait.
+	lockTimeout time.Duration
+	// `encryptionKey` is an AES key used to encrypt every record in the
+	// write-ahead log. If it is set, records are encrypted with AES-GCM.
+	encryptionKey []byte
+	// `logBufferSize` is how many bytes of records the write-ahead log holds in
+	// memory before writing them to its file. If it is 0, every batch of
+	// records is written as soon as it's appended.
+	logBufferSize int
+	// `logFlushInterval` is how often a buffered log writes the records it
+	// holds, however few there are. If it is 0, they're only written once the
+	// buffer is full, or the log is synced.
+	logFlushInterval time.Duration
+	// `compressLog` gzips large records before they're written to the
+	// write-ahead log.
+	compressLog bool
+	// `blobThreshold` is the size, in bytes, above which a record is kept in a
+	// blob file that the write-ahead log refers to. If it is 0, every record
+	// is kept in the log.
+	blobThreshold int
+	// `encodeWorkers` is how many goroutines encode the values of batches of
+	// updates for the update loops. If it is 0, each loop encodes its own.
+	encodeWorkers int
+	// `syncWrites` opens the write-ahead log's files so every write to them
+	// reaches the disk before it returns.
+	syncWrites bool
+	// `preallocateSize` is how many bytes of disk space the write-ahead log
+	// allocates at a time, ahead of its appends. If it is 0, space is
+	// allocated as records are written.
+	preallocateSize int64
+	// `segmentSize` is the size, in bytes, at which the write-ahead log starts a
+	// new segment file. If it is 0, the log is a single file.
+	segmentSize int64
+	// `recovery` determines how the store handles a corrupt tail at the end of
+	// its write-ahead log when it replays it.
+	recovery Recovery
+	// `replayMode` determines whether records in the write-ahead log that can't
+	// be replayed are an error, or are skipped.
+	replayMode Replay
+	// `mmapPath` points to a file that values are kept in, through a memory
+	// mapping, rather than on the heap.
+	mmapPath string
+	// `arena` keeps the store's data in large byte slabs on the heap, rather
+	// than in a map of values.
+	arena bool
+	// `lsmDir` points to a directory that the store keeps its data in, as a
+	// log-structured merge tree, rather than on the heap.
+	lsmDir string
+	// `memtableSize` is the size, in bytes, that the log-structured merge tree's
+	// memtables grow to before they're flushed to disk.
+	memtableSize int
+	// `hotEntries` is how many values a disk-backed store keeps decoded in
+	// memory, for the keys it used most recently. If it is 0, every value is
+	// read from disk.
+	hotEntries int
+	// `bloomKeys` is the number of keys the Bloom filter over a disk-backed
+	// store's keys is sized for. If it is 0, there is no filter.
+	bloomKeys int
+	// `bloomFalsePositiveRate` is the rate at which the Bloom filter reports that
+	// a missing key might be in the store, once it holds `bloomKeys` keys.
+	bloomFalsePositiveRate float64
+	// `fullText` keeps an inverted index of the words in a store's string
+	// values, so they can be searched with `Search`.
+	fullText bool
+	// `groupCommitWindow` is how long a shard waits for more updates to write to
+	// the log together with the one it has. If it is 0, it only batches updates
+	// that are already waiting.
+	groupCommitWindow time.Duration
+	// `beforeSet`, `afterSet` and `afterUnset` are hooks the store calls as it
+	// applies updates, `resolveConflict` as it merges them, and `loader` as it
+	// gets missing keys. They're functions of the store's key and value types.
+	beforeSet       any
+	afterSet        any
+	afterUnset      any
+	resolveConflict any
+	loader          any
+	// `loaderTTL` is how long values that `loader` loads stay in the store. If
+	// it is 0, they stay until they're unset.
+	loaderTTL time.Duration
+	// `snapshotEvery` is the number of updates after which the store writes a
+	// snapshot and trims its write-ahead log. If it is 0, it never does.
+	snapshotEvery int
+	// `snapshotInterval` is how often the store writes a snapshot and trims its
+	// write-ahead log. If it is 0, it never does.
+	snapshotInterval time.Duration
+	// `autoCompact` is how many bytes can be appended to the write-ahead log
+	// before the store compacts it in the background. If it is 0, it's only
+	// compacted when asked to.
+	autoCompact int64
+	// `compactionRate` is how many bytes a second background compactions
+	// write. If it is 0, they write as fast as they can.
+	compactionRate int
+	// `snapshotOnClose` makes `Close` write a snapshot and trim the write-ahead
+	// log before it closes the log.
+	snapshotOnClose bool
+	// `logger` receives structured events about the store, such as replaying
+	// its log, compactions, and errors it can't return to a caller.
+	logger *slog.Logger
+	// `expvarName` is the name of the `expvar` variable the store publishes
+	// its statistics as, if it's set.
+	expvarName string
+	// `tracer` records spans of the store's operations, if it's set.
+	tracer trace.Tracer
+	// `archive` is where the store uploads a snapshot and its rotated log
+	// segments every `archiveInterval`, if it's set.
+	archive         BackupDestination
+	archiveInterval time.Duration
+	// `replayProgress` is called with the store's progress as it replays its
+	// write-ahead log.
+	replayProgress func(ReplayProgress)
+	// `publishers` receive every change committed to the store. They're
+	// `Publisher`s of the store's key and value types.
+	publishers []any
+	// `codec` encodes the update records written to the write-ahead log and
+	// streamed to followers. Defaults to `JSONCodec`.
+	codec Codec
+	// `shards` is the number of partitions the key space is split into, each with
+	// its own update queue. Defaults to 1.
+	shards int
+	// `queueSize` is how many updates each shard's update queue holds before
+	// writers have to wait for its update loop. Defaults to 0, so every write
+	// waits for the loop to receive it.
+	queueSize int
+	// `rejectWhenQueueFull` makes writes fail with `ErrQueueFull`, instead of
+	// waiting, when their shard's update queue is full.
+	rejectWhenQueueFull bool
+	// `replicationListener` accepts connections from followers. If it is set, the
+	// store acts as a replication leader and streams every update to them.
+	replicationListener net.Listener
+	// `serverListener` accepts connections from `kvclient` clients. If it is set,
+	// the store serves its API to them.
+	serverListener net.Listener
+	// `memcachedListener` accepts connections from memcached clients. If it is
+	// set, the store serves them over the memcached text protocol.
+	memcachedListener net.Listener
+	// `memcachedMaxValue` is the largest value, in bytes, that memcached clients
+	// can set.
+	memcachedMaxValue int
+	// `healthListener` accepts HTTP health checks. If it is set, the store
+	// answers them at "/healthz".
+	healthListener net.Listener
+	// `serverTLS` secures connections to the store's servers, if it is set.
+	serverTLS *tls.Config
+	// `authToken` is the token clients must present to the store's servers, if
+	// it is set.
+	authToken string
+	// `acl` maps the other tokens clients may present to what they're granted.
+	acl map[string][]Grant
+	// `auditor` records every write made to the store, if it is set.
+	auditor Auditor
+	// `leaderAddr` is the address of a leader to replicate from. If it is set, the
+	// store acts as a read-only follower of that leader.
+	leaderAddr string
+	// `raftConfig` configures the Raft node the store runs, if it is set, so that
+	// every update is committed by a cluster before it is applied.
+	raftConfig *RaftConfig
+	// `lastWriterWins` timestamps every update with a hybrid logical clock, so
+	// the store can `Merge` with others that take writes independently.
+	lastWriterWins bool
+	// `node` names the store in the vector clocks it keeps, if it is set.
+	node string
+	// `gossipConfig` configures how the store discovers the other nodes of its
+	// cluster, if it is set.
+	gossipConfig *GossipConfig
 }
 
-// An option is a function that mutates the state of `optionsData`.
-type option func(optsData *optionsData)
+// An `Option` is a function that mutates the state of `optionsData`.
+type Option func(optsData *optionsData)
 
 // Option that sets a path to a write-ahead log the store will use.
-func LogPath(filepath string) option {
+func LogPath(filepath string) Option {
 	return func(optsData *optionsData) {
 		optsData.logPath = filepath
 	}
 }
 
+// Option that opens the store's write-ahead log read-only. The log is replayed,
+// but never written to, even to recover a corrupt tail, so it's safe to open a
+// log that another store is writing to. Every update returns `ErrReadOnly`.
+func ReadOnly() Option {
+	return func(optsData *optionsData) {
+		optsData.readOnly = true
+	}
+}
+
+// Option that makes the store wait up to `timeout` for another store, possibly
+// in another process, to close the write-ahead log, rather than failing with
+// `ErrLogLocked` straight away.
+func WaitForLock(timeout time.Duration) Option {
+	return func(optsData *optionsData) {
+		optsData.lockTimeout = timeout
+	}
+}
+
+// Option that encrypts the write-ahead log at rest with AES-GCM. `key` must be
+// 16, 24, or 32 bytes long, to select AES-128, AES-192, or AES-256. The same key
+// must be provided every time the store is opened.
+func EncryptionKey(key []byte) Option {
+	return func(optsData *optionsData) {
+		optsData.encryptionKey = key
+	}
+}
+
+// Option that buffers up to `size` bytes of records in memory, rather than
+// writing each batch of them to the write-ahead log's file as it's appended,
+// so a busy store makes fewer system calls. The buffer is written once it's
+// full, every `interval` if that's positive, and whenever the log is synced,
+// rotated, compacted or closed. Writes return before their records reach the
+// file, so the records still in the buffer are lost if the process crashes.
+func LogBuffer(size int, interval time.Duration) Option {
+	return func(optsData *optionsData) {
+		if size > 0 {
+			optsData.logBufferSize = size
+			optsData.logFlushInterval = interval
+		}
+	}
+}
+
+// Option that gzips large records before they're written to the write-ahead
+// log. Records of any size are read back whether or not they're compressed,
+// so compression can be turned on for an existing log.
+func CompressLog() Option {
+	return func(optsData *optionsData) {
+		optsData.compressLog = true
+	}
+}
+
+// Option that sets how the store recovers from a corrupt tail at the end of its
+// write-ahead log, such as a record that was only partly written when the
+// process crashed. See `ReplaySummary` to find out how many records were lost.
+func RecoveryMode(mode Recovery) Option {
+	return func(optsData *optionsData) {
+		optsData.recovery = mode
+	}
+}
+
+// Option that sets how the store handles records in its write-ahead log that
+// can't be replayed: either failing to open (`Strict`, the default), or skipping
+// them (`SkipBadRecords`). Skipped records are listed in the `ReplaySummary`.
+func ReplayMode(mode Replay) Option {
+	return func(optsData *optionsData) {
+		optsData.replayMode = mode
+	}
+}
+
+// Option that starts `n` goroutines to encode the values of the updates that
+// are written to the log, so a shard's update loop doesn't encode a whole
+// batch of large values one after another, holding up every write behind
+// them. Each batch's values are spread across the workers before the batch is
+// applied, so updates are still applied, and logged, in order. Handing a value
+// to a worker costs about as much as encoding a small one, so it's only worth
+// it for values that take a while to encode. Only records encoded with
+// `JSONCodec` can be encoded this way.
+func EncodeWorkers(n int) Option {
+	return func(optsData *optionsData) {
+		optsData.encodeWorkers = max(n, 0)
+	}
+}
+
+// Option that opens the write-ahead log's files with `O_DSYNC`, so each append
+// returns once its records are on disk, rather than when the operating system
+// has them, and every update has reached the disk by the time it's applied.
+// Each write waits on the disk, but only for its own data, so it takes about
+// as long as any other instead of some waiting on an `fsync` of everything
+// before them, and `Sync` has nothing left to do. Records aren't written with
+// `O_DIRECT`, which needs every write to be a whole number of disk blocks at a
+// block-aligned offset, which appends of records of any length aren't. Pair it
+// with `GroupCommitWindow`, so each write to the disk carries many updates, and
+// on Linux with `Preallocate`, so appends don't also wait on the file system's
+// block maps. Elsewhere than Linux, files are opened with `O_SYNC`.
+func SyncWrites() Option {
+	return func(optsData *optionsData) {
+		optsData.syncWrites = true
+	}
+}
+
+// Option that allocates disk space for the write-ahead log `bytes` at a time,
+// ahead of the records appended to it, rather than as each append extends the
+// file. Appends then rarely update the file system's block maps, which keeps
+// the slowest writes faster and the log less fragmented. Space is allocated
+// past the end of the file, so readers never see it, and any that's unused is
+// freed when a file is closed. With `SegmentSize`, pick the segment size, so
+// each segment is allocated once. Only Linux supports it; elsewhere, it has no
+// effect.
+func Preallocate(bytes int64) Option {
+	return func(optsData *optionsData) {
+		optsData.preallocateSize = max(bytes, 0)
+	}
+}
+
+// Option that keeps records larger than `bytes`, such as those of large values,
+// in their own blob files in `<path>.blobs`, with the write-ahead log only
+// holding a reference to each one. A few huge values then don't bloat the log,
+// which stays quick to scan, compact and copy. Blobs no longer referred to are
+// removed when the log is compacted or trimmed.
+func BlobThreshold(bytes int) Option {
+	return func(optsData *optionsData) {
+		optsData.blobThreshold = max(bytes, 0)
+	}
+}
+
+// Option that splits the write-ahead log into numbered segment files, starting
+// a new one once the latest reaches `bytes` in size. Segments are named after
+// the log's path: `<path>.000001`, `<path>.000002`, and so on.
+func SegmentSize(bytes int64) Option {
+	return func(optsData *optionsData) {
+		optsData.segmentSize = bytes
+	}
+}
+
+// Option that keeps the store's values in a memory-mapped file at `path`, with
+// only its keys held in memory, so it can hold more data than fits in RAM. The
+// operating system keeps recently used values in memory, so `Get` stays fast
+// for hot keys. The file is scratch space: it's emptied when the store opens,
+// and the store's values are recreated from the write-ahead log, so pair it
+// with `LogPath` to keep data between restarts. Values can't be read once the
+// store is closed.
+func MmapStorage(path string) Option {
+	return func(optsData *optionsData) {
+		optsData.mmapPath = path
+	}
+}
+
+// Option that keeps the store's keys and values encoded in large byte slabs,
+// indexed by a map without pointers, so the garbage collector has little to
+// scan however many keys the store holds. Gets decode their value, so they're
+// slower than with the default storage. Slabs are compacted as overwritten and
+// removed values build up.
+func ArenaStorage() Option {
+	return func(optsData *optionsData) {
+		optsData.arena = true
+	}
+}
+
+// Option that keeps the store's data in a log-structured merge tree in `dir`, so
+// it can hold more data than fits in RAM, and write it quickly. Writes go to an
+// in-memory memtable, which is flushed to a sorted, immutable SSTable file once
+// it's full; tables are merged in the background once there are too many. Like
+// `MmapStorage`, the directory is scratch space: it's emptied when the store
+// opens, and the store's data is recreated from the write-ahead log.
+func LSMStorage(dir string) Option {
+	return func(optsData *optionsData) {
+		optsData.lsmDir = dir
+	}
+}
+
+// Option that sets the size, in bytes, that an `LSMStorage` memtable grows to
+// before it's flushed to disk. Defaults to 4 MiB.
+func MemtableSize(bytes int) Option {
+	return func(optsData *optionsData) {
+		if bytes > 0 {
+			optsData.memtableSize = bytes
+		}
+	}
+}
+
+// Option that keeps the values of the `n` most recently used keys of a store
+// whose values are on disk, such as with `MmapStorage` or `LSMStorage`, decoded
+// in memory, so hot keys are read without going to disk or decoding them, while
+// the rest of the store's data stays out of memory. Writes go to disk as well,
+// and reading a key that isn't hot makes it hot, in place of the least
+// recently used one. Each shard keeps its share of the `n` keys. Unlike reads
+// of the default storage, reads lock their shard's hot keys, since they
+// change which keys are hot.
+func HotEntries(n int) Option {
+	return func(optsData *optionsData) {
+		optsData.hotEntries = max(n, 0)
+	}
+}
+
+// Option that keeps a Bloom filter over the keys of a store whose values are on
+// disk, such as with `MmapStorage`, so looking up a missing key can usually skip
+// the disk entirely. The filter is sized to hold `expectedKeys` keys with the
+// given false positive rate, between 0 and 1; it gets less accurate as the
+// store grows beyond that. It has no effect on stores kept in memory. With
+// `LSMStorage`, every SSTable has its own filter, sized for the keys in it, so
+// only the false positive rate applies.
+func BloomFilter(expectedKeys int, falsePositiveRate float64) Option {
+	return func(optsData *optionsData) {
+		if expectedKeys > 0 && falsePositiveRate > 0 && falsePositiveRate < 1 {
+			optsData.bloomKeys = expectedKeys
+			optsData.bloomFalsePositiveRate = falsePositiveRate
+		}
+	}
+}
+
+// Option that keeps an inverted index of the words in a store's values, which
+// must be strings, so `Search` can find the keys whose values contain them. The
+// index is kept up to date as values are written, and held in memory, whatever
+// the store keeps its values in.
+func FullTextSearch() Option {
+	return func(optsData *optionsData) {
+		optsData.fullText = true
+	}
+}
+
+// Option that sets how long a shard waits, after receiving an update, for more
+// updates to write to the log with it in a single write. Updates that are
+// already waiting are always written together, up to 128 at a time; waiting
+// for more trades latency for throughput when many goroutines write at once.
+func GroupCommitWindow(window time.Duration) Option {
+	return func(optsData *optionsData) {
+		optsData.groupCommitWindow = window
+	}
+}
+
+// Option that calls `hook` with every key and value before they're set, and
+// sets the value it returns instead, so it can validate or transform values. If
+// it returns an error, nothing is set, and the error is returned to the caller.
+// It's called for values set by `Set`, `CompareAndSwap`, `SetIfNotExists` and
+// `SetMany`, but not for values replayed from the log, streamed from a leader,
+// or restored from a backup. Hooks are called from the update loop, so they
+// must not update the store themselves, and their types must match the store's.
+func OnBeforeSet[K comparable, V any](hook func(key K, value V) (V, error)) Option {
+	return func(optsData *optionsData) {
+		optsData.beforeSet = hook
+	}
+}
+
+// Option that calls `hook` with every key and value after they're set, such as
+// to mirror writes to another system. It's called for every value set in the
+// store, including values replayed from its log or streamed from a leader, but
+// not for values restored from a backup.
+func OnAfterSet[K comparable, V any](hook func(key K, value V)) Option {
+	return func(optsData *optionsData) {
+		optsData.afterSet = hook
+	}
+}
+
+// Option that calls `hook` with every key after it's unset, such as to
+// invalidate a cache. Like `OnAfterSet`, it's called for updates replayed from
+// the log or streamed from a leader too.
+func OnAfterUnset[K comparable](hook func(key K)) Option {
+	return func(optsData *optionsData) {
+		optsData.afterUnset = hook
+	}
+}
+
+// Option that makes the store a read-through cache: when `Get` misses a key, it
+// calls `loader` to load its value, such as from a database, sets it, and
+// returns it, like `GetOrCompute` does. Concurrent gets of the same missing key
+// share a single call to `loader`. If `loader` returns an error, nothing is set
+// and the key isn't found, as it isn't for the gets sharing a call that panics.
+// Its types must match the store's.
+func Loader[K comparable, V any](loader func(key K) (V, error)) Option {
+	return func(optsData *optionsData) {
+		optsData.loader = loader
+	}
+}
+
+// Option that attaches every value `Loader` loads to a lease with the given
+// TTL, so it's unset, and loaded again on the next `Get`, once it's `ttl` old.
+func LoaderTTL(ttl time.Duration) Option {
+	return func(optsData *optionsData) {
+		optsData.loaderTTL = ttl
+	}
+}
+
+// Option that writes a snapshot of the store next to its write-ahead log, at
+// `<path>.snapshot`, every `updates` updates, then trims every record it covers
+// from the log, so replaying the log when the store opens stays fast. The
+// snapshot is written in the background while the store keeps taking writes.
+// Trimming the log discards its history, like `Compact` does.
+func SnapshotEvery(updates int) Option {
+	return func(optsData *optionsData) {
+		if updates > 0 {
+			optsData.snapshotEvery = updates
+		}
+	}
+}
+
+// Option that writes a snapshot of the store and trims its write-ahead log, like
+// `SnapshotEvery`, every `interval`. The two can be combined.
+func SnapshotInterval(interval time.Duration) Option {
+	return func(optsData *optionsData) {
+		optsData.snapshotInterval = interval
+	}
+}
+
+// Option that compacts the write-ahead log in the background once `bytes` have
+// been appended to it since it was last compacted. Unlike `Compact`, which
+// pauses every update while it rewrites the log, a background compaction only
+// pauses them to copy the store's data. It then writes the data to a snapshot,
+// like `SnapshotEvery`, while the store keeps taking writes, and trims the log.
+func AutoCompact(bytes int64) Option {
+	return func(optsData *optionsData) {
+		optsData.autoCompact = max(bytes, 0)
+	}
+}
+
+// Option that limits background compactions, and snapshots, to writing
+// `bytesPerSecond`, so they don't take the disk bandwidth that writes to the
+// log need. Compactions take longer, but the snapshot `Close` writes for
+// `SnapshotOnClose` isn't limited, nor is one still running when the store
+// closes.
+func CompactionRate(bytesPerSecond int) Option {
+	return func(optsData *optionsData) {
+		optsData.compactionRate = max(bytesPerSecond, 0)
+	}
+}
+
+// Option that makes `Close` write a final snapshot of the store and trim its
+// write-ahead log, like `SnapshotEvery`, so the next time the store opens it
+// only replays the snapshot, and whatever was written after it.
+func SnapshotOnClose() Option {
+	return func(optsData *optionsData) {
+		optsData.snapshotOnClose = true
+	}
+}
+
+// Option that archives the store to `dest` every `interval`, so it can be
+// restored with `RestoreArchive` if its own files are lost: a snapshot of its
+// data, whenever it has changed, and, if the store uses `SegmentSize`, every
+// log segment once it's rotated, so it can be restored to any revision since
+// the first archived snapshot. Snapshots that trim segments before they're
+// archived leave gaps in the history, so `interval` should be shorter than
+// `SnapshotInterval`.
+func ArchiveTo(dest BackupDestination, interval time.Duration) Option {
+	return func(optsData *optionsData) {
+		optsData.archive = dest
+		optsData.archiveInterval = interval
+	}
+}
+
+// Option that logs structured events about the store to `logger`: opening and
+// replaying its write-ahead log, snapshots and compactions, expired leases and
+// dropped followers, errors that happen in the background, or while applying
+// updates in the update loop, and closing the store. Without it, the store
+// logs nothing.
+func Logger(logger *slog.Logger) Option {
+	return func(optsData *optionsData) {
+		optsData.logger = logger
+	}
+}
+
+// Option that calls `hook` with the store's progress as it replays its
+// write-ahead log when it opens: every 10,000 lines of each file in the log, and
+// once more when the whole log has been replayed. It's called from `NewStore`,
+// before the store is returned, so it can report on a slow startup.
+func OnReplayProgress(hook func(progress ReplayProgress)) Option {
+	return func(optsData *optionsData) {
+		optsData.replayProgress = hook
+	}
+}
+
+// Option that publishes every change committed to the store to `publisher`, in
+// order, from a separate goroutine, so downstream systems can consume the
+// store's change stream. Changes replayed from the log when the store opens
+// aren't published again. The option can be given several times, to publish to
+// several publishers. Their types must match the store's.
+func PublishChanges[K comparable, V any](publisher Publisher[K, V]) Option {
+	return func(optsData *optionsData) {
+		optsData.publishers = append(optsData.publishers, publisher)
+	}
+}
+
+// Option that sets the codec used to encode records in the write-ahead log, and
+// in the stream sent to followers. Every store that reads the log, or follows
+// the store, must use the same codec. Records written by any codec other than
+// `JSONCodec` are written to the log as base64.
+func LogCodec(codec Codec) Option {
+	return func(optsData *optionsData) {
+		optsData.codec = codec
+	}
+}
+
+// Option that splits the store's key space into `n` shards, each with its own
+// update queue and goroutine, so writes to unrelated keys can be applied in
+// parallel. Updates to the same key are still applied in order.
+func Shards(n int) Option {
+	return func(optsData *optionsData) {
+		if n > 0 {
+			optsData.shards = n
+		}
+	}
+}
+
+// Option that lets each shard's update queue hold `n` updates that its update
+// loop hasn't received yet, so bursts of writes are absorbed without every
+// writer waiting for the loop. Writes still wait for their update to be
+// applied before they return.
+func QueueSize(n int) Option {
+	return func(optsData *optionsData) {
+		if n > 0 {
+			optsData.queueSize = n
+		}
+	}
+}
+
+// Option that makes writes fail with `ErrQueueFull` when their shard's update
+// queue is full, instead of waiting for room in it. Updates replayed from the
+// log, received from a leader, or committed through Raft always wait.
+func RejectWhenQueueFull() Option {
+	return func(optsData *optionsData) {
+		optsData.rejectWhenQueueFull = true
+	}
+}
+
+// Option that makes the store a replication leader, streaming its updates to
+// every follower that connects to `listener`.
+func ReplicationListener(listener net.Listener) Option {
+	return func(optsData *optionsData) {
+		optsData.replicationListener = listener
+	}
+}
+
+// Option that serves the store's API over a framed TCP protocol to clients that
+// connect to `listener`, so a program can use the store remotely through
+// `kvclient.Dial`. The listener is closed when the store is.
+func ServerListener(listener net.Listener) Option {
+	return func(optsData *optionsData) {
+		optsData.serverListener = listener
+	}
+}
+
+// Option that answers HTTP health checks at "/healthz", for connections to
+// `listener`, with `HealthHandler`, so an orchestrator can probe whether the
+// store is live and ready. The listener is closed when the store is.
+func HealthListener(listener net.Listener) Option {
+	return func(optsData *optionsData) {
+		optsData.healthListener = listener
+	}
+}
+
+// Option that serves clients over TLS with `config`, on the listeners given to
+// `ServerListener`, `MemcachedListener` and `HealthListener`, so the store can
+// be exposed beyond localhost. To authenticate clients by their certificates,
+// set the config's `ClientAuth` to `tls.RequireAndVerifyClientCert`, and its
+// `ClientCAs` to the authorities that issue them.
+func ServerTLS(config *tls.Config) Option {
+	return func(optsData *optionsData) {
+		optsData.serverTLS = config
+	}
+}
+
+// Option that requires clients to present `token` before the store serves
+// them: `kvclient` clients with the `kvclient.Token` option, and health checks
+// as a bearer token in their `Authorization` header. Tokens are sent in the
+// clear unless the store is also given `ServerTLS`. The memcached protocol has
+// no way to send one, so memcached clients can only be authenticated by their
+// certificates: a store with a `MemcachedListener` fails to open unless its
+// `ServerTLS` config requires and verifies them.
+func AuthToken(token string) Option {
+	return func(optsData *optionsData) {
+		optsData.authToken = token
+	}
+}
+
+// Option that serves `kvclient` clients that present one of the tokens in
+// `grants`, restricted to the namespaces and keys, and the operations, they're
+// granted, so teams can share a store without touching each other's data.
+// Clients that present the `AuthToken`, if there is one, may still do
+// anything. Token checks are made by the server, so they don't restrict the
+// store's own program. Like `AuthToken`, it needs memcached clients to be
+// authenticated by their certificates.
+func ACL(grants map[string][]Grant) Option {
+	return func(optsData *optionsData) {
+		optsData.acl = grants
+	}
+}
+
+// Option that records every write made to the store with `auditor`: who made
+// it, what it wrote, and when, whether it succeeded or not. Writes made by
+// clients of the store's servers are recorded with their identity, and writes
+// made by the store's own program as "local", as are leases the store revokes
+// once they expire. Updates a follower applies from its leader aren't
+// recorded.
+func Audit(auditor Auditor) Option {
+	return func(optsData *optionsData) {
+		optsData.auditor = auditor
+	}
+}
+
+// Option that serves the store over the memcached text protocol to clients that
+// connect to `listener`, so it can stand in for memcached behind existing client
+// libraries. It supports `get`, `set`, `delete`, `flush_all`, `version`
+// and `quit`, on the store's default namespace. Keys must be strings; string
+// and `[]byte` values are sent as they are, and any other values as JSON. Flags
+// and expiration times are accepted, but ignored, and `flush_all` takes effect
+// straight away. The listener is closed when the store is.
+func MemcachedListener(listener net.Listener) Option {
+	return func(optsData *optionsData) {
+		optsData.memcachedListener = listener
+	}
+}
+
+// Option that sets the largest value, in bytes, that memcached clients can set.
+// Larger ones are refused before they're read into memory. Defaults to 1 MiB,
+// like memcached.
+func MemcachedMaxValueSize(bytes int) Option {
+	return func(optsData *optionsData) {
+		if bytes > 0 {
+			optsData.memcachedMaxValue = bytes
+		}
+	}
+}
+
+// Option that makes the store a follower of the leader listening at `addr`.
+func FollowerOf(addr string) Option {
+	return func(optsData *optionsData) {
+		optsData.leaderAddr = addr
+	}
+}
+
+// Option that runs the store as a node in a Raft cluster.
+func Raft(config RaftConfig) Option {
+	return func(optsData *optionsData) {
+		optsData.raftConfig = &config
+	}
+}
+
+// Option that timestamps every update with a hybrid logical clock, and keeps
+// the time each key was last written, so stores that take writes independently,
+// like replicas in different regions, can be reconciled with `Merge`, keeping
+// the last write to each key. Unset keys are remembered, so merging doesn't bring
+// them back, which means the store's memory use grows with every key it has
+// ever held.
+func LastWriterWins() Option {
+	return func(optsData *optionsData) {
+		optsData.lastWriterWins = true
+	}
+}
+
+// Option that keeps a vector clock for every key, as well as its timestamp, so
+// `GetVersioned` can tell whether two versions of a key conflict. `Merge` then
+// takes a version that was written after seeing the other as it is, and only
+// resolves versions that were written concurrently, by the last write or by
+// `ResolveConflicts`. `node` names the store in the clocks, so every
+// store that's merged must use `VectorClocks` with a name of its own, which it
+// keeps each time it's opened. The store must use `LastWriterWins`.
+func VectorClocks(node string) Option {
+	return func(optsData *optionsData) {
+		optsData.node = node
+	}
+}
+
+// Option that decides the value of a key that both stores have set when `Merge`
+// merges them, or that they set concurrently if they use `VectorClocks`,
+// instead of keeping the one written last, such as by taking the larger of two
+// counters, or the union of two sets. `resolve` is called with
+// this store's value and the other store's, and the value it returns is set,
+// as if it was written whenever the later of the two was. Removals are still
+// merged by `LastWriterWins`, which the store must use. So that stores
+// converge, whichever of them merges the other, `resolve` should give the same
+// value for either order of its arguments. It's called from the update loop, so
+// it must not update the store itself, and its types must match the store's.
+func ResolveConflicts[K comparable, V any](resolve func(key K, local, remote V) V) Option {
+	return func(optsData *optionsData) {
+		optsData.resolveConflict = resolve
+	}
+}
+
+// Option that makes the store gossip with other nodes, so they discover each
+// other, and check each other's health. `Members` lists the nodes in the
+// cluster, and the addresses they serve clients on, which `kvclient.DialCluster`
+// uses to find another node when the one it's connected to fails.
+func Gossip(config GossipConfig) Option {
+	return func(optsData *optionsData) {
+		optsData.gossipConfig = &config
+	}
+}
+
 // Returns a pointer to `optionsData` that is the result of applying
 // a series of options
-func applyOptions(options ...option) (optsData *optionsData) {
-	optsData = &optionsData{}
+func applyOptions(options ...Option) (optsData *optionsData) {
+	optsData = &optionsData{shards: 1, memtableSize: defaultMemtableSize, memcachedMaxValue: defaultMemcachedMaxValue, codec: JSONCodec, logger: slog.New(slog.DiscardHandler)}
 	for _, opt := range options {
 		opt(optsData)
 	}
@@ -0,0 +1,67 @@
+package kv
+
+import (
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+)
+
+// Returned by `List` and `ListDesc` for a cursor they didn't return.
+var ErrInvalidCursor = errors.New("Cursor is invalid")
+
+var errPageLimit = errors.New("Page limit must be positive")
+
+func (s *kvStore[K, V]) List(cursor string, limit int) (page map[K]V, next string, err error) {
+	return s.list(cursor, limit, false)
+}
+
+func (s *kvStore[K, V]) ListDesc(cursor string, limit int) (page map[K]V, next string, err error) {
+	return s.list(cursor, limit, true)
+}
+
+// Gets a page of pairs for `List`, or for `ListDesc` if `descending` is set. A
+// cursor is the sort key of the last key on the page before, so the next page
+// carries on from that key, whether or not it's still in the store.
+func (s *kvStore[K, V]) list(cursor string, limit int, descending bool) (page map[K]V, next string, err error) {
+	if limit <= 0 {
+		return nil, "", errPageLimit
+	}
+	after, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, "", ErrInvalidCursor
+	}
+
+	// Compares sort keys in the order of the listing:
+	compare := strings.Compare
+	if descending {
+		compare = func(a, b string) int { return strings.Compare(b, a) }
+	}
+
+	type listed struct {
+		key     K
+		sortKey string
+	}
+	var keys []listed
+	page = make(map[K]V, limit)
+	s.exclusive(func() {
+		for _, sh := range s.shards {
+			for k := range sh.lookup(s.namespace).values.keys() {
+				sortKey, err := csvField(k)
+				if err == nil && (cursor == "" || compare(sortKey, string(after)) > 0) {
+					keys = append(keys, listed{k, sortKey})
+				}
+			}
+		}
+
+		slices.SortFunc(keys, func(a, b listed) int { return compare(a.sortKey, b.sortKey) })
+		for _, k := range keys[:min(limit, len(keys))] {
+			page[k.key], _ = s.shardFor(k.key).lookup(s.namespace).values.get(k.key)
+		}
+	})
+
+	if len(keys) > limit {
+		next = base64.RawURLEncoding.EncodeToString([]byte(keys[limit-1].sortKey))
+	}
+	return page, next, nil
+}
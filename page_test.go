@@ -0,0 +1,74 @@
+package kv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListPages(t *testing.T) {
+	store, _ := NewStore[string, int](Shards(4))
+	defer store.Close()
+	for i := range 10 {
+		store.Set(fmt.Sprintf("key-%d", i), i)
+	}
+
+	page, next, err := store.List("", 4)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"key-0": 0, "key-1": 1, "key-2": 2, "key-3": 3}, page)
+	assert.NotEmpty(t, next)
+
+	// Keys changed between pages don't move the ones after them:
+	store.Unset("key-4")
+	store.Set("key-10", 10)
+	store.Set("key-9b", 9)
+	page, next, err = store.List(next, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"key-5": 5, "key-6": 6, "key-7": 7, "key-8": 8}, page)
+
+	page, next, err = store.List(next, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"key-9": 9, "key-9b": 9}, page)
+	assert.Empty(t, next)
+
+	_, _, err = store.List("not a cursor!", 4)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, _, err = store.List("", 0)
+	assert.Error(t, err)
+}
+
+func TestListPagesNamespace(t *testing.T) {
+	store, _ := NewStore[int, string]()
+	defer store.Close()
+	users := store.Namespace("users")
+	store.Set(1, "other")
+	users.Set(20, "b")
+	users.Set(3, "a")
+
+	// Keys that aren't strings are ordered by their JSON encoding:
+	page, next, _ := users.List("", 1)
+	assert.Equal(t, map[int]string{20: "b"}, page)
+	page, next, _ = users.List(next, 1)
+	assert.Equal(t, map[int]string{3: "a"}, page)
+	assert.Empty(t, next)
+}
+
+func TestListPagesDescending(t *testing.T) {
+	store, _ := NewStore[string, string](Shards(4))
+	defer store.Close()
+	for _, day := range []string{"2024-01-01", "2024-01-02", "2024-01-03", "2024-01-04", "2024-01-05"} {
+		store.Set("events/"+day, day)
+	}
+
+	// The latest entries come first:
+	page, next, err := store.ListDesc("", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"events/2024-01-05": "2024-01-05", "events/2024-01-04": "2024-01-04"}, page)
+
+	store.Set("events/2024-01-06", "2024-01-06")
+	store.Unset("events/2024-01-03")
+	page, next, _ = store.ListDesc(next, 2)
+	assert.Equal(t, map[string]string{"events/2024-01-02": "2024-01-02", "events/2024-01-01": "2024-01-01"}, page)
+	assert.Empty(t, next)
+}
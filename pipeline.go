@@ -0,0 +1,81 @@
+package kv
+
+import "sync"
+
+// The most pipelined writes a server starts on a connection before it answers
+// them.
+const maxPipelined = 128
+
+// The result of a write that may still be in flight. Returned by
+// `SetPipelined`.
+type Future interface {
+	// Waits until the write has been applied, and its record synced to the
+	// write-ahead log if the store has one, then returns its revision, or the
+	// error it failed with. It can be called any number of times.
+	Wait() (revision uint64, err error)
+}
+
+// A pipelined write to a store in this process.
+type future[V any] struct {
+	once     sync.Once
+	wait     func() updateResult[V]
+	durable  func(revision uint64) error
+	revision uint64
+	err      error
+}
+
+func (f *future[V]) Wait() (revision uint64, err error) {
+	f.once.Do(func() {
+		result := f.wait()
+		f.revision, f.err = result.revision, result.err
+		if f.err == nil {
+			f.err = f.durable(f.revision)
+		}
+	})
+
+	return f.revision, f.err
+}
+
+// A future that has already failed.
+type failedFuture struct{ err error }
+
+func (f failedFuture) Wait() (revision uint64, err error) {
+	return 0, f.err
+}
+
+func (s *kvStore[K, V]) SetPipelined(key K, value V) Future {
+	wait, err := s.startWrite(s.newUpdate(set, key, value))
+	if err != nil {
+		return failedFuture{err}
+	}
+
+	return &future[V]{wait: wait, durable: s.syncThrough}
+}
+
+// Syncs the write-ahead log to disk, unless it's been synced since the update
+// at `revision` was appended to it. Writers waiting on the same sync share it,
+// so many writes in flight only need a sync or two between them.
+func (s *kvStore[K, V]) syncThrough(revision uint64) error {
+	if !s.appends() || s.synced.Load() >= revision {
+		return nil
+	}
+
+	s.commit.Lock()
+	defer s.commit.Unlock()
+
+	select {
+	case <-s.closing:
+		return ErrClosed
+	default:
+	}
+	if s.synced.Load() >= revision {
+		return nil
+	}
+
+	synced := s.revision
+	if err := s.log.sync(); err != nil {
+		return err
+	}
+	s.synced.Store(synced)
+	return nil
+}
@@ -0,0 +1,54 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetPipelined(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[int, int](LogPath(logPath), Shards(4))
+	var futures []Future
+	for n := range 100 {
+		futures = append(futures, store.SetPipelined(n%10, n))
+	}
+
+	// Every write is applied in order, and synced by the time it's waited on:
+	var expected, revisions []uint64
+	for i, f := range futures {
+		expected = append(expected, uint64(i+1))
+		revision, err := f.Wait()
+		assert.NoError(t, err)
+		revisions = append(revisions, revision)
+	}
+	assert.ElementsMatch(t, expected, revisions)
+	assert.GreaterOrEqual(t, store.(*kvStore[int, int]).synced.Load(), uint64(100))
+	v, _ := store.Get(3)
+	assert.Equal(t, 93, v)
+
+	// Waiting again returns the same result:
+	revision, err := futures[0].Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, revisions[0], revision)
+	store.Close()
+
+	_, err = store.SetPipelined(1, 1).Wait()
+	assert.ErrorIs(t, err, ErrClosed)
+
+	replayed, _ := NewStore[int, int](LogPath(logPath))
+	defer replayed.Close()
+	assert.Equal(t, 10, replayed.Len())
+}
+
+func TestSetPipelinedWithoutLog(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	defer store.Close()
+
+	revision, err := store.SetPipelined("name", "toby").Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), revision)
+	v, _ := store.Get("name")
+	assert.Equal(t, "toby", v)
+}
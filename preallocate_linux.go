@@ -0,0 +1,24 @@
+//go:build linux
+
+package kv
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Keeps a file's size as it is while allocating blocks past its end.
+const fallocKeepSize = 0x1
+
+// Allocates the disk blocks of a file up to `size` bytes, without changing its
+// size, so appends up to that size don't have to allocate them. File systems
+// that can't preallocate are left to allocate blocks as they're written.
+func preallocate(file *os.File, size int64) error {
+	err := syscall.Fallocate(int(file.Fd()), fallocKeepSize, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return nil
+	}
+
+	return err
+}
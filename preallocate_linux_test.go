@@ -0,0 +1,44 @@
+//go:build linux
+
+package kv
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/qsymmachus/ranger"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns how many bytes of disk space are allocated for a file.
+func allocated(t *testing.T, path string) int64 {
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	return info.Sys().(*syscall.Stat_t).Blocks * 512
+}
+
+func TestPreallocate(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[int, int](LogPath(logPath), Preallocate(1<<20))
+	for _, n := range ranger.Int(1, 10) {
+		store.Set(n, n)
+	}
+
+	// Space is allocated past the end of the log, which stays the size of its
+	// records:
+	info, _ := os.Stat(logPath)
+	assert.Less(t, info.Size(), int64(1024))
+	if allocated(t, logPath) < 1<<20 {
+		t.Skip("The file system doesn't support preallocation")
+	}
+
+	// The space that's left is freed:
+	store.Close()
+	assert.Less(t, allocated(t, logPath), int64(1<<20))
+
+	replayed, _ := NewStore[int, int](LogPath(logPath), Preallocate(1<<20))
+	defer replayed.Close()
+	assert.Equal(t, 10, replayed.Len())
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package kv
+
+import "os"
+
+// Preallocation isn't supported on this platform, so blocks are allocated as
+// files are written.
+func preallocate(file *os.File, size int64) error {
+	return nil
+}
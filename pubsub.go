@@ -0,0 +1,108 @@
+package kv
+
+import "sync"
+
+// How many messages can be waiting to be received by a subscription before
+// it's considered to have fallen behind, and it's ended.
+const subscriptionBufferSize = 1024
+
+// Receives the messages published to a topic, from when it was subscribed to.
+type Subscription[V any] interface {
+	// Receives each message, in the order it was published. Closed when the
+	// subscription ends: when it's closed, or falls behind, or the store is
+	// closed.
+	Messages() <-chan V
+	// Ends the subscription.
+	Close() error
+}
+
+// A subscription to a topic of a store in this process.
+type subscription[V any] struct {
+	topics   *topics[V]
+	topic    namespacedKey[string]
+	messages chan V
+}
+
+func (sub *subscription[V]) Messages() <-chan V {
+	return sub.messages
+}
+
+func (sub *subscription[V]) Close() error {
+	sub.topics.mu.Lock()
+	defer sub.topics.mu.Unlock()
+	sub.topics.drop(sub)
+	return nil
+}
+
+// The subscriptions to each of a store's topics, by namespace and topic.
+type topics[V any] struct {
+	mu          sync.Mutex
+	subscribers map[namespacedKey[string]]map[*subscription[V]]struct{}
+	closed      bool
+}
+
+// Removes a subscription and closes its channel, if it hasn't been already.
+// The caller must hold `mu`.
+func (t *topics[V]) drop(sub *subscription[V]) {
+	subscribers := t.subscribers[sub.topic]
+	if _, found := subscribers[sub]; !found {
+		return
+	}
+
+	delete(subscribers, sub)
+	if len(subscribers) == 0 {
+		delete(t.subscribers, sub.topic)
+	}
+	close(sub.messages)
+}
+
+// Ends every subscription, and stops new ones from starting.
+func (t *topics[V]) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	for _, subscribers := range t.subscribers {
+		for sub := range subscribers {
+			t.drop(sub)
+		}
+	}
+}
+
+func (s *kvStore[K, V]) Publish(topic string, message V) (receivers int, err error) {
+	s.topics.mu.Lock()
+	defer s.topics.mu.Unlock()
+
+	if s.topics.closed {
+		return 0, ErrClosed
+	}
+	for sub := range s.topics.subscribers[namespacedKey[string]{s.namespace, topic}] {
+		select {
+		case sub.messages <- message:
+			receivers++
+		default:
+			s.topics.drop(sub)
+		}
+	}
+
+	return receivers, nil
+}
+
+func (s *kvStore[K, V]) Subscribe(topic string) (Subscription[V], error) {
+	s.topics.mu.Lock()
+	defer s.topics.mu.Unlock()
+
+	if s.topics.closed {
+		return nil, ErrClosed
+	}
+	sub := &subscription[V]{topics: &s.topics, topic: namespacedKey[string]{s.namespace, topic}, messages: make(chan V, subscriptionBufferSize)}
+	if s.topics.subscribers == nil {
+		s.topics.subscribers = make(map[namespacedKey[string]]map[*subscription[V]]struct{})
+	}
+	if s.topics.subscribers[sub.topic] == nil {
+		s.topics.subscribers[sub.topic] = make(map[*subscription[V]]struct{})
+	}
+	s.topics.subscribers[sub.topic][sub] = struct{}{}
+
+	return sub, nil
+}
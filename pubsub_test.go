@@ -0,0 +1,66 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPubSub(t *testing.T) {
+	store, _ := NewStore[string, string]()
+
+	// Messages published before anyone subscribes are lost:
+	receivers, err := store.Publish("news", "early")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, receivers)
+
+	first, err := store.Subscribe("news")
+	assert.NoError(t, err)
+	second, _ := store.Subscribe("news")
+	other, _ := store.Namespace("other").Subscribe("news")
+	defer other.Close()
+
+	receivers, _ = store.Publish("news", "hello")
+	assert.Equal(t, 2, receivers)
+	store.Publish("news", "world")
+	assert.Equal(t, "hello", <-first.Messages())
+	assert.Equal(t, "world", <-first.Messages())
+	assert.Equal(t, "hello", <-second.Messages())
+
+	// Closed subscriptions stop receiving:
+	second.Close()
+	for range second.Messages() {
+	}
+	receivers, _ = store.Publish("news", "again")
+	assert.Equal(t, 1, receivers)
+
+	// Topics aren't shared between namespaces:
+	assert.Empty(t, other.Messages())
+
+	store.Close()
+	<-first.Messages()
+	_, open := <-first.Messages()
+	assert.False(t, open)
+	_, err = store.Subscribe("news")
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+// Test that a subscriber that doesn't keep up is dropped, rather than holding
+// up publishers.
+func TestSlowSubscriber(t *testing.T) {
+	store, _ := NewStore[string, int]()
+	defer store.Close()
+
+	sub, _ := store.Subscribe("numbers")
+	for i := range subscriptionBufferSize {
+		store.Publish("numbers", i)
+	}
+	receivers, _ := store.Publish("numbers", subscriptionBufferSize)
+	assert.Equal(t, 0, receivers)
+
+	received := 0
+	for range sub.Messages() {
+		received++
+	}
+	assert.Equal(t, subscriptionBufferSize, received)
+}
@@ -0,0 +1,284 @@
+package kv
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many of a leader's followers a read or write must reach.
+type Consistency uint8
+
+const (
+	// A write returns once the leader has applied it, and a read returns the
+	// store's own value, even on a follower that's behind its leader.
+	ConsistencyOne Consistency = 0
+	// A write returns once a majority of the leader and its followers have
+	// applied it, and a read on a follower first catches up with its leader.
+	ConsistencyQuorum Consistency = 1
+	// A write returns once every follower has applied it, and a read on a
+	// follower first catches up with its leader.
+	ConsistencyAll Consistency = 2
+)
+
+// Returned by a write when too few followers applied it in time. The write was
+// still applied by the leader, and will still reach the followers that are
+// connected.
+var ErrNotEnoughReplicas = errors.New("Not enough followers acknowledged the update")
+
+// Returned by a read on a follower that couldn't catch up with its leader.
+var ErrNoLeader = errors.New("Follower couldn't reach its leader")
+
+// How long a write waits for its followers, or a read for its leader.
+const replicaAckTimeout = 5 * time.Second
+
+// Gets how many of a leader's followers must apply a write at this level.
+func (c Consistency) acks(followers int) int {
+	switch c {
+	case ConsistencyQuorum:
+		// The leader is part of the majority:
+		return (followers + 1) / 2
+	case ConsistencyAll:
+		return followers
+	default:
+		return 0
+	}
+}
+
+func (s *kvStore[K, V]) SetWithConsistency(key K, value V, level Consistency) (revision uint64, err error) {
+	if revision, err = s.Set(key, value); err != nil {
+		return revision, err
+	}
+
+	return revision, s.awaitReplicas(revision, level)
+}
+
+func (s *kvStore[K, V]) UnsetWithConsistency(key K, level Consistency) (revision uint64, err error) {
+	if revision, err = s.Unset(key); err != nil {
+		return revision, err
+	}
+
+	return revision, s.awaitReplicas(revision, level)
+}
+
+func (s *kvStore[K, V]) GetWithConsistency(key K, level Consistency) (value V, found bool, err error) {
+	if level != ConsistencyOne && s.following.Load() {
+		if err := s.catchUp(); err != nil {
+			return value, false, err
+		}
+	}
+
+	value, found = s.find(key)
+	return value, found, nil
+}
+
+// Waits until enough of the store's followers have applied the update at
+// `revision`, which they acknowledge as they apply it.
+func (s *kvStore[K, V]) awaitReplicas(revision uint64, level Consistency) error {
+	timeout := time.After(replicaAckTimeout)
+	for {
+		// Get the channel before counting, so an acknowledgement that arrives
+		// in between isn't missed:
+		s.ackMu.Lock()
+		acked := s.acked
+		s.ackMu.Unlock()
+
+		if s.replicated(revision, level) {
+			return nil
+		}
+		select {
+		case <-acked:
+		case <-timeout:
+			return ErrNotEnoughReplicas
+		case <-s.closing:
+			return ErrClosed
+		}
+	}
+}
+
+// Reports whether enough of the followers connected now have applied the
+// update at `revision`. Followers that connected after it was applied started
+// from a snapshot that includes it.
+func (s *kvStore[K, V]) replicated(revision uint64, level Consistency) bool {
+	s.commit.Lock()
+	defer s.commit.Unlock()
+
+	acks := 0
+	for r := range s.replicas {
+		if r.acked.Load() >= revision {
+			acks++
+		}
+	}
+	return acks >= level.acks(len(s.replicas))
+}
+
+// Wakes every write waiting for its followers, so they count them again.
+func (s *kvStore[K, V]) notifyAcks() {
+	s.ackMu.Lock()
+	defer s.ackMu.Unlock()
+
+	close(s.acked)
+	s.acked = make(chan struct{})
+}
+
+// Reads what a follower sends its leader: the revision of each update it
+// applies, requests to catch up, and, if it's promoted, a request to fence the
+// leader. The follower is dropped once it disconnects.
+func (s *kvStore[K, V]) readAcks(r *replica[K, V]) {
+	scanner := bufio.NewScanner(r.conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "sync":
+			s.commit.Lock()
+			s.sendCheckpoint(r)
+			s.commit.Unlock()
+		case strings.HasPrefix(line, "fence "):
+			s.commit.Lock()
+			s.fence(line)
+			s.commit.Unlock()
+		case strings.HasPrefix(line, "ack "):
+			if revision, err := strconv.ParseUint(line[len("ack "):], 10, 64); err == nil {
+				r.acked.Store(revision)
+				s.notifyAcks()
+			}
+		}
+	}
+
+	s.commit.Lock()
+	defer s.commit.Unlock()
+	if _, found := s.replicas[r]; found {
+		s.dropReplica(r)
+	}
+}
+
+// Queues a checkpoint for a follower, after every update the store has
+// applied, so the follower knows it has caught up once it reaches it. The
+// caller must hold `commit`.
+func (s *kvStore[K, V]) sendCheckpoint(r *replica[K, V]) {
+	if _, found := s.replicas[r]; !found {
+		return
+	}
+
+	record, err := s.encodeUpdate(update[K, V]{UpdateType: checkpoint, Revision: s.revision})
+	if err != nil {
+		return
+	}
+	select {
+	case r.records <- record:
+	default:
+		s.dropReplica(r)
+	}
+}
+
+// A follower's connection to its leader, which it acknowledges updates on,
+// and asks for checkpoints on.
+type upstream struct {
+	mu   sync.Mutex
+	conn net.Conn
+	// The reads waiting for checkpoints they've asked for, oldest first. Each
+	// is sent true when its checkpoint arrives, or closed if the connection is
+	// lost first.
+	checkpoints []chan bool
+	// Closed when the follower is promoted, to stop following, and by the
+	// goroutine that follows the leader once it has.
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+// Starts using a new connection to the leader, before its update stream is
+// read, so a read made as soon as the follower starts can ask for a
+// checkpoint. Returns false, closing the connection, if the follower has been
+// promoted.
+func (u *upstream) connect(conn net.Conn) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	select {
+	case <-u.stopped:
+		conn.Close()
+		return false
+	default:
+	}
+
+	u.conn = conn
+	return true
+}
+
+// Stops using the connection to the leader, failing every read waiting on it.
+func (u *upstream) disconnect() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.conn = nil
+	for _, reached := range u.checkpoints {
+		close(reached)
+	}
+	u.checkpoints = nil
+}
+
+// Tells the leader the follower has applied the update at `revision`.
+func (u *upstream) ack(revision uint64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.conn != nil {
+		fmt.Fprintf(u.conn, "ack %d\n", revision)
+	}
+}
+
+// Asks the leader for a checkpoint, and returns the channel that's sent true
+// once the follower reaches it.
+func (u *upstream) requestCheckpoint() (chan bool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.conn == nil {
+		return nil, ErrNoLeader
+	}
+	if _, err := fmt.Fprint(u.conn, "sync\n"); err != nil {
+		return nil, ErrNoLeader
+	}
+
+	reached := make(chan bool, 1)
+	u.checkpoints = append(u.checkpoints, reached)
+	return reached, nil
+}
+
+// Handles a checkpoint from the leader, which answers the oldest request for
+// one.
+func (u *upstream) reached() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if len(u.checkpoints) > 0 {
+		u.checkpoints[0] <- true
+		u.checkpoints = u.checkpoints[1:]
+	}
+}
+
+// Waits until the follower has applied every update its leader had applied
+// when it was called.
+func (s *kvStore[K, V]) catchUp() error {
+	reached, err := s.upstream.requestCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case ok := <-reached:
+		if !ok {
+			return ErrNoLeader
+		}
+		return nil
+	case <-time.After(replicaAckTimeout):
+		return ErrNoLeader
+	case <-s.closing:
+		return ErrClosed
+	}
+}
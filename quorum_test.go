@@ -0,0 +1,90 @@
+package kv
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsistencyAcks(t *testing.T) {
+	assert.Equal(t, 0, ConsistencyOne.acks(2))
+	assert.Equal(t, 0, ConsistencyQuorum.acks(0))
+	assert.Equal(t, 1, ConsistencyQuorum.acks(1))
+	assert.Equal(t, 1, ConsistencyQuorum.acks(2))
+	assert.Equal(t, 2, ConsistencyQuorum.acks(3))
+	assert.Equal(t, 3, ConsistencyAll.acks(3))
+}
+
+func TestWriteConsistency(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	leader, _ := NewStore[string, string](ReplicationListener(listener))
+	defer leader.Close()
+	var followers []KVStore[string, string]
+	for range 2 {
+		follower, err := NewStore[string, string](FollowerOf(listener.Addr().String()))
+		assert.NoError(t, err)
+		defer follower.Close()
+		followers = append(followers, follower)
+	}
+	store := leader.(*kvStore[string, string])
+	eventually(t, func() bool {
+		store.commit.Lock()
+		defer store.commit.Unlock()
+		return len(store.replicas) == 2
+	})
+
+	// Once a write at ConsistencyAll returns, every follower has it:
+	_, err = leader.SetWithConsistency("name", "ralph", ConsistencyAll)
+	assert.NoError(t, err)
+	for _, follower := range followers {
+		v, _ := follower.Get("name")
+		assert.Equal(t, "ralph", v)
+	}
+
+	_, err = leader.UnsetWithConsistency("name", ConsistencyQuorum)
+	assert.NoError(t, err)
+}
+
+func TestReadConsistency(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	leader, _ := NewStore[string, string](ReplicationListener(listener))
+	defer leader.Close()
+	follower, _ := NewStore[string, string](FollowerOf(listener.Addr().String()))
+	defer follower.Close()
+
+	// A read at ConsistencyQuorum sees every write that's finished on the
+	// leader:
+	for _, name := range []string{"ralph", "ziggy", "toby"} {
+		leader.Set("name", name)
+		v, found, err := follower.GetWithConsistency("name", ConsistencyQuorum)
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, name, v)
+	}
+}
+
+func TestReadConsistencyWithoutLeader(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	leader, _ := NewStore[string, string](ReplicationListener(listener))
+	follower, _ := NewStore[string, string](FollowerOf(listener.Addr().String()))
+	defer follower.Close()
+	eventually(t, func() bool {
+		_, _, err := follower.GetWithConsistency("name", ConsistencyQuorum)
+		return err == nil
+	})
+	leader.Close()
+
+	eventually(t, func() bool {
+		_, _, err := follower.GetWithConsistency("name", ConsistencyQuorum)
+		return err == ErrNoLeader
+	})
+	_, _, err = follower.GetWithConsistency("name", ConsistencyOne)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,218 @@
+package kv
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// How long a write waits to be committed by the cluster before giving up.
+const raftApplyTimeout = 10 * time.Second
+
+// Configuration for running the store as a node in a Raft cluster. Any field
+// left empty falls back to an in-memory default, which is only suitable for
+// testing: a real deployment should provide durable log and snapshot stores.
+type RaftConfig struct {
+	// Raft settings for this node. `Config.LocalID` must be unique in the cluster.
+	Config *raft.Config
+	// How this node talks to the rest of the cluster.
+	Transport raft.Transport
+	// Stores the Raft log.
+	LogStore raft.LogStore
+	// Stores Raft's term and vote metadata.
+	StableStore raft.StableStore
+	// Stores snapshots of the store's data, used to compact the Raft log.
+	SnapshotStore raft.SnapshotStore
+	// Servers to bootstrap a brand new cluster with. Leave empty when joining
+	// an existing cluster, or when restarting a node.
+	Bootstrap []raft.Server
+}
+
+// Starts a Raft node that replicates the store's updates. Once started, `Set`
+// and `Unset` are only applied after the cluster commits them, and only the
+// leader accepts them; other nodes return `raft.ErrNotLeader`.
+func (s *kvStore[K, V]) startRaft(config RaftConfig) error {
+	if config.Config == nil {
+		config.Config = raft.DefaultConfig()
+	}
+	if config.LogStore == nil {
+		config.LogStore = raft.NewInmemStore()
+	}
+	if config.StableStore == nil {
+		config.StableStore = raft.NewInmemStore()
+	}
+	if config.SnapshotStore == nil {
+		config.SnapshotStore = raft.NewInmemSnapshotStore()
+	}
+	if config.Transport == nil {
+		_, config.Transport = raft.NewInmemTransport(raft.ServerAddress(config.Config.LocalID))
+	}
+
+	r, err := raft.NewRaft(config.Config, &raftFSM[K, V]{s}, config.LogStore, config.StableStore, config.SnapshotStore, config.Transport)
+	if err != nil {
+		return err
+	}
+
+	if len(config.Bootstrap) > 0 {
+		err := r.BootstrapCluster(raft.Configuration{Servers: config.Bootstrap}).Error()
+		if err != nil && err != raft.ErrCantBootstrap {
+			r.Shutdown()
+			return err
+		}
+	}
+
+	s.raft = r
+	return nil
+}
+
+// Proposes an update to the cluster, and returns a function that waits for it
+// to be applied. Its result is the result of applying the update to this node,
+// as decided by its `raftFSM`.
+func (s *kvStore[K, V]) propose(u update[K, V]) (wait func() updateResult[V], err error) {
+	record, err := s.encodeUpdate(u)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode update for the cluster: %w", err)
+	}
+
+	future := s.raft.Apply(record, raftApplyTimeout)
+	return func() updateResult[V] {
+		if err := future.Error(); err != nil {
+			return updateResult[V]{err: err}
+		}
+
+		return future.Response().(updateResult[V])
+	}, nil
+}
+
+// Applies committed Raft log entries to the store. Each entry is sent through
+// the store's update queue, and written to its write-ahead log if it has one.
+type raftFSM[K comparable, V any] struct {
+	store *kvStore[K, V]
+}
+
+// Returns the `updateResult` of the entry's update. Conditional updates are
+// resolved against this node's data, which is the same on every node. Entries
+// the store already applied before it restarted, and replayed from its own
+// log, are skipped.
+func (f *raftFSM[K, V]) Apply(entry *raft.Log) interface{} {
+	if entry.Index <= f.store.appliedRaftIndex() {
+		return updateResult[V]{ok: true}
+	}
+
+	u, err := f.store.decodeUpdate(entry.Data)
+	if err != nil {
+		return updateResult[V]{err: err}
+	}
+
+	u.RaftIndex = entry.Index
+	u.append = f.store.appends()
+	return f.store.queueUpdate(u)
+}
+
+// Returns the index of the last Raft log entry applied to the store.
+func (s *kvStore[K, V]) appliedRaftIndex() uint64 {
+	s.commit.Lock()
+	defer s.commit.Unlock()
+	return s.raftIndex
+}
+
+// Copies the store's data, along with its revision and the index of the last
+// entry applied to it. Raft never calls `Snapshot` concurrently with `Apply`,
+// and all writes go through `Apply`, so the data can't change while it's being
+// copied.
+func (f *raftFSM[K, V]) Snapshot() (raft.FSMSnapshot, error) {
+	f.store.commit.Lock()
+	header := raftSnapshotHeader{Index: f.store.raftIndex, Revision: f.store.revision}
+	f.store.commit.Unlock()
+
+	var entries []entry[K, V]
+	for _, sh := range f.store.shards {
+		for namespace, b := range sh.namespaces() {
+			for k, v := range b.values.all() {
+				entries = append(entries, entry[K, V]{Namespace: namespace, Key: k, Value: v})
+			}
+		}
+	}
+
+	return &raftSnapshot[K, V]{header, entries}, nil
+}
+
+// Replaces the store's data, in every namespace, with a snapshot written by
+// `raftSnapshot.Persist`, and carries on from its revision. A snapshot the
+// store has already applied every entry of, such as the one Raft restores when
+// a store with a log restarts, is skipped, since the store's data is newer.
+func (f *raftFSM[K, V]) Restore(snapshot io.ReadCloser) error {
+	defer snapshot.Close()
+
+	r := bufio.NewReader(snapshot)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	var header raftSnapshotHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return err
+	}
+	if header.Index <= f.store.appliedRaftIndex() {
+		return nil
+	}
+
+	entries, err := readEntries[K, V](r)
+	if err != nil {
+		return err
+	}
+
+	byNamespace := make(map[string][]entry[K, V])
+	for _, e := range entries {
+		byNamespace[e.Namespace] = append(byNamespace[e.Namespace], e)
+	}
+
+	restart := f.store.newUpdate(truncate, *new(K), *new(V))
+	restart.Revision, restart.RaftIndex = header.Revision, header.Index
+	if err := f.store.queueUpdate(restart).err; err != nil {
+		return err
+	}
+	for namespace, entries := range byNamespace {
+		u := f.store.newUpdate(setMany, *new(K), *new(V))
+		u.Namespace, u.Entries, u.Revision = namespace, entries, header.Revision
+		if err := f.store.queueUpdate(u).err; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// A point-in-time copy of the store's data, used by Raft to compact its log.
+type raftSnapshot[K comparable, V any] struct {
+	header  raftSnapshotHeader
+	entries []entry[K, V]
+}
+
+// The first line of a Raft snapshot: the store's revision, and the index of the
+// last entry applied to it, when it was taken.
+type raftSnapshotHeader struct {
+	Index    uint64
+	Revision uint64
+}
+
+// Writes the snapshot's header as a line of JSON, then its entries in the same
+// format as `Backup`, with each entry's namespace.
+func (s *raftSnapshot[K, V]) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s.header); err != nil {
+		sink.Cancel()
+		return err
+	}
+	if err := writeEntries(sink, s.entries); err != nil {
+		sink.Cancel()
+		return err
+	}
+
+	return sink.Close()
+}
+
+func (s *raftSnapshot[K, V]) Release() {}
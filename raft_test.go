@@ -0,0 +1,153 @@
+package kv
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+// Starts a cluster of stores connected by in-memory transports.
+func newCluster(t *testing.T, size int) []KVStore[string, string] {
+	transports := make([]*raft.InmemTransport, size)
+	servers := make([]raft.Server, size)
+	for i := range transports {
+		id := raft.ServerID(fmt.Sprintf("node-%d", i))
+		addr, transport := raft.NewInmemTransport(raft.ServerAddress(id))
+		transports[i] = transport
+		servers[i] = raft.Server{ID: id, Address: addr}
+	}
+	for _, a := range transports {
+		for _, b := range transports {
+			a.Connect(b.LocalAddr(), b)
+		}
+	}
+
+	stores := make([]KVStore[string, string], size)
+	for i := range stores {
+		config := raft.DefaultConfig()
+		config.LocalID = servers[i].ID
+		config.LogOutput = io.Discard
+		config.HeartbeatTimeout = 50 * time.Millisecond
+		config.ElectionTimeout = 50 * time.Millisecond
+		config.LeaderLeaseTimeout = 50 * time.Millisecond
+
+		store, err := NewStore[string, string](Raft(RaftConfig{
+			Config:    config,
+			Transport: transports[i],
+			Bootstrap: servers,
+		}))
+		assert.NoError(t, err)
+		stores[i] = store
+	}
+
+	return stores
+}
+
+// Keeps trying a write against every node until the leader accepts it.
+func setOnLeader(t *testing.T, stores []KVStore[string, string], key, value string) {
+	assert.Eventually(t, func() bool {
+		for _, store := range stores {
+			if _, err := store.Set(key, value); err == nil {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestRaftCluster(t *testing.T) {
+	stores := newCluster(t, 3)
+	defer func() {
+		for _, store := range stores {
+			store.Close()
+		}
+	}()
+
+	setOnLeader(t, stores, "a", "a")
+
+	// Every node serves reads of the committed write:
+	for _, store := range stores {
+		eventually(t, func() bool {
+			v, _ := store.Get("a")
+			return v == "a"
+		})
+	}
+
+	// Nodes that aren't the leader refuse writes:
+	rejected := 0
+	for _, store := range stores {
+		if _, err := store.Set("b", "b"); err == raft.ErrNotLeader {
+			rejected++
+		}
+	}
+	assert.Equal(t, 2, rejected)
+}
+
+func TestRaftRestart(t *testing.T) {
+	defer removeLog()
+
+	// Raft's stores outlive the node, as durable ones would:
+	logs, stable, snapshots := raft.NewInmemStore(), raft.NewInmemStore(), raft.NewInmemSnapshotStore()
+	start := func() KVStore[string, string] {
+		config := raft.DefaultConfig()
+		config.LocalID = "node"
+		config.LogOutput = io.Discard
+		config.HeartbeatTimeout = 50 * time.Millisecond
+		config.ElectionTimeout = 50 * time.Millisecond
+		config.LeaderLeaseTimeout = 50 * time.Millisecond
+		addr, transport := raft.NewInmemTransport("node")
+
+		store, err := NewStore[string, string](LogPath(logPath), Raft(RaftConfig{
+			Config:        config,
+			Transport:     transport,
+			LogStore:      logs,
+			StableStore:   stable,
+			SnapshotStore: snapshots,
+			Bootstrap:     []raft.Server{{ID: "node", Address: addr}},
+		}))
+		assert.NoError(t, err)
+		return store
+	}
+
+	store := start()
+	setOnLeader(t, []KVStore[string, string]{store}, "k", "a")
+	_, err := store.Append("k", "b")
+	assert.NoError(t, err)
+	store.Close()
+
+	// Entries the store's log already has aren't applied again:
+	store = start()
+	setOnLeader(t, []KVStore[string, string]{store}, "other", "x")
+	v, _ := store.Get("k")
+	assert.Equal(t, "ab", v)
+	revision, err := store.Append("k", "c")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), revision)
+
+	// Nor are the entries of a snapshot the store's log is ahead of:
+	assert.NoError(t, store.(*kvStore[string, string]).raft.Snapshot().Error())
+	_, err = store.Append("k", "d")
+	assert.NoError(t, err)
+	store.Close()
+
+	store = start()
+	setOnLeader(t, []KVStore[string, string]{store}, "other", "y")
+	v, _ = store.Get("k")
+	assert.Equal(t, "abcd", v)
+
+	// Without its log, the store is rebuilt from Raft's snapshot and log:
+	store.Close()
+	removeLog()
+	store = start()
+	setOnLeader(t, []KVStore[string, string]{store}, "other", "z")
+	v, _ = store.Get("k")
+	assert.Equal(t, "abcd", v)
+	revision, err = store.Set("k", "e")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(8), revision)
+	store.Close()
+}
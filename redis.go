@@ -0,0 +1,453 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The CRC-64 variant that checksums RDB files, with its polynomial reversed.
+var redisCRCTable = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+// Reads an RDB file, tracking the checksum of what it has read.
+type rdbReader struct {
+	r   *bufio.Reader
+	crc uint64
+}
+
+func (r *rdbReader) read(n int) ([]byte, error) {
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, err
+	}
+
+	r.crc = ^crc64.Update(^r.crc, redisCRCTable, data)
+	return data, nil
+}
+
+func (r *rdbReader) readByte() (byte, error) {
+	b, err := r.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// Reads a length, or, if `special` is returned true, the format of a string
+// that's encoded specially.
+func (r *rdbReader) readLength() (length uint64, special bool, err error) {
+	first, err := r.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch first >> 6 {
+	case 0:
+		return uint64(first & 0x3f), false, nil
+	case 1:
+		next, err := r.readByte()
+		return uint64(first&0x3f)<<8 | uint64(next), false, err
+	case 2:
+		switch first {
+		case 0x80:
+			data, err := r.read(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(data)), false, nil
+		case 0x81:
+			data, err := r.read(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(data), false, nil
+		default:
+			return 0, false, fmt.Errorf("Invalid length in RDB file: %#x", first)
+		}
+	default:
+		return uint64(first & 0x3f), true, nil
+	}
+}
+
+func (r *rdbReader) readString() (string, error) {
+	length, special, err := r.readLength()
+	if err != nil {
+		return "", err
+	}
+	if !special {
+		data, err := r.read(int(length))
+		return string(data), err
+	}
+
+	// Integers are encoded as little-endian binary, and long strings may be
+	// compressed with LZF:
+	switch length {
+	case 0, 1, 2:
+		data, err := r.read(1 << length)
+		if err != nil {
+			return "", err
+		}
+		var n int64
+		switch length {
+		case 0:
+			n = int64(int8(data[0]))
+		case 1:
+			n = int64(int16(binary.LittleEndian.Uint16(data)))
+		case 2:
+			n = int64(int32(binary.LittleEndian.Uint32(data)))
+		}
+		return strconv.FormatInt(n, 10), nil
+	case 3:
+		compressedLength, _, err := r.readLength()
+		if err != nil {
+			return "", err
+		}
+		length, _, err := r.readLength()
+		if err != nil {
+			return "", err
+		}
+		compressed, err := r.read(int(compressedLength))
+		if err != nil {
+			return "", err
+		}
+		return lzfDecompress(compressed, int(length))
+	default:
+		return "", fmt.Errorf("Invalid string encoding in RDB file: %d", length)
+	}
+}
+
+// Decompresses data compressed with LZF, as Redis compresses long strings.
+func lzfDecompress(in []byte, length int) (string, error) {
+	out := make([]byte, 0, length)
+	for i := 0; i < len(in); {
+		control := int(in[i])
+		i++
+
+		if control < 32 {
+			// A run of literal bytes:
+			end := i + control + 1
+			if end > len(in) {
+				return "", errors.New("Invalid LZF data in RDB file")
+			}
+			out = append(out, in[i:end]...)
+			i = end
+			continue
+		}
+
+		// A back reference to bytes already decompressed:
+		n := control >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return "", errors.New("Invalid LZF data in RDB file")
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return "", errors.New("Invalid LZF data in RDB file")
+		}
+		ref := len(out) - (control&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return "", errors.New("Invalid LZF data in RDB file")
+		}
+		for j := 0; j < n+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != length {
+		return "", errors.New("Invalid LZF data in RDB file")
+	}
+	return string(out), nil
+}
+
+// Skips a value that isn't a string, of the given RDB type.
+func (r *rdbReader) skipValue(valueType byte) error {
+	// How many strings each element of a collection holds, and whether each
+	// is followed by a score:
+	perElement, scoreLength := 0, 0
+	switch valueType {
+	case 1, 2, 14:
+		// Lists, sets, and quicklists of ziplists:
+		perElement = 1
+	case 3:
+		// Sorted sets with scores as strings:
+		perElement = 1
+		scoreLength = -1
+	case 4:
+		// Hashes:
+		perElement = 2
+	case 5:
+		// Sorted sets with binary scores:
+		perElement = 1
+		scoreLength = 8
+	case 9, 10, 11, 12, 13, 16, 17, 20:
+		// Types encoded as a single string:
+		_, err := r.readString()
+		return err
+	case 18:
+		// Quicklists of listpacks, with their container type:
+		perElement = 1
+		scoreLength = -2
+	default:
+		return fmt.Errorf("Cannot import Redis values of type %d", valueType)
+	}
+
+	count, _, err := r.readLength()
+	if err != nil {
+		return err
+	}
+	for ; count > 0; count-- {
+		if scoreLength == -2 {
+			if _, _, err := r.readLength(); err != nil {
+				return err
+			}
+		}
+		for range perElement {
+			if _, err := r.readString(); err != nil {
+				return err
+			}
+		}
+		switch scoreLength {
+		case -1:
+			n, err := r.readByte()
+			if err != nil {
+				return err
+			}
+			if n < 253 {
+				if _, err := r.read(int(n)); err != nil {
+					return err
+				}
+			}
+		case 8:
+			if _, err := r.read(8); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Reads the string keys of database 0 in a Redis RDB file into `data`,
+// skipping keys that have expired, and values of other types.
+func readRedisRDB(r *bufio.Reader, data map[string]string) error {
+	rdb := &rdbReader{r: r}
+	header, err := rdb.read(9)
+	if err != nil || !bytes.HasPrefix(header, []byte("REDIS")) {
+		return errors.New("Not a Redis RDB file")
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil {
+		return errors.New("Not a Redis RDB file")
+	}
+
+	database := uint64(0)
+	expiresAt := time.Time{}
+	for {
+		opcode, err := rdb.readByte()
+		if err != nil {
+			return err
+		}
+
+		switch opcode {
+		case 0xff:
+			// The end of the file, followed by its checksum, unless it was
+			// written without one:
+			crc := rdb.crc
+			if version >= 5 {
+				checksum, err := rdb.read(8)
+				if err != nil {
+					return err
+				}
+				if stored := binary.LittleEndian.Uint64(checksum); stored != 0 && stored != crc {
+					return errors.New("RDB file's checksum doesn't match")
+				}
+			}
+			return nil
+		case 0xfe:
+			if database, _, err = rdb.readLength(); err != nil {
+				return err
+			}
+		case 0xfd:
+			seconds, err := rdb.read(4)
+			if err != nil {
+				return err
+			}
+			expiresAt = time.Unix(int64(binary.LittleEndian.Uint32(seconds)), 0)
+		case 0xfc:
+			milliseconds, err := rdb.read(8)
+			if err != nil {
+				return err
+			}
+			expiresAt = time.UnixMilli(int64(binary.LittleEndian.Uint64(milliseconds)))
+		case 0xfb:
+			// The sizes of the database's hash tables:
+			for range 2 {
+				if _, _, err := rdb.readLength(); err != nil {
+					return err
+				}
+			}
+		case 0xfa:
+			// Auxiliary fields, like the version of Redis:
+			for range 2 {
+				if _, err := rdb.readString(); err != nil {
+					return err
+				}
+			}
+		case 0xf8:
+			// How often the next key is used:
+			if _, err := rdb.readByte(); err != nil {
+				return err
+			}
+		case 0xf7:
+			// How long the next key has been idle:
+			if _, _, err := rdb.readLength(); err != nil {
+				return err
+			}
+		case 0xf5:
+			// The code of a function library:
+			if _, err := rdb.readString(); err != nil {
+				return err
+			}
+		case 0xf4:
+			// The sizes of a cluster slot's hash tables:
+			for range 3 {
+				if _, _, err := rdb.readLength(); err != nil {
+					return err
+				}
+			}
+		default:
+			key, err := rdb.readString()
+			if err != nil {
+				return err
+			}
+			if opcode != 0 {
+				if err := rdb.skipValue(opcode); err != nil {
+					return err
+				}
+			} else {
+				value, err := rdb.readString()
+				if err != nil {
+					return err
+				}
+				if database == 0 && (expiresAt.IsZero() || expiresAt.After(time.Now())) {
+					data[key] = value
+				}
+			}
+			expiresAt = time.Time{}
+		}
+	}
+}
+
+// Reads a command from a Redis AOF file, in RESP: an array of bulk strings.
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("Invalid command in AOF file: %q", line)
+	}
+	count, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid command in AOF file: %q", line)
+	}
+
+	args := make([]string, count)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+		length, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+		if !strings.HasPrefix(line, "$") || err != nil {
+			return nil, fmt.Errorf("Invalid argument in AOF file: %q", line)
+		}
+
+		arg := make([]byte, length+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		args[i] = string(arg[:length])
+	}
+
+	return args, nil
+}
+
+// Reads a Redis AOF file into `data`, by replaying the commands that write
+// string keys in database 0. An AOF file that starts with an RDB preamble has
+// the preamble read first.
+func readRedisAOF(r *bufio.Reader, data map[string]string) error {
+	if preamble, _ := r.Peek(5); string(preamble) == "REDIS" {
+		if err := readRedisRDB(r, data); err != nil {
+			return err
+		}
+	}
+
+	database := "0"
+	for {
+		args, err := readRESPCommand(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		command := strings.ToUpper(args[0])
+		switch {
+		case command == "SELECT" && len(args) == 2:
+			database = args[1]
+		case command == "FLUSHALL":
+			clear(data)
+		}
+		if database != "0" {
+			continue
+		}
+
+		switch {
+		case command == "SET" && len(args) >= 3:
+			data[args[1]] = args[2]
+		case command == "SETNX" && len(args) == 3:
+			if _, found := data[args[1]]; !found {
+				data[args[1]] = args[2]
+			}
+		case (command == "SETEX" || command == "PSETEX") && len(args) == 4:
+			data[args[1]] = args[3]
+		case command == "MSET" && len(args)%2 == 1:
+			for i := 1; i < len(args); i += 2 {
+				data[args[i]] = args[i+1]
+			}
+		case command == "APPEND" && len(args) == 3:
+			data[args[1]] += args[2]
+		case command == "DEL" || command == "UNLINK":
+			for _, key := range args[1:] {
+				delete(data, key)
+			}
+		case (command == "PEXPIREAT" || command == "EXPIREAT") && len(args) >= 3:
+			at, err := strconv.ParseInt(args[2], 10, 64)
+			if command == "EXPIREAT" {
+				at *= 1000
+			}
+			if err == nil && time.UnixMilli(at).Before(time.Now()) {
+				delete(data, args[1])
+			}
+		case command == "FLUSHDB":
+			clear(data)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a Redis RDB file with string keys in two databases, one that has
+// expired, a list, and an integer and a compressed string.
+func redisRDB() []byte {
+	str := func(s string) []byte { return append([]byte{byte(len(s))}, s...) }
+	expiry := func(at time.Time) []byte {
+		return binary.LittleEndian.AppendUint64([]byte{0xfc}, uint64(at.UnixMilli()))
+	}
+
+	var rdb bytes.Buffer
+	rdb.WriteString("REDIS0011")
+	rdb.Write(append(append([]byte{0xfa}, str("redis-ver")...), str("7.2.4")...))
+	rdb.Write([]byte{0xfe, 0, 0xfb, 5, 2})
+	rdb.Write(append(append([]byte{0}, str("name")...), str("ralph")...))
+	rdb.Write(append(append([]byte{0}, str("count")...), 0xc0, 42))
+	rdb.Write(append(append([]byte{0}, str("big")...), 0xc3, 5, 10, 0x00, 'a', 0xe0, 0x00, 0x00))
+	rdb.Write(expiry(time.Now().Add(-time.Hour)))
+	rdb.Write(append(append([]byte{0}, str("expired")...), str("x")...))
+	rdb.Write(expiry(time.Now().Add(time.Hour)))
+	rdb.Write(append(append([]byte{0}, str("expiring")...), str("y")...))
+	rdb.Write(append(append(append([]byte{1}, str("list")...), 2), append(str("a"), str("b")...)...))
+	rdb.Write([]byte{0xfe, 1})
+	rdb.Write(append(append([]byte{0}, str("other")...), str("z")...))
+	rdb.WriteByte(0xff)
+
+	checksum := ^crc64.Update(^uint64(0), redisCRCTable, rdb.Bytes())
+	return binary.LittleEndian.AppendUint64(rdb.Bytes(), checksum)
+}
+
+func TestRedisCRC(t *testing.T) {
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), ^crc64.Update(^uint64(0), redisCRCTable, []byte("123456789")))
+}
+
+func TestImportRedisRDB(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	store.Set("kept", "k")
+
+	assert.NoError(t, store.Import(bytes.NewReader(redisRDB()), RedisRDB))
+	assert.Equal(t, map[string]string{
+		"kept":     "k",
+		"name":     "ralph",
+		"count":    "42",
+		"big":      "aaaaaaaaaa",
+		"expiring": "y",
+	}, store.GetAll())
+
+	// Values are parsed for the store's value type:
+	counts, _ := NewStore[string, int]()
+	assert.Error(t, counts.Import(bytes.NewReader(redisRDB()), RedisRDB))
+}
+
+func TestImportCorruptRedisRDB(t *testing.T) {
+	store, _ := NewStore[string, string]()
+
+	rdb := redisRDB()
+	rdb[len(rdb)-1] ^= 0xff
+	assert.Error(t, store.Import(bytes.NewReader(rdb), RedisRDB))
+	assert.Error(t, store.Import(bytes.NewReader(rdb[:20]), RedisRDB))
+	assert.Error(t, store.Import(strings.NewReader("not an RDB file"), RedisRDB))
+	assert.Equal(t, 0, store.Len())
+}
+
+func TestImportRedisAOF(t *testing.T) {
+	resp := func(args ...string) string {
+		command := "*" + string(rune('0'+len(args))) + "\r\n"
+		for _, arg := range args {
+			command += "$" + string(rune('0'+len(arg))) + "\r\n" + arg + "\r\n"
+		}
+		return command
+	}
+	aof := resp("SELECT", "0") +
+		resp("SET", "a", "1") +
+		resp("MSET", "b", "2", "c", "3") +
+		resp("APPEND", "a", "1") +
+		resp("SETEX", "d", "60", "4") +
+		resp("DEL", "b") +
+		resp("SET", "e", "5") +
+		resp("PEXPIREAT", "e", "1000") +
+		resp("SELECT", "1") +
+		resp("SET", "f", "6") +
+		resp("SELECT", "0") +
+		resp("SADD", "g", "7")
+
+	store, _ := NewStore[string, int]()
+	assert.NoError(t, store.Import(strings.NewReader(aof), RedisAOF))
+	assert.Equal(t, map[string]int{"a": 11, "c": 3, "d": 4}, store.GetAll())
+
+	// With an RDB preamble, which is read first:
+	strs, _ := NewStore[string, string]()
+	assert.NoError(t, strs.Import(bytes.NewReader(append(redisRDB(), resp("DEL", "name")...)), RedisAOF))
+	assert.Equal(t, map[string]string{"count": "42", "big": "aaaaaaaaaa", "expiring": "y"}, strs.GetAll())
+
+	assert.Error(t, store.Import(strings.NewReader("SET a 1\r\n"), RedisAOF))
+	assert.ErrorIs(t, store.Export(&bytes.Buffer{}, RedisAOF), ErrUnknownFormat)
+}
@@ -0,0 +1,37 @@
+package kv
+
+import "errors"
+
+// Returned by `Rename` and `RenameIfNotExists` when the key to rename isn't in
+// the store.
+var ErrKeyNotFound = errors.New("Key isn't in the store")
+
+func (s *kvStore[K, V]) Rename(oldKey K, newKey K) error {
+	u := s.newUpdate(rename, oldKey, *new(V))
+	u.To = newKey
+	return s.write(u).err
+}
+
+func (s *kvStore[K, V]) RenameIfNotExists(oldKey K, newKey K) (renamed bool, err error) {
+	u := s.newUpdate(renameIfNotExists, oldKey, *new(V))
+	u.To = newKey
+	result := s.write(u)
+	return result.ok, result.err
+}
+
+// Moves the value of a `rename` update's key to its `To` key, with the lease
+// the key is attached to, if any. The caller must have paused every shard.
+func (s *kvStore[K, V]) moveKey(update update[K, V]) error {
+	from, to := namespacedKey[K]{update.Namespace, update.Key}, namespacedKey[K]{update.Namespace, update.To}
+	lease := s.leased[from]
+	s.shardFor(update.Key).lookup(update.Namespace).remove(update.Key)
+	s.attach(from, 0)
+	s.stamp(from, update)
+	if err := s.shardFor(update.To).bucket(update.Namespace).put(update.To, update.Value); err != nil {
+		return err
+	}
+	s.attach(to, lease)
+	s.stamp(to, update)
+
+	return nil
+}
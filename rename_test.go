@@ -0,0 +1,76 @@
+package kv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRename(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath), Shards(4))
+	store.Set("draft", "hello")
+	store.Set("other", "world")
+
+	err := store.Rename("draft", "post")
+	assert.NoError(t, err)
+	_, found := store.Get("draft")
+	assert.False(t, found)
+	v, _ := store.Get("post")
+	assert.Equal(t, "hello", v)
+
+	renamed, err := store.RenameIfNotExists("post", "other")
+	assert.NoError(t, err)
+	assert.False(t, renamed)
+	v, _ = store.Get("post")
+	assert.Equal(t, "hello", v)
+
+	// Rename replaces the value a key already has:
+	assert.NoError(t, store.Rename("post", "other"))
+	v, _ = store.Get("other")
+	assert.Equal(t, "hello", v)
+
+	assert.ErrorIs(t, store.Rename("missing", "other"), ErrKeyNotFound)
+	_, err = store.RenameIfNotExists("missing", "new")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	store.Close()
+
+	replayed, _ := NewStore[string, string](LogPath(logPath), Shards(4))
+	defer replayed.Close()
+	assert.Equal(t, []string{"other"}, replayed.Keys())
+	v, _ = replayed.Get("other")
+	assert.Equal(t, "hello", v)
+}
+
+func TestRenameKeepsLease(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	defer store.Close()
+
+	lease, _ := store.GrantLease(time.Minute)
+	store.SetWithLease("session", "ralph", lease)
+	store.Rename("session", "renamed")
+	store.RevokeLease(lease)
+
+	_, found := store.Get("renamed")
+	assert.False(t, found)
+}
+
+func TestRenameHistory(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath))
+	defer store.Close()
+	revision, _ := store.Set("a", "value")
+	store.Rename("a", "b")
+
+	_, found, err := store.GetAt("b", revision)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	v, found, _ := store.GetAt("b", revision+1)
+	assert.True(t, found)
+	assert.Equal(t, "value", v)
+	_, found, _ = store.GetAt("a", revision+1)
+	assert.False(t, found)
+}
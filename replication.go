@@ -0,0 +1,254 @@
+package kv
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// How many updates can be waiting to be sent to a follower before the leader
+// considers it too slow and disconnects it.
+const replicaBufferSize = 1024
+
+// How long a follower waits before reconnecting to a leader it lost.
+const reconnectInterval = time.Second
+
+// A follower connected to a leader. The leader's update loops queue marshaled
+// updates in `records`, and a separate goroutine writes them to `conn`.
+type replica[K comparable, V any] struct {
+	conn net.Conn
+	// Update records that make up the follower's initial state.
+	snapshot [][]byte
+	// Update records applied after the snapshot was taken.
+	records chan []byte
+	// Closed by the writer goroutine if it can no longer write to `conn`.
+	gone chan struct{}
+	// Whether records are binary, so they're sent as base64.
+	binary bool
+	// The revision of the latest update the follower has acknowledged.
+	acked atomic.Uint64
+}
+
+// Accepts connections from followers until the listener is closed.
+func (s *kvStore[K, V]) acceptReplicas() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		if s.fenced.Load() {
+			conn.Close()
+			continue
+		}
+
+		r := &replica[K, V]{
+			conn:    conn,
+			records: make(chan []byte, replicaBufferSize),
+			gone:    make(chan struct{}),
+			binary:  s.options.codec != JSONCodec,
+		}
+		if err := s.queueUpdate(update[K, V]{UpdateType: subscribe, replica: r}).err; err != nil {
+			conn.Close()
+			return
+		}
+
+		go r.stream()
+		go s.readAcks(r)
+	}
+}
+
+// Registers a follower, capturing a snapshot of the store for it to start from.
+// Called while every shard is paused, so the snapshot is consistent with the
+// updates that will be streamed after it. Its records carry the store's current
+// revision, so the follower's revisions carry on from the leader's.
+func (s *kvStore[K, V]) addReplica(r *replica[K, V]) {
+	records, err := s.encodeUpdates(s.currentUpdates())
+	if err != nil {
+		r.conn.Close()
+		return
+	}
+
+	r.snapshot = records
+	s.replicas[r] = struct{}{}
+}
+
+// Queues an update record for every follower. Followers that have disconnected,
+// or have fallen too far behind, are dropped. The caller must hold `commit`.
+func (s *kvStore[K, V]) broadcast(record []byte) {
+	for r := range s.replicas {
+		select {
+		case <-r.gone:
+			s.dropReplica(r)
+			continue
+		default:
+		}
+
+		select {
+		case r.records <- record:
+		default:
+			s.options.logger.Warn("Dropped follower that fell behind", "addr", r.conn.RemoteAddr().String())
+			s.dropReplica(r)
+		}
+	}
+}
+
+// Stops streaming to a follower. The caller must hold `commit`.
+func (s *kvStore[K, V]) dropReplica(r *replica[K, V]) {
+	delete(s.replicas, r)
+	close(r.records)
+	s.notifyAcks()
+}
+
+// Stops streaming to every follower. The caller must hold `commit`.
+func (s *kvStore[K, V]) dropReplicas() {
+	for r := range s.replicas {
+		s.dropReplica(r)
+	}
+}
+
+// Writes the follower's snapshot followed by every update queued for it.
+func (r *replica[K, V]) stream() {
+	defer r.conn.Close()
+
+	w := bufio.NewWriter(r.conn)
+	write := func(record []byte) error {
+		if _, err := fmt.Fprintf(w, "%s\n", frame(record, r.binary)); err != nil {
+			return err
+		}
+		// Only flush once there's nothing else waiting to be sent:
+		if len(r.records) == 0 {
+			return w.Flush()
+		}
+		return nil
+	}
+
+	for _, record := range r.snapshot {
+		if err := write(record); err != nil {
+			close(r.gone)
+			return
+		}
+	}
+	r.snapshot = nil
+
+	for record := range r.records {
+		if err := write(record); err != nil {
+			close(r.gone)
+			return
+		}
+	}
+}
+
+// Connects to the store's leader and starts applying its update stream. If
+// the connection is lost, the follower keeps reconnecting until it is closed,
+// or promoted.
+func (s *kvStore[K, V]) followLeader() error {
+	conn, err := net.Dial("tcp", s.options.leaderAddr)
+	if err != nil {
+		return err
+	}
+	s.upstream.connect(conn)
+
+	go func() {
+		defer close(s.upstream.done)
+		for {
+			s.applyStream(conn)
+			select {
+			case <-s.closing:
+			case <-s.upstream.stopped:
+			default:
+				s.options.logger.Warn("Lost connection to leader, reconnecting", "addr", s.options.leaderAddr)
+			}
+
+			for {
+				select {
+				case <-s.closing:
+					return
+				case <-s.upstream.stopped:
+					return
+				case <-time.After(reconnectInterval):
+				}
+
+				if conn, err = net.Dial("tcp", s.options.leaderAddr); err == nil {
+					if !s.upstream.connect(conn) {
+						return
+					}
+					break
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Reads update records from a leader and sends them to the `updates` queue,
+// acknowledging each one once it's applied, until the connection fails or the
+// store is closed.
+func (s *kvStore[K, V]) applyStream(conn net.Conn) {
+	// Unblock the scanner below if the store is closed while it's reading:
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-s.closing:
+		case <-stopped:
+		}
+		conn.Close()
+	}()
+
+	defer s.upstream.disconnect()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, maxStreamLineSize)
+	for scanner.Scan() {
+		record, err := unframe(scanner.Bytes(), s.options.codec != JSONCodec)
+		if err != nil {
+			return
+		}
+		u, err := s.decodeUpdate(record)
+		if err != nil {
+			return
+		}
+		if u.UpdateType == checkpoint {
+			s.upstream.reached()
+			continue
+		}
+		// A leader that's older than the follower, such as one that a follower
+		// has since been promoted over, is fenced:
+		if u.UpdateType == truncate && u.Epoch < s.currentEpoch() {
+			return
+		}
+
+		u.append = s.appends()
+		if err := s.queueUpdate(u).err; err != nil {
+			return
+		}
+		s.upstream.ack(u.Revision)
+	}
+}
+
+// The longest line of the update stream: the largest record the log can hold,
+// once it's framed as base64.
+const maxStreamLineSize = (maxRecordSize + 2) / 3 * 4
+
+// Turns a record into a line of the update stream. Binary records are sent as
+// base64, so they can't contain a newline.
+func frame(record []byte, binary bool) []byte {
+	if !binary {
+		return record
+	}
+
+	return []byte(base64.StdEncoding.EncodeToString(record))
+}
+
+// Turns a line of the update stream back into a record.
+func unframe(line []byte, binary bool) ([]byte, error) {
+	if !binary {
+		return line, nil
+	}
+
+	return base64.StdEncoding.AppendDecode(nil, line)
+}
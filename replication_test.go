@@ -0,0 +1,97 @@
+package kv
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Waits for a follower to catch up with its leader.
+func eventually(t *testing.T, condition func() bool) {
+	assert.Eventually(t, condition, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestReplication(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	leader, err := NewStore[string, string](ReplicationListener(listener))
+	assert.NoError(t, err)
+	defer leader.Close()
+	leader.Set("a", "a")
+	leader.Set("b", "b")
+
+	follower, err := NewStore[string, string](FollowerOf(listener.Addr().String()))
+	assert.NoError(t, err)
+	defer follower.Close()
+
+	// The follower starts from a snapshot of the leader:
+	eventually(t, func() bool {
+		v, _ := follower.Get("b")
+		return v == "b"
+	})
+
+	// Then receives updates in real time:
+	leader.Set("c", "c")
+	leader.Unset("a")
+	eventually(t, func() bool {
+		_, found := follower.Get("a")
+		v, _ := follower.Get("c")
+		return !found && v == "c"
+	})
+}
+
+func TestReplicationLargeValues(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec, MsgpackCodec} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+
+		leader, err := NewStore[string, string](ReplicationListener(listener), LogCodec(codec))
+		assert.NoError(t, err)
+		defer leader.Close()
+		// Values larger than a scanner's default buffer, in the snapshot and
+		// the stream:
+		large := strings.Repeat("x", 100<<10)
+		leader.Set("a", large)
+
+		follower, err := NewStore[string, string](FollowerOf(listener.Addr().String()), LogCodec(codec))
+		assert.NoError(t, err)
+		defer follower.Close()
+		eventually(t, func() bool {
+			v, _ := follower.Get("a")
+			return v == large
+		})
+
+		leader.Set("b", large)
+		eventually(t, func() bool {
+			v, _ := follower.Get("b")
+			return v == large
+		})
+	}
+}
+
+func TestFollowerRejectsWrites(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	leader, _ := NewStore[string, string](ReplicationListener(listener))
+	defer leader.Close()
+	follower, err := NewStore[string, string](FollowerOf(listener.Addr().String()))
+	assert.NoError(t, err)
+	defer follower.Close()
+
+	_, err = follower.Set("a", "a")
+	assert.ErrorIs(t, err, ErrFollower)
+	_, err = follower.Unset("a")
+	assert.ErrorIs(t, err, ErrFollower)
+}
+
+func TestClose(t *testing.T) {
+	store, _ := NewStore[string, string]()
+	assert.NoError(t, store.Close())
+	_, err := store.Set("a", "a")
+	assert.ErrorIs(t, err, ErrClosed)
+}
@@ -0,0 +1,222 @@
+package kv
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// How long the store waits for each request to S3.
+const s3Timeout = time.Minute
+
+// Where a `BackupDestination` for S3, or a service with an S3-compatible API
+// such as MinIO, stores objects, and the credentials it signs requests with.
+type S3Config struct {
+	// The URL of the service, such as "https://s3.us-east-1.amazonaws.com", or
+	// "http://localhost:9000" for a local MinIO server. Buckets are addressed
+	// by path, after it.
+	Endpoint string
+	// The region requests are signed for. It defaults to "us-east-1", which
+	// MinIO uses too.
+	Region string
+	Bucket string
+	// Prepended to the name of every object, such as "backups/orders/", so
+	// several stores can archive to a bucket.
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// The session token of temporary credentials, if they are.
+	SessionToken string
+}
+
+// Stores objects in an S3 bucket, signing requests with AWS Signature Version
+// 4.
+type s3Destination struct {
+	config S3Config
+	client *http.Client
+}
+
+// Returns a `BackupDestination` that stores objects in an S3 bucket, or a
+// bucket of a service with an S3-compatible API, like MinIO.
+func S3Destination(config S3Config) BackupDestination {
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+
+	return &s3Destination{config: config, client: &http.Client{Timeout: s3Timeout}}
+}
+
+func (d *s3Destination) Put(name string, data []byte) error {
+	_, err := d.do(http.MethodPut, d.config.Prefix+name, nil, data)
+	return err
+}
+
+func (d *s3Destination) Get(name string) ([]byte, error) {
+	return d.do(http.MethodGet, d.config.Prefix+name, nil, nil)
+}
+
+// The response to a ListObjectsV2 request.
+type s3ListResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (d *s3Destination) List(prefix string) ([]string, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {d.config.Prefix + prefix}}
+
+	// Each response lists up to 1,000 objects, and continues from the last:
+	var names []string
+	for {
+		body, err := d.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			names = append(names, strings.TrimPrefix(object.Key, d.config.Prefix))
+		}
+		if !result.IsTruncated {
+			return names, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// Makes a signed request for the object `key`, or for the bucket if it's
+// empty, and returns the response's body.
+func (d *s3Destination) do(method string, key string, query url.Values, body []byte) ([]byte, error) {
+	endpoint, err := url.Parse(d.config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	endpoint.Path = "/" + d.config.Bucket
+	if key != "" {
+		endpoint.Path += "/" + key
+	}
+	endpoint.RawPath = s3Escape(endpoint.Path, false)
+	endpoint.RawQuery = s3Query(query)
+
+	req, err := http.NewRequest(method, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	d.sign(req, body, time.Now().UTC())
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("S3 responded with %s", res.Status)
+	}
+	return data, nil
+}
+
+// Signs a request with AWS Signature Version 4, as of `now`.
+func (d *s3Destination) sign(req *http.Request, body []byte, now time.Time) {
+	timestamp := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", timestamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	headers := []string{"host:" + req.URL.Host, "x-amz-content-sha256:" + payloadHash, "x-amz-date:" + timestamp}
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	if d.config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", d.config.SessionToken)
+		headers = append(headers, "x-amz-security-token:"+d.config.SessionToken)
+		signedHeaders += ";x-amz-security-token"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		strings.Join(headers, "\n") + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + d.config.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + timestamp + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	signature := hex.EncodeToString(hmacSHA256(signingKey(d.config.SecretAccessKey, date, d.config.Region, "s3"), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		d.config.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+// Derives the key that signs requests to `service` in `region` on `date`.
+func signingKey(secret string, date string, region string, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Encodes a query string the way Signature Version 4 expects it: sorted by
+// key, with every character but unreserved ones percent-encoded.
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var params []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			params = append(params, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// Percent-encodes every character of `s` but unreserved ones, and, unless
+// `slashes` is set, slashes.
+func s3Escape(s string, slashes bool) string {
+	var escaped strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !slashes:
+			escaped.WriteByte(c)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
@@ -0,0 +1,130 @@
+package kv
+
+import (
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qsymmachus/ranger"
+	"github.com/stretchr/testify/assert"
+)
+
+// A fake S3 server with a single bucket, which lists two objects at a time.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	key, found := strings.CutPrefix(r.URL.Path, "/bucket/")
+
+	switch {
+	case r.Method == http.MethodPut && found:
+		f.objects[key], _ = io.ReadAll(r.Body)
+	case r.Method == http.MethodGet && found:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case r.Method == http.MethodGet && r.URL.Path == "/bucket":
+		var keys []string
+		for key := range f.objects {
+			if strings.HasPrefix(key, r.URL.Query().Get("prefix")) && key > r.URL.Query().Get("continuation-token") {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+
+		var result s3ListResult
+		if len(keys) > 2 {
+			keys = keys[:2]
+			result.IsTruncated = true
+			result.NextContinuationToken = keys[1]
+		}
+		for _, key := range keys {
+			result.Contents = append(result.Contents, struct{ Key string }{key})
+		}
+		xml.NewEncoder(w).Encode(result)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, S3Config) {
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return fake, S3Config{
+		Endpoint:        server.URL,
+		Bucket:          "bucket",
+		Prefix:          "stores/a/",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+	}
+}
+
+func TestS3Destination(t *testing.T) {
+	fake, config := newFakeS3(t)
+	dest := S3Destination(config)
+
+	for _, name := range []string{"x-1", "x-2", "x-3", "y-1", "z 1"} {
+		assert.NoError(t, dest.Put(name, []byte(name)))
+	}
+	assert.Contains(t, fake.objects, "stores/a/z 1")
+
+	data, err := dest.Get("x-2")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("x-2"), data)
+	_, err = dest.Get("x-4")
+	assert.Error(t, err)
+
+	// Listing follows continuation tokens:
+	names, err := dest.List("x-")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"x-1", "x-2", "x-3"}, names)
+
+	// Requests are refused without credentials:
+	config.AccessKeyID = ""
+	assert.Error(t, S3Destination(config).Put("x-4", nil))
+}
+
+func TestArchiveToS3(t *testing.T) {
+	defer removeLog()
+	_, config := newFakeS3(t)
+	dest := S3Destination(config)
+
+	store, _ := NewStore[int, int](LogPath(logPath), SegmentSize(256), ArchiveTo(dest, 10*time.Millisecond))
+	for _, n := range ranger.Int(1, 50) {
+		store.Set(n%10, n)
+	}
+	awaitArchive(t, dest, 50)
+	store.Close()
+
+	restored, err := RestoreArchive[int, int](dest, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, archivedState(50), restored.GetAll())
+	restored.Close()
+}
+
+func TestSigningKey(t *testing.T) {
+	// The example from AWS's documentation of Signature Version 4:
+	key := signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	assert.Equal(t, "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d", hex.EncodeToString(key))
+}
@@ -0,0 +1,50 @@
+package kv
+
+import (
+	"cmp"
+	"hash/maphash"
+	"slices"
+)
+
+func (s *kvStore[K, V]) Scan(cursor uint64, count int) (pairs map[K]V, next uint64, err error) {
+	if count <= 0 {
+		return nil, 0, errPageLimit
+	}
+
+	// Keys are scanned in order of their hash, and the cursor is the hash to
+	// carry on from, so a key is never visited again once it has been:
+	type scanned struct {
+		key  K
+		hash uint64
+	}
+	var keys []scanned
+	pairs = make(map[K]V, count)
+	pauseErr := s.exclusive(func() {
+		for _, sh := range s.shards {
+			for k := range sh.lookup(s.namespace).values.keys() {
+				if hash := maphash.Comparable(s.seed, k); hash >= cursor {
+					keys = append(keys, scanned{k, hash})
+				}
+			}
+		}
+		slices.SortFunc(keys, func(a, b scanned) int { return cmp.Compare(a.hash, b.hash) })
+
+		// Keys with the same hash can't be split between scans, so the last of
+		// them may go over `count`:
+		n := min(count, len(keys))
+		for n < len(keys) && n > 0 && keys[n].hash == keys[n-1].hash {
+			n++
+		}
+		for _, k := range keys[:n] {
+			pairs[k.key], _ = s.shardFor(k.key).lookup(s.namespace).values.get(k.key)
+		}
+		if n < len(keys) {
+			next = keys[n-1].hash + 1
+		}
+	})
+	if pauseErr != nil {
+		return nil, 0, pauseErr
+	}
+
+	return pairs, next, nil
+}
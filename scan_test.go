@@ -0,0 +1,49 @@
+package kv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScan(t *testing.T) {
+	store, _ := NewStore[string, int](Shards(4))
+	defer store.Close()
+	for i := range 100 {
+		store.Set(fmt.Sprintf("key-%d", i), i)
+	}
+	store.Namespace("other").Set("key-0", -1)
+
+	visits := make(map[string]int)
+	cursor, batches := uint64(0), 0
+	for {
+		pairs, next, err := store.Scan(cursor, 10)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, len(pairs), 11)
+		for k, v := range pairs {
+			visits[k]++
+			assert.NotEqual(t, -1, v)
+		}
+
+		// Writes between batches don't disturb the scan:
+		store.Set(fmt.Sprintf("new-%d", batches), 0)
+		store.Unset(fmt.Sprintf("key-%d", 99-batches))
+		batches++
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	for k, n := range visits {
+		assert.Equal(t, 1, n, k)
+	}
+	// Every key that was there throughout was visited:
+	for i := range 100 - batches {
+		assert.Contains(t, visits, fmt.Sprintf("key-%d", i))
+	}
+
+	_, _, err := store.Scan(0, 0)
+	assert.Error(t, err)
+}
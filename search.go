@@ -0,0 +1,18 @@
+package kv
+
+import (
+	"iter"
+	"regexp"
+)
+
+func (s *kvStore[K, V]) SearchKeys(pattern *regexp.Regexp) iter.Seq[K] {
+	// Only copying the keys pauses the store, rather than matching them too:
+	keys := s.Keys()
+	return func(yield func(K) bool) {
+		for _, k := range keys {
+			if text, err := csvField(k); err == nil && pattern.MatchString(text) && !yield(k) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package kv
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchKeys(t *testing.T) {
+	store, _ := NewStore[string, int](Shards(4))
+	defer store.Close()
+	store.SetMany(map[string]int{"user:1": 1, "user:22:name": 2, "order:1": 3, "user:x": 4})
+
+	keys := store.SearchKeys(regexp.MustCompile(`^user:\d+`))
+	// Keys set afterwards aren't searched:
+	store.Set("user:3", 5)
+	assert.ElementsMatch(t, []string{"user:1", "user:22:name"}, slices.Collect(keys))
+
+	// Stops as soon as the caller does:
+	n := 0
+	for range store.SearchKeys(regexp.MustCompile(`:`)) {
+		n++
+		break
+	}
+	assert.Equal(t, 1, n)
+
+	numbers, _ := NewStore[[2]int, int]()
+	defer numbers.Close()
+	numbers.Set([2]int{1, 2}, 1)
+	numbers.Set([2]int{3, 4}, 2)
+	assert.Equal(t, [][2]int{{1, 2}}, slices.Collect(numbers.SearchKeys(regexp.MustCompile(`^\[1,`))))
+}
@@ -0,0 +1,526 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+
+	"github.com/qsymmachus/kv/internal/wire"
+)
+
+// Accepts connections from `kvclient` clients until the listener is closed.
+func (s *kvStore[K, V]) acceptClients() {
+	defer s.background.Done()
+
+	for {
+		conn, err := s.server.Accept()
+		if err != nil {
+			return
+		}
+
+		s.background.Add(1)
+		go s.serveClient(conn)
+	}
+}
+
+// Answers a client's requests, in order, until it disconnects or the store is
+// closed.
+func (s *kvStore[K, V]) serveClient(conn net.Conn) {
+	defer s.background.Done()
+	defer s.closeWithStore(conn)()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	perms, token, ok := s.authenticate(r, w)
+	if !ok {
+		return
+	}
+	// Writes are audited as the client's:
+	client := &kvStore[K, V]{core: s.core, caller: callerOf(conn, token)}
+	// Pipelined sets are started as they're read, and answered in order once
+	// no more requests have been read, so they're applied in batches and share
+	// their syncs to the log:
+	var pipelined []func() wire.Response
+	answer := func() bool {
+		for _, wait := range pipelined {
+			if wire.WriteFrame(w, wait()) != nil {
+				return false
+			}
+		}
+		pipelined = pipelined[:0]
+		return w.Flush() == nil
+	}
+	for {
+		var req wire.Request
+		if err := wire.ReadFrame(r, &req); err != nil {
+			return
+		}
+		if req.Op == wire.SetPipelined {
+			if err := perms.authorize(req); err != nil {
+				pipelined = append(pipelined, func() wire.Response { return wire.Response{Err: err.Error()} })
+			} else {
+				pipelined = append(pipelined, client.startPipelined(req))
+			}
+			if r.Buffered() > 0 && len(pipelined) < maxPipelined {
+				continue
+			}
+			if !answer() {
+				return
+			}
+			continue
+		}
+		if len(pipelined) > 0 && !answer() {
+			return
+		}
+		err := perms.authorize(req)
+		if err == nil {
+			err = s.authorizeLease(perms, req)
+		}
+		if err != nil {
+			if wire.WriteFrame(w, wire.Response{Err: err.Error()}) != nil || w.Flush() != nil {
+				return
+			}
+			continue
+		}
+		if req.Op == wire.Watch {
+			s.streamChanges(req, r, w)
+			return
+		}
+		if req.Op == wire.Subscribe {
+			s.streamMessages(req, r, w)
+			return
+		}
+
+		res, err := client.handle(req)
+		if err != nil {
+			res = wire.Response{Err: err.Error()}
+		}
+		if err := wire.WriteFrame(w, res); err != nil {
+			return
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Answers a `Watch` request with the watched entries, then sends each change to
+// them, until the client disconnects, falls behind, or the store is closed.
+func (s *kvStore[K, V]) streamChanges(req wire.Request, r *bufio.Reader, w *bufio.Writer) {
+	store := &kvStore[K, V]{core: s.core, namespace: req.Namespace}
+	watcher, entries, revision, err := store.watch(req.Prefix)
+	if err != nil {
+		wire.WriteFrame(w, wire.Response{Err: err.Error()})
+		w.Flush()
+		return
+	}
+	defer s.unwatch(watcher)
+
+	if wire.WriteFrame(w, wire.Response{Entries: encodeEntries(entries), Revision: revision}) != nil || w.Flush() != nil {
+		return
+	}
+
+	// The client doesn't send anything else, so reading only ends once it
+	// disconnects:
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, r)
+		close(gone)
+	}()
+
+	for {
+		select {
+		case change, ok := <-watcher.changes:
+			if !ok {
+				return
+			}
+			if wire.WriteFrame(w, wire.Response{Change: encodeField(change)}) != nil || w.Flush() != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// Answers a `Subscribe` request, then sends each message published to the topic,
+// until the client disconnects, falls behind, or the store is closed.
+func (s *kvStore[K, V]) streamMessages(req wire.Request, r *bufio.Reader, w *bufio.Writer) {
+	store := &kvStore[K, V]{core: s.core, namespace: req.Namespace}
+	sub, err := store.Subscribe(req.Topic)
+	if err != nil {
+		wire.WriteFrame(w, wire.Response{Err: err.Error()})
+		w.Flush()
+		return
+	}
+	defer sub.Close()
+
+	if wire.WriteFrame(w, wire.Response{}) != nil || w.Flush() != nil {
+		return
+	}
+
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, r)
+		close(gone)
+	}()
+
+	for {
+		select {
+		case message, ok := <-sub.Messages():
+			if !ok {
+				return
+			}
+			if wire.WriteFrame(w, wire.Response{Value: encodeField(message)}) != nil || w.Flush() != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// Closes a connection when the store is closed, to unblock whoever is reading
+// from it, or when the returned function is called, whichever comes first.
+func (s *kvStore[K, V]) closeWithStore(conn net.Conn) (done func()) {
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-s.closing:
+		case <-stopped:
+		}
+		conn.Close()
+	}()
+
+	return func() { close(stopped) }
+}
+
+// Starts the write a `SetPipelined` request asks for, in the request's
+// namespace, and returns a function that waits for its response.
+func (s *kvStore[K, V]) startPipelined(req wire.Request) (wait func() wire.Response) {
+	store := &kvStore[K, V]{core: s.core, namespace: req.Namespace, caller: s.caller}
+
+	var key K
+	var value V
+	if err := decodeField(req.Key, &key); err != nil {
+		return func() wire.Response { return wire.Response{Err: err.Error()} }
+	}
+	if err := decodeField(req.Value, &value); err != nil {
+		return func() wire.Response { return wire.Response{Err: err.Error()} }
+	}
+
+	future := store.SetPipelined(key, value)
+	return func() wire.Response {
+		revision, err := future.Wait()
+		if err != nil {
+			return wire.Response{Err: err.Error()}
+		}
+		return wire.Response{Revision: revision}
+	}
+}
+
+// Calls the store method a request asks for, in the request's namespace.
+func (s *kvStore[K, V]) handle(req wire.Request) (wire.Response, error) {
+	store := &kvStore[K, V]{core: s.core, namespace: req.Namespace, caller: s.caller}
+
+	var key K
+	var value, expected V
+	if err := decodeField(req.Key, &key); err != nil {
+		return wire.Response{}, err
+	}
+	if err := decodeField(req.Value, &value); err != nil {
+		return wire.Response{}, err
+	}
+	if err := decodeField(req.Expected, &expected); err != nil {
+		return wire.Response{}, err
+	}
+
+	switch req.Op {
+	case wire.Get:
+		v, found, err := store.GetWithConsistency(key, Consistency(req.Level))
+		return wire.Response{OK: found, Value: encodeField(v)}, err
+	case wire.GetVersioned:
+		v, clock, found := store.GetVersioned(key)
+		return wire.Response{OK: found, Value: encodeField(v), Clock: clock}, nil
+	case wire.Set:
+		revision, err := store.SetWithConsistency(key, value, Consistency(req.Level))
+		return wire.Response{Revision: revision}, err
+	case wire.Unset:
+		revision, err := store.UnsetWithConsistency(key, Consistency(req.Level))
+		return wire.Response{Revision: revision}, err
+	case wire.GetAt:
+		v, found, err := store.GetAt(key, req.Revision)
+		return wire.Response{OK: found, Value: encodeField(v)}, err
+	case wire.GetAll:
+		return wire.Response{Entries: encodeEntries(store.GetAll())}, nil
+	case wire.List:
+		page, next, err := store.List(req.Cursor, req.Count)
+		return wire.Response{Entries: encodeEntries(page), Cursor: next}, err
+	case wire.Scan:
+		pairs, next, err := store.Scan(req.ScanCursor, req.Count)
+		return wire.Response{Entries: encodeEntries(pairs), ScanCursor: next}, err
+	case wire.ListDesc:
+		page, next, err := store.ListDesc(req.Cursor, req.Count)
+		return wire.Response{Entries: encodeEntries(page), Cursor: next}, err
+	case wire.Keys:
+		keys := store.Keys()
+		res := wire.Response{Keys: make([]json.RawMessage, len(keys))}
+		for i, k := range keys {
+			res.Keys[i] = encodeField(k)
+		}
+		return res, nil
+	case wire.KeysMatching:
+		keys, err := store.KeysMatching(req.Pattern)
+		res := wire.Response{Keys: make([]json.RawMessage, len(keys))}
+		for i, k := range keys {
+			res.Keys[i] = encodeField(k)
+		}
+		return res, err
+	case wire.SearchKeys:
+		pattern, err := regexp.Compile(req.Pattern)
+		if err != nil {
+			return wire.Response{}, err
+		}
+		var res wire.Response
+		for k := range store.SearchKeys(pattern) {
+			res.Keys = append(res.Keys, encodeField(k))
+		}
+		return res, nil
+	case wire.Search:
+		keys, err := store.Search(req.Query)
+		res := wire.Response{Keys: make([]json.RawMessage, len(keys))}
+		for i, k := range keys {
+			res.Keys[i] = encodeField(k)
+		}
+		return res, err
+	case wire.Len:
+		return wire.Response{Len: store.Len()}, nil
+	case wire.CompareAndSwap:
+		swapped, err := store.CompareAndSwap(key, expected, value)
+		return wire.Response{OK: swapped}, err
+	case wire.Rename, wire.RenameIfNotExists:
+		var to K
+		if err := decodeField(req.To, &to); err != nil {
+			return wire.Response{}, err
+		}
+		if req.Op == wire.Rename {
+			return wire.Response{}, store.Rename(key, to)
+		}
+		renamed, err := store.RenameIfNotExists(key, to)
+		return wire.Response{OK: renamed}, err
+	case wire.UnsetIf:
+		deleted, err := store.UnsetIf(key, expected)
+		return wire.Response{OK: deleted}, err
+	case wire.SetIfNotExists:
+		set, err := store.SetIfNotExists(key, value)
+		return wire.Response{OK: set}, err
+	case wire.GetAndDelete:
+		v, found, err := store.GetAndDelete(key)
+		return wire.Response{OK: found, Value: encodeField(v)}, err
+	case wire.GetAndSet:
+		v, found, err := store.GetAndSet(key, value)
+		return wire.Response{OK: found, Value: encodeField(v)}, err
+	case wire.Append:
+		revision, err := store.Append(key, value)
+		return wire.Response{Revision: revision}, err
+	case wire.LPush:
+		length, err := store.LPush(key, value)
+		return wire.Response{Len: length}, err
+	case wire.RPush:
+		length, err := store.RPush(key, value)
+		return wire.Response{Len: length}, err
+	case wire.LPop:
+		values, err := store.LPop(key, req.Count)
+		return wire.Response{Value: encodeField(values)}, err
+	case wire.LRange:
+		values, err := store.LRange(key, req.Start, req.Stop)
+		return wire.Response{Value: encodeField(values)}, err
+	case wire.SAdd:
+		added, err := store.SAdd(key, value)
+		return wire.Response{Len: added}, err
+	case wire.SRem:
+		removed, err := store.SRem(key, value)
+		return wire.Response{Len: removed}, err
+	case wire.SIsMember:
+		isMember, err := store.SIsMember(key, value)
+		return wire.Response{OK: isMember}, err
+	case wire.SMembers:
+		members, err := store.SMembers(key)
+		return wire.Response{Value: encodeField(members)}, err
+	case wire.ZAdd:
+		added, err := store.ZAdd(key, value)
+		return wire.Response{Len: added}, err
+	case wire.ZRangeByScore:
+		members, err := store.ZRangeByScore(key, req.Min, req.Max)
+		return wire.Response{Value: encodeField(members)}, err
+	case wire.ZRank:
+		ranks, err := store.ZRank(key, value)
+		return wire.Response{Value: encodeField(ranks)}, err
+	case wire.Publish:
+		receivers, err := store.Publish(req.Topic, value)
+		return wire.Response{Len: receivers}, err
+	case wire.HSet:
+		added, err := store.HSet(key, value)
+		return wire.Response{Len: added}, err
+	case wire.HGet:
+		values, err := store.HGet(key, req.Fields...)
+		return wire.Response{Value: encodeField(values)}, err
+	case wire.HDel:
+		removed, err := store.HDel(key, req.Fields...)
+		return wire.Response{Len: removed}, err
+	case wire.Increment:
+		v, err := store.Increment(key, value)
+		return wire.Response{Value: encodeField(v)}, err
+	case wire.Decrement:
+		v, err := store.Decrement(key, value)
+		return wire.Response{Value: encodeField(v)}, err
+	case wire.GetMany:
+		keys := make([]K, len(req.Keys))
+		for i, raw := range req.Keys {
+			if err := json.Unmarshal(raw, &keys[i]); err != nil {
+				return wire.Response{}, err
+			}
+		}
+		return wire.Response{Entries: encodeEntries(store.GetMany(keys))}, nil
+	case wire.Clear:
+		return wire.Response{}, store.Clear()
+	case wire.SetMany:
+		entries, err := decodeEntries[K, V](req.Entries)
+		if err != nil {
+			return wire.Response{}, err
+		}
+		return wire.Response{}, store.SetMany(entries)
+	case wire.Commit:
+		entries, err := decodeEntries[K, V](req.Entries)
+		if err != nil {
+			return wire.Response{}, err
+		}
+		txn := store.BeginTxn()
+		for _, raw := range req.Keys {
+			var k K
+			if err := json.Unmarshal(raw, &k); err != nil {
+				return wire.Response{}, err
+			}
+			txn.Unset(k)
+		}
+		for k, v := range entries {
+			txn.Set(k, v)
+		}
+		revision, err := txn.Commit()
+		return wire.Response{Revision: revision}, err
+	case wire.GetByIndex:
+		values, err := store.GetByIndex(req.Index, req.Indexed)
+		return wire.Response{Entries: encodeEntries(values)}, err
+	case wire.Backup:
+		var buf bytes.Buffer
+		err := store.Backup(&buf)
+		return wire.Response{Data: buf.Bytes()}, err
+	case wire.Restore:
+		return wire.Response{}, store.Restore(bytes.NewReader(req.Data))
+	case wire.Export:
+		var buf bytes.Buffer
+		err := store.Export(&buf, Format(req.Format))
+		return wire.Response{Data: buf.Bytes()}, err
+	case wire.Import:
+		return wire.Response{}, store.Import(bytes.NewReader(req.Data), Format(req.Format))
+	case wire.Compact:
+		return wire.Response{}, store.Compact()
+	case wire.Sync:
+		return wire.Response{}, store.Sync()
+	case wire.GrantLease:
+		lease, err := store.GrantLease(req.TTL)
+		return wire.Response{Lease: uint64(lease)}, err
+	case wire.SetWithLease:
+		revision, err := store.SetWithLease(key, value, LeaseID(req.Lease))
+		return wire.Response{Revision: revision}, err
+	case wire.KeepAlive:
+		return wire.Response{}, store.KeepAlive(LeaseID(req.Lease))
+	case wire.RevokeLease:
+		return wire.Response{}, store.RevokeLease(LeaseID(req.Lease))
+	case wire.TryLock:
+		lease, locked, err := store.TryLock(key, value, req.TTL)
+		return wire.Response{OK: locked, Lease: uint64(lease)}, err
+	case wire.Digest:
+		return wire.Response{Digest: encodeField(store.Digest())}, nil
+	case wire.DigestEntries:
+		return wire.Response{Entries: encodeEntries(store.DigestEntries(req.Buckets))}, nil
+	case wire.Versions:
+		return wire.Response{Entries: encodeEntries(store.Versions())}, nil
+	case wire.Merge:
+		versions, err := decodeEntries[K, Version[V]](req.Entries)
+		if err != nil {
+			return wire.Response{}, err
+		}
+		return wire.Response{}, store.mergeVersions(versions)
+	case wire.Promote:
+		return wire.Response{}, store.Promote(req.Addr)
+	case wire.Members:
+		return wire.Response{Members: encodeField(store.Members())}, nil
+	case wire.ReplaySummary:
+		summary := store.ReplaySummary()
+		res := wire.Response{Summary: &wire.Summary{Recovered: summary.Recovered, Dropped: summary.Dropped}}
+		for _, skipped := range summary.Skipped {
+			res.Summary.Skipped = append(res.Summary.Skipped, wire.Skipped{Path: skipped.Path, Line: skipped.Line, Err: skipped.Err.Error()})
+		}
+		return res, nil
+	case wire.Stats:
+		return wire.Response{Stats: encodeField(store.Stats())}, nil
+	case wire.Authenticate:
+		// The connection is already authenticated, or needn't be:
+		return wire.Response{}, nil
+	case wire.Healthy:
+		return wire.Response{}, store.Healthy()
+	default:
+		return wire.Response{}, fmt.Errorf("Unknown operation %q", req.Op)
+	}
+}
+
+// Decodes a key or value in a request, if the request has it.
+func decodeField(raw json.RawMessage, v any) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return errors.New("Failed to decode request, its types may not match the store's")
+	}
+
+	return nil
+}
+
+// Encodes a key or value for a response. Keys and values that the store holds
+// can always be encoded, since they were written to the log, or could have been.
+func encodeField(v any) json.RawMessage {
+	raw, _ := json.Marshal(v)
+	return raw
+}
+
+func encodeEntries[K comparable, V any](data map[K]V) []wire.Entry {
+	entries := make([]wire.Entry, 0, len(data))
+	for k, v := range data {
+		entries = append(entries, wire.Entry{Key: encodeField(k), Value: encodeField(v)})
+	}
+
+	return entries
+}
+
+func decodeEntries[K comparable, V any](entries []wire.Entry) (map[K]V, error) {
+	data := make(map[K]V, len(entries))
+	for _, e := range entries {
+		var k K
+		var v V
+		if err := json.Unmarshal(e.Key, &k); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(e.Value, &v); err != nil {
+			return nil, err
+		}
+		data[k] = v
+	}
+
+	return data, nil
+}
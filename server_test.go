@@ -0,0 +1,40 @@
+package kv
+
+import (
+	"net"
+	"testing"
+
+	"github.com/qsymmachus/kv/internal/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	store, _ := NewStore[string, int](ServerListener(listener))
+	defer store.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	call := func(req wire.Request) wire.Response {
+		assert.NoError(t, wire.WriteFrame(conn, req))
+		var res wire.Response
+		assert.NoError(t, wire.ReadFrame(conn, &res))
+		return res
+	}
+
+	res := call(wire.Request{Op: wire.Set, Key: []byte(`"a"`), Value: []byte(`1`)})
+	assert.Equal(t, wire.Response{Revision: 1}, res)
+	res = call(wire.Request{Op: wire.Get, Key: []byte(`"a"`)})
+	assert.Equal(t, wire.Response{OK: true, Value: []byte(`1`)}, res)
+
+	// Requests that don't match the store's types are errors, and the
+	// connection stays open after them:
+	res = call(wire.Request{Op: wire.Set, Key: []byte(`"a"`), Value: []byte(`"one"`)})
+	assert.Contains(t, res.Err, "types may not match")
+	res = call(wire.Request{Op: "frobnicate"})
+	assert.Contains(t, res.Err, "Unknown operation")
+	res = call(wire.Request{Op: wire.Len})
+	assert.Equal(t, 1, res.Len)
+}
@@ -0,0 +1,108 @@
+package kv
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Returned by the set operations, `SAdd`, `SRem`, `SIsMember` and `SMembers`,
+// for stores whose values aren't slices of comparable elements.
+var ErrNotSet = errors.New("Store's values aren't slices of comparable elements")
+
+func (s *kvStore[K, V]) SAdd(key K, members V) (added int, err error) {
+	return s.changeMembers(addMembers, key, members)
+}
+
+func (s *kvStore[K, V]) SRem(key K, members V) (removed int, err error) {
+	return s.changeMembers(removeMembers, key, members)
+}
+
+func (s *kvStore[K, V]) changeMembers(updateType updateType, key K, members V) (changed int, err error) {
+	if !isSet[V]() {
+		return 0, ErrNotSet
+	}
+
+	result := s.write(s.newUpdate(updateType, key, members))
+	if result.err != nil {
+		return 0, result.err
+	}
+	return lengthOf(result.value), nil
+}
+
+func (s *kvStore[K, V]) SIsMember(key K, members V) (isMember bool, err error) {
+	if !isSet[V]() {
+		return false, ErrNotSet
+	}
+
+	// The members that adding would add are the ones that are missing:
+	set, _ := s.find(key)
+	_, missing, _ := withMembers(set, members)
+	return lengthOf(missing) == 0, nil
+}
+
+func (s *kvStore[K, V]) SMembers(key K) (members V, err error) {
+	if !isSet[V]() {
+		return members, ErrNotSet
+	}
+
+	members, _ = s.find(key)
+	return members, nil
+}
+
+// Reports whether a store's values are slices whose elements can be compared,
+// which the set operations need.
+func isSet[V any]() bool {
+	t := reflect.TypeFor[V]()
+	return t.Kind() == reflect.Slice && t.Elem().Comparable()
+}
+
+// Adds the elements of `members` to the set `set` that aren't in it yet, and
+// returns the set that leaves, and the elements that were added, in the order
+// they're given.
+func withMembers[V any](set V, members V) (result V, added V, err error) {
+	if !isSet[V]() {
+		return result, added, ErrNotSet
+	}
+
+	s, m := reflect.ValueOf(&set).Elem(), reflect.ValueOf(&members).Elem()
+	present := make(map[any]struct{}, s.Len()+m.Len())
+	for i := range s.Len() {
+		present[s.Index(i).Interface()] = struct{}{}
+	}
+	a := reflect.MakeSlice(s.Type(), 0, m.Len())
+	for i := range m.Len() {
+		if _, found := present[m.Index(i).Interface()]; !found {
+			present[m.Index(i).Interface()] = struct{}{}
+			a = reflect.Append(a, m.Index(i))
+		}
+	}
+	added = a.Interface().(V)
+
+	result, err = appendValues(set, added)
+	return result, added, err
+}
+
+// Removes the elements of `members` from the set `set`, and returns the set
+// that leaves, and the elements that were removed, in the order they were in
+// the set.
+func withoutMembers[V any](set V, members V) (result V, removed V, err error) {
+	if !isSet[V]() {
+		return result, removed, ErrNotSet
+	}
+
+	s, m := reflect.ValueOf(&set).Elem(), reflect.ValueOf(&members).Elem()
+	removing := make(map[any]struct{}, m.Len())
+	for i := range m.Len() {
+		removing[m.Index(i).Interface()] = struct{}{}
+	}
+	kept, r := reflect.MakeSlice(s.Type(), 0, s.Len()), reflect.MakeSlice(s.Type(), 0, m.Len())
+	for i := range s.Len() {
+		if _, found := removing[s.Index(i).Interface()]; found {
+			r = reflect.Append(r, s.Index(i))
+		} else {
+			kept = reflect.Append(kept, s.Index(i))
+		}
+	}
+
+	return kept.Interface().(V), r.Interface().(V), nil
+}
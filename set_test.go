@@ -0,0 +1,94 @@
+package kv
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSet(t *testing.T) {
+	defer removeLog()
+
+	store, err := NewStore[string, []string](LogPath(logPath))
+	assert.NoError(t, err)
+
+	added, err := store.SAdd("tags", []string{"go", "db", "go"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, added)
+	revision := store.(*kvStore[string, []string]).revision
+	added, _ = store.SAdd("tags", []string{"db"})
+	assert.Equal(t, 0, added)
+	// Adding members that are already in the set writes nothing:
+	assert.Equal(t, revision, store.(*kvStore[string, []string]).revision)
+	store.SAdd("tags", []string{"kv"})
+
+	members, err := store.SMembers("tags")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"go", "db", "kv"}, members)
+	isMember, err := store.SIsMember("tags", []string{"go", "kv"})
+	assert.NoError(t, err)
+	assert.True(t, isMember)
+	isMember, _ = store.SIsMember("tags", []string{"go", "rust"})
+	assert.False(t, isMember)
+
+	removed, err := store.SRem("tags", []string{"db", "rust"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	members, _ = store.SMembers("tags")
+	assert.Equal(t, []string{"go", "kv"}, members)
+	store.SAdd("empty", []string{"a"})
+	store.SRem("empty", []string{"a"})
+	_, found := store.Get("empty")
+	assert.False(t, found)
+	store.Close()
+
+	// Changes are logged as they were made, not as the whole set:
+	log, _ := os.ReadFile(logPath)
+	assert.NotContains(t, string(log), `["go","db","kv"]`)
+
+	replayed, err := NewStore[string, []string](LogPath(logPath))
+	assert.NoError(t, err)
+	defer replayed.Close()
+	members, _ = replayed.SMembers("tags")
+	assert.Equal(t, []string{"go", "kv"}, members)
+	_, found = replayed.Get("empty")
+	assert.False(t, found)
+}
+
+func TestConcurrentSAdd(t *testing.T) {
+	store, _ := NewStore[string, []int]()
+	defer store.Close()
+
+	var wg sync.WaitGroup
+	added := make(chan int, 100)
+	for i := range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, _ := store.SAdd("members", []int{i % 10})
+			added <- n
+		}()
+	}
+	wg.Wait()
+	close(added)
+
+	total := 0
+	for n := range added {
+		total += n
+	}
+	assert.Equal(t, 10, total)
+	members, _ := store.SMembers("members")
+	assert.ElementsMatch(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, members)
+}
+
+func TestSetNotComparable(t *testing.T) {
+	store, _ := NewStore[string, [][]byte]()
+	defer store.Close()
+
+	_, err := store.SAdd("set", [][]byte{[]byte("a")})
+	assert.ErrorIs(t, err, ErrNotSet)
+	_, err = store.SMembers("set")
+	assert.ErrorIs(t, err, ErrNotSet)
+}
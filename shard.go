@@ -0,0 +1,267 @@
+package kv
+
+import (
+	"hash/maphash"
+	"maps"
+	"sync/atomic"
+)
+
+// A partition of the store's key space, with its own maps and update queue.
+type shard[K comparable, V any] struct {
+	// The shard's part of each namespace, by namespace name. The map is never
+	// changed once it's stored, so readers can load it while the update loop
+	// adds a namespace.
+	buckets atomic.Pointer[map[string]*bucket[K, V]]
+	// A singular update queue, implemented as a channel, that receives
+	// update messages and applies them to the shard.
+	updates chan (update[K, V])
+	// The slice of the last batch of updates, reused by the next one. Only used
+	// by the shard's update loop.
+	batch []update[K, V]
+	// Creates the storage for a new bucket.
+	newStorage func() storage[K, V]
+	// Whether new buckets keep a full-text index of their values.
+	fullText bool
+}
+
+func newShard[K comparable, V any](newStorage func() storage[K, V], queueSize int, fullText bool) *shard[K, V] {
+	sh := &shard[K, V]{
+		updates:    make(chan (update[K, V]), queueSize),
+		newStorage: newStorage,
+		fullText:   fullText,
+	}
+	sh.buckets.Store(&map[string]*bucket[K, V]{"": newBucket(newStorage(), fullText)})
+	return sh
+}
+
+// Returns the shard's part of each namespace, by namespace name.
+func (sh *shard[K, V]) namespaces() map[string]*bucket[K, V] {
+	return *sh.buckets.Load()
+}
+
+// Returns the shard's part of a namespace, creating it if nothing has been
+// written to the namespace in this shard yet. Only called by the shard's update
+// loop, or while every shard is paused.
+func (sh *shard[K, V]) bucket(namespace string) *bucket[K, V] {
+	b, found := sh.namespaces()[namespace]
+	if !found {
+		b = newBucket(sh.newStorage(), sh.fullText)
+		buckets := maps.Clone(sh.namespaces())
+		buckets[namespace] = b
+		sh.buckets.Store(&buckets)
+	}
+
+	return b
+}
+
+// Returns the shard's part of a namespace for reading. If nothing has been
+// written to the namespace in this shard, it returns an empty bucket that isn't
+// added to the shard.
+func (sh *shard[K, V]) lookup(namespace string) *bucket[K, V] {
+	if b, found := sh.namespaces()[namespace]; found {
+		return b
+	}
+
+	return &bucket[K, V]{values: memoryStorage[K, V](nil)}
+}
+
+// A shard's part of a namespace.
+type bucket[K comparable, V any] struct {
+	// The bucket's key/value pairs.
+	values storage[K, V]
+	// The namespace's part of each secondary index, by index name.
+	indexes map[string]*index[K, V]
+	// The namespace's part of the full-text index, if the store keeps one.
+	text *textIndex[K]
+}
+
+func newBucket[K comparable, V any](values storage[K, V], fullText bool) *bucket[K, V] {
+	b := &bucket[K, V]{
+		values:  values,
+		indexes: make(map[string]*index[K, V]),
+	}
+	if fullText {
+		b.text = newTextIndex[K]()
+	}
+
+	return b
+}
+
+// Sets a key in the bucket, keeping its indexes up to date.
+func (b *bucket[K, V]) put(key K, value V) error {
+	old, found := b.values.get(key)
+	if err := b.values.put(key, value); err != nil {
+		return err
+	}
+
+	for _, idx := range b.indexes {
+		if found {
+			idx.remove(key, old)
+		}
+		idx.add(key, value)
+	}
+	if b.text != nil {
+		if found {
+			b.text.remove(key, textOf(old))
+		}
+		b.text.add(key, textOf(value))
+	}
+
+	return nil
+}
+
+// Removes a key from the bucket, keeping its indexes up to date.
+func (b *bucket[K, V]) remove(key K) {
+	if old, found := b.values.get(key); found {
+		for _, idx := range b.indexes {
+			idx.remove(key, old)
+		}
+		if b.text != nil {
+			b.text.remove(key, textOf(old))
+		}
+	}
+
+	b.values.remove(key)
+}
+
+// Removes every key from the bucket, and from its indexes.
+func (b *bucket[K, V]) reset() {
+	b.values.reset()
+	for _, idx := range b.indexes {
+		idx.entries = make(map[string]map[K]struct{})
+	}
+	if b.text != nil {
+		b.text = newTextIndex[K]()
+	}
+}
+
+// Returns the shard that owns a key.
+func (s *kvStore[K, V]) shardFor(key K) *shard[K, V] {
+	return s.shards[s.shardIndex(key)]
+}
+
+// Returns the position of the shard that owns a key among the store's shards.
+func (s *kvStore[K, V]) shardIndex(key K) int {
+	if len(s.shards) == 1 {
+		return 0
+	}
+
+	return int(maphash.Comparable(s.seed, key) % uint64(len(s.shards)))
+}
+
+// Holds paused update loops until the goroutine that paused them is done.
+type barrier struct {
+	// Receives from each shard once it has paused.
+	paused chan struct{}
+	// Closed to resume every paused shard.
+	resume chan struct{}
+}
+
+// Called by a shard's update loop to acknowledge that it has paused, then
+// block until it's resumed.
+func (b *barrier) wait() {
+	b.paused <- struct{}{}
+	<-b.resume
+}
+
+// Pauses every shard's update loop, runs `fn` while no other update can be
+// applied, then resumes them. Shards are always paused in the same order, so
+// concurrent callers can't deadlock each other. `fn` must not queue updates.
+func (s *kvStore[K, V]) exclusive(fn func()) error {
+	return s.pause(s.shards, fn)
+}
+
+// The storage of each shard's part of each namespace, by namespace, in the
+// order of the store's shards, as of a single point in time.
+type view[K comparable, V any] []map[string]storage[K, V]
+
+// Returns the storage of every shard's part of a namespace in a view, leaving
+// out the shards that had nothing written to it.
+func (v view[K, V]) parts(namespace string) []storage[K, V] {
+	var parts []storage[K, V]
+	for _, buckets := range v {
+		if values, found := buckets[namespace]; found {
+			parts = append(parts, values)
+		}
+	}
+
+	return parts
+}
+
+// Returns the storage of a key's part of a namespace in a view. If nothing had
+// been written to the namespace in the key's shard, it's an empty storage.
+func (s *kvStore[K, V]) lookupIn(v view[K, V], namespace string, key K) storage[K, V] {
+	if values, found := v[s.shardIndex(key)][namespace]; found {
+		return values
+	}
+
+	return memoryStorage[K, V](nil)
+}
+
+// Returns a view of every shard's data, and whether every storage in it was
+// frozen. If it wasn't, the view holds storages that later writes change. The
+// caller must have paused every shard.
+func (s *kvStore[K, V]) takeView() (v view[K, V], frozen bool) {
+	v, frozen = make(view[K, V], len(s.shards)), true
+	for i, sh := range s.shards {
+		v[i] = make(map[string]storage[K, V], len(sh.namespaces()))
+		for namespace, b := range sh.namespaces() {
+			if f, ok := b.values.(freezer[K, V]); ok {
+				v[i][namespace] = f.freeze()
+			} else {
+				v[i][namespace], frozen = b.values, false
+			}
+		}
+	}
+
+	return v, frozen
+}
+
+// Takes a view of every shard's data at a single point in time, and calls
+// `read` with it. Every shard is paused while the view is taken, and `capture`,
+// if it's set, is called then too, to copy anything else along with the view.
+// If every storage can be frozen, as the default one can, the shards resume
+// before `read` is called, so reading the view, however long it takes, doesn't
+// hold up writes. Otherwise, `read` is called while they're still paused.
+// Neither function may queue updates.
+func (s *kvStore[K, V]) readView(capture func(), read func(v view[K, V])) error {
+	var v view[K, V]
+	var frozen bool
+	err := s.exclusive(func() {
+		if capture != nil {
+			capture()
+		}
+		if v, frozen = s.takeView(); !frozen {
+			read(v)
+		}
+	})
+	if err != nil || !frozen {
+		return err
+	}
+
+	read(v)
+	return nil
+}
+
+// Pauses the update loops of `shards`, in order, runs `fn`, then resumes them.
+func (s *kvStore[K, V]) pause(shards []*shard[K, V], fn func()) error {
+	b := &barrier{paused: make(chan struct{}), resume: make(chan struct{})}
+	defer close(b.resume)
+
+	for _, sh := range shards {
+		select {
+		case sh.updates <- update[K, V]{UpdateType: pause, barrier: b}:
+		case <-s.closing:
+			return ErrClosed
+		}
+		// A pause still in the queue when the loops stop is never received:
+		select {
+		case <-b.paused:
+		case <-s.stopped:
+			return ErrClosed
+		}
+	}
+
+	fn()
+	return nil
+}
@@ -0,0 +1,196 @@
+package kv
+
+import (
+	"errors"
+	"io"
+	"maps"
+	"time"
+)
+
+// Writes a snapshot of the whole store next to its write-ahead log, then trims
+// every record the snapshot covers from the log, so the log replays faster.
+// Updates are only paused while a view of the store's data is taken; the
+// snapshot is written, and the log trimmed, while the store keeps taking writes.
+// Writing the snapshot is paced to the store's `CompactionRate`, until the store
+// starts closing.
+func (s *kvStore[K, V]) snapshotLog() error {
+	s.maintenance.Lock()
+	defer s.maintenance.Unlock()
+
+	var state storeState[K]
+	var updates []update[K, V]
+	var position logPosition
+	pauseErr := s.readView(func() {
+		s.commit.Lock()
+		defer s.commit.Unlock()
+		state = s.captureState()
+		position = s.log.position()
+		s.sinceSnapshot = 0
+		s.appendedAtSnapshot = s.log.appended.Load()
+	}, func(v view[K, V]) { updates = s.stateUpdates(state, v) })
+	if pauseErr != nil {
+		return pauseErr
+	}
+
+	// Like a compacted log, the snapshot starts from an empty store, at the
+	// revision it was taken at:
+	records, err := s.encodeUpdates(updates)
+	if err != nil {
+		return errors.New("Failed to encode update for the snapshot")
+	}
+
+	progress := &s.compaction
+	progress.written.Store(0)
+	progress.running.Store(true)
+	defer progress.running.Store(false)
+	pace := func(w io.Writer) io.Writer {
+		return &compactionWriter{w: w, rate: s.options.compactionRate, started: time.Now(), progress: progress}
+	}
+	if err := s.log.writeFile(s.log.snapshotPath(), records, pace); err != nil {
+		return err
+	}
+	progress.lastSize.Store(progress.written.Load())
+
+	// Records before the snapshot's position are now in the snapshot. If the
+	// store stops before they're trimmed, replaying the log skips them:
+	s.commit.Lock()
+	defer s.commit.Unlock()
+	if err := s.log.trim(position); err != nil {
+		return err
+	}
+
+	progress.completed.Add(1)
+	s.options.logger.Info("Took snapshot", "revision", updates[0].Revision, "records", len(records))
+	return nil
+}
+
+// Everything about the store apart from its data that the updates recreating
+// it carry, copied along with a view of its data.
+type storeState[K comparable] struct {
+	revision  uint64
+	epoch     uint64
+	raftIndex uint64
+	// The TTL and namespace of each lease.
+	leases     map[LeaseID]lease[K]
+	leased     map[namespacedKey[K]]LeaseID
+	timestamps map[namespacedKey[K]]uint64
+	clocks     map[namespacedKey[K]]VectorClock
+}
+
+// Copies the store's state apart from its data, so it can be listed as updates
+// after the shards it was copied with have resumed. Clocks are never changed
+// in place, so they're shared with the copy. The caller must hold `commit`.
+func (s *kvStore[K, V]) captureState() storeState[K] {
+	leases := make(map[LeaseID]lease[K], len(s.leases))
+	for id, l := range s.leases {
+		leases[id] = lease[K]{ttl: l.ttl, namespace: l.namespace}
+	}
+
+	return storeState[K]{
+		revision:   s.revision,
+		epoch:      s.epoch,
+		raftIndex:  s.raftIndex,
+		leases:     leases,
+		leased:     maps.Clone(s.leased),
+		timestamps: maps.Clone(s.timestamps),
+		clocks:     maps.Clone(s.clocks),
+	}
+}
+
+// Lists the updates that recreate the store's current state from an empty
+// store, at its current revision. The caller must have paused every shard, and
+// hold `commit`.
+func (s *kvStore[K, V]) currentUpdates() []update[K, V] {
+	v, _ := s.takeView()
+	return s.stateUpdates(s.captureState(), v)
+}
+
+// Lists the updates that recreate a store's state and a view of its data, taken
+// together, from an empty store, at the state's revision: a truncate, then a
+// grant for each lease, a set for every key, and an unset for every key that's
+// only kept as a tombstone. Compacted logs, snapshots and followers start from
+// them.
+func (s *kvStore[K, V]) stateUpdates(state storeState[K], v view[K, V]) []update[K, V] {
+	updates := []update[K, V]{{UpdateType: truncate, Revision: state.revision, Epoch: state.epoch, RaftIndex: state.raftIndex}}
+	for id, l := range state.leases {
+		updates = append(updates, update[K, V]{UpdateType: grantLease, Namespace: l.namespace, Revision: state.revision, Lease: id, TTL: l.ttl})
+	}
+	for _, buckets := range v {
+		for namespace, values := range buckets {
+			for k, value := range values.all() {
+				nk := namespacedKey[K]{namespace, k}
+				updates = append(updates, update[K, V]{UpdateType: set, Namespace: namespace, Revision: state.revision, Key: k, Value: value, Lease: state.leased[nk], Timestamp: state.timestamps[nk], Clock: state.clocks[nk]})
+			}
+		}
+	}
+	for nk, timestamp := range state.timestamps {
+		if _, found := s.lookupIn(v, nk.namespace, nk.key).get(nk.key); !found {
+			updates = append(updates, update[K, V]{UpdateType: unset, Namespace: nk.namespace, Revision: state.revision, Key: nk.key, Timestamp: timestamp, Clock: state.clocks[nk]})
+		}
+	}
+
+	return updates
+}
+
+// Encodes a list of updates, such as the ones `stateUpdates` lists.
+func (s *kvStore[K, V]) encodeUpdates(updates []update[K, V]) ([][]byte, error) {
+	records := make([][]byte, len(updates))
+	for i, u := range updates {
+		record, err := s.encodeUpdate(u)
+		if err != nil {
+			return nil, err
+		}
+		records[i] = record
+	}
+
+	return records, nil
+}
+
+// Counts updates applied since the last snapshot, and asks for a new one once
+// there have been `SnapshotEvery` of them. The caller must hold `commit`.
+func (s *kvStore[K, V]) countForSnapshot(n int) {
+	if s.options.snapshotEvery == 0 {
+		return
+	}
+
+	s.sinceSnapshot += n
+	if s.sinceSnapshot >= s.options.snapshotEvery {
+		s.requestSnapshot()
+	}
+}
+
+// Asks the snapshot goroutine to take a snapshot, unless one has already been
+// asked for.
+func (s *kvStore[K, V]) requestSnapshot() {
+	select {
+	case s.snapshotRequests <- struct{}{}:
+	default:
+	}
+}
+
+// Takes a snapshot whenever one is asked for, or whenever `SnapshotInterval`
+// passes, until the store is closed. A snapshot that fails leaves the log as it
+// was, so it's retried the next time.
+func (s *kvStore[K, V]) takeSnapshots() {
+	defer s.background.Done()
+
+	var tick <-chan time.Time
+	if s.options.snapshotInterval > 0 {
+		ticker := time.NewTicker(s.options.snapshotInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-tick:
+		case <-s.snapshotRequests:
+		}
+
+		if err := s.snapshotLog(); err != nil {
+			s.options.logger.Error("Failed to take snapshot", "error", err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package kv
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qsymmachus/ranger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotEvery(t *testing.T) {
+	defer removeLog()
+
+	store, err := NewStore[int, int](LogPath(logPath), SnapshotEvery(100))
+	assert.NoError(t, err)
+	for _, n := range ranger.Int(1, 100) {
+		store.Set(n%10, n)
+	}
+
+	// The snapshot is taken in the background:
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(logPath + ".snapshot")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	store.Set(1, 1000)
+	store.Close()
+
+	// The log only holds what came after the snapshot:
+	log, _ := os.ReadFile(logPath)
+	assert.Less(t, len(log), 200)
+
+	replayed, err := NewStore[int, int](LogPath(logPath))
+	assert.NoError(t, err)
+	assert.Equal(t, 10, replayed.Len())
+	v, _ := replayed.Get(1)
+	assert.Equal(t, 1000, v)
+	v, _ = replayed.Get(2)
+	assert.Equal(t, 92, v)
+
+	// Revisions carry on from before the snapshot:
+	revision, _ := replayed.Set(3, 3)
+	assert.Equal(t, uint64(102), revision)
+	replayed.Close()
+}
+
+func TestSnapshotInterval(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath), SnapshotInterval(10*time.Millisecond))
+	store.Set("name", "ralph")
+	assert.Eventually(t, func() bool {
+		// Only the log's header is left:
+		log, _ := os.ReadFile(logPath)
+		return strings.Count(string(log), "\n") == 1
+	}, time.Second, 10*time.Millisecond)
+	store.Close()
+
+	replayed, _ := NewStore[string, string](LogPath(logPath))
+	v, _ := replayed.Get("name")
+	assert.Equal(t, "ralph", v)
+	replayed.Close()
+}
+
+func TestAutoCompact(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[int, int](LogPath(logPath), AutoCompact(4096))
+	for _, n := range ranger.Int(1, 200) {
+		store.Set(n%10, n)
+	}
+
+	// The log is compacted in the background once it's grown by 4 KiB:
+	assert.Eventually(t, func() bool {
+		return store.Stats().Compaction.Completed > 0
+	}, time.Second, 10*time.Millisecond)
+	stats := store.Stats().Compaction
+	assert.False(t, stats.Running)
+	assert.Positive(t, stats.LastSize)
+	store.Close()
+
+	replayed, _ := NewStore[int, int](LogPath(logPath))
+	defer replayed.Close()
+	v, _ := replayed.Get(0)
+	assert.Equal(t, 200, v)
+}
+
+func TestCompactionRate(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[int, string](LogPath(logPath), SnapshotEvery(100), CompactionRate(32<<10))
+	for _, n := range ranger.Int(1, 100) {
+		store.Set(n, strings.Repeat("x", 1000))
+	}
+
+	// About 100 KiB of snapshot takes about three seconds at 32 KiB a second,
+	// and writes carry on meanwhile:
+	assert.Eventually(t, func() bool {
+		return store.Stats().Compaction.Running
+	}, time.Second, time.Millisecond)
+	started := time.Now()
+	_, err := store.Set(1, "value")
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(started), 100*time.Millisecond)
+	assert.Less(t, store.Stats().Compaction.Written, int64(100_000))
+
+	// Closing the store stops pacing the writes, and waits for them:
+	closed := time.Now()
+	store.Close()
+	assert.Less(t, time.Since(closed), time.Second)
+	assert.Equal(t, uint64(1), store.Stats().Compaction.Completed)
+	assert.Greater(t, store.Stats().Compaction.LastSize, int64(100_000))
+}
+
+func TestSnapshotSegmentedLog(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[int, int](LogPath(logPath), SegmentSize(1024))
+	for _, n := range ranger.Int(1, 200) {
+		store.Set(n, n)
+	}
+	assert.NoError(t, store.(*kvStore[int, int]).snapshotLog())
+	store.Set(1, 100)
+	store.Close()
+
+	segments, _ := (&writeAheadLog{path: logPath}).segments()
+	assert.Len(t, segments, 1)
+
+	replayed, _ := NewStore[int, int](LogPath(logPath), SegmentSize(1024))
+	assert.Equal(t, 200, replayed.Len())
+	v, _ := replayed.Get(1)
+	assert.Equal(t, 100, v)
+	replayed.Close()
+}
+
+func TestSnapshotUntrimmedLog(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath))
+	store.Set("name", "ralph")
+	store.Set("food", "pizza")
+	store.Unset("food")
+	log, _ := os.ReadFile(logPath)
+	assert.NoError(t, store.(*kvStore[string, string]).snapshotLog())
+	store.Set("name", "ziggy")
+	store.Close()
+
+	// If the store stops before it trims the log, the whole log is replayed
+	// after the snapshot:
+	rest, _ := os.ReadFile(logPath)
+	_, rest, _ = bytes.Cut(rest, []byte("\n"))
+	os.WriteFile(logPath, append(log, rest...), 0600)
+
+	replayed, _ := NewStore[string, string](LogPath(logPath))
+	assert.Equal(t, map[string]string{"name": "ziggy"}, replayed.GetAll())
+	v, found, err := replayed.GetAt("name", 3)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "ralph", v)
+	_, _, err = replayed.GetAt("name", 2)
+	assert.ErrorIs(t, err, ErrCompacted)
+	replayed.Close()
+}
+
+func TestCompactRemovesSnapshot(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath))
+	store.Set("name", "ralph")
+	assert.NoError(t, store.(*kvStore[string, string]).snapshotLog())
+	assert.NoError(t, store.Compact())
+	store.Close()
+
+	_, err := os.Stat(logPath + ".snapshot")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSnapshotOnClose(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath), SnapshotOnClose())
+	store.Set("name", "ralph")
+	store.Set("name", "ziggy")
+	assert.NoError(t, store.Close())
+
+	log, _ := os.ReadFile(logPath)
+	assert.Equal(t, 1, strings.Count(string(log), "\n"))
+
+	replayed, _ := NewStore[string, string](LogPath(logPath))
+	v, _ := replayed.Get("name")
+	assert.Equal(t, "ziggy", v)
+	// The snapshot's truncate record, and one set:
+	assert.Equal(t, 2, replayed.ReplaySummary().Recovered)
+	replayed.Close()
+}
@@ -0,0 +1,147 @@
+package kv
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// Returned by the sorted set operations, `ZAdd`, `ZRangeByScore` and `ZRank`,
+// for stores whose values aren't slices of `Scored` members.
+var ErrNotSortedSet = errors.New("Store's values aren't slices of scored members")
+
+var errNaNScore = errors.New("Score must be a number")
+
+// A member of a sorted set, and the score it's ordered by. A store whose
+// values are `[]Scored[E]` can use its values as sorted sets.
+type Scored[E comparable] struct {
+	Member E
+	Score  float64
+}
+
+func (m Scored[E]) scored() (member any, score float64) {
+	return m.Member, m.Score
+}
+
+// Implemented by `Scored`, whatever the type of its members.
+type scoredMember interface {
+	scored() (member any, score float64)
+}
+
+func (s *kvStore[K, V]) ZAdd(key K, members V) (added int, err error) {
+	if !isSortedSet[V]() {
+		return 0, ErrNotSortedSet
+	}
+
+	result := s.write(s.newUpdate(addScored, key, members))
+	if result.err != nil {
+		return 0, result.err
+	}
+	return lengthOf(result.value), nil
+}
+
+func (s *kvStore[K, V]) ZRangeByScore(key K, min float64, max float64) (members V, err error) {
+	if !isSortedSet[V]() {
+		return members, ErrNotSortedSet
+	}
+
+	set, _ := s.find(key)
+	v := reflect.ValueOf(set)
+	start := sort.Search(v.Len(), func(i int) bool { return scoreAt(v, i) >= min })
+	stop := sort.Search(v.Len(), func(i int) bool { return scoreAt(v, i) > max })
+	if start >= stop {
+		return members, nil
+	}
+
+	// Capped, so appending to the range can't write over the set:
+	return v.Slice3(start, stop, stop).Interface().(V), nil
+}
+
+func (s *kvStore[K, V]) ZRank(key K, members V) (ranks []int, err error) {
+	if !isSortedSet[V]() {
+		return nil, ErrNotSortedSet
+	}
+
+	set, _ := s.find(key)
+	positions := positionsOf(reflect.ValueOf(set))
+	m := reflect.ValueOf(members)
+	ranks = make([]int, m.Len())
+	for i := range m.Len() {
+		member, _ := m.Index(i).Interface().(scoredMember).scored()
+		ranks[i] = -1
+		if position, found := positions[member]; found {
+			ranks[i] = position
+		}
+	}
+
+	return ranks, nil
+}
+
+// Reports whether a store's values are slices of `Scored` members, which the
+// sorted set operations need.
+func isSortedSet[V any]() bool {
+	t := reflect.TypeFor[V]()
+	return t.Kind() == reflect.Slice && t.Elem().Implements(reflect.TypeFor[scoredMember]())
+}
+
+func scoreAt(set reflect.Value, i int) float64 {
+	_, score := set.Index(i).Interface().(scoredMember).scored()
+	return score
+}
+
+// Maps each member of a sorted set to its position in it.
+func positionsOf(set reflect.Value) map[any]int {
+	positions := make(map[any]int, set.Len())
+	for i := range set.Len() {
+		member, _ := set.Index(i).Interface().(scoredMember).scored()
+		positions[member] = i
+	}
+	return positions
+}
+
+// Adds `members` to the sorted set `set`, or moves the ones that are already
+// in it to their new score. Members are ordered by score, and members with the
+// same score by when they were added or last moved. Returns the set that
+// leaves, and the members that weren't in it before.
+func withScores[V any](set V, members V) (result V, added V, err error) {
+	if !isSortedSet[V]() {
+		return result, added, ErrNotSortedSet
+	}
+
+	s, m := reflect.ValueOf(&set).Elem(), reflect.ValueOf(&members).Elem()
+	existing := positionsOf(s)
+	// A member that's given more than once gets the last score it's given:
+	latest := make(map[any]int, m.Len())
+	for i := range m.Len() {
+		member, score := m.Index(i).Interface().(scoredMember).scored()
+		if math.IsNaN(score) {
+			return result, added, errNaNScore
+		}
+		latest[member] = i
+	}
+
+	r := reflect.MakeSlice(s.Type(), 0, s.Len()+m.Len())
+	for i := range s.Len() {
+		member, _ := s.Index(i).Interface().(scoredMember).scored()
+		if _, moved := latest[member]; !moved {
+			r = reflect.Append(r, s.Index(i))
+		}
+	}
+	a := reflect.MakeSlice(s.Type(), 0, m.Len())
+	for i := range m.Len() {
+		member, score := m.Index(i).Interface().(scoredMember).scored()
+		if latest[member] != i {
+			continue
+		}
+		if _, found := existing[member]; !found {
+			a = reflect.Append(a, m.Index(i))
+		}
+		at := sort.Search(r.Len(), func(j int) bool { return scoreAt(r, j) > score })
+		r = reflect.Append(r, m.Index(i))
+		reflect.Copy(r.Slice(at+1, r.Len()), r.Slice(at, r.Len()-1))
+		r.Index(at).Set(m.Index(i))
+	}
+
+	return r.Interface().(V), a.Interface().(V), nil
+}
@@ -0,0 +1,64 @@
+package kv
+
+import (
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type board = []Scored[string]
+
+func TestSortedSet(t *testing.T) {
+	defer removeLog()
+
+	store, err := NewStore[string, board](LogPath(logPath))
+	assert.NoError(t, err)
+
+	added, err := store.ZAdd("scores", board{{"ann", 30}, {"bob", 10}, {"cat", 20}})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, added)
+	// Moving a member to a new score doesn't add it again:
+	added, _ = store.ZAdd("scores", board{{"bob", 40}, {"dan", 20}})
+	assert.Equal(t, 1, added)
+
+	members, err := store.ZRangeByScore("scores", math.Inf(-1), math.Inf(1))
+	assert.NoError(t, err)
+	assert.Equal(t, board{{"cat", 20}, {"dan", 20}, {"ann", 30}, {"bob", 40}}, members)
+	members, _ = store.ZRangeByScore("scores", 20, 30)
+	assert.Equal(t, board{{"cat", 20}, {"dan", 20}, {"ann", 30}}, members)
+	members, _ = store.ZRangeByScore("scores", 50, 60)
+	assert.Empty(t, members)
+
+	ranks, err := store.ZRank("scores", board{{Member: "bob"}, {Member: "cat"}, {Member: "eve"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 0, -1}, ranks)
+	store.Close()
+
+	// Only the members added are logged, not the whole set:
+	log, _ := os.ReadFile(logPath)
+	assert.NotContains(t, string(log), `"cat","Score":20},{"Member":"dan"`)
+
+	replayed, err := NewStore[string, board](LogPath(logPath))
+	assert.NoError(t, err)
+	defer replayed.Close()
+	members, _ = replayed.ZRangeByScore("scores", 0, 100)
+	assert.Equal(t, board{{"cat", 20}, {"dan", 20}, {"ann", 30}, {"bob", 40}}, members)
+}
+
+func TestSortedSetErrors(t *testing.T) {
+	store, _ := NewStore[string, board]()
+	defer store.Close()
+	_, err := store.ZAdd("scores", board{{"ann", math.NaN()}})
+	assert.Error(t, err)
+	_, found := store.Get("scores")
+	assert.False(t, found)
+
+	strings, _ := NewStore[string, []string]()
+	defer strings.Close()
+	_, err = strings.ZAdd("scores", []string{"ann"})
+	assert.ErrorIs(t, err, ErrNotSortedSet)
+	_, err = strings.ZRank("scores", []string{"ann"})
+	assert.ErrorIs(t, err, ErrNotSortedSet)
+}
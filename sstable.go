@@ -0,0 +1,231 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"iter"
+	"os"
+	"sort"
+)
+
+// Every `indexInterval`-th record of an SSTable is kept in its in-memory index,
+// so a lookup reads at most this many records from disk.
+const indexInterval = 16
+
+// The false positive rate of each SSTable's Bloom filter, unless the store sets
+// one with `BloomFilter`.
+const defaultFalsePositiveRate = 0.01
+
+// A record in an SSTable: an encoded key and either its encoded value, or a
+// tombstone recording that it was removed.
+type tableRecord struct {
+	key       []byte
+	value     []byte
+	tombstone bool
+}
+
+// An entry in an SSTable's sparse index.
+type tableIndexEntry struct {
+	key    []byte
+	offset int64
+}
+
+// A sorted, immutable file of records. Each record is written as the length of
+// its key, the key, a tombstone flag, the length of its value, and the value.
+type sstable struct {
+	path string
+	file *os.File
+	size int64
+	// The number of records in the table.
+	count int
+	index []tableIndexEntry
+	// Rules out keys that aren't in the table without reading it.
+	filter *bloomFilter[string]
+	// The first error reading the table, if iterating over it stopped early.
+	err error
+}
+
+// Writes records, which must be sorted by key, to a new SSTable at `path`.
+// `count` is an estimate of how many records there are, to size its filter.
+func writeTable(path string, records iter.Seq[tableRecord], count int, falsePositiveRate float64) (*sstable, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &sstable{
+		path:   path,
+		file:   file,
+		filter: newBloomFilter[string](max(count, 1), falsePositiveRate),
+	}
+	buffered := bufio.NewWriter(file)
+	for r := range records {
+		if t.count%indexInterval == 0 {
+			t.index = append(t.index, tableIndexEntry{key: r.key, offset: t.size})
+		}
+		t.filter.add(string(r.key))
+		t.count++
+
+		header := binary.AppendUvarint(nil, uint64(len(r.key)))
+		flag := byte(0)
+		if r.tombstone {
+			flag = 1
+		}
+		n, err := buffered.Write(append(append(header, r.key...), flag))
+		t.size += int64(n)
+		if err != nil {
+			t.remove()
+			return nil, err
+		}
+
+		n, err = buffered.Write(append(binary.AppendUvarint(nil, uint64(len(r.value))), r.value...))
+		t.size += int64(n)
+		if err != nil {
+			t.remove()
+			return nil, err
+		}
+	}
+
+	if err := buffered.Flush(); err != nil {
+		t.remove()
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Reads the record for a key, if the table has one.
+func (t *sstable) get(key []byte) (record tableRecord, found bool, err error) {
+	if !t.filter.mayContain(string(key)) {
+		return record, false, nil
+	}
+
+	// Find the last indexed record at or before the key, and scan from there:
+	i := sort.Search(len(t.index), func(i int) bool { return bytes.Compare(t.index[i].key, key) > 0 }) - 1
+	if i < 0 {
+		return record, false, nil
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(t.file, t.index[i].offset, t.size-t.index[i].offset))
+	for range indexInterval {
+		record, err := readTableRecord(reader)
+		if err == io.EOF {
+			return record, false, nil
+		} else if err != nil {
+			return record, false, err
+		}
+
+		switch bytes.Compare(record.key, key) {
+		case 0:
+			return record, true, nil
+		case 1:
+			return record, false, nil
+		}
+	}
+
+	return record, false, nil
+}
+
+// Iterates over every record in the table, in order. If reading the table
+// fails, iteration stops and the error is kept in `err`.
+func (t *sstable) records() iter.Seq[tableRecord] {
+	return func(yield func(tableRecord) bool) {
+		reader := bufio.NewReader(io.NewSectionReader(t.file, 0, t.size))
+		for {
+			record, err := readTableRecord(reader)
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				t.err = err
+				return
+			}
+
+			if !yield(record) {
+				return
+			}
+		}
+	}
+}
+
+func readTableRecord(reader *bufio.Reader) (tableRecord, error) {
+	record := tableRecord{}
+	keyLength, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return record, err
+	}
+	record.key = make([]byte, keyLength)
+	if _, err := io.ReadFull(reader, record.key); err != nil {
+		return record, io.ErrUnexpectedEOF
+	}
+
+	flag, err := reader.ReadByte()
+	if err != nil {
+		return record, io.ErrUnexpectedEOF
+	}
+	record.tombstone = flag == 1
+
+	valueLength, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return record, io.ErrUnexpectedEOF
+	}
+	record.value = make([]byte, valueLength)
+	if _, err := io.ReadFull(reader, record.value); err != nil {
+		return record, io.ErrUnexpectedEOF
+	}
+
+	return record, nil
+}
+
+// Closes the table and deletes its file.
+func (t *sstable) remove() error {
+	t.file.Close()
+	return os.Remove(t.path)
+}
+
+// Merges sorted sequences of records into one sorted sequence. Sources are
+// ordered newest first, so when several have a record for the same key, the
+// record from the earliest source wins.
+func mergeRecords(sources []iter.Seq[tableRecord]) iter.Seq[tableRecord] {
+	return func(yield func(tableRecord) bool) {
+		nexts := make([]func() (tableRecord, bool), len(sources))
+		heads := make([]*tableRecord, len(sources))
+		advance := func(i int) {
+			if r, ok := nexts[i](); ok {
+				heads[i] = &r
+			} else {
+				heads[i] = nil
+			}
+		}
+		for i, source := range sources {
+			next, stop := iter.Pull(source)
+			defer stop()
+			nexts[i] = next
+			advance(i)
+		}
+
+		for {
+			smallest := -1
+			for i, head := range heads {
+				if head != nil && (smallest < 0 || bytes.Compare(head.key, heads[smallest].key) < 0) {
+					smallest = i
+				}
+			}
+			if smallest < 0 {
+				return
+			}
+
+			record := *heads[smallest]
+			for i, head := range heads {
+				if head != nil && bytes.Equal(head.key, record.key) {
+					advance(i)
+				}
+			}
+
+			if !yield(record) {
+				return
+			}
+		}
+	}
+}
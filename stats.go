@@ -0,0 +1,63 @@
+package kv
+
+import "time"
+
+// Statistics about a store, for monitoring it.
+type Stats struct {
+	// How long the store took to replay its write-ahead log when it opened.
+	ReplayDuration time.Duration
+	// Statistics about each kind of operation made on the store since it
+	// opened, in every namespace, by their names: "get", "set", "unset",
+	// "compare_and_swap", "unset_if", "rename", "rename_if_not_exists",
+	// "set_if_not_exists", "set_many", "transaction", "replace_all",
+	// "grant_lease", "revoke_lease", "merge", "increment", "decrement",
+	// "update", "get_and_delete", "get_and_set", "append", "lpush", "rpush",
+	// "lpop", "sadd", "srem", "zadd", "hset" and "hdel".
+	Operations map[string]OperationStats
+	// How many gets were of keys that weren't in the store.
+	Misses uint64
+	// How many updates are waiting for their shard's update loop to receive
+	// them, including those held in its queue.
+	QueueDepth int
+	// How many updates found their shard's update queue full since the store
+	// opened, and either waited for room in it or, if the store was opened
+	// with `RejectWhenQueueFull`, failed with `ErrQueueFull`. Without
+	// `QueueSize`, that's every update sent while its update loop was busy.
+	QueueFull uint64
+	// How many bytes have been appended to the write-ahead log since the store
+	// opened.
+	LogBytes uint64
+	// The size of the write-ahead log's files on disk, including its snapshot,
+	// in bytes.
+	LogSize int64
+	// How the store's background compactions are going.
+	Compaction CompactionStats
+	// How many keys are in the namespace.
+	Keys int
+}
+
+func (s *kvStore[K, V]) Stats() Stats {
+	stats := Stats{
+		ReplayDuration: s.replayDuration,
+		Operations:     make(map[string]OperationStats, len(s.metrics.operations)),
+		Misses:         s.metrics.misses.Load(),
+		QueueFull:      s.metrics.queueFull.Load(),
+		Compaction:     s.compaction.stats(),
+		Keys:           s.Len(),
+	}
+	// Senders waiting for room are counted by `queued`, and updates already in
+	// a queue by its length:
+	stats.QueueDepth = int(s.metrics.queued.Load())
+	for _, sh := range s.shards {
+		stats.QueueDepth += len(sh.updates)
+	}
+	for name, op := range s.metrics.operations {
+		stats.Operations[name] = op.stats()
+	}
+	if s.log != nil {
+		stats.LogBytes = s.log.appended.Load()
+		stats.LogSize = s.log.diskSize()
+	}
+
+	return stats
+}
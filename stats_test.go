@@ -0,0 +1,92 @@
+package kv
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/qsymmachus/ranger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayDuration(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[int, int](LogPath(logPath))
+	for _, n := range ranger.Int(1, 100) {
+		store.Set(n, n)
+	}
+	store.Close()
+
+	replayed, _ := NewStore[int, int](LogPath(logPath))
+	defer replayed.Close()
+	assert.Greater(t, replayed.Stats().ReplayDuration, time.Duration(0))
+
+	memory, _ := NewStore[int, int]()
+	defer memory.Close()
+	assert.Zero(t, memory.Stats().ReplayDuration)
+}
+
+func TestOperationStats(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, int](LogPath(logPath))
+	defer store.Close()
+	store.Set("a", 1)
+	store.Set("b", 2)
+	store.Unset("b")
+	store.Get("a")
+	store.Get("b")
+
+	stats := store.Stats()
+	assert.Equal(t, uint64(2), stats.Operations["set"].Count)
+	assert.Equal(t, uint64(1), stats.Operations["unset"].Count)
+	assert.Equal(t, uint64(2), stats.Operations["get"].Count)
+	assert.Zero(t, stats.Operations["set_many"].Count)
+	// Every kind of operation is listed, including those never made:
+	assert.ElementsMatch(t, []string{
+		"get", "set", "unset", "compare_and_swap", "unset_if", "rename", "rename_if_not_exists",
+		"set_if_not_exists", "set_many", "transaction", "replace_all", "grant_lease", "revoke_lease",
+		"merge", "increment", "decrement", "update", "get_and_delete", "get_and_set", "append",
+		"lpush", "rpush", "lpop", "sadd", "srem", "zadd", "hset", "hdel",
+	}, slices.Collect(maps.Keys(stats.Operations)))
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, 1, stats.Keys)
+	assert.Zero(t, stats.QueueDepth)
+	assert.Greater(t, stats.LogBytes, uint64(0))
+
+	// Buckets count every operation at or below their bound:
+	latency := stats.Operations["set"].Latency
+	assert.Equal(t, uint64(2), latency.Count)
+	assert.Greater(t, latency.Sum, time.Duration(0))
+	assert.Len(t, latency.Buckets, len(latencyBuckets))
+	for i := 1; i < len(latency.Buckets); i++ {
+		assert.GreaterOrEqual(t, latency.Buckets[i].Count, latency.Buckets[i-1].Count)
+	}
+	assert.Equal(t, uint64(2), latency.Buckets[len(latency.Buckets)-1].Count)
+}
+
+func TestWritePhaseStats(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, int](LogPath(logPath))
+	store.Set("a", 1)
+	store.Increment("a", 2)
+	store.Get("a")
+
+	stats := store.Stats().Operations
+	for _, operation := range []string{"set", "increment"} {
+		assert.Equal(t, uint64(1), stats[operation].QueueWait.Count, operation)
+		assert.Equal(t, uint64(1), stats[operation].Append.Count, operation)
+		assert.Equal(t, uint64(1), stats[operation].Apply.Count, operation)
+		assert.Greater(t, stats[operation].Append.Sum, time.Duration(0), operation)
+	}
+	assert.Empty(t, stats["get"].QueueWait.Buckets)
+	store.Close()
+
+	// Replayed updates weren't made on the store, so they aren't timed:
+	replayed, _ := NewStore[string, int](LogPath(logPath))
+	defer replayed.Close()
+	assert.Zero(t, replayed.Stats().Operations["set"].Apply.Count)
+}
@@ -0,0 +1,60 @@
+package kv
+
+import (
+	"iter"
+	"maps"
+)
+
+// Where a bucket keeps its key/value pairs. Only written by the update loop of
+// the bucket's shard, or while every shard is paused.
+type storage[K comparable, V any] interface {
+	get(key K) (value V, found bool)
+	put(key K, value V) error
+	remove(key K)
+	// Removes every key.
+	reset()
+	len() int
+	keys() iter.Seq[K]
+	all() iter.Seq2[K, V]
+}
+
+// A storage that can freeze its pairs as they are, without copying them, into a
+// storage that later writes don't change, so they can be read while the update
+// loop carries on.
+type freezer[K comparable, V any] interface {
+	freeze() storage[K, V]
+}
+
+// Keeps key/value pairs in a map. Readers must not load it while it's being
+// written.
+type memoryStorage[K comparable, V any] map[K]V
+
+func (m memoryStorage[K, V]) get(key K) (V, bool) {
+	value, found := m[key]
+	return value, found
+}
+
+func (m memoryStorage[K, V]) put(key K, value V) error {
+	m[key] = value
+	return nil
+}
+
+func (m memoryStorage[K, V]) remove(key K) {
+	delete(m, key)
+}
+
+func (m memoryStorage[K, V]) reset() {
+	clear(m)
+}
+
+func (m memoryStorage[K, V]) len() int {
+	return len(m)
+}
+
+func (m memoryStorage[K, V]) keys() iter.Seq[K] {
+	return maps.Keys(m)
+}
+
+func (m memoryStorage[K, V]) all() iter.Seq2[K, V] {
+	return maps.All(m)
+}
@@ -0,0 +1,133 @@
+package kv
+
+import (
+	"container/list"
+	"iter"
+	"sync"
+)
+
+// Keeps every pair of a bucket in a disk-backed storage, the cold tier, and
+// the values of the most recently used keys decoded in memory, the hot tier.
+// Writes go to both, and a read of a cold key promotes it, demoting the least
+// recently used hot key once the hot tier has `capacity` of them. Since reads
+// change the hot tier, both tiers are guarded by a mutex.
+type tieredStorage[K comparable, V any] struct {
+	mu   sync.Mutex
+	cold storage[K, V]
+	// The hot keys, most recently used first, and the element of each one.
+	recent   *list.List
+	hot      map[K]*list.Element
+	capacity int
+}
+
+// A key in the hot tier, and its value.
+type hotEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func newTieredStorage[K comparable, V any](cold storage[K, V], capacity int) *tieredStorage[K, V] {
+	return &tieredStorage[K, V]{
+		cold:     cold,
+		recent:   list.New(),
+		hot:      make(map[K]*list.Element),
+		capacity: capacity,
+	}
+}
+
+func (s *tieredStorage[K, V]) get(key K) (value V, found bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, found := s.hot[key]; found {
+		s.recent.MoveToFront(e)
+		return e.Value.(hotEntry[K, V]).value, true
+	}
+
+	if value, found = s.cold.get(key); found {
+		s.promote(key, value)
+	}
+	return value, found
+}
+
+func (s *tieredStorage[K, V]) put(key K, value V) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.cold.put(key, value); err != nil {
+		return err
+	}
+	s.promote(key, value)
+	return nil
+}
+
+// Makes a key the most recently used in the hot tier, with the value it's just
+// been given, or read from the cold tier. The cold tier holds every key, so
+// demoting one only drops it from the hot tier.
+func (s *tieredStorage[K, V]) promote(key K, value V) {
+	if e, found := s.hot[key]; found {
+		e.Value = hotEntry[K, V]{key, value}
+		s.recent.MoveToFront(e)
+		return
+	}
+
+	s.hot[key] = s.recent.PushFront(hotEntry[K, V]{key, value})
+	if s.recent.Len() > s.capacity {
+		coldest := s.recent.Back()
+		s.recent.Remove(coldest)
+		delete(s.hot, coldest.Value.(hotEntry[K, V]).key)
+	}
+}
+
+func (s *tieredStorage[K, V]) remove(key K) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, found := s.hot[key]; found {
+		s.recent.Remove(e)
+		delete(s.hot, key)
+	}
+	s.cold.remove(key)
+}
+
+func (s *tieredStorage[K, V]) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recent.Init()
+	clear(s.hot)
+	s.cold.reset()
+}
+
+func (s *tieredStorage[K, V]) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.cold.len()
+}
+
+// Visits every key in the cold tier. Only called by the update loop, or while
+// every shard is paused, so the tiers aren't locked while `yield` runs, which
+// may call back into the store.
+func (s *tieredStorage[K, V]) keys() iter.Seq[K] {
+	return s.cold.keys()
+}
+
+// Visits every pair, reading hot values from memory. Like `keys`, it doesn't
+// hold the lock while `yield` runs, and it doesn't promote the keys it reads.
+// Gets can still promote keys meanwhile, so the hot tier is locked to look in
+// it.
+func (s *tieredStorage[K, V]) all() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for k, v := range s.cold.all() {
+			s.mu.Lock()
+			if e, found := s.hot[k]; found {
+				v = e.Value.(hotEntry[K, V]).value
+			}
+			s.mu.Unlock()
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
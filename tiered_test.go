@@ -0,0 +1,71 @@
+package kv
+
+import (
+	"maps"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns the hot keys of a tiered storage, most recently used first.
+func hotKeys[K comparable, V any](s *tieredStorage[K, V]) []K {
+	var keys []K
+	for e := s.recent.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(hotEntry[K, V]).key)
+	}
+	return keys
+}
+
+func TestTieredStorage(t *testing.T) {
+	s := newTieredStorage[string, int](newHAMTStorage[string, int](), 2)
+	s.put("a", 1)
+	s.put("b", 2)
+	s.put("c", 3)
+	assert.Equal(t, []string{"c", "b"}, hotKeys(s))
+
+	// Reading a cold key promotes it, and demotes the coldest hot one:
+	v, found := s.get("a")
+	assert.True(t, found)
+	assert.Equal(t, 1, v)
+	assert.Equal(t, []string{"a", "c"}, hotKeys(s))
+	s.get("c")
+	assert.Equal(t, []string{"c", "a"}, hotKeys(s))
+
+	s.remove("c")
+	assert.Equal(t, []string{"a"}, hotKeys(s))
+	assert.Equal(t, 2, s.len())
+	assert.ElementsMatch(t, []string{"a", "b"}, slices.Collect(s.keys()))
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, maps.Collect(s.all()))
+
+	s.reset()
+	assert.Empty(t, hotKeys(s))
+	assert.Equal(t, 0, s.len())
+}
+
+func TestHotEntries(t *testing.T) {
+	defer os.Remove(mmapPath)
+
+	store, err := NewStore[int, pet](MmapStorage(mmapPath), HotEntries(10))
+	assert.NoError(t, err)
+	defer store.Close()
+	for i := range 100 {
+		store.Set(i, pet{"Toby", "dog"})
+	}
+	store.Set(0, pet{"Rex", "cat"})
+
+	v, found := store.Get(0)
+	assert.True(t, found)
+	assert.Equal(t, pet{"Rex", "cat"}, v)
+	v, found = store.Get(50)
+	assert.True(t, found)
+	assert.Equal(t, pet{"Toby", "dog"}, v)
+	assert.Equal(t, 100, store.Len())
+
+	tiers := store.(*kvStore[int, pet]).shards[0].namespaces()[""].values.(*tieredStorage[int, pet])
+	assert.Len(t, tiers.hot, 10)
+
+	_, err = NewStore[int, pet](HotEntries(10))
+	assert.Error(t, err)
+}
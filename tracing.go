@@ -0,0 +1,113 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The name of the tracer that spans are recorded with.
+const tracerName = "github.com/qsymmachus/kv"
+
+// Option that records OpenTelemetry spans with tracers from `provider`: one
+// for each get and write made on the store, named like "kv.get" and "kv.set",
+// and one for each append to the write-ahead log, and for its replay when the
+// store opens. Spans of writes record how long they waited in their shard's
+// queue. Keys are recorded as hashes, so they aren't exported, and values only
+// by their size. Since the store's methods don't take a context, each span
+// starts a trace of its own, except that appends are children of the first
+// write they append.
+func Tracing(provider trace.TracerProvider) Option {
+	return func(optsData *optionsData) {
+		optsData.tracer = provider.Tracer(tracerName)
+	}
+}
+
+// Hashes a key, so spans can tell keys apart without recording them.
+func keyHash(key any) int64 {
+	h := fnv.New64a()
+	if s, ok := key.(string); ok {
+		h.Write([]byte(s))
+	} else {
+		fmt.Fprint(h, key)
+	}
+	return int64(h.Sum64())
+}
+
+// Gets the size of a value: the length of a string or `[]byte`, or of the
+// JSON encoding of anything else.
+func valueSize(value any) int {
+	switch v := value.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	default:
+		encoded, _ := json.Marshal(v)
+		return len(encoded)
+	}
+}
+
+// Starts a span for an operation on a key, or returns nil if the store isn't
+// traced.
+func (s *kvStore[K, V]) startSpan(operation string, key K, attributes ...attribute.KeyValue) trace.Span {
+	if s.options.tracer == nil {
+		return nil
+	}
+
+	attributes = append(attributes, attribute.Int64("kv.key.hash", keyHash(key)))
+	if s.namespace != "" {
+		attributes = append(attributes, attribute.String("kv.namespace", s.namespace))
+	}
+	_, span := s.options.tracer.Start(context.Background(), "kv."+operation, trace.WithAttributes(attributes...))
+	return span
+}
+
+// Ends a span, recording the error that its operation failed with, if any.
+func endSpan(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Records how long the writes in a batch waited in the queue, and starts a
+// span for appending them to the log, as a child of the first one that's
+// traced. Returns nil if none are.
+func (s *kvStore[K, V]) traceBatch(batch []update[K, V], records int) trace.Span {
+	var parent trace.Span
+	var links []trace.Link
+	for _, u := range batch {
+		if u.span == nil {
+			continue
+		}
+
+		u.span.SetAttributes(attribute.Float64("kv.queue.wait_ms", float64(time.Since(u.queued))/float64(time.Millisecond)))
+		if parent == nil {
+			parent = u.span
+		} else {
+			links = append(links, trace.Link{SpanContext: u.span.SpanContext()})
+		}
+	}
+	if parent == nil || records == 0 {
+		return nil
+	}
+
+	ctx := trace.ContextWithSpan(context.Background(), parent)
+	_, span := s.options.tracer.Start(ctx, "kv.wal.append",
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("kv.wal.records", records)),
+	)
+	return span
+}
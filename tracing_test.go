@@ -0,0 +1,68 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Gets the value of a span's attribute.
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, a := range span.Attributes() {
+		if a.Key == key {
+			return a.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracing(t *testing.T) {
+	defer removeLog()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	store, _ := NewStore[string, string](LogPath(logPath), Tracing(provider))
+	store.Set("name", "ralph")
+	store.Get("name")
+	store.Unset("name")
+	store.Close()
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+	}
+	assert.ElementsMatch(t, []string{"kv.wal.replay", "kv.set", "kv.wal.append", "kv.get", "kv.unset", "kv.wal.append"}, names)
+
+	spans := recorder.Ended()
+	set, get := spans[2], spans[3]
+	assert.Equal(t, "kv.set", set.Name())
+	hash, _ := spanAttribute(set, "kv.key.hash")
+	assert.Equal(t, keyHash("name"), hash.AsInt64())
+	size, _ := spanAttribute(set, "kv.value.size")
+	assert.Equal(t, int64(5), size.AsInt64())
+	_, waited := spanAttribute(set, "kv.queue.wait_ms")
+	assert.True(t, waited)
+
+	// Appends are children of the writes they append:
+	assert.Equal(t, "kv.wal.append", spans[1].Name())
+	assert.Equal(t, set.SpanContext().SpanID(), spans[1].Parent().SpanID())
+
+	found, _ := spanAttribute(get, "kv.found")
+	assert.True(t, found.AsBool())
+}
+
+func TestTracingErrors(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	store, _ := NewStore[string, string](Tracing(provider))
+	defer store.Close()
+	store.SetWithLease("name", "ralph", 12345)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 1)
+	assert.Len(t, spans[0].Events(), 1)
+}
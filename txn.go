@@ -0,0 +1,126 @@
+package kv
+
+import (
+	"errors"
+	"sync"
+)
+
+// Returned by a transaction's methods once it has been committed or rolled
+// back.
+var ErrTxnDone = errors.New("Transaction has already been committed or rolled back")
+
+// Writes staged by a transaction, which aren't applied to the store until
+// they're committed. Returned by `BeginTxn`.
+type Txn[K comparable, V any] interface {
+	// Gets a key's value as the transaction would leave it: the value staged
+	// for it, or else the value it has in the store.
+	Get(key K) (value V, found bool)
+	// Stages setting a key.
+	Set(key K, value V) error
+	// Stages unsetting a key.
+	Unset(key K) error
+	// Applies every staged write to the store as a single atomic update,
+	// written to the log as one record, and ends the transaction. Returns the
+	// update's revision, or 0 if nothing was staged.
+	Commit() (revision uint64, err error)
+	// Discards every staged write, and ends the transaction.
+	Rollback() error
+}
+
+// A write staged by a transaction.
+type staged[V any] struct {
+	value   V
+	deleted bool
+}
+
+// A transaction on a store in this process.
+type txn[K comparable, V any] struct {
+	store *kvStore[K, V]
+	// Guards the fields below, so a transaction can be shared by goroutines.
+	mu     sync.Mutex
+	writes map[K]staged[V]
+	done   bool
+}
+
+func (s *kvStore[K, V]) BeginTxn() Txn[K, V] {
+	return &txn[K, V]{store: s, writes: make(map[K]staged[V])}
+}
+
+func (t *txn[K, V]) Get(key K) (value V, found bool) {
+	t.mu.Lock()
+	w, found := t.writes[key]
+	t.mu.Unlock()
+	if found {
+		return w.value, !w.deleted
+	}
+
+	return t.store.Get(key)
+}
+
+func (t *txn[K, V]) Set(key K, value V) error {
+	return t.stage(key, staged[V]{value: value})
+}
+
+func (t *txn[K, V]) Unset(key K) error {
+	return t.stage(key, staged[V]{deleted: true})
+}
+
+func (t *txn[K, V]) stage(key K, w staged[V]) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return ErrTxnDone
+	}
+	t.writes[key] = w
+	return nil
+}
+
+func (t *txn[K, V]) Commit() (revision uint64, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return 0, ErrTxnDone
+	}
+	t.done = true
+	if len(t.writes) == 0 {
+		return 0, nil
+	}
+
+	u := t.store.newUpdate(transaction, *new(K), *new(V))
+	for k, w := range t.writes {
+		if w.deleted {
+			u.Unsets = append(u.Unsets, k)
+		} else {
+			u.Entries = append(u.Entries, entry[K, V]{Key: k, Value: w.value})
+		}
+	}
+
+	result := t.store.write(u)
+	return result.revision, result.err
+}
+
+func (t *txn[K, V]) Rollback() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+	t.writes = nil
+	return nil
+}
+
+// Unsets every key of a `transaction` update's `Unsets`, in the shards that own
+// them, and sets its `Entries`. The caller must have paused every shard.
+func (s *kvStore[K, V]) applyTransaction(update update[K, V]) error {
+	for _, k := range update.Unsets {
+		s.shardFor(k).lookup(update.Namespace).remove(k)
+		s.attach(namespacedKey[K]{update.Namespace, k}, 0)
+		s.stamp(namespacedKey[K]{update.Namespace, k}, update)
+	}
+
+	return s.putEntries(update)
+}
@@ -0,0 +1,98 @@
+package kv
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxn(t *testing.T) {
+	defer removeLog()
+
+	var unset []string
+	store, _ := NewStore[string, int](LogPath(logPath), Shards(4), OnAfterUnset(func(key string) { unset = append(unset, key) }))
+	store.SetMany(map[string]int{"alice": 100, "bob": 50})
+
+	txn := store.BeginTxn()
+	alice, _ := txn.Get("alice")
+	assert.NoError(t, txn.Set("alice", alice-30))
+	assert.NoError(t, txn.Set("carol", 30))
+	assert.NoError(t, txn.Unset("bob"))
+
+	// Staged writes are only seen by the transaction until it commits:
+	v, _ := txn.Get("alice")
+	assert.Equal(t, 70, v)
+	_, found := txn.Get("bob")
+	assert.False(t, found)
+	v, _ = store.Get("alice")
+	assert.Equal(t, 100, v)
+	_, found = store.Get("carol")
+	assert.False(t, found)
+
+	revision, err := txn.Commit()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), revision)
+	assert.Equal(t, []string{"bob"}, unset)
+	v, _ = store.Get("alice")
+	assert.Equal(t, 70, v)
+	v, _ = store.Get("carol")
+	assert.Equal(t, 30, v)
+	_, found = store.Get("bob")
+	assert.False(t, found)
+
+	// The commit is a single record, after the header and the first update:
+	log, _ := os.ReadFile(logPath)
+	assert.Equal(t, 3, strings.Count(string(log), "\n"))
+
+	_, err = txn.Commit()
+	assert.ErrorIs(t, err, ErrTxnDone)
+	assert.ErrorIs(t, txn.Set("dave", 1), ErrTxnDone)
+	store.Close()
+
+	replayed, _ := NewStore[string, int](LogPath(logPath), Shards(4))
+	defer replayed.Close()
+	assert.Equal(t, map[string]int{"alice": 70, "carol": 30}, replayed.GetAll())
+	v, found, _ = replayed.GetAt("bob", revision)
+	assert.False(t, found)
+	v, _, _ = replayed.GetAt("carol", revision)
+	assert.Equal(t, 30, v)
+}
+
+func TestTxnRollback(t *testing.T) {
+	store, _ := NewStore[string, int]()
+	defer store.Close()
+	store.Set("a", 1)
+
+	txn := store.BeginTxn()
+	txn.Set("a", 2)
+	txn.Unset("a")
+	assert.NoError(t, txn.Rollback())
+	v, _ := store.Get("a")
+	assert.Equal(t, 1, v)
+	assert.ErrorIs(t, txn.Rollback(), ErrTxnDone)
+	_, err := txn.Commit()
+	assert.ErrorIs(t, err, ErrTxnDone)
+
+	// A transaction with nothing staged writes nothing:
+	revision, err := store.BeginTxn().Commit()
+	assert.NoError(t, err)
+	assert.Zero(t, revision)
+}
+
+func TestTxnNamespace(t *testing.T) {
+	store, _ := NewStore[string, int](Shards(2))
+	defer store.Close()
+	users := store.Namespace("users")
+	store.Set("a", 1)
+
+	txn := users.BeginTxn()
+	txn.Set("a", 2)
+	txn.Unset("b")
+	txn.Commit()
+	v, _ := store.Get("a")
+	assert.Equal(t, 1, v)
+	v, _ = users.Get("a")
+	assert.Equal(t, 2, v)
+}
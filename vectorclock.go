@@ -0,0 +1,74 @@
+package kv
+
+import "maps"
+
+// A vector clock, which records how much of each node's history a version of
+// a key has seen: for each node that has written the key, the hybrid logical
+// clock time of its latest write that the version includes. Comparing two
+// clocks tells whether one version was written with knowledge of the other,
+// or whether they were written concurrently, and one would be lost if the
+// other simply replaced it.
+type VectorClock map[string]uint64
+
+// Reports whether the version with clock `c` happened before the one with
+// clock `other`: everything `c` has seen, `other` has seen too, and more.
+func (c VectorClock) Before(other VectorClock) bool {
+	for node, timestamp := range c {
+		if other[node] < timestamp {
+			return false
+		}
+	}
+
+	return !maps.Equal(c, other)
+}
+
+// Reports whether the versions with clocks `c` and `other` were written
+// concurrently, neither of them seeing the other.
+func (c VectorClock) Concurrent(other VectorClock) bool {
+	return !maps.Equal(c, other) && !c.Before(other) && !other.Before(c)
+}
+
+// Returns a copy of the clock, with `node`'s latest write at `timestamp`.
+func (c VectorClock) with(node string, timestamp uint64) VectorClock {
+	clock := maps.Clone(c)
+	if clock == nil {
+		clock = make(VectorClock, 1)
+	}
+	clock[node] = timestamp
+	return clock
+}
+
+// Returns a clock that has seen everything both clocks have.
+func (c VectorClock) merged(other VectorClock) VectorClock {
+	clock := maps.Clone(c)
+	if clock == nil {
+		clock = make(VectorClock, len(other))
+	}
+	for node, timestamp := range other {
+		clock[node] = max(clock[node], timestamp)
+	}
+	return clock
+}
+
+// Gets the clock an update leaves its key with, given the key's clock before
+// it: the clock a `merge` brought from another store, or else `clock` moved on
+// by the write of the node that made the update, if the store uses
+// `VectorClocks`.
+func (u update[K, V]) nextClock(clock VectorClock) VectorClock {
+	switch {
+	case u.Clock != nil:
+		return u.Clock
+	case u.Node != "":
+		return clock.with(u.Node, u.Timestamp)
+	default:
+		return clock
+	}
+}
+
+func (s *kvStore[K, V]) GetVersioned(key K) (value V, clock VectorClock, found bool) {
+	s.commit.Lock()
+	defer s.commit.Unlock()
+
+	value, found = s.shardFor(key).lookup(s.namespace).values.get(key)
+	return value, maps.Clone(s.clocks[namespacedKey[K]{s.namespace, key}]), found
+}
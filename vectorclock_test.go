@@ -0,0 +1,75 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVectorClockOrder(t *testing.T) {
+	a := VectorClock{"east": 1}
+	b := VectorClock{"east": 1, "west": 2}
+	c := VectorClock{"east": 2}
+
+	assert.True(t, a.Before(b))
+	assert.False(t, b.Before(a))
+	assert.False(t, a.Before(a))
+	assert.True(t, VectorClock(nil).Before(a))
+
+	assert.True(t, b.Concurrent(c))
+	assert.False(t, a.Concurrent(b))
+	assert.False(t, a.Concurrent(a))
+	assert.Equal(t, VectorClock{"east": 2, "west": 2}, b.merged(c))
+}
+
+func TestVectorClocks(t *testing.T) {
+	east, _ := NewStore[string, string](LastWriterWins(), VectorClocks("east"))
+	defer east.Close()
+	west, _ := NewStore[string, string](LastWriterWins(), VectorClocks("west"))
+	defer west.Close()
+
+	// A write made after seeing another follows it:
+	east.Set("name", "ralph")
+	assert.NoError(t, west.Merge(east))
+	_, seen, _ := west.GetVersioned("name")
+	west.Set("name", "ziggy")
+	value, clock, found := west.GetVersioned("name")
+	assert.Equal(t, "ziggy", value)
+	assert.True(t, found)
+	assert.True(t, seen.Before(clock))
+
+	// Writes that didn't see each other are concurrent:
+	east.Set("food", "pizza")
+	west.Set("food", "tacos")
+	_, eastClock, _ := east.GetVersioned("food")
+	_, westClock, _ := west.GetVersioned("food")
+	assert.True(t, eastClock.Concurrent(westClock))
+
+	// Merging them resolves the conflict, and the result has seen both:
+	assert.NoError(t, east.Merge(west))
+	assert.NoError(t, west.Merge(east))
+	assert.Equal(t, east.Versions(), west.Versions())
+	_, merged, _ := east.GetVersioned("food")
+	assert.True(t, eastClock.Before(merged))
+	assert.True(t, westClock.Before(merged))
+}
+
+func TestVectorClocksReplay(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath), LastWriterWins(), VectorClocks("east"))
+	store.Set("name", "ralph")
+	store.SetMany(map[string]string{"food": "pizza"})
+	store.Unset("food")
+	before := store.Versions()
+	store.Close()
+
+	replayed, _ := NewStore[string, string](LogPath(logPath), LastWriterWins(), VectorClocks("east"))
+	defer replayed.Close()
+	assert.Equal(t, before, replayed.Versions())
+	assert.NoError(t, replayed.Compact())
+	assert.Equal(t, before, replayed.Versions())
+
+	_, err := NewStore[string, string](VectorClocks("east"))
+	assert.Error(t, err)
+}
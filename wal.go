@@ -0,0 +1,1212 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Records smaller than this aren't worth compressing: gzip's own overhead would
+// outweigh the savings.
+const compressionThreshold = 512
+
+// The largest record that can be read back from the log. Records for bulk
+// updates, like `SetMany` and `Restore`, can be much larger than a single value.
+const maxRecordSize = 1 << 30
+
+// Every gzip stream starts with these bytes, which a JSON record never does.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Returned when replaying a log whose records fail their checksum, or can't be
+// read at all. Use the `RecoveryMode` option to recover from a corrupt tail.
+var ErrCorruptRecord = errors.New("Corrupt record in the log")
+
+// How the store handles a corrupt tail at the end of its write-ahead log, which
+// is usually caused by the process crashing while it was appending a record.
+type Recovery uint8
+
+const (
+	// The store fails to open. This is the default.
+	FailOnCorruptTail Recovery = 0
+	// The log is truncated after its last good record, so new records are
+	// appended after it.
+	TruncateCorruptTail Recovery = 1
+)
+
+// A summary of the last time the store replayed its write-ahead log.
+type ReplaySummary struct {
+	// The number of records that were replayed.
+	Recovered int
+	// The number of corrupt records that were truncated from the tail of the log.
+	Dropped int
+	// Bad records that were skipped, when replaying with `SkipBadRecords`.
+	Skipped []SkippedRecord
+}
+
+// How far the store has got with replaying its write-ahead log, as reported to
+// an `OnReplayProgress` hook.
+type ReplayProgress struct {
+	// The number of records replayed so far.
+	Records int
+	// The number of bytes read so far, across every file in the log.
+	Bytes int64
+	// The number of bad records skipped so far, when replaying with
+	// `SkipBadRecords`.
+	Skipped int
+	// Whether the whole log has been replayed.
+	Done bool
+}
+
+// How many lines of the log are read between reports of the replay's progress.
+const replayProgressInterval = 10000
+
+// A record that couldn't be replayed, and was skipped.
+type SkippedRecord struct {
+	// The log file the record is in.
+	Path string
+	// The record's line number in the file, starting at 1.
+	Line int
+	// Why the record couldn't be replayed.
+	Err error
+}
+
+// How the store handles bad records when it replays its write-ahead log.
+type Replay uint8
+
+const (
+	// The store fails to open if any record can't be replayed. This is the
+	// default.
+	Strict Replay = 0
+	// Records that can't be replayed are skipped, and listed in the store's
+	// `ReplaySummary`.
+	SkipBadRecords Replay = 1
+)
+
+// A write-ahead log: a file of update records, one per line, that the store
+// appends to before applying each update, and replays when it starts. The log
+// can be split into numbered segment files, `<path>.000001`, `<path>.000002`
+// and so on, so it doesn't grow into one huge file.
+type writeAheadLog struct {
+	path string
+	// The file being appended to: either `path`, or the latest segment.
+	file *os.File
+	// The size of `file`, in bytes, including records still in `buffer`.
+	size int64
+	// Disk space is allocated for `file` in steps of this many bytes, ahead of
+	// the records appended to it. If it's 0, space is allocated as records are
+	// written.
+	preallocateSize int64
+	// How many bytes of `file` have had space allocated for them.
+	preallocated int64
+	// Whether files are appended to with `dsyncFlag`, so every write reaches
+	// the disk before it returns, and the log never needs syncing.
+	syncWrites bool
+	// Holds appended records until it fills up, or is flushed, if the log is
+	// buffered. Nil if every append writes to `file`.
+	buffer *bufio.Writer
+	// The number of bytes appended to the log since it was opened, which
+	// `Stats` reads without holding `commit`.
+	appended atomic.Uint64
+	// The number of the latest segment, or 0 if the log isn't segmented.
+	segment int
+	// Once the latest segment reaches this size, in bytes, a new one is started.
+	// If it's 0, the log isn't segmented.
+	segmentSize int64
+	// Encrypts and decrypts records, if the log is encrypted at rest.
+	aead cipher.AEAD
+	// Whether to compress large records before appending them.
+	compress bool
+	// Encoded records larger than this, in bytes, are kept in blob files that
+	// the log refers to. If it's 0, every record is kept in the log.
+	blobThreshold int
+	// How to handle a corrupt tail when replaying the log.
+	recovery Recovery
+	// How to handle any other bad records when replaying the log.
+	replayMode Replay
+	// Whether the log was opened read-only, so it's never written to.
+	readOnly bool
+	// Whether records are binary, rather than JSON, so they must never be
+	// written as plain text.
+	binary bool
+	// Holds an exclusive lock on the log, so no other store writes to it.
+	lock *os.File
+	// Called with the replay's progress every so often while the log is
+	// replayed, if it's set.
+	progress func(ReplayProgress)
+	// The number of bytes read from files that have already been replayed.
+	replayed int64
+	// How many times blobs the log no longer refers to have been removed, which
+	// `scan` reads without holding `commit`.
+	removals atomic.Uint64
+}
+
+// Returned by `NewStore` when another store, possibly in another process, has
+// the same write-ahead log open.
+var ErrLogLocked = errors.New("Log is locked by another store")
+
+// How often a store waiting for another store's lock on its log checks for it.
+const lockRetryInterval = 10 * time.Millisecond
+
+// The version of the log's format that this package writes. Every file in the
+// log starts with a header line naming the version it was written in, so a
+// change to the format can be detected, and older files read the way they were
+// written. Files from before headers were added are version 0.
+const logVersion = 1
+
+// Starts the header line at the start of every file in the log. No record can
+// start with it, since records are JSON or base64.
+const headerPrefix = "#kv-log "
+
+// Returned by `NewStore` when its write-ahead log was written in a newer format
+// than this version of the package can read.
+var ErrUnsupportedVersion = errors.New("Log was written in a newer format")
+
+// The header line at the start of a file in the log.
+type logHeader struct {
+	version int
+	// Whether the file's records are encrypted.
+	encrypted bool
+	// Whether the file's records were encoded by a binary codec.
+	binary bool
+}
+
+// Returns the header for files written by this log.
+func (l *writeAheadLog) header() logHeader {
+	return logHeader{version: logVersion, encrypted: l.aead != nil, binary: l.binary}
+}
+
+// Turns a header into the line written at the start of a file, with its
+// checksum, like `#kv-log 1 encrypted`.
+func (h logHeader) line() []byte {
+	line := fmt.Appendf(nil, "%s%d", headerPrefix, h.version)
+	if h.encrypted {
+		line = append(line, " encrypted"...)
+	}
+	if h.binary {
+		line = append(line, " binary"...)
+	}
+
+	return append(addChecksum(line), '\n')
+}
+
+// Reports whether a line of the log is a header, rather than a record.
+func isHeader(line []byte) bool {
+	return bytes.HasPrefix(line, []byte(headerPrefix))
+}
+
+// Parses a header line, and checks that this log can read the file it starts.
+func (l *writeAheadLog) readHeader(line []byte) (logHeader, error) {
+	payload, _, err := verifyChecksum(line)
+	if err != nil {
+		return logHeader{}, err
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(string(payload), headerPrefix))
+	if len(fields) == 0 {
+		return logHeader{}, fmt.Errorf("%w: malformed header", ErrCorruptRecord)
+	}
+	h := logHeader{}
+	if h.version, err = strconv.Atoi(fields[0]); err != nil {
+		return logHeader{}, fmt.Errorf("%w: malformed header", ErrCorruptRecord)
+	}
+	for _, field := range fields[1:] {
+		switch field {
+		case "encrypted":
+			h.encrypted = true
+		case "binary":
+			h.binary = true
+		}
+	}
+
+	if h.version > logVersion {
+		return h, fmt.Errorf("%w: version %d", ErrUnsupportedVersion, h.version)
+	}
+	if h.encrypted && l.aead == nil {
+		return h, errors.New("Log is encrypted, but no encryption key was given")
+	}
+	if !h.encrypted && l.aead != nil {
+		return h, errors.New("Log isn't encrypted, but an encryption key was given")
+	}
+	if h.binary != l.binary {
+		return h, errors.New("Log was written with a different codec")
+	}
+
+	return h, nil
+}
+
+// Opens the log at `path`, creating it if it doesn't exist yet, unless it's
+// opened read-only.
+func openLog(path string, options *optionsData) (*writeAheadLog, error) {
+	log, err := newLogFormat(options)
+	if err != nil {
+		return nil, err
+	}
+	log.path = path
+	log.segmentSize = options.segmentSize
+	log.blobThreshold = options.blobThreshold
+	log.preallocateSize = options.preallocateSize
+	log.syncWrites = options.syncWrites
+	log.recovery = options.recovery
+	log.replayMode = options.replayMode
+	log.readOnly = options.readOnly
+	log.progress = options.replayProgress
+	if options.logBufferSize > 0 && !log.readOnly {
+		log.buffer = bufio.NewWriterSize(nil, options.logBufferSize)
+	}
+
+	// Readers don't need the lock, since they never write to the log:
+	if !log.readOnly {
+		lock, err := lockLog(log.lockPath(), options.lockTimeout)
+		if err != nil {
+			return nil, err
+		}
+		log.lock = lock
+	}
+
+	if log.segmentSize > 0 {
+		segments, err := log.segments()
+		if err != nil {
+			log.unlock()
+			return nil, err
+		}
+
+		log.segment = 1
+		if len(segments) > 0 {
+			log.segment = segments[len(segments)-1]
+		}
+	}
+
+	if err := log.openSegment(); err != nil {
+		log.unlock()
+		return nil, err
+	}
+
+	return log, nil
+}
+
+// Returns a log that isn't backed by any file, for encoding and decoding files
+// in the format the options give, such as archived copies of the log.
+func newLogFormat(options *optionsData) (*writeAheadLog, error) {
+	log := &writeAheadLog{
+		compress: options.compressLog,
+		binary:   options.codec != JSONCodec,
+	}
+
+	if options.encryptionKey != nil {
+		block, err := aes.NewCipher(options.encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		if log.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+
+	return log, nil
+}
+
+// Returns the path of the file that's locked while the log is open. The log
+// itself isn't locked, since a segmented log keeps replacing its files.
+func (l *writeAheadLog) lockPath() string {
+	return l.path + ".lock"
+}
+
+// Locks the file at `path`, creating it if it doesn't exist. If another store
+// holds the lock, it retries until `timeout` passes, then returns `ErrLogLocked`.
+func lockLog(path string, timeout time.Duration) (*os.File, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			return nil, err
+		}
+
+		err = tryLock(file)
+		if err == nil {
+			// The store that held the lock may have removed the file while
+			// this one was waiting for it, in which case start over:
+			info, statErr := os.Stat(path)
+			locked, _ := file.Stat()
+			if statErr == nil && os.SameFile(info, locked) {
+				return file, nil
+			}
+		}
+		file.Close()
+		if err != nil && (err != ErrLogLocked || time.Now().After(deadline)) {
+			return nil, err
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// Releases the lock on the log, if it holds one, and removes the lock file.
+func (l *writeAheadLog) unlock() {
+	if l.lock != nil {
+		// Remove the file before closing it, which releases the lock, so no other
+		// store can lock it and then have it removed:
+		os.Remove(l.lockPath())
+		l.lock.Close()
+		l.lock = nil
+	}
+}
+
+// Returns the path of a numbered segment.
+func (l *writeAheadLog) segmentPath(segment int) string {
+	return fmt.Sprintf("%s.%06d", l.path, segment)
+}
+
+// Returns the number of every segment that exists, in ascending order.
+func (l *writeAheadLog) segments() ([]int, error) {
+	matches, err := filepath.Glob(l.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []int
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(filepath.Base(match), filepath.Base(l.path)+".")
+		if n, err := strconv.Atoi(suffix); err == nil {
+			segments = append(segments, n)
+		}
+	}
+	sort.Ints(segments)
+
+	return segments, nil
+}
+
+// Returns the paths of every file in the log, in the order they were written.
+// An unsegmented log that was later segmented is read before its segments.
+func (l *writeAheadLog) files() ([]string, error) {
+	if l.segmentSize == 0 {
+		return []string{l.path}, nil
+	}
+
+	var files []string
+	if _, err := os.Stat(l.path); err == nil {
+		files = append(files, l.path)
+	}
+
+	segments, err := l.segments()
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		files = append(files, l.segmentPath(segment))
+	}
+
+	return files, nil
+}
+
+// Opens the file that new records are appended to.
+func (l *writeAheadLog) openSegment() error {
+	path := l.path
+	if l.segment > 0 {
+		path = l.segmentPath(l.segment)
+	}
+
+	flag := os.O_APPEND | os.O_RDWR | os.O_CREATE
+	if l.syncWrites {
+		flag |= dsyncFlag
+	}
+	if l.readOnly {
+		flag = os.O_RDONLY
+	}
+
+	file, err := os.OpenFile(path, flag, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file = file
+	l.size = info.Size()
+	if l.buffer != nil {
+		l.buffer.Reset(file)
+	}
+
+	// Start each new file with a header:
+	if l.size == 0 && !l.readOnly {
+		n, err := l.file.Write(l.header().line())
+		l.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	l.preallocated = 0
+	return l.preallocate()
+}
+
+// Allocates disk space for the next step of the file being appended to, once
+// its records have reached the space already allocated, so appends don't have
+// to allocate blocks one at a time.
+func (l *writeAheadLog) preallocate() error {
+	if l.preallocateSize == 0 || l.readOnly || l.size < l.preallocated {
+		return nil
+	}
+
+	l.preallocated = (l.size/l.preallocateSize + 1) * l.preallocateSize
+	return preallocate(l.file, l.preallocated)
+}
+
+// Frees any space allocated past the end of the file being appended to,
+// before it's closed. The caller must have flushed the log.
+func (l *writeAheadLog) releasePreallocated() error {
+	if l.preallocateSize == 0 || l.readOnly {
+		return nil
+	}
+
+	return l.file.Truncate(l.size)
+}
+
+// Closes the latest segment and starts a new one. The segment is synced first,
+// so `sync` only ever has the latest one to sync.
+func (l *writeAheadLog) rotate() error {
+	if err := l.flush(); err != nil {
+		return err
+	}
+	if err := l.releasePreallocated(); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+
+	l.segment++
+	return l.openSegment()
+}
+
+// Appends records to the log, in a single write.
+func (l *writeAheadLog) append(records ...[]byte) error {
+	var lines []byte
+	for _, record := range records {
+		line, err := l.encode(record)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, addChecksum(line)...), '\n')
+	}
+
+	var n int
+	var err error
+	if l.buffer != nil {
+		n, err = l.buffer.Write(lines)
+	} else {
+		n, err = l.file.Write(lines)
+	}
+	l.size += int64(n)
+	l.appended.Add(uint64(n))
+	if err != nil {
+		return err
+	}
+
+	if l.segmentSize > 0 && l.size >= l.segmentSize {
+		return l.rotate()
+	}
+
+	return l.preallocate()
+}
+
+// Reads every record in the log, in order, and calls `fn` with each one.
+// Corrupt records at the very end of the log are handled according to its
+// recovery mode; corrupt records anywhere else are always an error.
+func (l *writeAheadLog) replay(fn func(record []byte) error) (ReplaySummary, error) {
+	summary := ReplaySummary{}
+	files, err := l.files()
+	if err != nil {
+		return summary, err
+	}
+
+	if _, err := os.Stat(l.snapshotPath()); err == nil {
+		if err := l.replayFile(l.snapshotPath(), false, &summary, fn); err != nil {
+			return summary, err
+		}
+	}
+
+	for i, path := range files {
+		last := i == len(files)-1
+		if err := l.replayFile(path, last, &summary, fn); err != nil {
+			return summary, err
+		}
+	}
+
+	if l.progress != nil {
+		l.progress(ReplayProgress{Records: summary.Recovered, Bytes: l.replayed, Skipped: len(summary.Skipped), Done: true})
+	}
+
+	return summary, nil
+}
+
+// Reads every record in one of the log's files.
+func (l *writeAheadLog) replayFile(path string, last bool, summary *ReplaySummary, fn func(record []byte) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Bad records that will be the log's tail, if no good record follows them,
+	// and where they start:
+	var tail []SkippedRecord
+	var tailOffset int64
+	var offset int64
+	lineNumber := 0
+
+	// Count what was read from this file towards the replay's progress:
+	defer func() { l.replayed += offset }()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxRecordSize)
+	for scanner.Scan() {
+		if l.progress != nil && lineNumber > 0 && lineNumber%replayProgressInterval == 0 {
+			skipped := len(summary.Skipped) + len(tail)
+			l.progress(ReplayProgress{Records: summary.Recovered, Bytes: l.replayed + offset, Skipped: skipped})
+		}
+
+		line := scanner.Bytes()
+		lineOffset := offset
+		offset += int64(len(line)) + 1
+		lineNumber++
+
+		if lineNumber == 1 && isHeader(line) {
+			// Every version so far has the same record format, so there's
+			// nothing to do with the header but check it:
+			if _, err := l.readHeader(line); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := l.replayLine(line, fn); err != nil {
+			recoverable := errors.Is(err, ErrCorruptRecord) && last && l.recovery == TruncateCorruptTail
+			if !recoverable && l.replayMode != SkipBadRecords {
+				return err
+			}
+
+			if len(tail) == 0 {
+				tailOffset = lineOffset
+			}
+			tail = append(tail, SkippedRecord{Path: path, Line: lineNumber, Err: err})
+			continue
+		}
+
+		if len(tail) > 0 {
+			// A good record after a bad one means the bad ones aren't just an
+			// interrupted append, so they can only be skipped:
+			if l.replayMode != SkipBadRecords {
+				return tail[0].Err
+			}
+			summary.Skipped = append(summary.Skipped, tail...)
+			tail = nil
+		}
+
+		summary.Recovered++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if last && len(tail) > 0 && l.recovery == TruncateCorruptTail && allCorrupt(tail) {
+		// A read-only log is left as it is. Its tail may be a record that's
+		// still being appended:
+		if !l.readOnly {
+			if err := l.file.Truncate(tailOffset); err != nil {
+				return err
+			}
+			l.size = tailOffset
+		}
+		summary.Dropped += len(tail)
+		return nil
+	}
+	summary.Skipped = append(summary.Skipped, tail...)
+
+	// If the last line was only partly written, end it so new records aren't
+	// appended onto it:
+	if last && offset > l.size && l.size > 0 && !l.readOnly {
+		if _, err := l.file.Write([]byte("\n")); err != nil {
+			return err
+		}
+		l.size++
+	}
+
+	return nil
+}
+
+// The files of the log as of a point in it, opened so they can be read without
+// holding `commit`, while records are appended after it, or the log is
+// compacted.
+type logFiles struct {
+	files []*os.File
+	// The size of the last file when it was opened. Anything after it was
+	// appended since.
+	size int64
+	// The log's `removals` when the files were opened.
+	removals uint64
+}
+
+// Returned by `scan` when a record can't be read, and blobs have been removed
+// since its files were opened, so the record's blob may have been one of them.
+var errLogChanged = errors.New("Log was compacted while it was read")
+
+// Opens every file of the log, its snapshot first, for `scan` to read. The
+// caller must hold the store's `commit` lock, so nothing is being appended.
+func (l *writeAheadLog) openFiles() (logFiles, error) {
+	if err := l.flush(); err != nil {
+		return logFiles{}, err
+	}
+	paths, err := l.files()
+	if err != nil {
+		return logFiles{}, err
+	}
+	if _, err := os.Stat(l.snapshotPath()); err == nil {
+		paths = append([]string{l.snapshotPath()}, paths...)
+	}
+
+	opened := logFiles{size: l.size, removals: l.removals.Load()}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			opened.close()
+			return logFiles{}, err
+		}
+		opened.files = append(opened.files, file)
+	}
+
+	return opened, nil
+}
+
+func (f logFiles) close() {
+	for _, file := range f.files {
+		file.Close()
+	}
+}
+
+// Reads every record in files opened by `openFiles`, oldest first, stopping
+// early if `fn` returns an error, then closes them. Unlike `replay`, it never
+// changes the log: lines that can't be read are passed over, since replaying
+// the log already dropped or skipped them.
+func (l *writeAheadLog) scan(files logFiles, fn func(record []byte) error) error {
+	defer files.close()
+
+	for i, file := range files.files {
+		var r io.Reader = file
+		if i == len(files.files)-1 {
+			r = io.LimitReader(file, files.size)
+		}
+		if err := l.scanFile(r, files.removals, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *writeAheadLog) scanFile(r io.Reader, removals uint64, fn func(record []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxRecordSize)
+	for scanner.Scan() {
+		if isHeader(scanner.Bytes()) {
+			continue
+		}
+		payload, _, err := verifyChecksum(scanner.Bytes())
+		if err != nil {
+			continue
+		}
+		record, err := l.decode(payload)
+		if err != nil && l.removals.Load() != removals {
+			return errLogChanged
+		}
+		if err != nil {
+			continue
+		}
+
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Reports whether every record was skipped because it was corrupt.
+func allCorrupt(records []SkippedRecord) bool {
+	for _, r := range records {
+		if !errors.Is(r.Err, ErrCorruptRecord) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Verifies and decodes a single line of the log, and calls `fn` with its
+// record. Lines that fail their checksum are corrupt. So are lines without a
+// checksum, written before checksums were added, if they can't be read.
+func (l *writeAheadLog) replayLine(line []byte, fn func(record []byte) error) error {
+	payload, checksummed, err := verifyChecksum(line)
+	if err != nil {
+		return err
+	}
+
+	record, err := l.decode(payload)
+	if err == nil {
+		err = fn(record)
+	}
+	if err != nil && !checksummed {
+		return fmt.Errorf("%w: %v", ErrCorruptRecord, err)
+	}
+
+	return err
+}
+
+// Appends a tab and the CRC-32 checksum of a line to it. A tab never appears in
+// a JSON or base64 encoded record, so it unambiguously separates the two.
+func addChecksum(line []byte) []byte {
+	return fmt.Appendf(line, "\t%08x", crc32.ChecksumIEEE(line))
+}
+
+// Splits a line into its payload and checksum, and verifies the checksum.
+// Returns whether the line had a checksum at all.
+func verifyChecksum(line []byte) (payload []byte, checksummed bool, err error) {
+	tab := bytes.LastIndexByte(line, '\t')
+	if tab < 0 {
+		return line, false, nil
+	}
+
+	payload = line[:tab]
+	expected, err := strconv.ParseUint(string(line[tab+1:]), 16, 32)
+	if err != nil || uint32(expected) != crc32.ChecksumIEEE(payload) {
+		return nil, true, fmt.Errorf("%w: checksum mismatch", ErrCorruptRecord)
+	}
+
+	return payload, true, nil
+}
+
+// Replaces every record in the log with `records`. The new log is written to a
+// temporary file, then renamed into place, so a crash partway through leaves
+// the old log intact. A segmented log is replaced by a single new segment.
+func (l *writeAheadLog) rewrite(records [][]byte) error {
+	if err := l.flush(); err != nil {
+		return err
+	}
+	target := l.path
+	if l.segmentSize > 0 {
+		target = l.segmentPath(l.segment + 1)
+	}
+
+	if err := l.writeFile(target, records, nil); err != nil {
+		return err
+	}
+	l.file.Close()
+
+	if l.segmentSize > 0 {
+		segments, err := l.segments()
+		if err != nil {
+			return err
+		}
+		for _, segment := range segments {
+			if segment <= l.segment {
+				os.Remove(l.segmentPath(segment))
+			}
+		}
+		os.Remove(l.path)
+		l.segment++
+	}
+
+	// The rewritten log holds everything the snapshot did:
+	os.Remove(l.snapshotPath())
+	if err := l.openSegment(); err != nil {
+		return err
+	}
+
+	return l.removeUnusedBlobs()
+}
+
+// Writes records to the file at `path`, replacing it atomically: they're
+// written to a temporary file, which is synced, then renamed to `path`. If
+// `wrap` is set, the records are written through the writer it returns for the
+// file.
+func (l *writeAheadLog) writeFile(path string, records [][]byte, wrap func(io.Writer) io.Writer) error {
+	temp := path + ".writing"
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+
+	var w io.Writer = file
+	if wrap != nil {
+		w = wrap(file)
+	}
+	if err := l.writeRecords(w, records); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, path)
+}
+
+// Writes a header, then records, in the format of a file in the log.
+func (l *writeAheadLog) writeRecords(w io.Writer, records [][]byte) error {
+	buffered := bufio.NewWriter(w)
+	buffered.Write(l.header().line())
+	for _, record := range records {
+		line, err := l.encode(record)
+		if err != nil {
+			return err
+		}
+		buffered.Write(addChecksum(line))
+		buffered.WriteByte('\n')
+	}
+
+	return buffered.Flush()
+}
+
+// Reads every record from a file in the log's format, such as one written by
+// `writeRecords`, and calls `fn` with each one, stopping early if it returns
+// an error. Unlike replaying the log, any bad record is an error.
+func (l *writeAheadLog) readRecords(r io.Reader, fn func(record []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxRecordSize)
+	for first := true; scanner.Scan(); first = false {
+		if first && isHeader(scanner.Bytes()) {
+			if _, err := l.readHeader(scanner.Bytes()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := l.replayLine(scanner.Bytes(), fn); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Returns the path of the log's snapshot, which holds the records needed to
+// recreate the store as of some point in the log. It's replayed before the log.
+func (l *writeAheadLog) snapshotPath() string {
+	return l.path + ".snapshot"
+}
+
+// Returns the total size of the log's files, its snapshot and its blobs, in
+// bytes.
+func (l *writeAheadLog) diskSize() int64 {
+	files, _ := l.files()
+	size := l.blobsSize()
+	for _, path := range append(files, l.snapshotPath()) {
+		if info, err := os.Stat(path); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// A point in the log: an offset in one of its files.
+type logPosition struct {
+	// The segment the offset is in, or 0 if the log isn't segmented.
+	segment int
+	offset  int64
+}
+
+// Returns the position that the next record will be appended at.
+func (l *writeAheadLog) position() logPosition {
+	return logPosition{segment: l.segment, offset: l.size}
+}
+
+// Reads every record appended to the log from `position` on, in order.
+func (l *writeAheadLog) recordsSince(position logPosition) ([][]byte, error) {
+	if err := l.flush(); err != nil {
+		return nil, err
+	}
+	paths := []string{l.path}
+	if l.segmentSize > 0 {
+		paths = nil
+		for segment := position.segment; segment <= l.segment; segment++ {
+			paths = append(paths, l.segmentPath(segment))
+		}
+	}
+
+	var records [][]byte
+	for i, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			if _, err := file.Seek(position.offset, io.SeekStart); err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, maxRecordSize)
+		for scanner.Scan() {
+			if isHeader(scanner.Bytes()) {
+				continue
+			}
+			// A plain record is the scanner's own line, which the next scan
+			// overwrites:
+			err = l.replayLine(scanner.Bytes(), func(record []byte) error {
+				records = append(records, bytes.Clone(record))
+				return nil
+			})
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = scanner.Err()
+		}
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return records, nil
+}
+
+// Drops every record before `position` from the log, once a snapshot holds
+// them. Records after it are copied to a new file, which replaces the one
+// `position` is in, and any earlier files are removed.
+func (l *writeAheadLog) trim(position logPosition) error {
+	if err := l.flush(); err != nil {
+		return err
+	}
+	path := l.path
+	if l.segmentSize > 0 {
+		segments, err := l.segments()
+		if err != nil {
+			return err
+		}
+		for _, segment := range segments {
+			if segment < position.segment {
+				os.Remove(l.segmentPath(segment))
+			}
+		}
+		os.Remove(l.path)
+		path = l.segmentPath(position.segment)
+	}
+	if position.offset == 0 {
+		return l.removeUnusedBlobs()
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if _, err := src.Seek(position.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	temp := path + ".trimming"
+	dst, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+	// The header is trimmed along with the records after it, so write it again.
+	// A file from before headers were added is upgraded by this, since its
+	// records are in the same format:
+	if _, err := dst.Write(l.header().line()); err != nil {
+		dst.Close()
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temp, path); err != nil {
+		return err
+	}
+
+	// If records are still being appended to the trimmed file, reopen it:
+	if position.segment == l.segment {
+		l.file.Close()
+		if err := l.openSegment(); err != nil {
+			return err
+		}
+	}
+
+	return l.removeUnusedBlobs()
+}
+
+// Writes any records still in the buffer to the log's file, if it's buffered.
+func (l *writeAheadLog) flush() error {
+	if l.buffer == nil {
+		return nil
+	}
+
+	return l.buffer.Flush()
+}
+
+// Flushes every record appended so far to disk. A log whose writes are synced
+// as they're made only has its buffer to flush.
+func (l *writeAheadLog) sync() error {
+	if err := l.flush(); err != nil {
+		return err
+	}
+	if l.syncWrites {
+		return nil
+	}
+
+	return l.file.Sync()
+}
+
+func (l *writeAheadLog) close() error {
+	defer l.unlock()
+	err := l.flush()
+	if err == nil {
+		err = l.releasePreallocated()
+	}
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Flushes the log's buffer every `LogBuffer` interval, so records don't wait
+// in it for long when writes are few and far between.
+func (s *kvStore[K, V]) flushLog() {
+	defer s.background.Done()
+
+	ticker := time.NewTicker(s.options.logFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ticker.C:
+			s.commit.Lock()
+			err := s.log.flush()
+			s.commit.Unlock()
+			if err != nil {
+				s.options.logger.Error("Failed to flush write-ahead log", "error", err)
+			}
+		}
+	}
+}
+
+// Turns a record into the line that's written to the log. Large records are
+// gzipped if the log is compressed. Encrypted records are sealed with a random
+// nonce, which is stored in front of the ciphertext. Binary lines are base64
+// encoded so they can't contain a newline. Lines over the log's blob threshold
+// are then moved into blob files.
+func (l *writeAheadLog) encode(record []byte) ([]byte, error) {
+	payload := record
+	if l.compress && len(record) >= compressionThreshold {
+		var compressed bytes.Buffer
+		w := gzip.NewWriter(&compressed)
+		if _, err := w.Write(record); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		payload = compressed.Bytes()
+	}
+
+	if l.aead != nil {
+		nonce := make([]byte, l.aead.NonceSize())
+		rand.Read(nonce)
+		payload = l.aead.Seal(nonce, nonce, payload, nil)
+	}
+
+	if l.aead == nil && !l.binary && !bytes.HasPrefix(payload, gzipMagic) {
+		return l.spill(payload)
+	}
+
+	line := make([]byte, base64.StdEncoding.EncodedLen(len(payload)))
+	base64.StdEncoding.Encode(line, payload)
+	return l.spill(line)
+}
+
+// Turns a line read from the log back into a record. Plain JSON records, which
+// always start with `{`, and compressed records can be mixed in the same log,
+// so compression can be turned on or off at any time. So can records kept in
+// blob files.
+func (l *writeAheadLog) decode(line []byte) ([]byte, error) {
+	if bytes.HasPrefix(line, []byte(blobPrefix)) {
+		contents, err := l.readBlob(line)
+		if err != nil {
+			return nil, err
+		}
+		line = contents
+	}
+	if l.aead == nil && bytes.HasPrefix(line, []byte("{")) {
+		return line, nil
+	}
+
+	payload := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(payload, line)
+	if err != nil {
+		if l.aead != nil {
+			return nil, errors.New("Failed to decode encrypted record, the log may not be encrypted")
+		}
+		return nil, errors.New("Failed to decode record")
+	}
+	payload = payload[:n]
+
+	if l.aead != nil {
+		if len(payload) < l.aead.NonceSize() {
+			return nil, errors.New("Failed to decrypt record, it is too short")
+		}
+
+		nonce, ciphertext := payload[:l.aead.NonceSize()], payload[l.aead.NonceSize():]
+		if payload, err = l.aead.Open(nil, nonce, ciphertext, nil); err != nil {
+			return nil, errors.New("Failed to decrypt record, the encryption key may be wrong")
+		}
+	}
+
+	if bytes.HasPrefix(payload, gzipMagic) {
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, errors.New("Failed to decompress record")
+		}
+		if payload, err = io.ReadAll(r); err != nil {
+			return nil, errors.New("Failed to decompress record")
+		}
+	}
+
+	return payload, nil
+}
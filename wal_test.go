@@ -0,0 +1,542 @@
+package kv
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qsymmachus/ranger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedLog(t *testing.T) {
+	defer os.Remove(logPath)
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	first, err := NewStore[string, string](LogPath(logPath), EncryptionKey(key))
+	assert.NoError(t, err)
+	first.Set("password", "hunter2")
+	first.Close()
+
+	// Nothing is stored in plaintext:
+	log, _ := os.ReadFile(logPath)
+	assert.NotContains(t, string(log), "hunter2")
+	assert.NotContains(t, string(log), "password")
+
+	// The log can be replayed with the same key:
+	second, err := NewStore[string, string](LogPath(logPath), EncryptionKey(key))
+	assert.NoError(t, err)
+	v, _ := second.Get("password")
+	assert.Equal(t, "hunter2", v)
+	second.Close()
+
+	// But not with a different one:
+	wrongKey := []byte(strings.Repeat("x", 32))
+	_, err = NewStore[string, string](LogPath(logPath), EncryptionKey(wrongKey))
+	assert.Error(t, err)
+}
+
+func TestInvalidEncryptionKey(t *testing.T) {
+	defer os.Remove(logPath)
+
+	_, err := NewStore[string, string](LogPath(logPath), EncryptionKey([]byte("short")))
+	assert.Error(t, err)
+}
+
+func TestCompressedLog(t *testing.T) {
+	defer os.Remove(logPath)
+	large := strings.Repeat("compressible ", 1000)
+
+	// Start with an uncompressed log, then turn compression on:
+	first, _ := NewStore[string, string](LogPath(logPath))
+	first.Set("small", "value")
+	first.Close()
+
+	second, err := NewStore[string, string](LogPath(logPath), CompressLog())
+	assert.NoError(t, err)
+	second.Set("large", large)
+	second.Close()
+
+	log, _ := os.ReadFile(logPath)
+	assert.Less(t, len(log), len(large)/10)
+	assert.Contains(t, string(log), "value")
+
+	// Both kinds of record are replayed, with or without the option:
+	third, err := NewStore[string, string](LogPath(logPath))
+	assert.NoError(t, err)
+	v, _ := third.Get("large")
+	assert.Equal(t, large, v)
+	v, _ = third.Get("small")
+	assert.Equal(t, "value", v)
+	third.Close()
+}
+
+func TestCompressedEncryptedLog(t *testing.T) {
+	defer os.Remove(logPath)
+	key := []byte("0123456789abcdef")
+	large := strings.Repeat("compressible ", 1000)
+
+	first, _ := NewStore[string, string](LogPath(logPath), CompressLog(), EncryptionKey(key))
+	first.Set("large", large)
+	first.Close()
+
+	second, err := NewStore[string, string](LogPath(logPath), EncryptionKey(key))
+	assert.NoError(t, err)
+	v, _ := second.Get("large")
+	assert.Equal(t, large, v)
+	second.Close()
+}
+
+func TestSegmentedLog(t *testing.T) {
+	defer removeLog()
+
+	first, err := NewStore[int, int](LogPath(logPath), SegmentSize(1024))
+	assert.NoError(t, err)
+	for _, n := range ranger.Int(1, 200) {
+		first.Set(n, n)
+	}
+	first.Close()
+
+	segments, _ := filepath.Glob(logPath + ".*")
+	assert.Greater(t, len(segments), 1)
+	_, err = os.Stat(logPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// Segments are replayed in order, and new records go to the latest one:
+	second, err := NewStore[int, int](LogPath(logPath), SegmentSize(1024))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, second.Len())
+	second.Set(1, 100)
+	second.Close()
+
+	third, _ := NewStore[int, int](LogPath(logPath), SegmentSize(1024))
+	v, _ := third.Get(1)
+	assert.Equal(t, 100, v)
+	third.Close()
+}
+
+func TestSegmentingExistingLog(t *testing.T) {
+	defer removeLog()
+
+	first, _ := NewStore[string, string](LogPath(logPath))
+	first.Set("a", "a")
+	first.Close()
+
+	// An existing single-file log is replayed before any segments:
+	second, _ := NewStore[string, string](LogPath(logPath), SegmentSize(1024))
+	second.Set("a", "b")
+	second.Close()
+
+	third, _ := NewStore[string, string](LogPath(logPath), SegmentSize(1024))
+	v, _ := third.Get("a")
+	assert.Equal(t, "b", v)
+	third.Close()
+}
+
+// Removes the test log, and any segments or other files that were created
+// alongside it.
+func removeLog() {
+	os.Remove(logPath)
+	files, _ := filepath.Glob(logPath + ".*")
+	for _, file := range files {
+		os.RemoveAll(file)
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	defer os.Remove(logPath)
+
+	first, _ := NewStore[string, string](LogPath(logPath))
+	first.Set("a", "a")
+	first.Set("b", "b")
+	first.Close()
+
+	// Flip a byte in the first record, which is still valid JSON:
+	log, _ := os.ReadFile(logPath)
+	corrupted := strings.Replace(string(log), `"a"`, `"z"`, 1)
+	os.WriteFile(logPath, []byte(corrupted), 0600)
+
+	_, err := NewStore[string, string](LogPath(logPath))
+	assert.ErrorIs(t, err, ErrCorruptRecord)
+
+	// Corruption in the middle of the log can't be recovered from:
+	_, err = NewStore[string, string](LogPath(logPath), RecoveryMode(TruncateCorruptTail))
+	assert.ErrorIs(t, err, ErrCorruptRecord)
+}
+
+func TestTruncateCorruptTail(t *testing.T) {
+	defer os.Remove(logPath)
+
+	first, _ := NewStore[string, string](LogPath(logPath))
+	first.Set("a", "a")
+	first.Set("b", "b")
+	first.Close()
+
+	// Simulate a crash partway through appending a record:
+	file, _ := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0600)
+	file.WriteString(`{"UpdateType":0,"Key":"c","Val`)
+	file.Close()
+
+	_, err := NewStore[string, string](LogPath(logPath))
+	assert.ErrorIs(t, err, ErrCorruptRecord)
+
+	second, err := NewStore[string, string](LogPath(logPath), RecoveryMode(TruncateCorruptTail))
+	assert.NoError(t, err)
+	assert.Equal(t, ReplaySummary{Recovered: 2, Dropped: 1}, second.ReplaySummary())
+	assert.Equal(t, map[string]string{"a": "a", "b": "b"}, second.GetAll())
+	second.Set("c", "c")
+	second.Close()
+
+	// The tail was truncated, so the log is readable without recovery:
+	third, err := NewStore[string, string](LogPath(logPath))
+	assert.NoError(t, err)
+	assert.Equal(t, ReplaySummary{Recovered: 3}, third.ReplaySummary())
+	third.Close()
+}
+
+func TestSkipBadRecords(t *testing.T) {
+	defer os.Remove(logPath)
+
+	first, _ := NewStore[string, string](LogPath(logPath))
+	first.Set("a", "a")
+	first.Set("b", "b")
+	first.Set("c", "c")
+	first.Close()
+
+	// Corrupt the middle record, after the header:
+	log, _ := os.ReadFile(logPath)
+	lines := strings.Split(string(log), "\n")
+	lines[2] = "not a record"
+	os.WriteFile(logPath, []byte(strings.Join(lines, "\n")), 0600)
+
+	_, err := NewStore[string, string](LogPath(logPath), ReplayMode(Strict))
+	assert.ErrorIs(t, err, ErrCorruptRecord)
+
+	second, err := NewStore[string, string](LogPath(logPath), ReplayMode(SkipBadRecords))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "a", "c": "c"}, second.GetAll())
+
+	summary := second.ReplaySummary()
+	assert.Equal(t, 2, summary.Recovered)
+	assert.Len(t, summary.Skipped, 1)
+	assert.Equal(t, 3, summary.Skipped[0].Line)
+	assert.ErrorIs(t, summary.Skipped[0].Err, ErrCorruptRecord)
+	second.Close()
+}
+
+func TestSkipBadTail(t *testing.T) {
+	defer os.Remove(logPath)
+
+	first, _ := NewStore[string, string](LogPath(logPath))
+	first.Set("a", "a")
+	first.Close()
+
+	file, _ := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0600)
+	file.WriteString(`{"UpdateType":0,"Key":"b","Val`)
+	file.Close()
+
+	// A skipped tail is left in the log, but new records aren't appended onto it:
+	second, err := NewStore[string, string](LogPath(logPath), ReplayMode(SkipBadRecords))
+	assert.NoError(t, err)
+	assert.Len(t, second.ReplaySummary().Skipped, 1)
+	second.Set("c", "c")
+	second.Close()
+
+	third, err := NewStore[string, string](LogPath(logPath), ReplayMode(SkipBadRecords))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "a", "c": "c"}, third.GetAll())
+	third.Close()
+}
+
+func TestReadOnly(t *testing.T) {
+	defer os.Remove(logPath)
+
+	writer, _ := NewStore[string, string](LogPath(logPath))
+	defer writer.Close()
+	writer.Set("a", "a")
+
+	reader, err := NewStore[string, string](LogPath(logPath), ReadOnly())
+	assert.NoError(t, err)
+	defer reader.Close()
+	v, _ := reader.Get("a")
+	assert.Equal(t, "a", v)
+
+	_, err = reader.Set("b", "b")
+	assert.ErrorIs(t, err, ErrReadOnly)
+	_, err = reader.Unset("a")
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.ErrorIs(t, reader.Compact(), ErrReadOnly)
+
+	// Nothing was written to the log after the writer's header and record:
+	written, _ := os.ReadFile(logPath)
+	assert.Equal(t, 2, bytes.Count(written, []byte("\n")))
+}
+
+func TestReadOnlyLeavesCorruptTail(t *testing.T) {
+	defer os.Remove(logPath)
+
+	writer, _ := NewStore[string, string](LogPath(logPath))
+	writer.Set("a", "a")
+	writer.Close()
+
+	// Simulate a record that's still being appended:
+	file, _ := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0600)
+	file.WriteString(`{"UpdateType":0,"Key":"b","Val`)
+	file.Close()
+	before, _ := os.ReadFile(logPath)
+
+	reader, err := NewStore[string, string](LogPath(logPath), ReadOnly(), RecoveryMode(TruncateCorruptTail))
+	assert.NoError(t, err)
+	assert.Equal(t, ReplaySummary{Recovered: 1, Dropped: 1}, reader.ReplaySummary())
+	reader.Close()
+
+	after, _ := os.ReadFile(logPath)
+	assert.Equal(t, before, after)
+}
+
+func TestReadOnlyMissingLog(t *testing.T) {
+	_, err := NewStore[string, string](LogPath(logPath), ReadOnly())
+	assert.Error(t, err)
+}
+
+func TestLogLocked(t *testing.T) {
+	defer removeLog()
+
+	first, err := NewStore[string, string](LogPath(logPath))
+	assert.NoError(t, err)
+
+	_, err = NewStore[string, string](LogPath(logPath))
+	assert.ErrorIs(t, err, ErrLogLocked)
+
+	// Readers don't need the lock:
+	reader, err := NewStore[string, string](LogPath(logPath), ReadOnly())
+	assert.NoError(t, err)
+	reader.Close()
+
+	// The lock is released when the store is closed:
+	first.Close()
+	second, err := NewStore[string, string](LogPath(logPath))
+	assert.NoError(t, err)
+	second.Close()
+}
+
+func TestWaitForLock(t *testing.T) {
+	defer removeLog()
+
+	first, _ := NewStore[string, string](LogPath(logPath))
+	first.Set("name", "ralph")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		first.Close()
+	}()
+
+	second, err := NewStore[string, string](LogPath(logPath), WaitForLock(time.Second))
+	assert.NoError(t, err)
+	v, _ := second.Get("name")
+	assert.Equal(t, "ralph", v)
+	second.Close()
+
+	// It gives up once the timeout passes:
+	third, _ := NewStore[string, string](LogPath(logPath))
+	defer third.Close()
+	_, err = NewStore[string, string](LogPath(logPath), WaitForLock(20*time.Millisecond))
+	assert.ErrorIs(t, err, ErrLogLocked)
+}
+
+func TestLogHeader(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath), EncryptionKey([]byte("0123456789abcdef")))
+	store.Set("name", "ralph")
+	store.Close()
+
+	written, _ := os.ReadFile(logPath)
+	assert.True(t, strings.HasPrefix(string(written), "#kv-log 1 encrypted\t"))
+
+	// The header says what the log needs to be read:
+	_, err := NewStore[string, string](LogPath(logPath))
+	assert.ErrorContains(t, err, "no encryption key")
+	_, err = NewStore[string, string](LogPath(logPath), EncryptionKey([]byte("0123456789abcdef")), LogCodec(GobCodec))
+	assert.ErrorContains(t, err, "different codec")
+}
+
+func TestUnsupportedLogVersion(t *testing.T) {
+	defer removeLog()
+
+	header := addChecksum([]byte("#kv-log 99"))
+	os.WriteFile(logPath, append(header, '\n'), 0600)
+
+	_, err := NewStore[string, string](LogPath(logPath))
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func TestLogWithoutHeader(t *testing.T) {
+	defer removeLog()
+
+	// Logs from before headers were added are read as they are:
+	record := addChecksum([]byte(`{"UpdateType":0,"Key":"name","Value":"ralph"}`))
+	os.WriteFile(logPath, append(record, '\n'), 0600)
+
+	store, err := NewStore[string, string](LogPath(logPath))
+	assert.NoError(t, err)
+	v, _ := store.Get("name")
+	assert.Equal(t, "ralph", v)
+
+	// And upgraded when they're compacted:
+	assert.NoError(t, store.Compact())
+	store.Close()
+	written, _ := os.ReadFile(logPath)
+	assert.True(t, strings.HasPrefix(string(written), "#kv-log 1\t"))
+
+	replayed, _ := NewStore[string, string](LogPath(logPath))
+	v, _ = replayed.Get("name")
+	assert.Equal(t, "ralph", v)
+	replayed.Close()
+}
+
+func TestSync(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath), SegmentSize(128))
+	for _, n := range ranger.Int(1, 10) {
+		store.Set(strconv.Itoa(n), "value")
+	}
+	assert.NoError(t, store.Sync())
+	store.Close()
+	assert.ErrorIs(t, store.Sync(), ErrClosed)
+
+	// Stores without a log have nothing to sync:
+	memory, _ := NewStore[string, string]()
+	assert.NoError(t, memory.Sync())
+	memory.Close()
+}
+
+func TestLogBuffer(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath), LogBuffer(1<<20, 0))
+	store.Set("name", "toby")
+	store.Set("name", "ralph")
+
+	// Records wait in the buffer until it's flushed:
+	written, _ := os.ReadFile(logPath)
+	assert.NotContains(t, string(written), "toby")
+	v, found, err := store.GetAt("name", 1)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "toby", v)
+
+	store.Set("food", "pizza")
+	assert.NoError(t, store.Sync())
+	written, _ = os.ReadFile(logPath)
+	assert.Contains(t, string(written), "pizza")
+
+	store.Set("food", "tacos")
+	store.Close()
+	replayed, _ := NewStore[string, string](LogPath(logPath))
+	defer replayed.Close()
+	assert.Equal(t, map[string]string{"name": "ralph", "food": "tacos"}, replayed.GetAll())
+}
+
+func TestLogBufferInterval(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath), LogBuffer(1<<20, 10*time.Millisecond))
+	defer store.Close()
+	store.Set("name", "toby")
+
+	eventually(t, func() bool {
+		written, _ := os.ReadFile(logPath)
+		return strings.Contains(string(written), "toby")
+	})
+}
+
+func TestLogBufferFills(t *testing.T) {
+	defer removeLog()
+
+	// Records are written once the buffer is full, and the log is still
+	// segmented by what's been appended:
+	store, _ := NewStore[int, string](LogPath(logPath), LogBuffer(256, 0), SegmentSize(1024))
+	defer store.Close()
+	for _, n := range ranger.Int(1, 100) {
+		store.Set(n, "value")
+	}
+
+	segments, _ := filepath.Glob(logPath + ".0*")
+	assert.Greater(t, len(segments), 2)
+	v, found, err := store.GetAt(1, 1)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "value", v)
+}
+
+func TestBlobThreshold(t *testing.T) {
+	defer removeLog()
+
+	large := strings.Repeat("x", 100_000)
+	store, _ := NewStore[string, string](LogPath(logPath), BlobThreshold(4096))
+	store.Set("small", "value")
+	store.Set("large", large)
+	store.Set("copy", large)
+	store.Close()
+
+	// Each large value's record is kept in a blob, which the log only refers
+	// to:
+	info, _ := os.Stat(logPath)
+	assert.Less(t, info.Size(), int64(1024))
+	blobs, _ := os.ReadDir(logPath + ".blobs")
+	assert.Len(t, blobs, 2)
+
+	replayed, err := NewStore[string, string](LogPath(logPath), BlobThreshold(4096))
+	assert.NoError(t, err)
+	defer replayed.Close()
+	assert.Equal(t, map[string]string{"small": "value", "large": large, "copy": large}, replayed.GetAll())
+
+	// Compacting the log removes the blobs it no longer refers to:
+	replayed.Set("large", "value")
+	replayed.Set("copy", "value")
+	assert.NoError(t, replayed.Compact())
+	blobs, _ = os.ReadDir(logPath + ".blobs")
+	assert.Empty(t, blobs)
+}
+
+func TestBlobThresholdMissingBlob(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[string, string](LogPath(logPath), BlobThreshold(16))
+	store.Set("large", strings.Repeat("x", 100))
+	store.Close()
+	os.RemoveAll(logPath + ".blobs")
+
+	_, err := NewStore[string, string](LogPath(logPath))
+	assert.ErrorIs(t, err, ErrCorruptRecord)
+}
+
+func TestReplayProgress(t *testing.T) {
+	defer removeLog()
+
+	store, _ := NewStore[int, int](LogPath(logPath))
+	for _, n := range ranger.Int(1, replayProgressInterval+100) {
+		store.Set(n, n)
+	}
+	store.Close()
+	info, _ := os.Stat(logPath)
+
+	var reports []ReplayProgress
+	replayed, err := NewStore[int, int](LogPath(logPath), OnReplayProgress(func(p ReplayProgress) {
+		reports = append(reports, p)
+	}))
+	assert.NoError(t, err)
+	defer replayed.Close()
+
+	// One report part way through, after the header and the records before it,
+	// and one at the end:
+	assert.Len(t, reports, 2)
+	assert.Equal(t, replayProgressInterval-1, reports[0].Records)
+	assert.False(t, reports[0].Done)
+	assert.Equal(t, ReplayProgress{Records: replayProgressInterval + 100, Bytes: info.Size(), Done: true}, reports[1])
+}
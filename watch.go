@@ -0,0 +1,91 @@
+package kv
+
+// How many changes can be waiting to be sent to a watching client before it's
+// considered to have fallen behind, and its watch is stopped.
+const watchBufferSize = 1024
+
+// A client watching the changes to keys with a prefix, in a namespace.
+type watcher[K comparable, V any] struct {
+	namespace string
+	prefix    string
+	changes   chan Change[K, V]
+}
+
+// Reports whether a change should be sent to the watcher. Changes that remove
+// every key in the watched namespace are always sent.
+func (w *watcher[K, V]) matches(change Change[K, V]) bool {
+	if change.Type == ChangeTruncate {
+		return true
+	}
+
+	return change.Namespace == w.namespace && (change.Type == ChangeClear || keyHasPrefix(change.Key, w.prefix))
+}
+
+// Starts watching the namespace's keys that start with `prefix`. Returns the
+// watched keys' values, and the revision the store was at when they were read;
+// every change after that revision is sent to the watcher, so a client can keep
+// a copy of the keys in sync.
+func (s *kvStore[K, V]) watch(prefix string) (*watcher[K, V], map[K]V, uint64, error) {
+	w := &watcher[K, V]{namespace: s.namespace, prefix: prefix, changes: make(chan Change[K, V], watchBufferSize)}
+	entries := make(map[K]V)
+	var revision uint64
+	var closed bool
+	pauseErr := s.exclusive(func() {
+		for _, sh := range s.shards {
+			for k, v := range sh.lookup(s.namespace).values.all() {
+				if keyHasPrefix(k, prefix) {
+					entries[k] = v
+				}
+			}
+		}
+
+		s.commit.Lock()
+		defer s.commit.Unlock()
+		if closed = !s.publishing; !closed {
+			revision = s.revision
+			s.watchers[w] = struct{}{}
+		}
+	})
+	if pauseErr != nil {
+		return nil, nil, 0, pauseErr
+	}
+	if closed {
+		return nil, nil, 0, ErrClosed
+	}
+
+	return w, entries, revision, nil
+}
+
+// Stops sending changes to a watcher, if they haven't stopped already.
+func (s *kvStore[K, V]) unwatch(w *watcher[K, V]) {
+	s.commit.Lock()
+	defer s.commit.Unlock()
+	s.dropWatcher(w)
+}
+
+// Removes a watcher and closes its channel. The caller must hold `commit`.
+func (s *kvStore[K, V]) dropWatcher(w *watcher[K, V]) {
+	if _, found := s.watchers[w]; found {
+		delete(s.watchers, w)
+		close(w.changes)
+	}
+}
+
+// Sends changes to every watcher they match. A watcher whose buffer is full is
+// dropped, rather than holding up updates. The caller must hold `commit`.
+func (s *kvStore[K, V]) sendToWatchers(changes []Change[K, V]) {
+	for w := range s.watchers {
+	send:
+		for _, c := range changes {
+			if !w.matches(c) {
+				continue
+			}
+			select {
+			case w.changes <- c:
+			default:
+				s.dropWatcher(w)
+				break send
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// How long the store waits for a webhook endpoint to respond.
+const webhookTimeout = 10 * time.Second
+
+// Publishes changes to keys with any of its prefixes by POSTing them, as JSON,
+// to an HTTP endpoint.
+type webhook[K comparable, V any] struct {
+	url      string
+	prefixes []string
+	client   *http.Client
+}
+
+// Option that POSTs each change to a key starting with one of `keyPrefixes` to
+// the endpoint at `url`, as a JSON `Change`, so external services can react to
+// writes. With no prefixes, every change is posted. Keys that aren't strings are
+// matched as they're formatted by `fmt.Sprint`. Changes that clear keys, rather
+// than changing a single key, are always posted. The endpoint must respond with
+// a 2xx status; otherwise the change is retried with exponential backoff, and
+// later changes wait for it, so they're received in order. The option can be
+// given several times, for several endpoints.
+func Webhook[K comparable, V any](url string, keyPrefixes ...string) Option {
+	return PublishChanges[K, V](&webhook[K, V]{
+		url:      url,
+		prefixes: keyPrefixes,
+		client:   &http.Client{Timeout: webhookTimeout},
+	})
+}
+
+func (w *webhook[K, V]) Publish(change Change[K, V]) error {
+	if !w.matches(change) {
+		return nil
+	}
+
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	res, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Webhook responded with %s", res.Status)
+	}
+
+	return nil
+}
+
+// Reports whether a change should be posted to the endpoint.
+func (w *webhook[K, V]) matches(change Change[K, V]) bool {
+	if len(w.prefixes) == 0 || change.Type == ChangeClear || change.Type == ChangeTruncate {
+		return true
+	}
+
+	for _, prefix := range w.prefixes {
+		if keyHasPrefix(change.Key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,53 @@
+package kv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var received []Change[string, string]
+	failures := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// Fail the first request, which is retried:
+		if failures == 0 {
+			failures++
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		change := Change[string, string]{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&change))
+		received = append(received, change)
+	}))
+	defer server.Close()
+
+	store, _ := NewStore[string, string](Webhook[string, string](server.URL, "user:", "admin:"))
+	store.Set("user:alice", "Alice")
+	store.Set("session:alice", "token")
+	store.Unset("admin:bob")
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 2
+	}, time.Second, 10*time.Millisecond)
+	store.Close()
+
+	// Only changes to matching keys are posted:
+	assert.Equal(t, "user:alice", received[0].Key)
+	assert.Equal(t, ChangeSet, received[0].Type)
+	assert.Equal(t, "admin:bob", received[1].Key)
+	assert.Equal(t, ChangeUnset, received[1].Type)
+	assert.Equal(t, uint64(3), received[1].Revision)
+}